@@ -19,8 +19,21 @@
 //	@tag.description			Image analysis and FOV calculation
 //	@tag.name					Solving
 //	@tag.description			Plate-solving operations
+//	@tag.name					WCS
+//	@tag.description			Pixel and sky coordinate conversion
+//	@tag.name					Coordinates
+//	@tag.description			Coordinate epoch conversion
 //	@tag.name					Health
 //	@tag.description			Server health and status
+//	@tag.name					Admin
+//	@tag.description			Solver pool administration
+//	@tag.name					Nova
+//	@tag.description			nova.astrometry.net compatible API
+//
+//	@securityDefinitions.apikey	AdminToken
+//	@in							header
+//	@name						Authorization
+//	@description				"Bearer " followed by the ADMIN_TOKEN of the server
 package main
 
 import (
@@ -29,13 +42,24 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	_ "github.com/DiarmuidKelly/astrometry-api-server/docs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/cache"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/catalog"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/escalate"
 	"github.com/DiarmuidKelly/astrometry-api-server/internal/handlers"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
 	"github.com/DiarmuidKelly/astrometry-api-server/internal/middleware"
-	client "github.com/DiarmuidKelly/astrometry-go-client"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solver"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/store"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/webhook"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
@@ -43,35 +67,182 @@ func main() {
 	// Configuration from environment
 	indexPath := getEnv("ASTROMETRY_INDEX_PATH", "/data/indexes")
 	port := getEnv("PORT", "8080")
+	solverBackend := getEnv("SOLVER_BACKEND", "docker")
+	solverTimeout := getEnvDuration("SOLVER_TIMEOUT", 5*time.Minute)
 	containerName := getEnv("ASTROMETRY_CONTAINER_NAME", "astrometry-solver")
+	solveFieldPath := getEnv("SOLVE_FIELD_PATH", "solve-field")
+	solverURL := getEnv("SOLVER_URL", "")
+	solverTargets := getEnv("SOLVER_TARGETS", "")
+	solverMaxFailures := getEnvInt("SOLVER_MAX_FAILURES", solver.DefaultMaxFailures)
+	solverCooldown := getEnvDuration("SOLVER_COOLDOWN", solver.DefaultCooldown)
+	sharedDataDir := getEnv("SHARED_DATA_DIR", "/shared-data")
+	jobStoreDir := getEnv("JOB_STORE_DIR", "/data/jobs")
 	maxUploadSize := int64(50 * 1024 * 1024) // 50MB default
+	maxBatchSize := int64(500 * 1024 * 1024) // 500MB default
+	maxBatchExtracted := int64(getEnvInt("MAX_BATCH_EXTRACTED_MB", 1024)) << 20
+	maxBatchFiles := getEnvInt("MAX_BATCH_FILES", 100)
+	maxConcurrentSolves := getEnvInt("MAX_CONCURRENT_SOLVES", 2)
+	solveQueueSize := getEnvInt("SOLVE_QUEUE_SIZE", 16)
+	jobWorkers := getEnvInt("JOB_WORKERS", 2)
+	jobQueueSize := getEnvInt("JOB_QUEUE_SIZE", 32)
+	jobRetention := getEnvDuration("JOB_RETENTION", 24*time.Hour)
+	webhookSecret := getEnv("WEBHOOK_SECRET", "")
+	webhookAllowedHosts := getEnv("WEBHOOK_ALLOWED_HOSTS", "")
+	webhookMaxAttempts := getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5)
+	webhookBackoff := getEnvDuration("WEBHOOK_BACKOFF", 10*time.Second)
+	cacheSize := getEnvInt("CACHE_SIZE", 1000)
+	cacheTTL := getEnvDuration("CACHE_TTL", 24*time.Hour)
+	escalationLadder := getEnv("ESCALATION_LADDER", "")
+	escalationBudget := getEnvDuration("ESCALATION_BUDGET", escalate.DefaultBudget)
+	novaAPIKey := getEnv("NOVA_API_KEY", "")
+	adminToken := getEnv("ADMIN_TOKEN", "")
+	ngcCatalog := getEnv("NGC_CATALOG", "")
+
+	// Add the NGC and IC objects to the catalog before it is first used
+	if ngcCatalog != "" {
+		n, err := catalog.LoadOpenNGC(ngcCatalog)
+		if err != nil {
+			log.Fatalf("Failed to load NGC catalog: %v", err)
+		}
+		log.Printf("Loaded %d objects from %s", n, ngcCatalog)
+	}
+
+	// Create the solver targets: SOLVER_TARGETS lists solver containers and
+	// worker URLs, otherwise there is a single target of SOLVER_BACKEND. The
+	// docker backend needs access to the Docker socket; the local and remote
+	// backends do not. See SECURITY.md for security considerations
+	var targetNames []string
+	for _, name := range strings.Split(solverTargets, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			targetNames = append(targetNames, name)
+		}
+	}
+	targets, err := solver.NewTargets(solverBackend, targetNames, solver.Config{
+		IndexPath:      indexPath,
+		Timeout:        solverTimeout,
+		SolveFieldPath: solveFieldPath,
+		ContainerName:  containerName,
+		URL:            solverURL,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create solver backend: %v", err)
+	}
+	// Each target runs up to MAX_CONCURRENT_SOLVES solves, so adding targets
+	// adds capacity
+	for i := range targets {
+		targets[i].MaxConcurrent = max(maxConcurrentSolves, 1)
+	}
+	pool, err := solver.NewPool(targets, solverMaxFailures, solverCooldown)
+	if err != nil {
+		log.Fatalf("Failed to create solver pool: %v", err)
+	}
+	healthCtx, cancelHealth := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := pool.Health(healthCtx); err != nil {
+		log.Printf("Solver backend is not healthy yet: %v", err)
+	}
+	cancelHealth()
+
+	// Solves queue for the targets' combined capacity, and the pool sends each
+	// to a target with a free slot
+	limiter := queue.NewLimiter(pool.Capacity(), solveQueueSize)
+	solveClient := queue.NewClient(pool, limiter)
 
-	// Create astrometry client with docker exec mode
-	// Note: Docker socket access required for containerized deployment
-	// See SECURITY.md for security considerations
-	config := &client.ClientConfig{
-		IndexPath:     indexPath,
-		Timeout:       5 * time.Minute,
-		TempDir:       "/shared-data",
-		UseDockerExec: true,
-		ContainerName: containerName,
+	// Per-request workspaces on the shared volume
+	workspaces, err := workspace.NewManager(sharedDataDir, 24*time.Hour)
+	if err != nil {
+		log.Fatalf("Failed to create workspace manager: %v", err)
 	}
 
-	astrometryClient, err := client.NewClient(config)
+	// Every accepted solve is recorded on disk so it survives restarts
+	jobStore, err := store.Open(jobStoreDir)
 	if err != nil {
-		log.Fatalf("Failed to create astrometry client: %v", err)
+		log.Fatalf("Failed to open job store: %v", err)
+	}
+	jobManager := jobs.NewManager(solveClient, jobStore, jobWorkers, jobQueueSize, jobRetention)
+
+	// Ladder and budget for strategy=escalate solves that do not choose their own
+	escalation := escalate.Plan{Ladder: escalate.DefaultLadder, Budget: escalationBudget}
+	if escalationLadder != "" {
+		if escalation.Ladder, err = escalate.ParseLadder(escalationLadder); err != nil {
+			log.Fatalf("Invalid ESCALATION_LADDER: %v", err)
+		}
 	}
+	jobManager.SetEscalation(escalation)
+
+	// Repeated uploads of the same image are answered from memory
+	if cacheSize > 0 {
+		jobManager.SetCache(cache.New(cacheSize, cacheTTL))
+	}
+
+	// Callbacks are signed, so they need a secret as well as somewhere to go
+	switch {
+	case webhookAllowedHosts == "":
+		log.Printf("Callbacks disabled (set WEBHOOK_ALLOWED_HOSTS and WEBHOOK_SECRET to enable)")
+	case webhookSecret == "":
+		log.Printf("Callbacks disabled: WEBHOOK_SECRET is not set")
+	default:
+		sender := webhook.NewSender(webhookSecret, strings.Split(webhookAllowedHosts, ","), webhookMaxAttempts, webhookBackoff)
+		jobManager.SetDeliverer(handlers.NewCallbackDeliverer(sender))
+		log.Printf("Callbacks enabled for hosts: %s", webhookAllowedHosts)
+	}
+
+	// Resume unfinished solves before removing workspaces left behind by a crash
+	if n, err := jobManager.Recover(workspaces); err != nil {
+		log.Printf("Failed to recover unfinished jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Resuming %d unfinished jobs", n)
+	}
+	if n, err := workspaces.Reap(); err != nil {
+		log.Printf("Failed to reap stale workspaces: %v", err)
+	} else if n > 0 {
+		log.Printf("Removed %d stale workspaces", n)
+	}
+	jobManager.Start()
 
 	// Create handlers
-	solveHandler := handlers.NewSolveHandler(astrometryClient, maxUploadSize)
-	analyseHandler := handlers.NewAnalyseHandler(maxUploadSize)
-	healthHandler := handlers.NewHealthHandler()
+	solveHandler := handlers.NewSolveHandler(jobManager, workspaces, maxUploadSize)
+	batchHandler := handlers.NewBatchHandler(jobManager, workspaces, maxUploadSize, maxBatchSize, maxBatchExtracted, maxBatchFiles)
+	analyseHandler := handlers.NewAnalyseHandler(workspaces, maxUploadSize)
+	jobsHandler := handlers.NewJobsHandler(jobManager, workspaces, maxUploadSize)
+	solvesHandler := handlers.NewSolvesHandler(jobManager)
+	queueHandler := handlers.NewQueueHandler(limiter)
+	healthHandler := handlers.NewHealthHandler(pool)
+	solverPoolHandler := handlers.NewSolverPoolHandler(pool, adminToken)
+	wcsHandler := handlers.NewWCSHandler(maxUploadSize)
+	epochHandler := handlers.NewEpochHandler()
+	// nova's numeric submission IDs are kept next to the jobs they map to
+	novaStore, err := store.Open(filepath.Join(jobStoreDir, "nova"))
+	if err != nil {
+		log.Fatalf("Failed to open nova submission store: %v", err)
+	}
+	novaHandler, err := handlers.NewNovaHandler(jobManager, workspaces, novaStore, maxUploadSize, novaAPIKey)
+	if err != nil {
+		log.Fatalf("Failed to load nova submissions: %v", err)
+	}
 
 	// Setup router
 	mux := http.NewServeMux()
 	mux.Handle("/solve", middleware.Logger(middleware.CORS(solveHandler)))
+	mux.Handle("/solve/batch", middleware.Logger(middleware.CORS(batchHandler)))
+	mux.Handle("/jobs", middleware.Logger(middleware.CORS(jobsHandler)))
+	mux.Handle("/jobs/", middleware.Logger(middleware.CORS(jobsHandler)))
+	mux.Handle("/solves/", middleware.Logger(middleware.CORS(solvesHandler)))
+	mux.Handle("/queue", middleware.Logger(middleware.CORS(queueHandler)))
 	mux.Handle("/analyse", middleware.Logger(middleware.CORS(analyseHandler)))
+	mux.Handle("/wcs/", middleware.Logger(middleware.CORS(wcsHandler)))
+	mux.Handle("/epoch", middleware.Logger(middleware.CORS(epochHandler)))
 	mux.Handle("/health", middleware.Logger(healthHandler))
+	// The admin endpoints run health checks on every solver, so they are only
+	// served with a token to guard them
+	if adminToken != "" {
+		mux.Handle("/admin/solvers", middleware.Logger(solverPoolHandler))
+	} else {
+		log.Printf("ADMIN_TOKEN is not set: /admin/solvers is disabled")
+	}
+
+	// nova.astrometry.net compatible API for clients such as KStars/Ekos and NINA
+	mux.Handle("/api/", middleware.Logger(middleware.CORS(novaHandler)))
+	mux.Handle("/wcs_file/", middleware.Logger(middleware.CORS(novaHandler)))
 
 	// Swagger UI
 	mux.Handle("/swagger/", httpSwagger.WrapHandler)
@@ -81,7 +252,7 @@ func main() {
 		Addr:         ":" + port,
 		Handler:      mux,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Minute, // Long timeout for solving
+		WriteTimeout: 10 * time.Minute, // Solve handlers move it on before writing, as a solve may take longer
 		IdleTimeout:  60 * time.Second,
 	}
 
@@ -89,7 +260,17 @@ func main() {
 	go func() {
 		log.Printf("Starting Astrometry API Server on port %s", port)
 		log.Printf("Using index path: %s", indexPath)
-		log.Printf("Using docker exec mode with container: %s", containerName)
+		for _, target := range targets {
+			log.Printf("Using %s solver: %s", target.Backend.Name(), target.Name)
+		}
+		log.Printf("Max concurrent solves: %d, solve queue size: %d", maxConcurrentSolves, solveQueueSize)
+		log.Printf("Job workers: %d, job queue size: %d", jobWorkers, jobQueueSize)
+		log.Printf("Job store: %s (retention %v)", jobStoreDir, jobRetention)
+		if cacheSize > 0 {
+			log.Printf("Result cache: %d entries (TTL %v)", cacheSize, cacheTTL)
+		} else {
+			log.Printf("Result cache disabled")
+		}
 		log.Printf("Swagger UI available at: http://localhost:%s/swagger/", port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
@@ -110,6 +291,11 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	if err := jobManager.Stop(ctx); err != nil {
+		log.Printf("Job workers did not stop cleanly: %v", err)
+	}
+	workspaces.Close()
+
 	log.Println("Server exited")
 }
 
@@ -119,3 +305,23 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+		log.Printf("Ignoring invalid %s=%q, using %d", key, value, fallback)
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		log.Printf("Ignoring invalid %s=%q, using %v", key, value, fallback)
+	}
+	return fallback
+}
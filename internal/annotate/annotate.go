@@ -0,0 +1,415 @@
+// Package annotate draws an RA/Dec grid, constellation figures and deep-sky
+// objects from the offline catalog onto a preview of a solved image
+package annotate
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/catalog"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/wcs"
+)
+
+// Layers that can be drawn
+const (
+	LayerGrid           = "grid"
+	LayerConstellations = "constellations"
+	LayerDSO            = "dso"
+)
+
+// Layers lists every layer, in the order they are drawn
+var Layers = []string{LayerGrid, LayerConstellations, LayerDSO}
+
+// DefaultFontSize is the default height of labels in preview pixels
+const DefaultFontSize = 14
+
+// Options select what is drawn
+type Options struct {
+	// Layers to draw; all of them if empty
+	Layers []string
+	// FontSize is the height of labels in preview pixels
+	FontSize float64
+}
+
+var (
+	gridColor          = color.NRGBA{R: 120, G: 170, B: 255, A: 150}
+	constellationColor = color.NRGBA{R: 255, G: 200, B: 80, A: 190}
+	objectColor        = color.NRGBA{R: 120, G: 255, B: 150, A: 230}
+)
+
+// minObjectRadius is the radius in preview pixels of the circle around an
+// object too small to see
+const minObjectRadius = 6
+
+// Annotation is a preview with the shapes drawn on it
+type Annotation struct {
+	preview  *Preview
+	fontSize float64
+	paths    []path
+	circles  []circle
+	labels   []label
+}
+
+// path is a line through a series of points
+type path struct {
+	points []point
+	color  color.NRGBA
+}
+
+type circle struct {
+	center point
+	radius float64
+	color  color.NRGBA
+}
+
+// label is text whose baseline starts at a point
+type label struct {
+	text  string
+	at    point
+	color color.NRGBA
+}
+
+// field is the part of the sky on a preview
+type field struct {
+	preview *Preview
+	wcs     *wcs.WCS
+	// centerRA, centerDec and radius are the image's center and the distance
+	// to its furthest corner in degrees
+	centerRA, centerDec, radius float64
+	// scale is the size of a degree in preview pixels
+	scale float64
+	// width and height are the preview's size
+	width, height float64
+}
+
+// New draws the selected layers onto a preview, using the WCS of its solve
+func New(preview *Preview, w *wcs.WCS, opts Options) *Annotation {
+	a := &Annotation{preview: preview, fontSize: opts.FontSize}
+	if a.fontSize <= 0 {
+		a.fontSize = DefaultFontSize
+	}
+	layers := opts.Layers
+	if len(layers) == 0 {
+		layers = Layers
+	}
+
+	bounds := preview.Image.Bounds()
+	f := &field{
+		preview: preview,
+		wcs:     w,
+		width:   float64(bounds.Dx()),
+		height:  float64(bounds.Dy()),
+		scale:   3600 / w.PixelScale() * float64(bounds.Dx()) / float64(preview.width),
+	}
+	f.centerRA, f.centerDec = w.PixelToSky(float64(preview.width)/2+0.5, float64(preview.height)/2+0.5)
+	for _, corner := range [][2]float64{{0.5, 0.5}, {float64(preview.width) + 0.5, 0.5}, {0.5, float64(preview.height) + 0.5}, {float64(preview.width) + 0.5, float64(preview.height) + 0.5}} {
+		ra, dec := w.PixelToSky(corner[0], corner[1])
+		f.radius = max(f.radius, wcs.Separation(f.centerRA, f.centerDec, ra, dec))
+	}
+
+	for _, layer := range Layers {
+		if !contains(layers, layer) {
+			continue
+		}
+		switch layer {
+		case LayerGrid:
+			a.drawGrid(f)
+		case LayerConstellations:
+			a.drawConstellations(f)
+		case LayerDSO:
+			a.drawObjects(f)
+		}
+	}
+	return a
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// project returns the preview position of a sky position. It is false for
+// positions the projection cannot show or that are far off the preview.
+func (f *field) project(ra, dec float64) (point, bool) {
+	x, y, ok := f.wcs.SkyToPixel(ra, dec)
+	if !ok {
+		return point{}, false
+	}
+	p := f.preview.point(x, y)
+	limit := 4 * max(f.width, f.height)
+	return p, math.Abs(p.X) < limit && math.Abs(p.Y) < limit
+}
+
+// inside reports whether a point is on the preview, less margin pixels
+func (f *field) inside(p point, margin float64) bool {
+	return p.X >= margin && p.Y >= margin && p.X <= f.width-margin && p.Y <= f.height-margin
+}
+
+// addLine adds the parts of a line through sky positions that project near
+// the preview, returning the projected points that are on it
+func (a *Annotation) addLine(f *field, positions [][2]float64, c color.NRGBA) []point {
+	var visible []point
+	var current []point
+	flush := func() {
+		if len(current) > 1 {
+			a.paths = append(a.paths, path{points: current, color: c})
+		}
+		current = nil
+	}
+	margin := -0.1 * max(f.width, f.height)
+	wasOutside := false
+	for _, position := range positions {
+		p, ok := f.project(position[0], position[1])
+		if !ok {
+			flush()
+			wasOutside = false
+			continue
+		}
+		if f.inside(p, 0) {
+			visible = append(visible, p)
+		}
+		// A line is cut where it runs well off the preview, keeping the
+		// points either side of the cut so it still reaches the edge
+		outside := !f.inside(p, margin)
+		if outside && wasOutside {
+			flush()
+		}
+		wasOutside = outside
+		current = append(current, p)
+	}
+	flush()
+	return visible
+}
+
+// addLabel adds a label, moved if needed so it stays on the preview
+func (a *Annotation) addLabel(f *field, text string, at point, c color.NRGBA) {
+	width := textWidth(text, a.fontSize)
+	at.X = min(max(at.X, 2), f.width-width-2)
+	at.Y = min(max(at.Y, a.fontSize+2), f.height-2)
+	a.labels = append(a.labels, label{text: text, at: at, color: c})
+}
+
+// Grid steps: Dec in arcseconds and RA in seconds of time
+var (
+	decSteps = []float64{1, 2, 5, 10, 15, 20, 30, 60, 120, 300, 600, 900, 1200, 1800, 3600, 7200, 18000, 36000, 54000, 72000, 108000}
+	raSteps  = []float64{1, 2, 5, 10, 15, 20, 30, 60, 120, 300, 600, 900, 1200, 1800, 3600, 7200, 10800}
+)
+
+// gridLines is about the number of grid lines across the field in each direction
+const gridLines = 5
+
+// niceStep returns the smallest step giving no more than about gridLines
+// lines over span
+func niceStep(span float64, steps []float64) float64 {
+	for _, step := range steps {
+		if step >= span/gridLines {
+			return step
+		}
+	}
+	return steps[len(steps)-1]
+}
+
+func (a *Annotation) drawGrid(f *field) {
+	// The RA and Dec ranges are found by sampling the image, with RA relative
+	// to the center so that it does not wrap
+	raMin, raMax, decMin, decMax := 0.0, 0.0, f.centerDec, f.centerDec
+	const samples = 10
+	for i := 0; i <= samples; i++ {
+		for j := 0; j <= samples; j++ {
+			x := 0.5 + float64(f.preview.width)*float64(i)/samples
+			y := 0.5 + float64(f.preview.height)*float64(j)/samples
+			ra, dec := f.wcs.PixelToSky(x, y)
+			offset := math.Remainder(ra-f.centerRA, 360)
+			raMin, raMax = min(raMin, offset), max(raMax, offset)
+			decMin, decMax = min(decMin, dec), max(decMax, dec)
+		}
+	}
+	for _, pole := range []float64{90, -90} {
+		if p, ok := f.project(0, pole); ok && f.inside(p, 0) {
+			raMin, raMax = -180, 180
+			decMin, decMax = min(decMin, pole), max(decMax, pole)
+		}
+	}
+
+	decStep := niceStep((decMax-decMin)*3600, decSteps) / 3600
+	raStep := niceStep((raMax-raMin)*240, raSteps) / 240
+	decMin, decMax = max(decMin-decStep, -90), min(decMax+decStep, 90)
+	raMin, raMax = max(raMin-raStep, -180), min(raMax+raStep, 180)
+
+	const points = 120
+	for dec := math.Ceil(decMin/decStep) * decStep; dec <= decMax; dec += decStep {
+		if math.Abs(dec) >= 90-1e-9 {
+			continue
+		}
+		positions := make([][2]float64, 0, points+1)
+		for i := 0; i <= points; i++ {
+			positions = append(positions, [2]float64{f.centerRA + raMin + (raMax-raMin)*float64(i)/points, dec})
+		}
+		visible := a.addLine(f, positions, gridColor)
+		if len(visible) > 0 {
+			// Dec is labelled at the line's leftmost point
+			leftmost := visible[0]
+			for _, p := range visible {
+				if p.X < leftmost.X {
+					leftmost = p
+				}
+			}
+			a.addLabel(f, formatDec(dec, decStep), point{leftmost.X + 3, leftmost.Y - 3}, gridColor)
+		}
+	}
+
+	start := math.Ceil((f.centerRA+raMin)/raStep) * raStep
+	for ra := start; ra <= f.centerRA+raMax && ra < start+360-1e-9; ra += raStep {
+		positions := make([][2]float64, 0, points+1)
+		for i := 0; i <= points; i++ {
+			positions = append(positions, [2]float64{ra, decMin + (decMax-decMin)*float64(i)/points})
+		}
+		visible := a.addLine(f, positions, gridColor)
+		if len(visible) > 0 {
+			// RA is labelled at the line's lowest point
+			lowest := visible[0]
+			for _, p := range visible {
+				if p.Y > lowest.Y {
+					lowest = p
+				}
+			}
+			a.addLabel(f, formatRA(ra, raStep), point{lowest.X + 3, lowest.Y - 3}, gridColor)
+		}
+	}
+}
+
+// formatRA formats an RA in degrees as hours, minutes and, for steps under a
+// minute of time, seconds
+func formatRA(ra, step float64) string {
+	seconds := int(math.Round(ra*240)) % 86400
+	if seconds < 0 {
+		seconds += 86400
+	}
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+	switch {
+	case step*240 >= 3600:
+		return fmt.Sprintf("%dh", h)
+	case step*240 >= 60:
+		return fmt.Sprintf("%dh%02dm", h, m)
+	}
+	return fmt.Sprintf("%dh%02dm%02ds", h, m, s)
+}
+
+// formatDec formats a Dec in degrees as degrees, arcminutes and, for steps
+// under an arcminute, arcseconds
+func formatDec(dec, step float64) string {
+	sign := "+"
+	if dec < 0 {
+		sign = "-"
+	}
+	arcseconds := int(math.Round(math.Abs(dec) * 3600))
+	d, m, s := arcseconds/3600, arcseconds/60%60, arcseconds%60
+	switch {
+	case step*3600 >= 3600:
+		return fmt.Sprintf("%s%d°", sign, d)
+	case step*3600 >= 60:
+		return fmt.Sprintf("%s%d°%02d'", sign, d, m)
+	}
+	return fmt.Sprintf("%s%d°%02d'%02d\"", sign, d, m, s)
+}
+
+func (a *Annotation) drawConstellations(f *field) {
+	// Lines are sampled finely enough to follow their curve on the image
+	spacing := max(f.radius/20, 1e-3)
+	for _, c := range catalog.Constellations() {
+		var stars []point
+		seen := make(map[*catalog.Star]bool)
+		for _, line := range c.Lines {
+			for i := 1; i < len(line); i++ {
+				from, to := line[i-1], line[i]
+				length := wcs.Separation(from.RA, from.Dec, to.RA, to.Dec)
+				nearest := min(wcs.Separation(f.centerRA, f.centerDec, from.RA, from.Dec), wcs.Separation(f.centerRA, f.centerDec, to.RA, to.Dec))
+				// Every point of the line is within half its length of an end
+				if nearest-length/2 > f.radius {
+					continue
+				}
+				n := min(max(int(math.Ceil(length/spacing)), 1), 500)
+				a.addLine(f, greatCircle(from.RA, from.Dec, to.RA, to.Dec, n), constellationColor)
+			}
+			for _, star := range line {
+				if seen[star] {
+					continue
+				}
+				seen[star] = true
+				if p, ok := f.project(star.RA, star.Dec); ok && f.inside(p, 0) {
+					stars = append(stars, p)
+				}
+			}
+		}
+		if len(stars) == 0 {
+			continue
+		}
+		// The name goes at the middle of the figure's stars on the image
+		var center point
+		for _, p := range stars {
+			center.X += p.X / float64(len(stars))
+			center.Y += p.Y / float64(len(stars))
+		}
+		a.addLabel(f, c.Name, point{center.X - textWidth(c.Name, a.fontSize)/2, center.Y + a.fontSize/2}, constellationColor)
+	}
+}
+
+// greatCircle returns n+1 positions evenly spaced along the great circle from
+// one position to another
+func greatCircle(ra1, dec1, ra2, dec2 float64, n int) [][2]float64 {
+	from, to := unitVector(ra1, dec1), unitVector(ra2, dec2)
+	angle := wcs.Separation(ra1, dec1, ra2, dec2) * math.Pi / 180
+	positions := make([][2]float64, 0, n+1)
+	for i := 0; i <= n; i++ {
+		t := float64(i) / float64(n)
+		a, b := 1-t, t
+		if s := math.Sin(angle); s > 1e-12 {
+			a, b = math.Sin((1-t)*angle)/s, math.Sin(t*angle)/s
+		}
+		v := [3]float64{a*from[0] + b*to[0], a*from[1] + b*to[1], a*from[2] + b*to[2]}
+		ra := math.Atan2(v[1], v[0]) * 180 / math.Pi
+		dec := math.Atan2(v[2], math.Hypot(v[0], v[1])) * 180 / math.Pi
+		positions = append(positions, [2]float64{ra, dec})
+	}
+	return positions
+}
+
+func unitVector(ra, dec float64) [3]float64 {
+	sinRA, cosRA := math.Sincos(ra * math.Pi / 180)
+	sinDec, cosDec := math.Sincos(dec * math.Pi / 180)
+	return [3]float64{cosDec * cosRA, cosDec * sinRA, sinDec}
+}
+
+func (a *Annotation) drawObjects(f *field) {
+	for _, o := range catalog.Objects() {
+		if wcs.Separation(f.centerRA, f.centerDec, o.RA, o.Dec) > f.radius+o.Size[0]/120 {
+			continue
+		}
+		center, ok := f.project(o.RA, o.Dec)
+		if !ok {
+			continue
+		}
+		radius := max(o.Size[0]/120*f.scale, minObjectRadius)
+		// Skip circles that miss the preview entirely
+		dx := max(-center.X, 0, center.X-f.width)
+		dy := max(-center.Y, 0, center.Y-f.height)
+		if math.Hypot(dx, dy) > radius {
+			continue
+		}
+		a.circles = append(a.circles, circle{center: center, radius: radius, color: objectColor})
+		if !f.inside(center, 0) {
+			continue
+		}
+		text := o.ID
+		if o.CommonName != "" {
+			text += " " + o.CommonName
+		}
+		offset := radius * math.Sqrt2 / 2
+		a.addLabel(f, text, point{center.X + offset + 2, center.Y - offset - 2}, objectColor)
+	}
+}
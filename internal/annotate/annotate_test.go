@@ -0,0 +1,233 @@
+package annotate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/wcs"
+)
+
+// testWCS returns the solution of an 800x600 image of Orion's belt and sword,
+// 6.4 degrees across with north up
+func testWCS(t *testing.T) *wcs.WCS {
+	t.Helper()
+	w, err := wcs.Parse(map[string]string{
+		"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN",
+		"CRVAL1": "84", "CRVAL2": "-3.5",
+		"CRPIX1": "400.5", "CRPIX2": "300.5",
+		"CD1_1": "-0.008", "CD1_2": "0", "CD2_1": "0", "CD2_2": "-0.008",
+		"IMAGEW": "800", "IMAGEH": "600",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return w
+}
+
+// testImage returns a dark noisy image with a few bright pixels
+func testImage() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 800, 600))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(10 + i*7919%11)
+	}
+	img.Pix[1234] = 250
+	return img
+}
+
+func TestNew(t *testing.T) {
+	preview := NewPreview(testImage(), 400)
+	if bounds := preview.Image.Bounds(); bounds.Dx() != 400 || bounds.Dy() != 300 {
+		t.Fatalf("expected a 400x300 preview, got %v", bounds)
+	}
+	a := New(preview, testWCS(t), Options{})
+
+	if len(a.paths) == 0 {
+		t.Error("expected grid and constellation lines")
+	}
+	texts := make(map[string]bool)
+	for _, l := range a.labels {
+		texts[l.text] = true
+	}
+	for _, want := range []string{"M42 Orion Nebula", "Orion", "-4°", "5h40m"} {
+		if !texts[want] {
+			t.Errorf("expected a %q label, got %v", want, texts)
+		}
+	}
+
+	// M42 is 85' across, so its circle has a radius of 42.5' at 0.016
+	// degrees per preview pixel
+	found := false
+	for _, c := range a.circles {
+		if c.center.X > 200 && c.center.X < 225 && c.center.Y > 255 && c.center.Y < 280 && math.Abs(c.radius-44.3) < 0.1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected a circle of radius 44.3 around M42")
+	}
+
+	a = New(preview, testWCS(t), Options{Layers: []string{LayerDSO}})
+	for _, l := range a.labels {
+		if l.text == "Orion" || strings.HasSuffix(l.text, "°") {
+			t.Errorf("expected only object labels, got %q", l.text)
+		}
+	}
+	if len(a.paths) != 0 {
+		t.Errorf("expected no lines without the grid and constellations, got %d", len(a.paths))
+	}
+}
+
+func TestAnnotation_WritePNG(t *testing.T) {
+	preview := NewPreview(testImage(), 400)
+	before := preview.Image.RGBAAt(100, 100)
+
+	var buf bytes.Buffer
+	if err := New(preview, testWCS(t), Options{FontSize: 10}).WritePNG(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+	if img.Bounds().Dx() != 400 || img.Bounds().Dy() != 300 {
+		t.Errorf("expected a 400x300 image, got %v", img.Bounds())
+	}
+	if preview.Image.RGBAAt(100, 100) != before {
+		t.Error("expected the preview to be left as it was")
+	}
+
+	// Some pixels take the colors of the annotations
+	colored := 0
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if r != g || g != b {
+				colored++
+			}
+		}
+	}
+	if colored < 1000 {
+		t.Errorf("expected annotations to be drawn, got %d colored pixels", colored)
+	}
+}
+
+func TestAnnotation_WriteSVG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New(NewPreview(testImage(), 400), testWCS(t), Options{}).WriteSVG(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	svg := buf.String()
+	for _, want := range []string{`width="400" height="300"`, "data:image/jpeg;base64,", "<polyline", "<circle", ">M42 Orion Nebula</text>"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("expected the SVG to contain %q", want)
+		}
+	}
+
+	decoder := xml.NewDecoder(&buf)
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("expected well-formed XML, got %v", err)
+		}
+	}
+}
+
+func TestNewPreview_Stretch(t *testing.T) {
+	// A faint linear image is brightened so its background is visible
+	img := image.NewGray16(image.Rect(0, 0, 100, 100))
+	for i := 0; i < 100*100; i++ {
+		img.SetGray16(i%100, i/100, color.Gray16{Y: uint16(1000 + i*7919%200)})
+	}
+	img.SetGray16(50, 50, color.Gray16{Y: 60000})
+
+	preview := NewPreview(img, 1600)
+	var sum float64
+	for i := 0; i < len(preview.Image.Pix); i += 4 {
+		sum += float64(preview.Image.Pix[i])
+	}
+	if mean := sum / (100 * 100) / 255; mean < 0.15 || mean > 0.4 {
+		t.Errorf("expected the background to be stretched to about %f, got %f", targetBackground, mean)
+	}
+	if preview.Image.Pix[4*(50*100+50)] != 255 {
+		t.Error("expected the brightest pixel to stay white")
+	}
+}
+
+// largePNGHeader returns a 1x1 PNG whose header says it is width x height
+// pixels
+func largePNGHeader(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := buf.Bytes()
+	// The IHDR chunk follows the 8 byte signature; its data starts at 16
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestDecodePreview_TooLarge(t *testing.T) {
+	_, err := DecodePreview(bytes.NewReader(largePNGHeader(t, 100000, 100000)), MaxPreviewSize)
+	var tooLarge *TooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Width != 100000 || tooLarge.Height != 100000 {
+		t.Errorf("expected a TooLargeError for 100000x100000 pixels, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	preview, err := DecodePreview(bytes.NewReader(buf.Bytes()), MaxPreviewSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bounds := preview.Image.Bounds(); bounds.Dx() != 800 || bounds.Dy() != 600 {
+		t.Errorf("expected an 800x600 preview, got %v", bounds)
+	}
+}
+
+func TestMTF(t *testing.T) {
+	m := 0.02
+	if got := mtf(mtf(targetBackground, m), m); math.Abs(got-targetBackground) > 1e-9 {
+		t.Errorf("expected the midtones balance to map %f to %f, got %f", m, targetBackground, got)
+	}
+	if mtf(0.3, 0) != 0 || mtf(0.3, 1) != 1 || mtf(0.3, 0.3) != 0.5 {
+		t.Error("expected mtf to map 0, m and 1 to 0, 0.5 and 1")
+	}
+}
+
+func TestFormatGridLabels(t *testing.T) {
+	if got := formatRA(83.75, 0.25); got != "5h35m" {
+		t.Errorf("expected 5h35m, got %s", got)
+	}
+	if got := formatRA(359.999, 15); got != "0h" {
+		t.Errorf("expected 0h, got %s", got)
+	}
+	if got := formatRA(10.0/240, 5.0/240); got != "0h00m10s" {
+		t.Errorf("expected 0h00m10s, got %s", got)
+	}
+	if got := formatDec(-5.5, 0.5); got != "-5°30'" {
+		t.Errorf("expected -5°30', got %s", got)
+	}
+	if got := formatDec(10, 5); got != "+10°" {
+		t.Errorf("expected +10°, got %s", got)
+	}
+	if got := formatDec(0.01, 10.0/3600); got != "+0°00'36\"" {
+		t.Errorf("expected +0°00'36\", got %s", got)
+	}
+}
@@ -0,0 +1,246 @@
+package annotate
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Register the JPEG decoder for DecodePreview
+	_ "image/png"  // Register the PNG decoder for DecodePreview
+	"io"
+	"math"
+	"slices"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/fits"
+)
+
+// MaxPreviewSize is the default size of the longer side of a preview in pixels
+const MaxPreviewSize = 1600
+
+// MaxPixels is the largest JPEG or PNG image DecodePreview decodes. The whole
+// image is held in memory while it is scaled down, at up to 8 bytes a pixel.
+const MaxPixels = 64_000_000
+
+// targetBackground is where a stretch puts the median of a dark image
+const targetBackground = 0.25
+
+// Preview is a scaled down, stretched copy of a solved image that annotations
+// are drawn on
+type Preview struct {
+	Image *image.RGBA
+	// width and height are the size of the solved image in pixels
+	width, height int
+	// flipY is set when the image's first row is its bottom row, as in FITS
+	flipY bool
+}
+
+// point is a position on a preview, in pixels from its top left corner
+type point struct {
+	X, Y float64
+}
+
+// point returns the position on the preview of the 1-based image pixel (x, y)
+func (p *Preview) point(x, y float64) point {
+	bounds := p.Image.Bounds()
+	sx := float64(bounds.Dx()) / float64(p.width)
+	sy := float64(bounds.Dy()) / float64(p.height)
+	if p.flipY {
+		return point{(x - 0.5) * sx, (float64(p.height) - y + 0.5) * sy}
+	}
+	return point{(x - 0.5) * sx, (y - 0.5) * sy}
+}
+
+// TooLargeError is returned for an image with more than MaxPixels pixels
+type TooLargeError struct {
+	Width, Height int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("image is %dx%d pixels, more than the limit of %d megapixels", e.Width, e.Height, MaxPixels/1_000_000)
+}
+
+// CheckSize reads the size of a JPEG or PNG image from its header and fails
+// with a TooLargeError if it is too large to decode
+func CheckSize(r io.Reader) error {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return &TooLargeError{config.Width, config.Height}
+	}
+	return nil
+}
+
+// DecodePreview decodes a JPEG or PNG image and makes a preview of it no
+// larger than maxSize pixels on either side. Its size is checked with
+// CheckSize before it is decoded.
+func DecodePreview(r io.ReadSeeker, maxSize int) (*Preview, error) {
+	if err := CheckSize(r); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewPreview(img, maxSize), nil
+}
+
+// NewPreview makes a preview of img no larger than maxSize pixels on either side
+func NewPreview(img image.Image, maxSize int) *Preview {
+	bounds := img.Bounds()
+	width, height := previewSize(bounds.Dx(), bounds.Dy(), maxSize)
+	r := newRaster(width, height)
+
+	rgba64, fast := img.(image.RGBA64Image)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * height / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var c color.RGBA64
+			if fast {
+				c = rgba64.RGBA64At(x, y)
+			} else {
+				red, green, blue, alpha := img.At(x, y).RGBA()
+				c = color.RGBA64{uint16(red), uint16(green), uint16(blue), uint16(alpha)}
+			}
+			r.add((x-bounds.Min.X)*width/bounds.Dx(), row, float32(c.R)/0xffff, float32(c.G)/0xffff, float32(c.B)/0xffff)
+		}
+	}
+	return &Preview{Image: r.stretch(), width: bounds.Dx(), height: bounds.Dy()}
+}
+
+// FITSPreview makes a preview of the image in HDU hdu of a FITS file no larger
+// than maxSize pixels on either side. Only the first plane of a data cube is
+// shown.
+func FITSPreview(f *fits.File, hdu, maxSize int) (*Preview, error) {
+	if hdu < 0 || hdu >= len(f.HDUs) {
+		return nil, fmt.Errorf("HDU %d does not exist", hdu)
+	}
+	naxis1, _ := f.HDUs[hdu].Header.Int("NAXIS1", 0)
+	naxis2, _ := f.HDUs[hdu].Header.Int("NAXIS2", 0)
+	// Only as many pixels are read as the preview can use
+	step := max(1, int(max(naxis1, naxis2))/(2*maxSize))
+	planeWidth, planeHeight, pixels, err := f.Image(hdu, step)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := previewSize(int(naxis1), int(naxis2), maxSize)
+	r := newRaster(width, height)
+	for y := range planeHeight {
+		// FITS images are stored bottom row first
+		row := height - 1 - y*height/planeHeight
+		for x := range planeWidth {
+			v := pixels[y*planeWidth+x]
+			if !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0) {
+				r.add(x*width/planeWidth, row, v, v, v)
+			}
+		}
+	}
+	return &Preview{Image: r.stretch(), width: int(naxis1), height: int(naxis2), flipY: true}, nil
+}
+
+// previewSize scales width and height down to fit within maxSize
+func previewSize(width, height, maxSize int) (int, int) {
+	if width <= maxSize && height <= maxSize {
+		return width, height
+	}
+	scale := float64(maxSize) / float64(max(width, height))
+	return max(1, int(math.Round(float64(width)*scale))), max(1, int(math.Round(float64(height)*scale)))
+}
+
+// raster is an RGB image with floating point values, where the pixels of an
+// image are summed to scale it down before it is stretched
+type raster struct {
+	width, height int
+	// pix holds the red, green and blue sums of each pixel, row by row
+	pix    []float32
+	counts []int32
+}
+
+func newRaster(width, height int) *raster {
+	return &raster{
+		width:  width,
+		height: height,
+		pix:    make([]float32, 3*width*height),
+		counts: make([]int32, width*height),
+	}
+}
+
+func (r *raster) add(x, y int, red, green, blue float32) {
+	i := y*r.width + x
+	r.pix[3*i] += red
+	r.pix[3*i+1] += green
+	r.pix[3*i+2] += blue
+	r.counts[i]++
+}
+
+// stretch averages the summed pixels and maps them to 8 bits. The black point
+// is set just below the background and the white point at the brightest
+// pixels, and a dark image is brightened with a midtones transfer function
+// until its median is at targetBackground.
+func (r *raster) stretch() *image.RGBA {
+	for i, n := range r.counts {
+		if n > 1 {
+			for c := range 3 {
+				r.pix[3*i+c] /= float32(n)
+			}
+		}
+	}
+
+	// Statistics of the luminance, from a sample of at most about 100000 pixels
+	step := max(1, len(r.counts)/100000)
+	var samples []float64
+	for i := 0; i < len(r.counts); i += step {
+		if r.counts[i] > 0 {
+			samples = append(samples, float64(r.pix[3*i]+r.pix[3*i+1]+r.pix[3*i+2])/3)
+		}
+	}
+	black, white, midtones := 0.0, 1.0, 0.5
+	if len(samples) > 0 {
+		slices.Sort(samples)
+		median := samples[len(samples)/2]
+		deviations := make([]float64, len(samples))
+		for i, s := range samples {
+			deviations[i] = math.Abs(s - median)
+		}
+		slices.Sort(deviations)
+		mad := deviations[len(deviations)/2] * 1.4826
+
+		black = max(samples[0], median-2.8*mad)
+		white = samples[min(len(samples)-1, len(samples)*9999/10000)]
+		if white <= black {
+			white = black + 1
+		}
+		if m := (median - black) / (white - black); m > 0 && m < targetBackground {
+			midtones = mtf(targetBackground, m)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, r.width, r.height))
+	for i := range r.counts {
+		for c := range 3 {
+			v := (float64(r.pix[3*i+c]) - black) / (white - black)
+			v = mtf(midtones, min(max(v, 0), 1))
+			img.Pix[4*i+c] = uint8(math.Round(v * 255))
+		}
+		img.Pix[4*i+3] = 0xff
+	}
+	return img
+}
+
+// mtf is the midtones transfer function with midtones balance m, which maps 0
+// to 0, m to 0.5 and 1 to 1
+func mtf(m, x float64) float64 {
+	switch {
+	case x <= 0:
+		return 0
+	case x >= 1:
+		return 1
+	case m == 0.5:
+		return x
+	}
+	return (m - 1) * x / ((2*m-1)*x - m)
+}
@@ -0,0 +1,213 @@
+package annotate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+)
+
+// lineWidth is the width of lines in preview pixels
+const lineWidth = 1.5
+
+// shadowColor is drawn behind labels to keep them readable on bright areas
+var shadowColor = color.NRGBA{A: 160}
+
+// WritePNG writes the annotated preview as a PNG image
+func (a *Annotation) WritePNG(w io.Writer) error {
+	img := image.NewRGBA(a.preview.Image.Bounds())
+	draw.Draw(img, img.Bounds(), a.preview.Image, image.Point{}, draw.Src)
+	c := &canvas{img: img, mask: make([]float32, len(img.Pix)/4)}
+
+	for _, p := range a.paths {
+		for i := 1; i < len(p.points); i++ {
+			c.segment(p.points[i-1], p.points[i])
+		}
+		c.fill(p.color)
+	}
+	for _, circle := range a.circles {
+		c.ring(circle.center, circle.radius)
+		c.fill(circle.color)
+	}
+	for _, l := range a.labels {
+		// The shadow is offset down and right by a font pixel
+		shift := a.fontSize / glyphHeight
+		c.text(l.text, point{l.at.X + shift, l.at.Y + shift}, a.fontSize)
+		c.fill(shadowColor)
+		c.text(l.text, l.at, a.fontSize)
+		c.fill(l.color)
+	}
+	return png.Encode(w, img)
+}
+
+// canvas draws antialiased shapes onto an image. Shapes are first drawn into a
+// coverage mask and then filled with a color, so overlapping parts of a shape
+// are not blended twice.
+type canvas struct {
+	img  *image.RGBA
+	mask []float32
+	// dirty is the part of the mask that has been drawn into
+	dirty image.Rectangle
+}
+
+// cover raises the mask's coverage over the pixels near a shape with the
+// given bounds, using coverage to find each pixel's coverage from its center
+func (c *canvas) cover(bounds image.Rectangle, coverage func(x, y float64) float64, add bool) {
+	bounds = bounds.Intersect(c.img.Bounds())
+	c.dirty = c.dirty.Union(bounds)
+	width := c.img.Bounds().Dx()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			v := float32(min(max(coverage(float64(x)+0.5, float64(y)+0.5), 0), 1))
+			i := y*width + x
+			if add {
+				c.mask[i] = min(c.mask[i]+v, 1)
+			} else {
+				c.mask[i] = max(c.mask[i], v)
+			}
+		}
+	}
+}
+
+// fill blends col over the image where the mask covers it, and clears the mask
+func (c *canvas) fill(col color.NRGBA) {
+	width := c.img.Bounds().Dx()
+	for y := c.dirty.Min.Y; y < c.dirty.Max.Y; y++ {
+		for x := c.dirty.Min.X; x < c.dirty.Max.X; x++ {
+			i := y*width + x
+			if c.mask[i] == 0 {
+				continue
+			}
+			alpha := float32(col.A) / 0xff * c.mask[i]
+			pix := c.img.Pix[4*i : 4*i+3]
+			for j, v := range [3]uint8{col.R, col.G, col.B} {
+				pix[j] = uint8(float32(pix[j])*(1-alpha) + float32(v)*alpha + 0.5)
+			}
+			c.mask[i] = 0
+		}
+	}
+	c.dirty = image.Rectangle{}
+}
+
+// segment covers a line from p to q
+func (c *canvas) segment(p, q point) {
+	bounds := image.Rect(
+		int(math.Floor(min(p.X, q.X)-lineWidth)), int(math.Floor(min(p.Y, q.Y)-lineWidth)),
+		int(math.Ceil(max(p.X, q.X)+lineWidth)), int(math.Ceil(max(p.Y, q.Y)+lineWidth)),
+	)
+	dx, dy := q.X-p.X, q.Y-p.Y
+	length2 := dx*dx + dy*dy
+	c.cover(bounds, func(x, y float64) float64 {
+		t := 0.0
+		if length2 > 0 {
+			t = min(max(((x-p.X)*dx+(y-p.Y)*dy)/length2, 0), 1)
+		}
+		distance := math.Hypot(x-p.X-t*dx, y-p.Y-t*dy)
+		return lineWidth/2 + 0.5 - distance
+	}, false)
+}
+
+// ring covers a circle's outline
+func (c *canvas) ring(center point, radius float64) {
+	r := radius + lineWidth
+	bounds := image.Rect(int(math.Floor(center.X-r)), int(math.Floor(center.Y-r)), int(math.Ceil(center.X+r)), int(math.Ceil(center.Y+r)))
+	c.cover(bounds, func(x, y float64) float64 {
+		distance := math.Abs(math.Hypot(x-center.X, y-center.Y) - radius)
+		return lineWidth/2 + 0.5 - distance
+	}, false)
+}
+
+// text covers text in the bitmap font, scaled so capitals are size pixels
+// tall, with its baseline starting at at
+func (c *canvas) text(text string, at point, size float64) {
+	scale := size / glyphHeight
+	x := at.X
+	for _, r := range text {
+		g := glyph(r)
+		for row, bits := range g {
+			for col := range glyphWidth {
+				if bits&(1<<(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				left := x + float64(col)*scale
+				top := at.Y - size + float64(row)*scale
+				c.rect(left, top, left+scale, top+scale)
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
+
+// rect covers a rectangle, partly covering the pixels on its edges
+func (c *canvas) rect(x0, y0, x1, y1 float64) {
+	bounds := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+	c.cover(bounds, func(x, y float64) float64 {
+		w := min(x+0.5, x1) - max(x-0.5, x0)
+		h := min(y+0.5, y1) - max(y-0.5, y0)
+		return max(w, 0) * max(h, 0)
+	}, true)
+}
+
+// WriteSVG writes the annotated preview as an SVG image, with the preview
+// embedded as a JPEG and the annotations as vector shapes and text
+func (a *Annotation) WriteSVG(w io.Writer) error {
+	var preview bytes.Buffer
+	if err := jpeg.Encode(&preview, a.preview.Image, &jpeg.Options{Quality: 90}); err != nil {
+		return err
+	}
+	bounds := a.preview.Image.Bounds()
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", bounds.Dx(), bounds.Dy(), bounds.Dx(), bounds.Dy())
+	fmt.Fprintf(out, `<image width="%d" height="%d" href="data:image/jpeg;base64,%s"/>`+"\n", bounds.Dx(), bounds.Dy(), base64.StdEncoding.EncodeToString(preview.Bytes()))
+
+	fmt.Fprintf(out, `<g fill="none" stroke-width="%s" stroke-linejoin="round">`+"\n", formatNumber(lineWidth))
+	for _, p := range a.paths {
+		fmt.Fprintf(out, `<polyline %s points="`, svgStroke(p.color))
+		for i, pt := range p.points {
+			if i > 0 {
+				out.WriteByte(' ')
+			}
+			fmt.Fprintf(out, "%s,%s", formatNumber(pt.X), formatNumber(pt.Y))
+		}
+		fmt.Fprintf(out, `"/>`+"\n")
+	}
+	for _, c := range a.circles {
+		fmt.Fprintf(out, `<circle %s cx="%s" cy="%s" r="%s"/>`+"\n", svgStroke(c.color), formatNumber(c.center.X), formatNumber(c.center.Y), formatNumber(c.radius))
+	}
+	fmt.Fprintf(out, "</g>\n")
+
+	// Text is sized so capitals are about fontSize pixels tall, as in the PNG
+	fmt.Fprintf(out, `<g font-family="sans-serif" font-size="%s" paint-order="stroke" stroke="#000" stroke-opacity="%s" stroke-width="%s">`+"\n",
+		formatNumber(a.fontSize/0.72), formatNumber(float64(shadowColor.A)/0xff), formatNumber(a.fontSize/5))
+	for _, l := range a.labels {
+		fmt.Fprintf(out, `<text x="%s" y="%s" fill="%s">`, formatNumber(l.at.X), formatNumber(l.at.Y), svgColor(l.color))
+		xml.EscapeText(out, []byte(l.text)) //nolint:errcheck // Errors are reported by Flush
+		fmt.Fprintf(out, "</text>\n")
+	}
+	fmt.Fprintf(out, "</g>\n</svg>\n")
+	return out.Flush()
+}
+
+func svgStroke(c color.NRGBA) string {
+	return fmt.Sprintf(`stroke="%s" stroke-opacity="%s"`, svgColor(c), formatNumber(float64(c.A)/0xff))
+}
+
+func svgColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// formatNumber formats a coordinate to a tenth of a pixel
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
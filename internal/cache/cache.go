@@ -0,0 +1,174 @@
+// Package cache keeps recent solve results, keyed by the content of the solved
+// image and the options it was solved with
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// Cache is an in-memory LRU of solve results whose entries also expire after a TTL
+type Cache struct {
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	result  *client.Result
+	expires time.Time
+}
+
+// New creates a cache holding up to maxEntries results, each for at most ttl.
+// A ttl of 0 keeps results until they are evicted to make room.
+func New(maxEntries int, ttl time.Duration) *Cache {
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &Cache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns a copy of the result cached under key
+func (c *Cache) Get(key string) (*client.Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.expired(e) {
+		c.removeLocked(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	result := *e.result
+	return &result, true
+}
+
+// Put caches a copy of result under key, evicting the least recently used
+// results if the cache is full
+func (c *Cache) Put(key string, result *client.Result) {
+	stored := *result
+	e := &entry{key: key, result: &stored}
+	if c.ttl > 0 {
+		e.expires = c.now().Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(e)
+
+	// Expired entries go first, then the least recently used
+	for el := c.order.Back(); el != nil && c.order.Len() > c.maxEntries; {
+		prev := el.Prev()
+		if c.expired(el.Value.(*entry)) {
+			c.removeLocked(el)
+		}
+		el = prev
+	}
+	for c.order.Len() > c.maxEntries {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+// Len returns the number of cached results, including expired ones not yet evicted
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Cache) expired(e *entry) bool {
+	return !e.expires.IsZero() && !c.now().Before(e.expires)
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+// keyOptions are the solve options that can change a solve's result, normalized
+// so that equivalent requests share a key
+type keyOptions struct {
+	ScaleLow         float64 `json:"scale_low"`
+	ScaleHigh        float64 `json:"scale_high"`
+	ScaleUnits       string  `json:"scale_units"`
+	DownsampleFactor int     `json:"downsample_factor"`
+	DepthLow         int     `json:"depth_low"`
+	DepthHigh        int     `json:"depth_high"`
+	RA               float64 `json:"ra"`
+	Dec              float64 `json:"dec"`
+	Radius           float64 `json:"radius"`
+}
+
+// Key returns the cache key for solving the image at imagePath with opts: a hex
+// SHA-256 over the image's bytes and the normalized options
+func Key(imagePath string, opts *client.SolveOptions) (string, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %w", err)
+	}
+	defer f.Close() //nolint:errcheck // Error from Close on read is not critical
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+	normalized, err := json.Marshal(normalize(opts))
+	if err != nil {
+		return "", err
+	}
+	h.Write([]byte{0})
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func normalize(opts *client.SolveOptions) keyOptions {
+	if opts == nil {
+		opts = client.DefaultSolveOptions()
+	}
+	k := keyOptions{
+		DownsampleFactor: opts.DownsampleFactor,
+		DepthLow:         opts.DepthLow,
+		DepthHigh:        opts.DepthHigh,
+		RA:               opts.RA,
+		Dec:              opts.Dec,
+		Radius:           opts.Radius,
+	}
+	// Scale units only matter when there is a scale
+	if opts.ScaleLow != 0 || opts.ScaleHigh != 0 {
+		k.ScaleLow = opts.ScaleLow
+		k.ScaleHigh = opts.ScaleHigh
+		k.ScaleUnits = strings.ToLower(strings.TrimSpace(opts.ScaleUnits))
+	}
+	return k
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(2, 0)
+	c.Put("a", &client.Result{RA: 1})
+	c.Put("b", &client.Result{RA: 2})
+
+	// Using a makes b the least recently used
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	c.Put("c", &client.Result{RA: 3})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for key, ra := range map[string]float64{"a": 1, "c": 3} {
+		result, ok := c.Get(key)
+		if !ok || result.RA != ra {
+			t.Errorf("expected %s to be cached with RA %v, got %+v", key, ra, result)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestCache_Expires(t *testing.T) {
+	now := time.Now()
+	c := New(2, time.Hour)
+	c.now = func() time.Time { return now }
+
+	c.Put("a", &client.Result{RA: 1})
+	now = now.Add(30 * time.Minute)
+	c.Put("b", &client.Result{RA: 2})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached before its TTL")
+	}
+
+	// a has expired, so it makes room for c even though b is older in use
+	now = now.Add(45 * time.Minute)
+	c.Put("c", &client.Result{RA: 3})
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected a to have expired")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error("expected b to be cached")
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to have expired")
+	}
+}
+
+func TestCache_ReturnsCopies(t *testing.T) {
+	c := New(1, 0)
+	result := &client.Result{RA: 1}
+	c.Put("a", result)
+	result.RA = 2
+
+	cached, _ := c.Get("a")
+	cached.RA = 3
+	if again, _ := c.Get("a"); again.RA != 1 {
+		t.Errorf("expected cached RA 1, got %v", again.RA)
+	}
+}
+
+func TestKey(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	key := func(path string, opts *client.SolveOptions) string {
+		k, err := Key(path, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return k
+	}
+
+	a := write("a.fits", "image")
+	copyOfA := write("copy.fits", "image")
+	b := write("b.fits", "other image")
+	defaults := client.DefaultSolveOptions()
+
+	if key(a, defaults) != key(copyOfA, defaults) {
+		t.Error("expected identical images to share a key regardless of name")
+	}
+	if key(a, defaults) == key(b, defaults) {
+		t.Error("expected different images to have different keys")
+	}
+
+	otherUnits := client.DefaultSolveOptions()
+	otherUnits.ScaleUnits = "degwidth"
+	if key(a, defaults) != key(a, otherUnits) {
+		t.Error("expected scale units to be ignored without a scale")
+	}
+
+	scaled := client.DefaultSolveOptions()
+	scaled.ScaleLow, scaled.ScaleHigh = 1, 2
+	if key(a, defaults) == key(a, scaled) {
+		t.Error("expected a scale to change the key")
+	}
+
+	debug := client.DefaultSolveOptions()
+	debug.KeepTempFiles = true
+	if key(a, defaults) != key(a, debug) {
+		t.Error("expected keep_temp_files to be ignored")
+	}
+
+	if _, err := Key(filepath.Join(dir, "missing.fits"), defaults); err == nil {
+		t.Error("expected an error for a missing image")
+	}
+}
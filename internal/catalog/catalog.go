@@ -0,0 +1,239 @@
+// Package catalog is an offline catalog of deep-sky objects, bright stars and
+// constellation figures, embedded in the binary
+package catalog
+
+import (
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed data
+var data embed.FS
+
+// Object is a deep-sky object
+type Object struct {
+	// ID is the object's Messier or Caldwell number, or else its main designation
+	ID string
+	// Names are the object's other designations, such as its NGC number
+	Names []string
+	Type  string
+	// RA and Dec are the J2000 position in degrees
+	RA  float64
+	Dec float64
+	// Mag is the visual magnitude, or 0 if it is not known
+	Mag float64
+	// Size is the extent along the major and minor axes in arcminutes
+	Size          [2]float64
+	Constellation string
+	CommonName    string
+}
+
+// Star is a bright star
+type Star struct {
+	// Designation is the Bayer or Flamsteed designation, such as "alf Ori"
+	Designation string
+	Name        string
+	// RA and Dec are the J2000 position in degrees
+	RA            float64
+	Dec           float64
+	Mag           float64
+	Constellation string
+}
+
+// Constellation is a constellation with the lines of its figure
+type Constellation struct {
+	// Abbr is the IAU abbreviation, such as "Ori"
+	Abbr string
+	Name string
+	// Lines are polylines joining the figure's stars
+	Lines [][]*Star
+}
+
+var (
+	objects        = sync.OnceValue(func() []Object { return mergeObjects(must(loadObjects()), openNGC) })
+	stars          = sync.OnceValue(func() []Star { return must(loadStars()) })
+	constellations = sync.OnceValue(func() []Constellation { return must(loadConstellations(stars())) })
+)
+
+// Objects returns the deep-sky objects of the catalog: every Messier and
+// Caldwell object and a few dozen other objects popular with
+// astrophotographers, followed by the NGC and IC objects of LoadOpenNGC.
+// Without LoadOpenNGC most NGC and IC objects are not in it.
+func Objects() []Object {
+	return objects()
+}
+
+// StarsCompleteMag is the magnitude Stars is complete to. Most fainter stars
+// are not in it.
+const StarsCompleteMag = 3.0
+
+// Stars returns the bright stars of the catalog: the stars of the
+// constellation figures and other named stars. It is complete to
+// StarsCompleteMag but not much beyond.
+func Stars() []Star {
+	return stars()
+}
+
+// Constellations returns the 88 constellations with their figures
+func Constellations() []Constellation {
+	return constellations()
+}
+
+// must panics on an error loading the embedded data, which the tests catch
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic("catalog: " + err.Error())
+	}
+	return v
+}
+
+// readCSV reads an embedded data file, checking every record has n fields
+func readCSV(name string, n int) ([][]string, error) {
+	f, err := data.Open("data/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck // Error from Close on read is not critical
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = n
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return records, nil
+}
+
+func loadObjects() ([]Object, error) {
+	records, err := readCSV("dso.csv", 9)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Object, 0, len(records))
+	for _, record := range records {
+		o := Object{ID: record[0], Type: record[2], Constellation: record[7], CommonName: record[8]}
+		if record[1] != "" {
+			o.Names = strings.Split(record[1], ";")
+		}
+		if o.RA, err = parseSexagesimal(record[3]); err != nil {
+			return nil, fmt.Errorf("dso.csv: %s: RA: %w", o.ID, err)
+		}
+		o.RA *= 15
+		if o.Dec, err = parseSexagesimal(record[4]); err != nil {
+			return nil, fmt.Errorf("dso.csv: %s: Dec: %w", o.ID, err)
+		}
+		if record[5] != "" {
+			if o.Mag, err = strconv.ParseFloat(record[5], 64); err != nil {
+				return nil, fmt.Errorf("dso.csv: %s: magnitude: %w", o.ID, err)
+			}
+		}
+		if o.Size, err = parseSize(record[6]); err != nil {
+			return nil, fmt.Errorf("dso.csv: %s: size: %w", o.ID, err)
+		}
+		result = append(result, o)
+	}
+	return result, nil
+}
+
+func loadStars() ([]Star, error) {
+	records, err := readCSV("stars.csv", 5)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Star, 0, len(records))
+	for _, record := range records {
+		s := Star{Designation: record[0], Name: record[1]}
+		_, s.Constellation, _ = strings.Cut(s.Designation, " ")
+		if s.RA, err = parseSexagesimal(record[2]); err != nil {
+			return nil, fmt.Errorf("stars.csv: %s: RA: %w", s.Designation, err)
+		}
+		s.RA *= 15
+		if s.Dec, err = parseSexagesimal(record[3]); err != nil {
+			return nil, fmt.Errorf("stars.csv: %s: Dec: %w", s.Designation, err)
+		}
+		if s.Mag, err = strconv.ParseFloat(record[4], 64); err != nil {
+			return nil, fmt.Errorf("stars.csv: %s: magnitude: %w", s.Designation, err)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func loadConstellations(stars []Star) ([]Constellation, error) {
+	records, err := readCSV("constellations.csv", 3)
+	if err != nil {
+		return nil, err
+	}
+	byDesignation := make(map[string]*Star, len(stars))
+	for i := range stars {
+		byDesignation[stars[i].Designation] = &stars[i]
+	}
+
+	result := make([]Constellation, 0, len(records))
+	for _, record := range records {
+		c := Constellation{Abbr: record[0], Name: record[1]}
+		for _, line := range strings.Split(record[2], ";") {
+			var polyline []*Star
+			for _, designation := range strings.Split(line, "-") {
+				if !strings.Contains(designation, " ") {
+					designation += " " + c.Abbr
+				}
+				star, ok := byDesignation[designation]
+				if !ok {
+					return nil, fmt.Errorf("constellations.csv: %s: unknown star %q", c.Abbr, designation)
+				}
+				polyline = append(polyline, star)
+			}
+			c.Lines = append(c.Lines, polyline)
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// parseSexagesimal parses a signed value of colon-separated units, minutes and
+// optionally seconds, such as "-05:23" or "05:35:17.3"
+func parseSexagesimal(s string) (float64, error) {
+	sign := 1.0
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign, s = -1, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	value := 0.0
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i > 0 && n >= 60) {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		value += n / []float64{1, 60, 3600}[i]
+	}
+	return sign * value, nil
+}
+
+// parseSize parses "major x minor" or, for a round object, a single size
+func parseSize(s string) ([2]float64, error) {
+	major, minor, ok := strings.Cut(s, "x")
+	if !ok {
+		minor = major
+	}
+	a, err := strconv.ParseFloat(major, 64)
+	if err != nil {
+		return [2]float64{}, err
+	}
+	b, err := strconv.ParseFloat(minor, 64)
+	if err != nil {
+		return [2]float64{}, err
+	}
+	return [2]float64{a, b}, nil
+}
@@ -0,0 +1,97 @@
+package catalog
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestObjects(t *testing.T) {
+	objects := Objects()
+	ids := make(map[string]bool)
+	for _, o := range objects {
+		if ids[o.ID] {
+			t.Errorf("duplicate object %s", o.ID)
+		}
+		ids[o.ID] = true
+		if o.RA < 0 || o.RA >= 360 || o.Dec < -90 || o.Dec > 90 {
+			t.Errorf("%s: position %f %f out of range", o.ID, o.RA, o.Dec)
+		}
+		if o.Size[0] <= 0 || o.Size[1] <= 0 {
+			t.Errorf("%s: expected a size, got %v", o.ID, o.Size)
+		}
+	}
+	for i := 1; i <= 110; i++ {
+		if id := "M" + strconv.Itoa(i); !ids[id] {
+			t.Errorf("expected %s in the catalog", id)
+		}
+	}
+	for i := 1; i <= 109; i++ {
+		if id := "C" + strconv.Itoa(i); !ids[id] {
+			t.Errorf("expected %s in the catalog", id)
+		}
+	}
+
+	for _, o := range objects {
+		if o.ID != "M42" {
+			continue
+		}
+		if math.Abs(o.RA-83.825) > 1e-9 || math.Abs(o.Dec+5.383333) > 1e-6 {
+			t.Errorf("expected M42 at 83.825 -5.383333, got %f %f", o.RA, o.Dec)
+		}
+		if len(o.Names) != 1 || o.Names[0] != "NGC 1976" || o.CommonName != "Orion Nebula" {
+			t.Errorf("expected M42 to be NGC 1976, the Orion Nebula, got %v %s", o.Names, o.CommonName)
+		}
+		if o.Size != [2]float64{85, 60} {
+			t.Errorf("expected size 85x60, got %v", o.Size)
+		}
+	}
+}
+
+func TestConstellations(t *testing.T) {
+	constellations := Constellations()
+	if len(constellations) != 88 {
+		t.Errorf("expected 88 constellations, got %d", len(constellations))
+	}
+	for _, c := range constellations {
+		for _, line := range c.Lines {
+			if len(line) < 2 {
+				t.Errorf("%s: expected lines of two or more stars", c.Abbr)
+			}
+		}
+		if c.Abbr == "Ori" && c.Lines[0][0].Name != "Betelgeuse" {
+			t.Errorf("expected Orion to start at Betelgeuse, got %s", c.Lines[0][0].Designation)
+		}
+	}
+}
+
+func TestStars(t *testing.T) {
+	for _, s := range Stars() {
+		if s.Constellation == "" || s.Mag > 6 {
+			t.Errorf("%s: unexpected constellation %q or magnitude %f", s.Designation, s.Constellation, s.Mag)
+		}
+		if s.Name == "Sirius" && (math.Abs(s.RA-101.287) > 0.001 || math.Abs(s.Dec+16.716) > 0.001) {
+			t.Errorf("expected Sirius at 101.287 -16.716, got %f %f", s.RA, s.Dec)
+		}
+	}
+}
+
+func TestParseSexagesimal(t *testing.T) {
+	tests := map[string]float64{
+		"05:30":      5.5,
+		"-00:30":     -0.5,
+		"+10:30:36":  10.51,
+		"12:00:00.0": 12,
+	}
+	for input, want := range tests {
+		got, err := parseSexagesimal(input)
+		if err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: expected %f, got %f (%v)", input, want, got, err)
+		}
+	}
+	for _, input := range []string{"", "5:60", "1:2:3:4", "a:b"} {
+		if _, err := parseSexagesimal(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// openNGC holds the objects loaded by LoadOpenNGC, added to the embedded ones
+// when the catalog is first used
+var openNGC []Object
+
+// openNGCTypes maps OpenNGC object types to the catalog's. Duplicate and
+// nonexistent entries are not mapped and so are skipped.
+var openNGCTypes = map[string]string{
+	"*":      "star",
+	"**":     "double_star",
+	"*Ass":   "asterism",
+	"OCl":    "open_cluster",
+	"GCl":    "globular_cluster",
+	"Cl+N":   "open_cluster",
+	"G":      "galaxy",
+	"GPair":  "galaxy",
+	"GTrpl":  "galaxy",
+	"GGroup": "galaxy",
+	"PN":     "planetary_nebula",
+	"HII":    "emission_nebula",
+	"EmN":    "emission_nebula",
+	"Neb":    "nebula",
+	"RfN":    "reflection_nebula",
+	"DrkN":   "dark_nebula",
+	"SNR":    "supernova_remnant",
+	"Nova":   "nova",
+	"Other":  "other",
+}
+
+// LoadOpenNGC loads an NGC/IC catalogue in the format of OpenNGC's NGC.csv
+// (https://github.com/mattiaverga/OpenNGC) from path, returning how many
+// objects it read. Objects already in the embedded catalog, such as the
+// Messier objects, are not added again. It must be called before the catalog
+// is first used.
+func LoadOpenNGC(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() //nolint:errcheck // Error from Close on read is not critical
+
+	objects, err := readOpenNGC(f)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	openNGC = append(openNGC, objects...)
+	return len(objects), nil
+}
+
+// readOpenNGC reads the objects of an OpenNGC file, finding its columns by
+// the names in its header
+func readOpenNGC(r io.Reader) ([]Object, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = ';'
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"Name", "Type", "RA", "Dec"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("no %s column", name)
+		}
+	}
+
+	var result []Object
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		t, ok := openNGCTypes[field("Type")]
+		if !ok {
+			continue
+		}
+		o := Object{ID: openNGCDesignation(field("Name")), Type: t, Constellation: field("Const")}
+		if o.RA, err = parseSexagesimal(field("RA")); err != nil {
+			return nil, fmt.Errorf("%s: RA: %w", o.ID, err)
+		}
+		o.RA *= 15
+		if o.Dec, err = parseSexagesimal(field("Dec")); err != nil {
+			return nil, fmt.Errorf("%s: Dec: %w", o.ID, err)
+		}
+		for _, name := range []string{"V-Mag", "B-Mag"} {
+			if v := field(name); v != "" {
+				if o.Mag, err = strconv.ParseFloat(v, 64); err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", o.ID, name, err)
+				}
+				break
+			}
+		}
+		if v := field("MajAx"); v != "" {
+			if o.Size[0], err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("%s: MajAx: %w", o.ID, err)
+			}
+			o.Size[1] = o.Size[0]
+		}
+		if v := field("MinAx"); v != "" {
+			if o.Size[1], err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("%s: MinAx: %w", o.ID, err)
+			}
+		}
+		// The NGC and IC columns hold the object's designation in the other
+		// catalogue, such as the NGC number of an IC object
+		for _, catalogue := range []string{"NGC", "IC"} {
+			for _, n := range strings.Split(field(catalogue), ",") {
+				if n = strings.TrimLeft(strings.TrimSpace(n), "0"); n != "" {
+					o.Names = append(o.Names, catalogue+" "+n)
+				}
+			}
+		}
+		o.CommonName, _, _ = strings.Cut(field("Common names"), ",")
+		result = append(result, o)
+	}
+}
+
+// openNGCDesignation turns an OpenNGC name such as "NGC0224" or "IC0434" into
+// the catalog's form, "NGC 224" or "IC 434"
+func openNGCDesignation(name string) string {
+	i := strings.IndexFunc(name, unicode.IsDigit)
+	if i <= 0 {
+		return name
+	}
+	number := strings.TrimLeft(name[i:], "0")
+	if number == "" || !unicode.IsDigit(rune(number[0])) {
+		number = "0" + number
+	}
+	return name[:i] + " " + number
+}
+
+// mergeObjects adds to objects those of extra that are not already in it
+// under any of their designations
+func mergeObjects(objects, extra []Object) []Object {
+	known := make(map[string]bool)
+	for _, o := range objects {
+		known[normalizeName(o.ID)] = true
+		for _, name := range o.Names {
+			known[normalizeName(name)] = true
+		}
+	}
+	for _, o := range extra {
+		if known[normalizeName(o.ID)] || slices.ContainsFunc(o.Names, func(name string) bool { return known[normalizeName(name)] }) {
+			continue
+		}
+		known[normalizeName(o.ID)] = true
+		objects = append(objects, o)
+	}
+	return objects
+}
@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const openNGCSample = `Name;Type;RA;Dec;Const;MajAx;MinAx;PosAng;B-Mag;V-Mag;M;NGC;IC;Common names
+IC0434;HII;05:41:00.88;-02:27:13.6;Ori;60.00;10.00;;;;;;;Horsehead Nebula
+NGC0224;G;00:42:44.35;+41:16:08.6;And;177.83;69.66;35;4.29;3.44;031;;;Andromeda Galaxy
+NGC0001;G;00:07:15.84;+27:42:29.1;Peg;1.57;1.07;112;13.65;12.93;;;;
+NGC0002;G;00:07:17.10;+27:40:42.0;Peg;1.00;0.51;110;15.13;;;;;
+IC0600;Dup;12:40:07.60;+11:22:50.0;Vir;;;;;;;4586;;
+NGC7092;OCl;21:31:48.00;+48:26:00.0;Cyg;;;;;;039;;;
+`
+
+func TestReadOpenNGC(t *testing.T) {
+	objects, err := readOpenNGC(strings.NewReader(openNGCSample))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// The duplicate IC 600 is skipped
+	if len(objects) != 5 {
+		t.Fatalf("expected 5 objects, got %d", len(objects))
+	}
+
+	horsehead := objects[0]
+	if horsehead.ID != "IC 434" || horsehead.Type != "emission_nebula" || horsehead.CommonName != "Horsehead Nebula" {
+		t.Errorf("expected IC 434, the Horsehead Nebula, got %+v", horsehead)
+	}
+	if math.Abs(horsehead.RA-85.253667) > 1e-6 || math.Abs(horsehead.Dec+2.453778) > 1e-6 {
+		t.Errorf("expected IC 434 at 85.253667 -2.453778, got %f %f", horsehead.RA, horsehead.Dec)
+	}
+	if horsehead.Size != [2]float64{60, 10} || horsehead.Mag != 0 {
+		t.Errorf("expected size 60x10 and no magnitude, got %v %f", horsehead.Size, horsehead.Mag)
+	}
+	if m31 := objects[1]; m31.Mag != 3.44 {
+		t.Errorf("expected the V magnitude 3.44, got %f", m31.Mag)
+	}
+	if ngc2 := objects[3]; ngc2.Mag != 15.13 {
+		t.Errorf("expected the B magnitude 15.13 without a V magnitude, got %f", ngc2.Mag)
+	}
+	if m39 := objects[4]; m39.Size != [2]float64{} {
+		t.Errorf("expected no size, got %v", m39.Size)
+	}
+
+	if _, err := readOpenNGC(strings.NewReader("Name;Type;RA\n")); err == nil {
+		t.Error("expected an error without a Dec column")
+	}
+	if _, err := readOpenNGC(strings.NewReader("Name;Type;RA;Dec\nNGC0001;G;x;+27:42:29.1\n")); err == nil {
+		t.Error("expected an error for an invalid RA")
+	}
+}
+
+func TestMergeObjects(t *testing.T) {
+	extra, err := readOpenNGC(strings.NewReader(openNGCSample))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	embedded := must(loadObjects())
+	merged := mergeObjects(embedded, extra)
+
+	// M31 (NGC 224), the Horsehead (IC 434) and M39 (NGC 7092) are embedded
+	added := merged[len(embedded):]
+	if len(added) != 2 || added[0].ID != "NGC 1" || added[1].ID != "NGC 2" {
+		t.Errorf("expected NGC 1 and NGC 2 to be added, got %+v", added)
+	}
+}
+
+func TestOpenNGCDesignation(t *testing.T) {
+	tests := map[string]string{
+		"NGC0224":       "NGC 224",
+		"IC0434":        "IC 434",
+		"NGC5194":       "NGC 5194",
+		"NGC0080 NED01": "NGC 80 NED01",
+		"B033":          "B 33",
+		"Mel022":        "Mel 22",
+		"NGC":           "NGC",
+	}
+	for name, expected := range tests {
+		if got := openNGCDesignation(name); got != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, got)
+		}
+	}
+}
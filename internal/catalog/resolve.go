@@ -0,0 +1,73 @@
+package catalog
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Position is the sky position of a named object
+type Position struct {
+	// Name is the object's main designation
+	Name string
+	RA   float64
+	Dec  float64
+}
+
+// greekLetters maps spelled-out Greek letters to the abbreviations of star
+// designations
+var greekLetters = map[string]string{
+	"alpha": "alf", "beta": "bet", "gamma": "gam", "delta": "del", "epsilon": "eps",
+	"zeta": "zet", "theta": "the", "iota": "iot", "kappa": "kap", "lambda": "lam",
+	"omicron": "omi", "sigma": "sig", "upsilon": "ups", "omega": "ome",
+}
+
+var names = sync.OnceValue(func() map[string]Position {
+	names := make(map[string]Position)
+	add := func(name string, p Position) {
+		key := normalizeName(name)
+		if _, ok := names[key]; key != "" && !ok {
+			names[key] = p
+		}
+	}
+	for _, o := range Objects() {
+		p := Position{Name: o.ID, RA: o.RA, Dec: o.Dec}
+		add(o.ID, p)
+		for _, name := range o.Names {
+			add(name, p)
+		}
+		add(o.CommonName, p)
+	}
+	for _, s := range Stars() {
+		p := Position{Name: s.Designation, RA: s.RA, Dec: s.Dec}
+		add(s.Designation, p)
+		add(s.Name, p)
+	}
+	return names
+})
+
+// Resolve finds an object or star by any of its designations or names, such
+// as "M42", "NGC 1976", "Orion Nebula", "Betelgeuse", "alf Ori" or "Alpha Ori".
+// Case and spaces do not matter.
+func Resolve(name string) (Position, bool) {
+	p, ok := names()[normalizeName(name)]
+	return p, ok
+}
+
+// normalizeName lower-cases a name, abbreviates a spelled-out Greek letter at
+// its start and drops everything but letters and digits
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for letter, abbreviation := range greekLetters {
+		if rest, ok := strings.CutPrefix(name, letter); ok && (rest == "" || !unicode.IsLetter(rune(rest[0]))) {
+			name = abbreviation + rest
+			break
+		}
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, name)
+}
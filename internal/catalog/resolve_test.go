@@ -0,0 +1,39 @@
+package catalog
+
+import (
+	"math"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	for name, want := range map[string]string{
+		"M42":          "M42",
+		"m 42":         "M42",
+		"NGC1976":      "M42",
+		"orion nebula": "M42",
+		"C14":          "C14",
+		"Betelgeuse":   "alf Ori",
+		"alf Ori":      "alf Ori",
+		"Alpha Ori":    "alf Ori",
+		"eta Ori":      "eta Ori",
+	} {
+		p, ok := Resolve(name)
+		if !ok {
+			t.Errorf("%s: expected to resolve", name)
+			continue
+		}
+		if p.Name != want {
+			t.Errorf("%s: expected %s, got %s", name, want, p.Name)
+		}
+	}
+
+	p, _ := Resolve("M42")
+	if math.Abs(p.RA-83.825) > 1e-9 || math.Abs(p.Dec+5.383333) > 1e-6 {
+		t.Errorf("expected M42 at 83.825 -5.3833, got %f %f", p.RA, p.Dec)
+	}
+	for _, name := range []string{"", "M111", "Alphard Ori", "Nebula"} {
+		if _, ok := Resolve(name); ok {
+			t.Errorf("%q: expected not to resolve", name)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package coord
+
+import (
+	"math"
+	"time"
+)
+
+const deg = math.Pi / 180
+
+// J2000 is the Julian date of the J2000.0 epoch
+const J2000 = 2451545.0
+
+// obliquityJ2000 is the mean obliquity of the ecliptic at J2000.0 in degrees
+const obliquityJ2000 = 23.4392911
+
+// galactic rotates J2000 equatorial unit vectors to galactic ones (the
+// Hipparcos definition of the galactic frame)
+var galactic = matrix{
+	{-0.0548755604162154, -0.8734370902348850, -0.4838350155487132},
+	{+0.4941094278755837, -0.4448296299600112, +0.7469822444972189},
+	{-0.8676661490190047, -0.1980763734312015, +0.4559837761750669},
+}
+
+// JulianDate returns the Julian date of t, ignoring the difference between
+// UTC and terrestrial time
+func JulianDate(t time.Time) float64 {
+	return 2440587.5 + (float64(t.Unix())+float64(t.Nanosecond())/1e9)/86400
+}
+
+// Galactic converts a J2000 position to galactic longitude and latitude, all
+// in degrees
+func Galactic(ra, dec float64) (l, b float64) {
+	return toSpherical(galactic.apply(unitVector(ra, dec)))
+}
+
+// Ecliptic converts a J2000 position to ecliptic longitude and latitude on the
+// mean ecliptic and equinox of J2000, all in degrees
+func Ecliptic(ra, dec float64) (lon, lat float64) {
+	return toEcliptic(ra, dec, obliquityJ2000)
+}
+
+// Precess converts a J2000 position to the mean equator and equinox of t, with
+// the IAU 1976 precession angles
+func Precess(ra, dec float64, t time.Time) (float64, float64) {
+	return toSpherical(precession(t).apply(unitVector(ra, dec)))
+}
+
+// precession returns the rotation from the J2000 mean equator and equinox to
+// those of t
+func precession(t time.Time) matrix {
+	c := centuries(t)
+	zeta := (2306.2181*c + 0.30188*c*c + 0.017998*c*c*c) / 3600 * deg
+	z := (2306.2181*c + 1.09468*c*c + 0.018203*c*c*c) / 3600 * deg
+	theta := (2004.3109*c - 0.42665*c*c - 0.041833*c*c*c) / 3600 * deg
+	return rotateZ(z).mul(rotateY(-theta)).mul(rotateZ(zeta))
+}
+
+// SiderealTime returns the local mean sidereal time at t and an east
+// longitude, in degrees
+func SiderealTime(t time.Time, longitude float64) float64 {
+	d := JulianDate(t) - J2000
+	c := centuries(t)
+	gmst := 280.46061837 + 360.98564736629*d + 0.000387933*c*c - c*c*c/38710000
+	return normalizeDegrees(gmst + longitude)
+}
+
+// Horizontal is where a position appears in the sky of an observer
+type Horizontal struct {
+	// Altitude is the geometric altitude above the horizon in degrees, without
+	// refraction
+	Altitude float64
+	// Azimuth is measured from north through east, in degrees
+	Azimuth float64
+	// HourAngle is in hours from -12 to 12, negative east of the meridian
+	HourAngle float64
+	// ParallacticAngle is the angle in degrees from the direction of the
+	// celestial pole to the zenith, measured through east
+	ParallacticAngle float64
+}
+
+// ToHorizontal converts a position of date (degrees) to horizontal
+// coordinates for an observer at latitude whose local sidereal time is lst,
+// both in degrees
+func ToHorizontal(ra, dec, latitude, lst float64) Horizontal {
+	h := normalizeDegrees(lst-ra+180) - 180
+	sinH, cosH := math.Sincos(h * deg)
+	sinD, cosD := math.Sincos(dec * deg)
+	sinL, cosL := math.Sincos(latitude * deg)
+
+	altitude := math.Asin(clamp(sinL*sinD+cosL*cosD*cosH)) / deg
+	azimuth := math.Atan2(-cosD*sinH, sinD*cosL-cosD*sinL*cosH) / deg
+	parallactic := math.Atan2(sinH, sinL/cosL*cosD-sinD*cosH) / deg
+	return Horizontal{
+		Altitude:         altitude,
+		Azimuth:          normalizeDegrees(azimuth),
+		HourAngle:        h / 15,
+		ParallacticAngle: parallactic,
+	}
+}
+
+// Airmass returns the relative air mass at a geometric altitude in degrees,
+// using the formula of Kasten and Young (1989). It is false at or below the
+// horizon.
+func Airmass(altitude float64) (float64, bool) {
+	if altitude <= 0 {
+		return 0, false
+	}
+	return 1 / (math.Sin(altitude*deg) + 0.50572*math.Pow(altitude+6.07995, -1.6364)), true
+}
+
+// matrix is a rotation of unit vectors
+type matrix [3][3]float64
+
+// rotateZ returns the rotation by a radians about the z axis, and rotateY the
+// one about the y axis
+func rotateZ(a float64) matrix {
+	sin, cos := math.Sincos(a)
+	return matrix{{cos, -sin, 0}, {sin, cos, 0}, {0, 0, 1}}
+}
+
+func rotateY(a float64) matrix {
+	sin, cos := math.Sincos(a)
+	return matrix{{cos, 0, sin}, {0, 1, 0}, {-sin, 0, cos}}
+}
+
+func (m matrix) mul(n matrix) matrix {
+	var p matrix
+	for i := range 3 {
+		for j := range 3 {
+			p[i][j] = m[i][0]*n[0][j] + m[i][1]*n[1][j] + m[i][2]*n[2][j]
+		}
+	}
+	return p
+}
+
+func (m matrix) transpose() matrix {
+	var t matrix
+	for i := range 3 {
+		for j := range 3 {
+			t[i][j] = m[j][i]
+		}
+	}
+	return t
+}
+
+func (m matrix) apply(v [3]float64) [3]float64 {
+	var r [3]float64
+	for i, row := range m {
+		r[i] = row[0]*v[0] + row[1]*v[1] + row[2]*v[2]
+	}
+	return r
+}
+
+func unitVector(ra, dec float64) [3]float64 {
+	sinA, cosA := math.Sincos(ra * deg)
+	sinD, cosD := math.Sincos(dec * deg)
+	return [3]float64{cosD * cosA, cosD * sinA, sinD}
+}
+
+// toSpherical returns the longitude and latitude of a unit vector in degrees
+func toSpherical(v [3]float64) (float64, float64) {
+	return normalizeDegrees(math.Atan2(v[1], v[0]) / deg), math.Asin(clamp(v[2])) / deg
+}
+
+// normalizeDegrees wraps an angle into [0, 360)
+func normalizeDegrees(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+// clamp keeps a sine or cosine within [-1, 1] against rounding
+func clamp(v float64) float64 {
+	return math.Max(-1, math.Min(1, v))
+}
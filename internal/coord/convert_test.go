@@ -0,0 +1,110 @@
+package coord
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGalactic(t *testing.T) {
+	tests := []struct {
+		name         string
+		ra, dec      float64
+		wantL, wantB float64
+	}{
+		{"galactic centre", 266.40499, -28.93617, 0, 0},
+		{"north galactic pole", 192.85948, 27.12825, 0, 90},
+		{"M42", 83.8221, -5.3911, 209.01, -19.38},
+	}
+	for _, tt := range tests {
+		l, b := Galactic(tt.ra, tt.dec)
+		if math.Abs(b-tt.wantB) > 0.01 || (tt.wantB != 90 && math.Abs(math.Remainder(l-tt.wantL, 360)) > 0.01) {
+			t.Errorf("%s: expected l %f b %f, got %f %f", tt.name, tt.wantL, tt.wantB, l, b)
+		}
+	}
+}
+
+func TestEcliptic(t *testing.T) {
+	tests := []struct {
+		ra, dec          float64
+		wantLon, wantLat float64
+	}{
+		{0, 0, 0, 0},
+		{90, obliquityJ2000, 90, 0},
+		{270, 90 - obliquityJ2000, 0, 90},
+		// Pollux, from Meeus, Astronomical Algorithms, example 13.a
+		{116.328942, 28.026183, 113.215630, 6.684170},
+	}
+	for _, tt := range tests {
+		lon, lat := Ecliptic(tt.ra, tt.dec)
+		if math.Abs(lat-tt.wantLat) > 1e-5 || (tt.wantLat != 90 && math.Abs(lon-tt.wantLon) > 1e-5) {
+			t.Errorf("(%f, %f): expected %f %f, got %f %f", tt.ra, tt.dec, tt.wantLon, tt.wantLat, lon, lat)
+		}
+	}
+}
+
+func TestPrecess(t *testing.T) {
+	// theta Persei, from Meeus, Astronomical Algorithms, example 21.b
+	when := time.Date(2028, 11, 13, 4, 33, 36, 0, time.UTC)
+	ra, dec := Precess(41.054063, 49.227750, when)
+	if math.Abs(ra-41.547214) > 1e-5 || math.Abs(dec-49.348483) > 1e-5 {
+		t.Errorf("expected 41.547214 49.348483, got %f %f", ra, dec)
+	}
+}
+
+func TestSiderealTime(t *testing.T) {
+	// Meeus, Astronomical Algorithms, examples 12.a and 12.b
+	tests := []struct {
+		when time.Time
+		want float64
+	}{
+		{time.Date(1987, 4, 10, 0, 0, 0, 0, time.UTC), 197.693195},
+		{time.Date(1987, 4, 10, 19, 21, 0, 0, time.UTC), 128.737873},
+	}
+	for _, tt := range tests {
+		if got := SiderealTime(tt.when, 0); math.Abs(got-tt.want) > 1e-5 {
+			t.Errorf("%v: expected %f, got %f", tt.when, tt.want, got)
+		}
+	}
+	if got := SiderealTime(tests[0].when, -77.065556); math.Abs(got-120.627639) > 1e-5 {
+		t.Errorf("expected the local sidereal time west of Greenwich, got %f", got)
+	}
+}
+
+func TestToHorizontal(t *testing.T) {
+	// Venus from Washington, from Meeus, Astronomical Algorithms, example
+	// 13.b, which measures azimuth from the south
+	ra, dec, latitude := 347.3193375, -6.719892, 38.921389
+	h := ToHorizontal(ra, dec, latitude, ra+64.352133)
+	if math.Abs(h.Altitude-15.1249) > 1e-4 || math.Abs(h.Azimuth-248.0337) > 1e-4 {
+		t.Errorf("expected altitude 15.1249 azimuth 248.0337, got %f %f", h.Altitude, h.Azimuth)
+	}
+	if math.Abs(h.HourAngle-64.352133/15) > 1e-9 {
+		t.Errorf("expected hour angle %f, got %f", 64.352133/15, h.HourAngle)
+	}
+
+	// On the meridian south of the zenith the parallactic angle is zero, and
+	// it is positive west of the meridian
+	h = ToHorizontal(100, 10, 50, 100)
+	if math.Abs(h.Altitude-50) > 1e-9 || math.Abs(h.Azimuth-180) > 1e-9 || math.Abs(h.ParallacticAngle) > 1e-9 {
+		t.Errorf("expected altitude 50 azimuth 180 on the meridian, got %+v", h)
+	}
+	if h = ToHorizontal(100, 10, 50, 130); h.ParallacticAngle <= 0 || h.HourAngle != 2 {
+		t.Errorf("expected a positive parallactic angle two hours west, got %+v", h)
+	}
+	if h = ToHorizontal(100, 10, 50, 70); h.ParallacticAngle >= 0 || h.HourAngle != -2 {
+		t.Errorf("expected a negative parallactic angle two hours east, got %+v", h)
+	}
+}
+
+func TestAirmass(t *testing.T) {
+	if x, ok := Airmass(90); !ok || math.Abs(x-1) > 1e-3 {
+		t.Errorf("expected an airmass of 1 at the zenith, got %f", x)
+	}
+	if x, ok := Airmass(30); !ok || math.Abs(x-1.995) > 0.01 {
+		t.Errorf("expected an airmass near 2 at 30 degrees, got %f", x)
+	}
+	if _, ok := Airmass(-1); ok {
+		t.Error("expected no airmass below the horizon")
+	}
+}
@@ -0,0 +1,146 @@
+package coord
+
+import (
+	"math"
+	"time"
+)
+
+// Epochs of a position
+const (
+	EpochJ2000 = "j2000"
+	EpochJNow  = "jnow"
+)
+
+// aberrationConstant is the constant of annual aberration in degrees
+const aberrationConstant = 20.49552 / 3600
+
+// ToJNow converts a J2000 mean position to the true equator and equinox of t
+// by applying precession and nutation, and the annual aberration if
+// aberration is set. All angles are in degrees.
+func ToJNow(ra, dec float64, t time.Time, aberration bool) (float64, float64) {
+	ra, dec = Precess(ra, dec, t)
+	ra, dec = nutate(ra, dec, t)
+	if aberration {
+		ra, dec = aberrate(ra, dec, t)
+	}
+	return ra, dec
+}
+
+// FromJNow converts a position on the true equator and equinox of t back to
+// J2000, undoing ToJNow with the same options
+func FromJNow(ra, dec float64, t time.Time, aberration bool) (float64, float64) {
+	if aberration {
+		ra, dec = unaberrate(ra, dec, t)
+	}
+	dPsi, dEps := nutation(t)
+	mean := meanObliquity(t)
+	lon, lat := toEcliptic(ra, dec, mean+dEps)
+	ra, dec = fromEcliptic(lon-dPsi, lat, mean)
+	return toSpherical(precession(t).transpose().apply(unitVector(ra, dec)))
+}
+
+// centuries returns the Julian centuries from J2000 to t
+func centuries(t time.Time) float64 {
+	return (JulianDate(t) - J2000) / 36525
+}
+
+// meanObliquity returns the mean obliquity of the ecliptic at t in degrees
+func meanObliquity(t time.Time) float64 {
+	c := centuries(t)
+	return obliquityJ2000 + (-46.8150*c-0.00059*c*c+0.001813*c*c*c)/3600
+}
+
+// nutation returns the nutation in longitude and in obliquity at t in degrees,
+// from the main terms of the IAU 1980 series (good to about 0.5")
+func nutation(t time.Time) (dPsi, dEps float64) {
+	c := centuries(t)
+	node := (125.04452 - 1934.136261*c) * deg
+	sun := (280.4665 + 36000.7698*c) * deg
+	moon := (218.3165 + 481267.8813*c) * deg
+	dPsi = -17.20*math.Sin(node) - 1.32*math.Sin(2*sun) - 0.23*math.Sin(2*moon) + 0.21*math.Sin(2*node)
+	dEps = 9.20*math.Cos(node) + 0.57*math.Cos(2*sun) + 0.10*math.Cos(2*moon) - 0.09*math.Cos(2*node)
+	return dPsi / 3600, dEps / 3600
+}
+
+// nutate moves a mean position of date to the true equator and equinox by
+// shifting its ecliptic longitude and using the true obliquity
+func nutate(ra, dec float64, t time.Time) (float64, float64) {
+	dPsi, dEps := nutation(t)
+	mean := meanObliquity(t)
+	lon, lat := toEcliptic(ra, dec, mean)
+	return fromEcliptic(lon+dPsi, lat, mean+dEps)
+}
+
+// aberrate adds the annual aberration at t to a position of date
+func aberrate(ra, dec float64, t time.Time) (float64, float64) {
+	return toSpherical(displace(unitVector(ra, dec), earthVelocity(t)))
+}
+
+// unaberrate removes the annual aberration at t from a position of date
+func unaberrate(ra, dec float64, t time.Time) (float64, float64) {
+	// The displacement barely changes over the 20" it moves a position, so a
+	// few steps find the position that was displaced well under a
+	// milliarcsecond
+	apparent, v := unitVector(ra, dec), earthVelocity(t)
+	p := apparent
+	for range 3 {
+		d := displace(p, v)
+		p = normalize([3]float64{p[0] + apparent[0] - d[0], p[1] + apparent[1] - d[1], p[2] + apparent[2] - d[2]})
+	}
+	return toSpherical(p)
+}
+
+// earthVelocity returns the Earth's orbital velocity at t as a fraction of
+// the speed of light, in the equatorial frame of date, from the Sun's true
+// longitude and the eccentricity terms of the orbit
+func earthVelocity(t time.Time) [3]float64 {
+	c := centuries(t)
+	meanLon := 280.46646 + 36000.76983*c + 0.0003032*c*c
+	anomaly := (357.52911 + 35999.05029*c - 0.0001537*c*c) * deg
+	center := (1.914602-0.004817*c-0.000014*c*c)*math.Sin(anomaly) +
+		(0.019993-0.000101*c)*math.Sin(2*anomaly) + 0.000289*math.Sin(3*anomaly)
+	e := 0.016708634 - 0.000042037*c - 0.0000001267*c*c
+	perihelion := (102.93735 + 1.71946*c + 0.00046*c*c) * deg
+
+	sinS, cosS := math.Sincos((meanLon + center) * deg)
+	sinP, cosP := math.Sincos(perihelion)
+	sinE, cosE := math.Sincos(meanObliquity(t) * deg)
+	k := aberrationConstant * deg
+	return [3]float64{
+		k * (sinS - e*sinP),
+		-k * (cosS - e*cosP) * cosE,
+		-k * (cosS - e*cosP) * sinE,
+	}
+}
+
+// displace moves the direction p towards the velocity v
+func displace(p, v [3]float64) [3]float64 {
+	dot := p[0]*v[0] + p[1]*v[1] + p[2]*v[2]
+	return normalize([3]float64{p[0] + v[0] - dot*p[0], p[1] + v[1] - dot*p[1], p[2] + v[2] - dot*p[2]})
+}
+
+func normalize(v [3]float64) [3]float64 {
+	n := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	return [3]float64{v[0] / n, v[1] / n, v[2] / n}
+}
+
+// toEcliptic converts an equatorial position to ecliptic coordinates for an
+// obliquity, all in degrees
+func toEcliptic(ra, dec, obliquity float64) (lon, lat float64) {
+	sinE, cosE := math.Sincos(obliquity * deg)
+	sinA, cosA := math.Sincos(ra * deg)
+	sinD, cosD := math.Sincos(dec * deg)
+	lon = math.Atan2(sinA*cosD*cosE+sinD*sinE, cosA*cosD) / deg
+	lat = math.Asin(clamp(sinD*cosE-cosD*sinE*sinA)) / deg
+	return normalizeDegrees(lon), lat
+}
+
+// fromEcliptic converts ecliptic coordinates back to an equatorial position
+func fromEcliptic(lon, lat, obliquity float64) (ra, dec float64) {
+	sinE, cosE := math.Sincos(obliquity * deg)
+	sinL, cosL := math.Sincos(lon * deg)
+	sinB, cosB := math.Sincos(lat * deg)
+	ra = math.Atan2(sinL*cosB*cosE-sinB*sinE, cosL*cosB) / deg
+	dec = math.Asin(clamp(sinB*cosE+cosB*sinE*sinL)) / deg
+	return normalizeDegrees(ra), dec
+}
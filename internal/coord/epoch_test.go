@@ -0,0 +1,62 @@
+package coord
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// thetaPersei is the J2000 position of theta Persei and the date of Meeus,
+// Astronomical Algorithms, examples 21.b and 23.a
+var (
+	thetaPersei     = [2]float64{41.054063, 49.227750}
+	thetaPerseiDate = time.Date(2028, 11, 13, 4, 33, 36, 0, time.UTC)
+)
+
+func TestNutation(t *testing.T) {
+	dPsi, dEps := nutation(thetaPerseiDate)
+	if math.Abs(dPsi*3600-14.861) > 0.5 || math.Abs(dEps*3600-2.705) > 0.1 {
+		t.Errorf("expected nutation 14.861\" 2.705\", got %f\" %f\"", dPsi*3600, dEps*3600)
+	}
+	if got := meanObliquity(thetaPerseiDate); math.Abs(got-23.436) > 0.001 {
+		t.Errorf("expected mean obliquity 23.436, got %f", got)
+	}
+}
+
+func TestToJNow(t *testing.T) {
+	// Within the accuracy of the shortened nutation series, about 0.5"
+	const tolerance = 0.5 / 3600
+	tests := []struct {
+		aberration      bool
+		wantRA, wantDec float64
+	}{
+		{false, 41.551615, 49.350210},
+		{true, 41.559958, 49.352069},
+	}
+	for _, tt := range tests {
+		ra, dec := ToJNow(thetaPersei[0], thetaPersei[1], thetaPerseiDate, tt.aberration)
+		if math.Abs(ra-tt.wantRA) > tolerance || math.Abs(dec-tt.wantDec) > tolerance {
+			t.Errorf("aberration %v: expected %f %f, got %f %f", tt.aberration, tt.wantRA, tt.wantDec, ra, dec)
+		}
+	}
+}
+
+func TestFromJNow(t *testing.T) {
+	when := time.Date(2026, 10, 16, 22, 0, 0, 0, time.UTC)
+	for _, p := range [][2]float64{thetaPersei, {83.8221, -5.3911}, {359.999, 0.5}, {0.001, -30}, {37.95, 89.26}, {180, -89.9}} {
+		for _, aberration := range []bool{false, true} {
+			nowRA, nowDec := ToJNow(p[0], p[1], when, aberration)
+			ra, dec := FromJNow(nowRA, nowDec, when, aberration)
+			if sep := separation(ra, dec, p[0], p[1]); sep > 1e-3/3600 {
+				t.Errorf("(%f, %f), aberration %v: expected the position back, got %f %f, %g\" off", p[0], p[1], aberration, ra, dec, sep*3600)
+			}
+		}
+	}
+}
+
+// separation returns the angle between two positions in degrees
+func separation(ra1, dec1, ra2, dec2 float64) float64 {
+	a, b := unitVector(ra1, dec1), unitVector(ra2, dec2)
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return 2 * math.Asin(math.Sqrt(dx*dx+dy*dy+dz*dz)/2) / deg
+}
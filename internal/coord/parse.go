@@ -0,0 +1,123 @@
+// Package coord parses and converts celestial coordinates
+package coord
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Units of a right ascension. Without a unit, a decimal RA is taken to be in
+// degrees and a sexagesimal one in hours.
+const (
+	Degrees = "degrees"
+	Hours   = "hours"
+)
+
+// ParseRA parses a right ascension, given as a decimal number or as
+// sexagesimal hours, minutes and seconds such as "05:35:17.3", "05 35 17.3"
+// or "5h35m17.3s", and returns it in degrees. units is Degrees, Hours or empty;
+// an "h" or "d" in the value takes precedence over it.
+func ParseRA(s, units string) (float64, error) {
+	a, err := parseAngle(s)
+	if err != nil {
+		return 0, err
+	}
+	if a.negative {
+		return 0, errors.New("right ascension cannot be negative")
+	}
+
+	hours := units == Hours || (units == "" && a.sexagesimal)
+	switch a.unit {
+	case 'h':
+		hours = true
+	case 'd':
+		hours = false
+	}
+	if hours {
+		if a.value >= 24 {
+			return 0, fmt.Errorf("%g hours is not below 24", a.value)
+		}
+		return a.value * 15, nil
+	}
+	if a.value >= 360 {
+		return 0, fmt.Errorf("%g degrees is not below 360", a.value)
+	}
+	return a.value, nil
+}
+
+// ParseDec parses a declination in degrees, given as a decimal number or as
+// sexagesimal degrees, minutes and seconds such as "-05:23:28", "-05 23 28",
+// "-5d23m28s" or "-5°23'28\""
+func ParseDec(s string) (float64, error) {
+	a, err := parseAngle(s)
+	if err != nil {
+		return 0, err
+	}
+	if a.unit == 'h' {
+		return 0, errors.New("declination cannot be in hours")
+	}
+	if a.value > 90 {
+		return 0, fmt.Errorf("%g degrees is beyond the pole", a.value)
+	}
+	if a.negative {
+		return -a.value, nil
+	}
+	return a.value, nil
+}
+
+// angle is a parsed angle before its unit is applied
+type angle struct {
+	value    float64
+	negative bool
+	// sexagesimal is set when the value was split into several parts
+	sexagesimal bool
+	// unit is 'h' or 'd' when the value said it was in hours or degrees
+	unit rune
+}
+
+// separators split the parts of a sexagesimal value
+const separators = ":hmsd°'\"′″"
+
+// parseAngle parses an unsigned decimal or sexagesimal value with an optional
+// sign
+func parseAngle(s string) (angle, error) {
+	var a angle
+	s = strings.TrimSpace(s)
+	for _, sign := range []string{"-", "−", "+"} {
+		if rest, ok := strings.CutPrefix(s, sign); ok {
+			a.negative = sign != "+"
+			s = strings.TrimSpace(rest)
+			break
+		}
+	}
+
+	for _, r := range s {
+		switch {
+		case r == 'h' || r == 'H':
+			a.unit = 'h'
+		case r == 'd' || r == 'D' || r == '°':
+			a.unit = 'd'
+		case unicode.IsDigit(r) || r == '.' || unicode.IsSpace(r) || strings.ContainsRune(separators, unicode.ToLower(r)):
+		default:
+			return a, fmt.Errorf("unexpected %q in %q", r, s)
+		}
+	}
+	parts := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(separators, r)
+	})
+	if len(parts) == 0 || len(parts) > 3 {
+		return a, fmt.Errorf("invalid value %q", s)
+	}
+	a.sexagesimal = len(parts) > 1
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || (i > 0 && n >= 60) || (i < len(parts)-1 && n != float64(int64(n))) {
+			return a, fmt.Errorf("invalid value %q", s)
+		}
+		a.value += n / []float64{1, 60, 3600}[i]
+	}
+	return a, nil
+}
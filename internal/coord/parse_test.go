@@ -0,0 +1,73 @@
+package coord
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseRA(t *testing.T) {
+	tests := []struct {
+		value, units string
+		want         float64
+	}{
+		{"83.8221", "", 83.8221},
+		{"5.588", Hours, 83.82},
+		{"05:35:17.3", "", 83.822083},
+		{"05 35 17.3", "", 83.822083},
+		{"5h35m17.3s", "", 83.822083},
+		{"5h35m17.3s", Degrees, 83.822083},
+		{"83:49:19.5", Degrees, 83.822083},
+		{"83.8221d", Hours, 83.8221},
+		{"5.588h", "", 83.82},
+		{"+0:00:00", "", 0},
+	}
+	for _, tt := range tests {
+		got, err := ParseRA(tt.value, tt.units)
+		if err != nil {
+			t.Errorf("%q %s: unexpected error: %v", tt.value, tt.units, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%q %s: expected %f, got %f", tt.value, tt.units, tt.want, got)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "-5", "24:00:00", "360", "5:60:00", "5:30.5:10", "1:2:3:4", "5h 3e2"} {
+		if _, err := ParseRA(value, ""); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestParseDec(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"-5.3911", -5.3911},
+		{"-05:23:28", -5.391111},
+		{"-05 23 28", -5.391111},
+		{"-5d23m28s", -5.391111},
+		{"−5°23′28″", -5.391111},
+		{"-5°23'28\"", -5.391111},
+		{"- 0:30", -0.5},
+		{"+89:59:59.9", 89.999972},
+		{"90", 90},
+	}
+	for _, tt := range tests {
+		got, err := ParseDec(tt.value)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%q: expected %f, got %f", tt.value, tt.want, got)
+		}
+	}
+
+	for _, value := range []string{"", "north", "91", "-90:00:01", "5h", "10:75"} {
+		if _, err := ParseDec(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
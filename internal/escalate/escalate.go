@@ -0,0 +1,177 @@
+// Package escalate retries failed solves with progressively looser options
+package escalate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// Step is one rung of an escalation ladder, loosening the options of the
+// previous attempt
+type Step string
+
+// Escalation steps
+const (
+	// StepInitial marks the first attempt, made with the requested options
+	StepInitial Step = "initial"
+	// WidenScale halves the lower and doubles the upper scale bound
+	WidenScale Step = "widen_scale"
+	// DropScale removes the scale range, solving blind
+	DropScale Step = "drop_scale"
+	// IncreaseDepth doubles the maximum number of quads to try
+	IncreaseDepth Step = "increase_depth"
+	// ChangeDownsample downsamples by 4, or by 2 if it already was by 4 or more
+	ChangeDownsample Step = "change_downsample"
+	// DropHint removes the RA/Dec position hint
+	DropHint Step = "drop_hint"
+)
+
+// DefaultLadder is the ladder used when none is configured
+var DefaultLadder = []Step{WidenScale, IncreaseDepth, ChangeDownsample, DropHint}
+
+// DefaultBudget is the time budget used when none is configured
+const DefaultBudget = 10 * time.Minute
+
+var steps = map[Step]func(*client.SolveOptions){
+	WidenScale: func(o *client.SolveOptions) {
+		o.ScaleLow /= 2
+		o.ScaleHigh *= 2
+	},
+	DropScale: func(o *client.SolveOptions) {
+		o.ScaleLow, o.ScaleHigh = 0, 0
+	},
+	IncreaseDepth: func(o *client.SolveOptions) {
+		if o.DepthHigh <= 0 {
+			o.DepthHigh = client.DefaultSolveOptions().DepthHigh
+		}
+		o.DepthHigh *= 2
+	},
+	ChangeDownsample: func(o *client.SolveOptions) {
+		if o.DownsampleFactor < 4 {
+			o.DownsampleFactor = 4
+		} else {
+			o.DownsampleFactor = 2
+		}
+	},
+	DropHint: func(o *client.SolveOptions) {
+		o.RA, o.Dec, o.Radius = 0, 0, 0
+	},
+}
+
+// ParseLadder parses a comma-separated list of steps
+func ParseLadder(s string) ([]Step, error) {
+	var ladder []Step
+	for _, name := range strings.Split(s, ",") {
+		step := Step(strings.TrimSpace(name))
+		if step == "" {
+			continue
+		}
+		if _, ok := steps[step]; !ok {
+			return nil, fmt.Errorf("unknown escalation step %q", step)
+		}
+		ladder = append(ladder, step)
+	}
+	if len(ladder) == 0 {
+		return nil, errors.New("escalation ladder is empty")
+	}
+	return ladder, nil
+}
+
+// Plan describes how a solve escalates
+type Plan struct {
+	Ladder []Step        `json:"ladder"`
+	Budget time.Duration `json:"budget"`
+}
+
+// Attempt records one solve of an escalation
+type Attempt struct {
+	Number   int                  `json:"number"`
+	Step     Step                 `json:"step"`
+	Options  *client.SolveOptions `json:"options"`
+	Solved   bool                 `json:"solved"`
+	Duration time.Duration        `json:"duration"`
+	Error    string               `json:"error,omitempty"`
+}
+
+// SolveFunc runs one attempt with the given options. n is the attempt number, starting at 1.
+type SolveFunc func(ctx context.Context, opts *client.SolveOptions, n int) (*client.Result, error)
+
+// AttemptFunc is called after each attempt of an escalation
+type AttemptFunc func(Attempt)
+
+type attemptKey struct{}
+
+// WithAttemptFunc returns a context that reports every attempt of an
+// escalation run with it to fn
+func WithAttemptFunc(ctx context.Context, fn AttemptFunc) context.Context {
+	return context.WithValue(ctx, attemptKey{}, fn)
+}
+
+// errBudget is the cause of attempts cut short by the time budget
+var errBudget = errors.New("time budget exhausted")
+
+// Run solves with opts and, while the solve fails to find a solution, retries
+// with the options loosened by each step of the ladder in turn. Steps that do
+// not change the options are skipped. Every attempt is passed to record, and
+// then to the AttemptFunc attached to ctx. The whole run, including the first
+// attempt, must fit in the plan's budget; when it runs out Run returns an
+// error along with the last result.
+func (p Plan) Run(ctx context.Context, opts *client.SolveOptions, solve SolveFunc, record func(Attempt)) (*client.Result, error) {
+	if fn, ok := ctx.Value(attemptKey{}).(AttemptFunc); ok && fn != nil {
+		persist := record
+		record = func(a Attempt) {
+			persist(a)
+			fn(a)
+		}
+	}
+	if p.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, p.Budget, errBudget)
+		defer cancel()
+	}
+
+	current := *opts
+	step := StepInitial
+	next := 0
+	for n := 1; ; n++ {
+		attemptOpts := current
+		attempt := Attempt{Number: n, Step: step, Options: &attemptOpts}
+
+		started := time.Now()
+		result, err := solve(ctx, &attemptOpts, n)
+		attempt.Duration = time.Since(started)
+		attempt.Solved = err == nil && result != nil && result.Solved
+
+		if err != nil && errors.Is(context.Cause(ctx), errBudget) {
+			attempt.Error = errBudget.Error()
+			record(attempt)
+			return result, fmt.Errorf("escalation %s after %d attempts (%v)", errBudget, n, p.Budget)
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		record(attempt)
+		if err != nil || attempt.Solved {
+			return result, err
+		}
+
+		// Move on to the next step that actually loosens the options
+		step = ""
+		for ; next < len(p.Ladder) && step == ""; next++ {
+			loosened := current
+			steps[p.Ladder[next]](&loosened)
+			if loosened != current {
+				current = loosened
+				step = p.Ladder[next]
+			}
+		}
+		if step == "" {
+			return result, nil
+		}
+	}
+}
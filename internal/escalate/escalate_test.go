@@ -0,0 +1,125 @@
+package escalate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+func TestPlan_EscalatesUntilSolved(t *testing.T) {
+	opts := client.DefaultSolveOptions()
+	opts.ScaleLow, opts.ScaleHigh = 10, 20
+
+	var seen []client.SolveOptions
+	solve := func(ctx context.Context, o *client.SolveOptions, n int) (*client.Result, error) {
+		seen = append(seen, *o)
+		return &client.Result{Solved: n == 3}, nil
+	}
+	var attempts []Attempt
+	var reported int
+	ctx := WithAttemptFunc(context.Background(), func(Attempt) { reported++ })
+
+	// The position hint is not set, so drop_hint is skipped
+	plan := Plan{Ladder: []Step{DropHint, WidenScale, IncreaseDepth, ChangeDownsample}}
+	result, err := plan.Run(ctx, opts, solve, func(a Attempt) { attempts = append(attempts, a) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Solved {
+		t.Error("expected the third attempt to solve")
+	}
+
+	if len(attempts) != 3 || reported != 3 {
+		t.Fatalf("expected 3 attempts recorded and reported, got %d and %d", len(attempts), reported)
+	}
+	for i, step := range []Step{StepInitial, WidenScale, IncreaseDepth} {
+		if attempts[i].Number != i+1 || attempts[i].Step != step {
+			t.Errorf("attempt %d: expected %s, got %d %s", i+1, step, attempts[i].Number, attempts[i].Step)
+		}
+	}
+	if !attempts[2].Solved || attempts[1].Solved {
+		t.Errorf("expected only the last attempt to be solved, got %+v", attempts)
+	}
+
+	// Steps are cumulative
+	if seen[1].ScaleLow != 5 || seen[1].ScaleHigh != 40 || seen[1].DepthHigh != 20 {
+		t.Errorf("expected a widened scale, got %+v", seen[1])
+	}
+	if seen[2].ScaleLow != 5 || seen[2].DepthHigh != 40 {
+		t.Errorf("expected a widened scale and doubled depth, got %+v", seen[2])
+	}
+	if opts.ScaleLow != 10 || opts.DepthHigh != 20 {
+		t.Errorf("expected the requested options to be left alone, got %+v", opts)
+	}
+}
+
+func TestPlan_LadderExhausted(t *testing.T) {
+	solves := 0
+	solve := func(ctx context.Context, o *client.SolveOptions, n int) (*client.Result, error) {
+		solves++
+		return &client.Result{Solved: false}, nil
+	}
+	plan := Plan{Ladder: []Step{ChangeDownsample, DropScale}}
+	result, err := plan.Run(context.Background(), client.DefaultSolveOptions(), solve, func(Attempt) {})
+	if err != nil || result.Solved {
+		t.Errorf("expected an unsolved result without error, got %+v, %v", result, err)
+	}
+	// Without a scale range drop_scale changes nothing
+	if solves != 2 {
+		t.Errorf("expected 2 solves, got %d", solves)
+	}
+}
+
+func TestPlan_StopsOnError(t *testing.T) {
+	failure := errors.New("solver unavailable")
+	solve := func(ctx context.Context, o *client.SolveOptions, n int) (*client.Result, error) {
+		return nil, failure
+	}
+	var attempts []Attempt
+	_, err := Plan{Ladder: DefaultLadder}.Run(context.Background(), client.DefaultSolveOptions(), solve, func(a Attempt) { attempts = append(attempts, a) })
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the solver error, got %v", err)
+	}
+	if len(attempts) != 1 || attempts[0].Error != failure.Error() {
+		t.Errorf("expected one failed attempt, got %+v", attempts)
+	}
+}
+
+func TestPlan_Budget(t *testing.T) {
+	solve := func(ctx context.Context, o *client.SolveOptions, n int) (*client.Result, error) {
+		if n == 1 {
+			return &client.Result{Solved: false}, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	var attempts []Attempt
+	plan := Plan{Ladder: DefaultLadder, Budget: 50 * time.Millisecond}
+	_, err := plan.Run(context.Background(), client.DefaultSolveOptions(), solve, func(a Attempt) { attempts = append(attempts, a) })
+	if err == nil || !strings.Contains(err.Error(), "time budget exhausted after 2 attempts") {
+		t.Errorf("expected the budget to run out, got %v", err)
+	}
+	if len(attempts) != 2 || attempts[1].Error != "time budget exhausted" {
+		t.Errorf("expected the second attempt to be cut short, got %+v", attempts)
+	}
+}
+
+func TestParseLadder(t *testing.T) {
+	ladder, err := ParseLadder(" widen_scale, drop_hint,,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ladder) != 2 || ladder[0] != WidenScale || ladder[1] != DropHint {
+		t.Errorf("expected [widen_scale drop_hint], got %v", ladder)
+	}
+
+	for _, s := range []string{"", " , ", "widen_scale,guess", "initial"} {
+		if _, err := ParseLadder(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
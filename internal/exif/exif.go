@@ -0,0 +1,333 @@
+// Package exif reads the capture time and GPS position from the EXIF data of
+// JPEG and PNG images.
+//
+// It has its own TIFF reader rather than using goexif, which the astrometry
+// client depends on: goexif sizes a tag's values from its count alone, so a
+// single crafted tag in an upload makes it allocate gigabytes and kills the
+// server, and it reads no PNG eXIf chunks or OffsetTimeOriginal. Only the few
+// tags needed are read here, with every offset and size checked against the
+// block.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoEXIF is returned when an image holds no EXIF data
+var ErrNoEXIF = errors.New("no EXIF data")
+
+// maxSize bounds the EXIF block read from an image
+const maxSize = 1 << 20
+
+// dateTimeLayout is the layout of EXIF dates and times
+const dateTimeLayout = "2006:01:02 15:04:05"
+
+// Tags used from IFD0, the Exif IFD and the GPS IFD
+const (
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+	tagGPSTimeStamp       = 0x0007
+	tagGPSDateStamp       = 0x001d
+)
+
+// Location is a GPS position in degrees, north and east positive
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Info is the capture data read from an image. Parts the image does not hold
+// are left at their zero values.
+type Info struct {
+	// Location is the GPS position, or nil
+	Location *Location
+	// Time is when the image was taken. It comes from DateTimeOriginal when
+	// that has a time zone in OffsetTimeOriginal, else from the GPS time
+	// stamp.
+	Time time.Time
+	// LocalTime is DateTimeOriginal when Time is not known because it has no
+	// time zone: the camera's clock reading, in an unknown zone, given as UTC
+	LocalTime time.Time
+}
+
+// ReadFile reads the EXIF data of the JPEG or PNG image at path
+func ReadFile(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck // Error from Close on read is not critical
+	return Read(f)
+}
+
+// Read reads the EXIF data of a JPEG or PNG image
+func Read(r io.Reader) (*Info, error) {
+	block, err := find(r)
+	if err != nil {
+		return nil, err
+	}
+	return parse(block)
+}
+
+// find returns the TIFF block holding the EXIF data of an image
+func find(r io.Reader) ([]byte, error) {
+	var magic [8]byte
+	if _, err := io.ReadFull(r, magic[:2]); err != nil {
+		return nil, ErrNoEXIF
+	}
+	if magic[0] == 0xff && magic[1] == 0xd8 {
+		return findJPEG(r)
+	}
+	if _, err := io.ReadFull(r, magic[2:]); err == nil && string(magic[:]) == "\x89PNG\r\n\x1a\n" {
+		return findPNG(r)
+	}
+	return nil, errors.New("not a JPEG or PNG image")
+}
+
+// findJPEG returns the contents of the APP1 Exif segment, which comes before
+// the image data
+func findJPEG(r io.Reader) ([]byte, error) {
+	var marker [4]byte
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xff {
+			return nil, ErrNoEXIF
+		}
+		// Start of scan and end of image mean no EXIF segment came first
+		if marker[1] == 0xda || marker[1] == 0xd9 {
+			return nil, ErrNoEXIF
+		}
+		size := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if size < 0 {
+			return nil, ErrNoEXIF
+		}
+		if marker[1] != 0xe1 {
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return nil, ErrNoEXIF
+			}
+			continue
+		}
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, ErrNoEXIF
+		}
+		if block, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); ok {
+			return block, nil
+		}
+	}
+}
+
+// findPNG returns the contents of the eXIf chunk
+func findPNG(r io.Reader) ([]byte, error) {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, ErrNoEXIF
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:])
+		switch {
+		case kind == "IDAT" || kind == "IEND":
+			return nil, ErrNoEXIF
+		case kind == "eXIf" && size <= maxSize:
+			chunk := make([]byte, size+4)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, ErrNoEXIF
+			}
+			data := chunk[:size]
+			if crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, data) != binary.BigEndian.Uint32(chunk[size:]) {
+				return nil, errors.New("corrupt eXIf chunk")
+			}
+			return data, nil
+		}
+		if _, err := io.CopyN(io.Discard, r, size+4); err != nil {
+			return nil, ErrNoEXIF
+		}
+	}
+}
+
+// tiff is a TIFF block and its byte order
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// entry is an IFD entry
+type entry struct {
+	kind  uint16
+	count uint32
+	// value is the value itself if it fits in four bytes, or else its offset
+	value []byte
+}
+
+// Types of IFD entries
+const (
+	typeASCII    = 2
+	typeLong     = 4
+	typeRational = 5
+)
+
+func parse(data []byte) (*Info, error) {
+	if len(data) < 8 {
+		return nil, errors.New("short EXIF block")
+	}
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid EXIF byte order")
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, errors.New("invalid EXIF header")
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	var exifIFD, gpsIFD map[uint16]entry
+	if e, ok := ifd0[tagExifIFD]; ok && e.kind == typeLong {
+		if exifIFD, err = t.ifd(t.order.Uint32(e.value)); err != nil {
+			return nil, err
+		}
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok && e.kind == typeLong {
+		if gpsIFD, err = t.ifd(t.order.Uint32(e.value)); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &Info{}
+	lat, latOK := t.coordinate(gpsIFD, tagGPSLatitude, tagGPSLatitudeRef, "S")
+	lon, lonOK := t.coordinate(gpsIFD, tagGPSLongitude, tagGPSLongitudeRef, "W")
+	if latOK && lonOK && lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180 {
+		info.Location = &Location{Latitude: lat, Longitude: lon}
+	}
+
+	original := t.ascii(exifIFD, tagDateTimeOriginal)
+	zoned, zonedErr := time.Parse(dateTimeLayout+"-07:00", original+t.ascii(exifIFD, tagOffsetTimeOriginal))
+	gps, gpsOK := t.gpsTime(gpsIFD)
+	switch {
+	case zonedErr == nil:
+		info.Time = zoned.UTC()
+	case gpsOK:
+		info.Time = gps
+	default:
+		if local, err := time.Parse(dateTimeLayout, original); err == nil {
+			info.LocalTime = local
+		}
+	}
+	return info, nil
+}
+
+// ifd reads the entries of the IFD at offset
+func (t *tiff) ifd(offset uint32) (map[uint16]entry, error) {
+	if int64(offset)+2 > int64(len(t.data)) {
+		return nil, errors.New("IFD offset out of range")
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(t.data) {
+		return nil, errors.New("IFD out of range")
+	}
+	entries := make(map[uint16]entry, count)
+	for i := range count {
+		raw := t.data[start+i*12 : start+i*12+12]
+		entries[t.order.Uint16(raw)] = entry{
+			kind:  t.order.Uint16(raw[2:]),
+			count: t.order.Uint32(raw[4:]),
+			value: raw[8:12],
+		}
+	}
+	return entries, nil
+}
+
+// valueBytes returns the value of an entry whose values are size bytes each
+func (t *tiff) valueBytes(e entry, size int) ([]byte, bool) {
+	n := int64(e.count) * int64(size)
+	if n <= 4 {
+		return e.value[:n], true
+	}
+	offset := int64(t.order.Uint32(e.value))
+	if offset+n > int64(len(t.data)) {
+		return nil, false
+	}
+	return t.data[offset : offset+n], true
+}
+
+// ascii returns the string value of a tag, or "" if it is missing
+func (t *tiff) ascii(ifd map[uint16]entry, tag uint16) string {
+	e, ok := ifd[tag]
+	if !ok || e.kind != typeASCII {
+		return ""
+	}
+	value, ok := t.valueBytes(e, 1)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+}
+
+// rationals returns the values of a tag of three rationals
+func (t *tiff) rationals(ifd map[uint16]entry, tag uint16) ([3]float64, bool) {
+	var values [3]float64
+	e, ok := ifd[tag]
+	if !ok || e.kind != typeRational || e.count != 3 {
+		return values, false
+	}
+	raw, ok := t.valueBytes(e, 8)
+	if !ok {
+		return values, false
+	}
+	for i := range values {
+		num, den := t.order.Uint32(raw[i*8:]), t.order.Uint32(raw[i*8+4:])
+		if den == 0 {
+			return values, false
+		}
+		values[i] = float64(num) / float64(den)
+	}
+	return values, true
+}
+
+// coordinate returns a GPS latitude or longitude in degrees, negated when its
+// reference is negative
+func (t *tiff) coordinate(ifd map[uint16]entry, tag, refTag uint16, negative string) (float64, bool) {
+	dms, ok := t.rationals(ifd, tag)
+	if !ok {
+		return 0, false
+	}
+	degrees := dms[0] + dms[1]/60 + dms[2]/3600
+	if strings.EqualFold(t.ascii(ifd, refTag), negative) {
+		degrees = -degrees
+	}
+	return degrees, true
+}
+
+// gpsTime returns the UTC time of the GPS fix
+func (t *tiff) gpsTime(ifd map[uint16]entry) (time.Time, bool) {
+	date, err := time.Parse("2006:01:02", t.ascii(ifd, tagGPSDateStamp))
+	if err != nil {
+		return time.Time{}, false
+	}
+	hms, ok := t.rationals(ifd, tagGPSTimeStamp)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds := hms[0]*3600 + hms[1]*60 + hms[2]
+	return date.Add(time.Duration(seconds * float64(time.Second))), true
+}
@@ -0,0 +1,182 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+)
+
+// byteOrder reads and appends in one byte order
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+type testEntry struct {
+	tag, kind uint16
+	count     uint32
+	data      []byte
+}
+
+func asciiEntry(tag uint16, s string) testEntry {
+	return testEntry{tag, typeASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func rationalEntry(order binary.ByteOrder, tag uint16, values ...[2]uint32) testEntry {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		order.PutUint32(data[i*8:], v[0])
+		order.PutUint32(data[i*8+4:], v[1])
+	}
+	return testEntry{tag, typeRational, uint32(len(values)), data}
+}
+
+// buildTIFF lays out a TIFF block with IFD0 pointing at an Exif and a GPS IFD
+func buildTIFF(order byteOrder, exifIFD, gpsIFD []testEntry) []byte {
+	ifdSize := func(entries []testEntry) int { return 2 + 12*len(entries) + 4 }
+	exifOffset := 8 + ifdSize(make([]testEntry, 2))
+	gpsOffset := exifOffset + ifdSize(exifIFD)
+	dataOffset := gpsOffset + ifdSize(gpsIFD)
+
+	pointer := func(tag uint16, offset int) testEntry {
+		data := make([]byte, 4)
+		order.PutUint32(data, uint32(offset))
+		return testEntry{tag, typeLong, 1, data}
+	}
+	var out, data []byte
+	if order == binary.LittleEndian {
+		out = append(out, "II"...)
+	} else {
+		out = append(out, "MM"...)
+	}
+	out = order.AppendUint16(out, 42)
+	out = order.AppendUint32(out, 8)
+	for _, entries := range [][]testEntry{{pointer(tagExifIFD, exifOffset), pointer(tagGPSIFD, gpsOffset)}, exifIFD, gpsIFD} {
+		out = order.AppendUint16(out, uint16(len(entries)))
+		for _, e := range entries {
+			out = order.AppendUint16(out, e.tag)
+			out = order.AppendUint16(out, e.kind)
+			out = order.AppendUint32(out, e.count)
+			if len(e.data) <= 4 {
+				out = append(out, e.data...)
+				out = append(out, make([]byte, 4-len(e.data))...)
+			} else {
+				out = order.AppendUint32(out, uint32(dataOffset+len(data)))
+				data = append(data, e.data...)
+			}
+		}
+		out = order.AppendUint32(out, 0)
+	}
+	return append(out, data...)
+}
+
+func testJPEG(t *testing.T, block []byte) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("failed to encode JPEG: %v", err)
+	}
+	if block == nil {
+		return encoded.Bytes()
+	}
+	segment := append([]byte("Exif\x00\x00"), block...)
+	out := []byte{0xff, 0xd8, 0xff, 0xe1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, encoded.Bytes()[2:]...)
+}
+
+func TestRead_JPEG(t *testing.T) {
+	order := binary.BigEndian
+	block := buildTIFF(order,
+		[]testEntry{
+			asciiEntry(tagDateTimeOriginal, "2026:01:15 22:30:00"),
+			asciiEntry(tagOffsetTimeOriginal, "+01:00"),
+		},
+		[]testEntry{
+			asciiEntry(tagGPSLatitudeRef, "N"),
+			rationalEntry(order, tagGPSLatitude, [2]uint32{53, 1}, [2]uint32{20, 1}, [2]uint32{3000, 100}),
+			asciiEntry(tagGPSLongitudeRef, "W"),
+			rationalEntry(order, tagGPSLongitude, [2]uint32{6, 1}, [2]uint32{15, 1}, [2]uint32{0, 1}),
+		})
+
+	info, err := Read(bytes.NewReader(testJPEG(t, block)))
+	if err != nil {
+		t.Fatalf("expected EXIF data, got %v", err)
+	}
+	if info.Location == nil || math.Abs(info.Location.Latitude-53.341667) > 1e-6 || info.Location.Longitude != -6.25 {
+		t.Errorf("expected 53.341667, -6.25, got %+v", info.Location)
+	}
+	if want := time.Date(2026, 1, 15, 21, 30, 0, 0, time.UTC); !info.Time.Equal(want) {
+		t.Errorf("expected %v, got %v", want, info.Time)
+	}
+}
+
+func TestRead_PNG(t *testing.T) {
+	// Without an offset for DateTimeOriginal the GPS time stamp is used
+	order := binary.LittleEndian
+	block := buildTIFF(order,
+		[]testEntry{asciiEntry(tagDateTimeOriginal, "2026:01:15 22:30:00")},
+		[]testEntry{
+			asciiEntry(tagGPSLatitudeRef, "S"),
+			rationalEntry(order, tagGPSLatitude, [2]uint32{30, 1}, [2]uint32{15, 1}, [2]uint32{0, 1}),
+			asciiEntry(tagGPSLongitudeRef, "E"),
+			rationalEntry(order, tagGPSLongitude, [2]uint32{70, 1}, [2]uint32{44, 1}, [2]uint32{0, 1}),
+			rationalEntry(order, tagGPSTimeStamp, [2]uint32{1, 1}, [2]uint32{2, 1}, [2]uint32{305, 10}),
+			asciiEntry(tagGPSDateStamp, "2026:01:16"),
+		})
+
+	var png bytes.Buffer
+	png.WriteString("\x89PNG\r\n\x1a\n")
+	png.Write(binary.BigEndian.AppendUint32(nil, 13))
+	png.WriteString("IHDR")
+	png.Write(make([]byte, 13+4))
+	png.Write(binary.BigEndian.AppendUint32(nil, uint32(len(block))))
+	png.WriteString("eXIf")
+	png.Write(block)
+	png.Write(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(append([]byte("eXIf"), block...))))
+
+	info, err := Read(&png)
+	if err != nil {
+		t.Fatalf("expected EXIF data, got %v", err)
+	}
+	if info.Location == nil || info.Location.Latitude != -30.25 || math.Abs(info.Location.Longitude-70.733333) > 1e-6 {
+		t.Errorf("expected -30.25, 70.733333, got %+v", info.Location)
+	}
+	if want := time.Date(2026, 1, 16, 1, 2, 30, 500e6, time.UTC); !info.Time.Equal(want) {
+		t.Errorf("expected %v, got %v", want, info.Time)
+	}
+}
+
+func TestRead_NoEXIF(t *testing.T) {
+	if _, err := Read(bytes.NewReader(testJPEG(t, nil))); !errors.Is(err, ErrNoEXIF) {
+		t.Errorf("expected ErrNoEXIF, got %v", err)
+	}
+
+	// A time with no zone is only the camera's clock reading
+	block := buildTIFF(binary.BigEndian, []testEntry{asciiEntry(tagDateTimeOriginal, "2026:01:15 22:30:00")}, nil)
+	info, err := Read(bytes.NewReader(testJPEG(t, block)))
+	if err != nil {
+		t.Fatalf("expected EXIF data, got %v", err)
+	}
+	if info.Location != nil || !info.Time.IsZero() || !info.LocalTime.Equal(time.Date(2026, 1, 15, 22, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected only a local time, got %+v", info)
+	}
+
+	// A tag whose count claims far more values than the block holds is
+	// ignored without reading past it
+	block = buildTIFF(binary.BigEndian, []testEntry{{tagDateTimeOriginal, typeASCII, 0x40000001, []byte("2026")}}, nil)
+	if info, err := Read(bytes.NewReader(testJPEG(t, block))); err != nil || !info.Time.IsZero() || !info.LocalTime.IsZero() {
+		t.Errorf("expected the oversized tag to be ignored, got %+v, %v", info, err)
+	}
+
+	if _, err := Read(bytes.NewReader([]byte("SIMPLE  ="))); err == nil {
+		t.Error("expected an error for a file that is not an image")
+	}
+}
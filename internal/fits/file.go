@@ -0,0 +1,309 @@
+package fits
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFITS is returned when a file does not start with a FITS primary header
+var ErrNotFITS = errors.New("not a FITS file")
+
+// HDU is one header and data unit of a FITS file
+type HDU struct {
+	Header Header
+	// dataOffset and dataSize locate the data in the source file; dataSize
+	// does not include the padding of the last block
+	dataOffset int64
+	dataSize   int64
+}
+
+// File is a FITS file read from a source. Its headers may be changed before it
+// is written out again; everything else is copied from the source unchanged.
+type File struct {
+	HDUs []*HDU
+	src  io.ReaderAt
+	size int64
+	// end is where the last HDU ends; anything after it is kept as it is
+	end int64
+}
+
+// Read reads the headers of the FITS file of the given size in src. The data
+// is only read from src when the file is written.
+func Read(src io.ReaderAt, size int64) (*File, error) {
+	f := &File{src: src, size: size}
+	offset := int64(0)
+	for offset+BlockSize <= size {
+		header, headerSize, err := readHeader(src, offset, size)
+		switch {
+		case len(f.HDUs) == 0 && err != nil:
+			return nil, err
+		case len(f.HDUs) == 0 && (header[0].Key != "SIMPLE" || header[0].Value != "T"):
+			return nil, ErrNotFITS
+		case len(f.HDUs) > 0 && (errors.Is(err, ErrNotFITS) || err == nil && header[0].Key != "XTENSION"):
+			// Not an extension: whatever follows the last HDU is kept as it is
+			f.end = offset
+			return f, nil
+		case err != nil:
+			return nil, fmt.Errorf("HDU %d: %w", len(f.HDUs), err)
+		}
+
+		dataOffset := offset + headerSize
+		dataSize, err := header.dataSize(size - dataOffset)
+		if err != nil {
+			return nil, fmt.Errorf("HDU %d: %w", len(f.HDUs), err)
+		}
+		hdu := &HDU{
+			Header:     header,
+			dataOffset: dataOffset,
+			dataSize:   dataSize,
+		}
+		f.HDUs = append(f.HDUs, hdu)
+		// Some writers leave out the padding at the end of the file. The
+		// header takes at least a block, so the offset always moves on.
+		next := min(hdu.dataOffset+padded(dataSize), size)
+		if next <= offset {
+			return nil, fmt.Errorf("HDU %d: does not advance through the file", len(f.HDUs)-1)
+		}
+		offset = next
+	}
+	if len(f.HDUs) == 0 {
+		return nil, ErrNotFITS
+	}
+	f.end = offset
+	return f, nil
+}
+
+// readHeader reads the header starting at offset, returning its cards without
+// END and its size in bytes
+func readHeader(src io.ReaderAt, offset, size int64) (Header, int64, error) {
+	var header Header
+	block := make([]byte, BlockSize)
+	for start := offset; start+BlockSize <= size; start += BlockSize {
+		if _, err := src.ReadAt(block, start); err != nil {
+			return nil, 0, err
+		}
+		for i := 0; i < BlockSize; i += cardSize {
+			record := string(block[i : i+cardSize])
+			card := parseCard(record)
+			if len(header) == 0 && card.Key != "SIMPLE" && card.Key != "XTENSION" {
+				return nil, 0, ErrNotFITS
+			}
+			if card.Key == "END" {
+				return header, start + BlockSize - offset, nil
+			}
+			header = append(header, card)
+		}
+	}
+	return nil, 0, errors.New("header has no END card")
+}
+
+// parseCard splits an 80 character record into a card that is written back
+// exactly as it was read
+func parseCard(record string) Card {
+	card := Card{Key: strings.TrimRight(record[:8], " "), raw: record}
+	if record[8:10] != "= " || card.Key == "COMMENT" || card.Key == "HISTORY" || card.Key == "" {
+		card.Value = strings.TrimRight(record[8:], " ")
+		return card
+	}
+
+	rest := strings.TrimLeft(record[10:], " ")
+	if strings.HasPrefix(rest, "'") {
+		// A quote inside a string is written as two quotes
+		end := 1
+		for end < len(rest) {
+			if rest[end] == '\'' {
+				if end+1 < len(rest) && rest[end+1] == '\'' {
+					end += 2
+					continue
+				}
+				break
+			}
+			end++
+		}
+		card.Value = rest[:min(end+1, len(rest))]
+		rest = rest[min(end+1, len(rest)):]
+		if _, comment, ok := strings.Cut(rest, "/"); ok {
+			card.Comment = strings.TrimSpace(comment)
+		}
+		return card
+	}
+	value, comment, ok := strings.Cut(rest, "/")
+	card.Value = strings.TrimSpace(value)
+	if ok {
+		card.Comment = strings.TrimSpace(comment)
+	}
+	return card
+}
+
+// Get returns the value of the first card with the given key
+func (h Header) Get(key string) (string, bool) {
+	for _, c := range h {
+		if c.Key == key {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+// Int returns the integer value of key, or def if it is not set
+func (h Header) Int(key string, def int64) (int64, error) {
+	value, ok := h.Get(key)
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q", key, value)
+	}
+	return n, nil
+}
+
+// Values returns the value of each keyword as written in the header, taking
+// the first card of a repeated keyword and leaving out commentary cards
+func (h Header) Values() map[string]string {
+	values := make(map[string]string, len(h))
+	for _, c := range h {
+		if c.Key == "" || c.Key == "COMMENT" || c.Key == "HISTORY" {
+			continue
+		}
+		if _, ok := values[c.Key]; !ok {
+			values[c.Key] = c.Value
+		}
+	}
+	return values
+}
+
+// padded returns size rounded up to a whole number of blocks
+func padded(size int64) int64 {
+	return (size + BlockSize - 1) / BlockSize * BlockSize
+}
+
+// errTruncated is returned for an HDU whose data runs past the end of the
+// file
+var errTruncated = errors.New("data is truncated")
+
+// dataSize returns the size of the data following the header, without
+// padding. It fails with errTruncated if the size is more than limit, the
+// bytes left in the file.
+func (h Header) dataSize(limit int64) (int64, error) {
+	bitpix, err := h.Int("BITPIX", 0)
+	if err != nil {
+		return 0, err
+	}
+	naxis, err := h.Int("NAXIS", 0)
+	if err != nil {
+		return 0, err
+	}
+	if naxis < 0 || naxis > 999 {
+		return 0, fmt.Errorf("invalid NAXIS value %d", naxis)
+	}
+	if naxis == 0 {
+		return 0, nil
+	}
+
+	elements := int64(1)
+	for i := int64(1); i <= naxis; i++ {
+		n, err := h.Int("NAXIS"+strconv.FormatInt(i, 10), 0)
+		if err != nil {
+			return 0, err
+		}
+		if n < 0 {
+			return 0, fmt.Errorf("invalid NAXIS%d value %d", i, n)
+		}
+		// A size past the end of the file is rejected before it can overflow
+		if n > 0 && elements > limit/n {
+			return 0, errTruncated
+		}
+		// Random groups have NAXIS1 = 0, which does not count
+		if i == 1 && n == 0 && h[0].Key == "SIMPLE" {
+			if groups, _ := h.Get("GROUPS"); groups == "T" {
+				continue
+			}
+		}
+		elements *= n
+	}
+	pcount, err := h.Int("PCOUNT", 0)
+	if err != nil {
+		return 0, err
+	}
+	if pcount < 0 {
+		return 0, fmt.Errorf("invalid PCOUNT value %d", pcount)
+	}
+	gcount, err := h.Int("GCOUNT", 1)
+	if err != nil {
+		return 0, err
+	}
+	if gcount < 1 {
+		return 0, fmt.Errorf("invalid GCOUNT value %d", gcount)
+	}
+	elementSize := bitpix / 8
+	if elementSize < 0 {
+		elementSize = -elementSize
+	}
+
+	// Both terms are at most limit, so the sum cannot overflow
+	if pcount > limit {
+		return 0, errTruncated
+	}
+	size := pcount + elements
+	if size > limit/gcount || elementSize > 0 && size*gcount > limit/elementSize {
+		return 0, errTruncated
+	}
+	return elementSize * gcount * size, nil
+}
+
+// IsImage reports whether the HDU holds an image with at least two axes,
+// either as an image or as a tile-compressed image
+func (hdu *HDU) IsImage() bool {
+	naxis, _ := hdu.Header.Int("NAXIS", 0)
+	switch xtension, _ := hdu.Header.Get("XTENSION"); {
+	case hdu.Header[0].Key == "SIMPLE" || strings.Trim(xtension, "' ") == "IMAGE":
+		return naxis >= 2
+	case strings.Trim(xtension, "' ") == "BINTABLE":
+		zimage, _ := hdu.Header.Get("ZIMAGE")
+		znaxis, _ := hdu.Header.Int("ZNAXIS", 0)
+		return zimage == "T" && znaxis >= 2
+	}
+	return false
+}
+
+// Size returns the size of the file as it will be written
+func (f *File) Size() int64 {
+	size := f.size - f.end
+	for _, hdu := range f.HDUs {
+		// The header is followed by END
+		size += padded(int64(len(hdu.Header)+1)*cardSize) + padded(hdu.dataSize)
+	}
+	return size
+}
+
+// WriteTo writes the file, with its headers as they are now, to w
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, hdu := range f.HDUs {
+		n, err := w.Write(hdu.Header.Encode())
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		// Copy the data with its padding, adding any padding the source lacks
+		size := padded(hdu.dataSize)
+		available := min(size, f.size-hdu.dataOffset)
+		copied, err := io.Copy(w, io.NewSectionReader(f.src, hdu.dataOffset, available))
+		written += copied
+		if err != nil {
+			return written, err
+		}
+		n, err = w.Write(make([]byte, size-available))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	copied, err := io.Copy(w, io.NewSectionReader(f.src, f.end, f.size-f.end))
+	written += copied
+	return written, err
+}
@@ -0,0 +1,196 @@
+package fits
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFile returns a FITS file with a 4x3 16-bit primary image carrying a
+// stale WCS, followed by a binary table extension
+func testFile() []byte {
+	var buf bytes.Buffer
+	buf.Write(Header{
+		{Key: "SIMPLE", Value: "T"},
+		{Key: "BITPIX", Value: "16"},
+		{Key: "NAXIS", Value: "2"},
+		{Key: "NAXIS1", Value: "4"},
+		{Key: "NAXIS2", Value: "3"},
+		{Key: "OBJECT", Value: "'M42'", Comment: "Orion   / Nebula"},
+		{Key: "CTYPE1", Value: "'RA---TAN'"},
+		{Key: "CTYPE1", Value: "'RA---TAN'"},
+		{Key: "CDELT1", Value: "-0.001"},
+		{Key: "CD1_1", Value: "-0.002"},
+		{Key: "PC1_2", Value: "0.1"},
+		{Key: "A_ORDER", Value: "3"},
+		{Key: "A_3_0", Value: "1E-9"},
+		{Key: "CHECKSUM", Value: "'9a3dAa3d9a3dAa3d'"},
+		{Key: "HISTORY", Value: "Taken with a camera"},
+	}.Encode())
+	image := bytes.Repeat([]byte{1, 2}, 12)
+	buf.Write(image)
+	buf.Write(make([]byte, BlockSize-len(image)))
+
+	buf.Write(Header{
+		{Key: "XTENSION", Value: "'BINTABLE'"},
+		{Key: "BITPIX", Value: "8"},
+		{Key: "NAXIS", Value: "2"},
+		{Key: "NAXIS1", Value: "8"},
+		{Key: "NAXIS2", Value: "2"},
+		{Key: "PCOUNT", Value: "0"},
+		{Key: "GCOUNT", Value: "1"},
+		{Key: "TFIELDS", Value: "1"},
+		{Key: "CRVAL1", Value: "1.0"},
+	}.Encode())
+	// The last block's padding is left out, as some writers do
+	buf.WriteString("abcdefghijklmnop")
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	data := testFile()
+	f, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.HDUs) != 2 {
+		t.Fatalf("expected 2 HDUs, got %d", len(f.HDUs))
+	}
+	if !f.HDUs[0].IsImage() || f.HDUs[1].IsImage() {
+		t.Error("expected only the primary HDU to be an image")
+	}
+	if value, _ := f.HDUs[0].Header.Get("OBJECT"); value != "'M42'" {
+		t.Errorf("expected OBJECT 'M42', got %s", value)
+	}
+	if c := f.HDUs[0].Header[5]; c.Comment != "Orion   / Nebula" {
+		t.Errorf("expected comment with a slash, got %q", c.Comment)
+	}
+	values := f.HDUs[0].Header.Values()
+	if values["CTYPE1"] != "'RA---TAN'" || values["NAXIS1"] != "4" {
+		t.Errorf("expected header values, got %v", values)
+	}
+	if _, ok := values["HISTORY"]; ok {
+		t.Error("expected HISTORY to be left out of the values")
+	}
+
+	var out bytes.Buffer
+	if _, err := f.WriteTo(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := append(data, make([]byte, BlockSize-16)...)
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("expected an unchanged file to be written back as it was, padded")
+	}
+	if f.Size() != int64(out.Len()) {
+		t.Errorf("expected size %d, got %d", out.Len(), f.Size())
+	}
+}
+
+func TestRead_Invalid(t *testing.T) {
+	tests := map[string][]byte{
+		"jpeg":      append([]byte{0xFF, 0xD8, 0xFF}, make([]byte, BlockSize)...),
+		"short":     []byte("SIMPLE  =                    T"),
+		"no end":    bytes.Repeat([]byte(Card{Key: "SIMPLE", Value: "T"}.String()), 36),
+		"truncated": Header{{Key: "SIMPLE", Value: "T"}, {Key: "BITPIX", Value: "8"}, {Key: "NAXIS", Value: "1"}, {Key: "NAXIS1", Value: "10"}}.Encode(),
+	}
+	for name, data := range tests {
+		if _, err := Read(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRead_InvalidSizes(t *testing.T) {
+	primary := Header{{Key: "SIMPLE", Value: "T"}, {Key: "BITPIX", Value: "8"}, {Key: "NAXIS", Value: "0"}}.Encode()
+	extension := func(cards ...Card) []byte {
+		header := Header{{Key: "XTENSION", Value: "'BINTABLE'"}, {Key: "BITPIX", Value: "8"}, {Key: "NAXIS", Value: "2"}}
+		return append(slices.Clone(primary), append(header, cards...).Encode()...)
+	}
+	tests := map[string][]byte{
+		// A negative size would step back to the same extension forever
+		"negative gcount": extension(Card{Key: "NAXIS1", Value: "8"}, Card{Key: "NAXIS2", Value: "2"}, Card{Key: "GCOUNT", Value: "-2"}),
+		"zero gcount":     extension(Card{Key: "NAXIS1", Value: "8"}, Card{Key: "NAXIS2", Value: "2"}, Card{Key: "GCOUNT", Value: "0"}),
+		"negative pcount": extension(Card{Key: "NAXIS1", Value: "8"}, Card{Key: "NAXIS2", Value: "2"}, Card{Key: "PCOUNT", Value: "-16"}),
+		"huge pcount":     extension(Card{Key: "NAXIS1", Value: "8"}, Card{Key: "NAXIS2", Value: "2"}, Card{Key: "PCOUNT", Value: "9223372036854775807"}),
+		"overflow":        extension(Card{Key: "NAXIS1", Value: "4294967296"}, Card{Key: "NAXIS2", Value: "4294967296"}),
+		"huge gcount":     extension(Card{Key: "NAXIS1", Value: "8"}, Card{Key: "NAXIS2", Value: "2"}, Card{Key: "GCOUNT", Value: "4611686018427387904"}),
+	}
+	for name, data := range tests {
+		data = append(data, make([]byte, 2*BlockSize)...)
+		done := make(chan error, 1)
+		go func() {
+			_, err := Read(bytes.NewReader(data), int64(len(data)))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: expected Read to return", name)
+		}
+	}
+}
+
+func TestHeader_WithWCS(t *testing.T) {
+	data := testFile()
+	f, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	primary := f.HDUs[0]
+	primary.Header = primary.Header.WithWCS(map[string]string{
+		"SIMPLE":  "T",
+		"NAXIS":   "2",
+		"DATE":    "2026-01-01",
+		"CTYPE1":  "RA---TAN-SIP",
+		"CRPIX1":  "2",
+		"CD1_1":   "-0.0003",
+		"A_ORDER": "2",
+		"A_2_0":   "1e-6",
+	}, "WCS from a test solve")
+
+	var out bytes.Buffer
+	if _, err := f.WriteTo(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Size() != int64(out.Len()) {
+		t.Errorf("expected size %d, got %d", out.Len(), f.Size())
+	}
+	written, err := Read(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("failed to read written file: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, c := range written.HDUs[0].Header {
+		counts[c.Key]++
+	}
+	for key, want := range map[string]int{
+		"SIMPLE": 1, "NAXIS": 1, "OBJECT": 1, "CTYPE1": 1, "CRPIX1": 1, "CD1_1": 1,
+		"A_ORDER": 1, "A_2_0": 1, "HISTORY": 2,
+		"CDELT1": 0, "PC1_2": 0, "A_3_0": 0, "CHECKSUM": 0, "DATE": 0,
+	} {
+		if counts[key] != want {
+			t.Errorf("expected %d %s cards, got %d", want, key, counts[key])
+		}
+	}
+	if value, _ := written.HDUs[0].Header.Get("CRPIX1"); value != "2.0" {
+		t.Errorf("expected CRPIX1 2.0, got %s", value)
+	}
+	if !strings.Contains(out.String(), "HISTORY WCS from a test solve") {
+		t.Error("expected the history card")
+	}
+
+	// The data and the extension are untouched
+	image := out.Bytes()[BlockSize : BlockSize+24]
+	if !bytes.Equal(image, bytes.Repeat([]byte{1, 2}, 12)) {
+		t.Error("expected the image data to be preserved")
+	}
+	if value, _ := written.HDUs[1].Header.Get("CRVAL1"); value != "1.0" {
+		t.Errorf("expected the extension's CRVAL1 1.0 to be kept, got %s", value)
+	}
+}
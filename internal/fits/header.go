@@ -0,0 +1,292 @@
+// Package fits writes FITS headers holding the WCS of a solve, on their own or
+// merged into the FITS file that was solved
+package fits
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BlockSize is the size FITS headers and data are padded to
+const BlockSize = 2880
+
+// cardSize is the size of one header card
+const cardSize = 80
+
+// Card is one keyword record of a header. Value holds the value as written in
+// the header, e.g. "-6.19791638337", "T" or "'RA---TAN'".
+type Card struct {
+	Key     string
+	Value   string
+	Comment string
+	// raw is the record a card was read from, which it is written back as
+	raw string
+}
+
+// Header is an ordered list of cards
+type Header []Card
+
+// wcsOrder is the order WCS keywords are written in; others follow alphabetically
+var wcsOrder = []string{
+	"WCSAXES", "CTYPE1", "CTYPE2", "EQUINOX", "LONPOLE", "LATPOLE",
+	"CRVAL1", "CRVAL2", "CRPIX1", "CRPIX2", "CUNIT1", "CUNIT2",
+	"CD1_1", "CD1_2", "CD2_1", "CD2_2", "IMAGEW", "IMAGEH",
+	"A_ORDER", "B_ORDER", "AP_ORDER", "BP_ORDER",
+}
+
+// structural keywords are written by WCSHeader itself
+var structural = map[string]bool{
+	"SIMPLE": true, "BITPIX": true, "NAXIS": true, "EXTEND": true, "END": true,
+	"XTENSION": true, "PCOUNT": true, "GCOUNT": true,
+}
+
+// WCSHeader returns the header of a data-less FITS file holding the WCS keywords
+// of a solve result
+func WCSHeader(wcs map[string]string) Header {
+	header := Header{
+		{Key: "SIMPLE", Value: "T", Comment: "Standard FITS file"},
+		{Key: "BITPIX", Value: "8", Comment: "No data"},
+		{Key: "NAXIS", Value: "0", Comment: "No data"},
+		{Key: "EXTEND", Value: "T"},
+	}
+	return append(header, wcsCards(wcs)...)
+}
+
+// wcsCards returns the cards of the WCS keywords of a solve result in the
+// standard order, leaving out those describing the file rather than the WCS
+func wcsCards(wcs map[string]string) Header {
+	var header Header
+	rank := make(map[string]int, len(wcsOrder))
+	for i, key := range wcsOrder {
+		rank[key] = i
+	}
+	values := make(map[string]string, len(wcs))
+	keys := make([]string, 0, len(wcs))
+	for key, value := range wcs {
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if key == "" || structural[key] || strings.HasPrefix(key, "NAXIS") {
+			continue
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, iKnown := rank[keys[i]]
+		rj, jKnown := rank[keys[j]]
+		switch {
+		case iKnown && jKnown:
+			return ri < rj
+		case iKnown != jKnown:
+			return iKnown
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		header = append(header, NewCard(key, values[key]))
+	}
+	return header
+}
+
+// wcsKey matches the keywords of a primary WCS, including SIP distortions and
+// the IMAGEW/IMAGEH keywords solve-field adds
+var wcsKey = regexp.MustCompile(`^(WCSAXES|WCSNAME|CTYPE\d|CRVAL\d|CRPIX\d|CUNIT\d|CDELT\d|CROTA\d|CD\d_\d|PC\d_\d|PV\d_\d+|PS\d_\d+|` +
+	`EQUINOX|EPOCH|RADESYS|RADECSYS|LONPOLE|LATPOLE|IMAGEW|IMAGEH|(A|B|AP|BP)_(ORDER|DMAX|\d+_\d+))$`)
+
+// WithWCS returns h with its WCS replaced by that of a solve result. Every
+// existing WCS keyword is removed, so no stale CD matrix, CDELT/CROTA pair or
+// SIP polynomial survives, then the solve's keywords and the history cards are
+// added at the end.
+func (h Header) WithWCS(wcs map[string]string, history ...string) Header {
+	header := make(Header, 0, len(h)+len(wcs)+len(history))
+	for _, c := range h {
+		// The changed header no longer matches the checksum, but DATASUM still holds
+		if !wcsKey.MatchString(c.Key) && c.Key != "CHECKSUM" {
+			header = append(header, c)
+		}
+	}
+	// Only WCS keywords are taken from the solve, as the rest describe its own file
+	solved := make(map[string]string, len(wcs))
+	for key, value := range wcs {
+		if wcsKey.MatchString(strings.ToUpper(strings.TrimSpace(key))) {
+			solved[key] = value
+		}
+	}
+	header = append(header, wcsCards(solved)...)
+	for _, line := range history {
+		header = append(header, Card{Key: "HISTORY", Value: line})
+	}
+	return header
+}
+
+// integerKeys are WCS keywords holding integers
+var integerKeys = map[string]bool{
+	"WCSAXES": true, "IMAGEW": true, "IMAGEH": true,
+	"A_ORDER": true, "B_ORDER": true, "AP_ORDER": true, "BP_ORDER": true,
+}
+
+// realPrefixes start the WCS keywords holding reals
+var realPrefixes = []string{
+	"EQUINOX", "LONPOLE", "LATPOLE", "CRVAL", "CRPIX", "CDELT", "CROTA",
+	"CD", "PC", "A_", "B_", "AP_", "BP_",
+}
+
+// comments describe the standard WCS keywords, as solve-field does
+var comments = map[string]string{
+	"WCSAXES":  "Number of WCS axes",
+	"EQUINOX":  "Equatorial coordinates definition (yr)",
+	"LONPOLE":  "Native longitude of celestial pole",
+	"LATPOLE":  "Native latitude of celestial pole",
+	"CRVAL1":   "RA  of reference point",
+	"CRVAL2":   "DEC of reference point",
+	"CRPIX1":   "X reference pixel",
+	"CRPIX2":   "Y reference pixel",
+	"CUNIT1":   "X pixel scale units",
+	"CUNIT2":   "Y pixel scale units",
+	"CD1_1":    "Transformation matrix",
+	"CD1_2":    "Transformation matrix",
+	"CD2_1":    "Transformation matrix",
+	"CD2_2":    "Transformation matrix",
+	"IMAGEW":   "Image width,  in pixels.",
+	"IMAGEH":   "Image height, in pixels.",
+	"A_ORDER":  "Polynomial order, axis 1",
+	"B_ORDER":  "Polynomial order, axis 2",
+	"AP_ORDER": "Inv polynomial order, axis 1",
+	"BP_ORDER": "Inv polynomial order, axis 2",
+}
+
+// NewCard returns the card for a WCS keyword and its value as reported by the
+// solver, typing the value by keyword and adding the standard comment
+func NewCard(key, value string) Card {
+	value = strings.TrimSpace(value)
+	card := Card{Key: key, Comment: comments[key]}
+	switch {
+	case key == "COMMENT" || key == "HISTORY":
+		card.Value = value
+		return card
+	case integerKeys[key]:
+		card.Value = formatInteger(value)
+	case isReal(key):
+		card.Value = formatReal(value)
+	default:
+		card.Value = FormatValue(value)
+	}
+	if key == "CTYPE1" || key == "CTYPE2" {
+		card.Comment = "TAN (gnomic) projection"
+		if strings.HasSuffix(strings.Trim(value, "' "), "-SIP") {
+			card.Comment += " + SIP distortions"
+		}
+	}
+	return card
+}
+
+func isReal(key string) bool {
+	for _, prefix := range realPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatInteger writes a whole number as an integer, e.g. "2.0" as "2"
+func formatInteger(v string) string {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || !numeric(v) || f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return FormatValue(v)
+	}
+	return strconv.FormatInt(int64(f), 10)
+}
+
+// formatReal writes a number as a real with a decimal point and an upper case
+// exponent, e.g. "512" as "512.0" and "1e-07" as "1.0E-07", keeping its digits
+func formatReal(v string) string {
+	if _, err := strconv.ParseFloat(v, 64); err != nil || !numeric(v) {
+		return FormatValue(v)
+	}
+	mantissa, exponent, _ := strings.Cut(strings.ToUpper(v), "E")
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	if exponent != "" {
+		return mantissa + "E" + exponent
+	}
+	return mantissa
+}
+
+// FormatValue returns v as a header value: logicals and numbers as they are,
+// anything else as a quoted string
+func FormatValue(v string) string {
+	v = strings.TrimSpace(v)
+	switch {
+	case v == "T" || v == "F":
+		return v
+	case len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'':
+		return Quote(strings.ReplaceAll(v[1:len(v)-1], "''", "'"))
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return v
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(v, "D", "E", 1), 64); err == nil && numeric(v) {
+		// FITS exponents are upper case
+		return strings.ToUpper(v)
+	}
+	return Quote(v)
+}
+
+// numeric reports whether v is written in decimal notation, which rules out
+// the "Inf", "NaN" and hexadecimal forms strconv also accepts
+func numeric(v string) bool {
+	return strings.Trim(v, "0123456789+-.eEdD") == "" && strings.ContainsAny(v, "0123456789")
+}
+
+// Quote returns s as a FITS string value
+func Quote(s string) string {
+	s = strings.TrimRight(s, " ")
+	// Strings are padded to at least 8 characters
+	return "'" + fmt.Sprintf("%-8s", strings.ReplaceAll(s, "'", "''")) + "'"
+}
+
+// String returns the card as an 80 character record
+func (c Card) String() string {
+	if c.raw != "" {
+		return c.raw
+	}
+	var card string
+	switch {
+	case c.Key == "COMMENT" || c.Key == "HISTORY" || c.Key == "":
+		card = fmt.Sprintf("%-8s%s", c.Key, c.Value)
+	case strings.HasPrefix(c.Value, "'"):
+		card = fmt.Sprintf("%-8s= %s", c.Key, c.Value)
+	default:
+		// Fixed format: logicals and numbers end in column 30
+		card = fmt.Sprintf("%-8s= %20s", c.Key, c.Value)
+	}
+	if c.Comment != "" && c.Key != "COMMENT" && c.Key != "HISTORY" {
+		card += " / " + c.Comment
+	}
+	if len(card) > cardSize {
+		card = card[:cardSize]
+	}
+	return fmt.Sprintf("%-80s", card)
+}
+
+// Encode returns the header followed by END, padded to a whole number of blocks
+func (h Header) Encode() []byte {
+	var buf bytes.Buffer
+	for _, c := range h {
+		buf.WriteString(c.String())
+	}
+	fmt.Fprintf(&buf, "%-80s", "END")
+	if pad := buf.Len() % BlockSize; pad != 0 {
+		buf.Write(bytes.Repeat([]byte{' '}, BlockSize-pad))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,106 @@
+package fits
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"83.822", "83.822"},
+		{"-6.2E-04", "-6.2E-04"},
+		{"1e-07", "1E-07"},
+		{"Inf", "'Inf     '"},
+		{"2", "2"},
+		{"T", "T"},
+		{"RA---TAN", "'RA---TAN'"},
+		{"'deg'", "'deg     '"},
+		{"O'Neil", "'O''Neil '"},
+	}
+	for _, tt := range tests {
+		if got := FormatValue(tt.in); got != tt.want {
+			t.Errorf("FormatValue(%q): expected %s, got %s", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestCard_String(t *testing.T) {
+	tests := []struct {
+		card Card
+		want string
+	}{
+		{Card{Key: "NAXIS", Value: "0", Comment: "No data"}, "NAXIS   =                    0 / No data"},
+		{Card{Key: "CTYPE1", Value: "'RA---TAN'"}, "CTYPE1  = 'RA---TAN'"},
+		{Card{Key: "HISTORY", Value: "Solved by astrometry-api-server"}, "HISTORY Solved by astrometry-api-server"},
+	}
+	for _, tt := range tests {
+		got := tt.card.String()
+		if len(got) != 80 {
+			t.Errorf("expected 80 characters, got %d", len(got))
+		}
+		if strings.TrimRight(got, " ") != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestWCSHeader(t *testing.T) {
+	header := WCSHeader(map[string]string{
+		"CD1_1":   "-0.0003",
+		"CRVAL1":  "83.8",
+		"ctype1":  "RA---TAN",
+		"NAXIS":   "2",
+		"XTRA":    "1",
+		"A_0_2":   "1e-7",
+		"A_ORDER": "2",
+	})
+
+	var keys []string
+	for _, c := range header {
+		keys = append(keys, c.Key)
+	}
+	want := "SIMPLE BITPIX NAXIS EXTEND CTYPE1 CRVAL1 CD1_1 A_ORDER A_0_2 XTRA"
+	if got := strings.Join(keys, " "); got != want {
+		t.Errorf("expected keys %s, got %s", want, got)
+	}
+
+	data := header.Encode()
+	if len(data)%BlockSize != 0 {
+		t.Errorf("expected whole blocks, got %d bytes", len(data))
+	}
+	if !strings.Contains(string(data), "END"+strings.Repeat(" ", 77)) {
+		t.Error("expected END card")
+	}
+}
+
+func TestNewCard(t *testing.T) {
+	tests := []struct {
+		key, value  string
+		wantValue   string
+		wantComment string
+	}{
+		{"CRPIX1", "512", "512.0", "X reference pixel"},
+		{"CRVAL2", "-5.391", "-5.391", "DEC of reference point"},
+		{"CD1_1", "-3.3e-04", "-3.3E-04", "Transformation matrix"},
+		{"A_0_2", "1e-07", "1.0E-07", ""},
+		{"IMAGEW", "1024.0", "1024", "Image width,  in pixels."},
+		{"A_ORDER", "2", "2", "Polynomial order, axis 1"},
+		{"CTYPE1", "RA---TAN-SIP", "'RA---TAN-SIP'", "TAN (gnomic) projection + SIP distortions"},
+		{"CTYPE2", "'DEC--TAN'", "'DEC--TAN'", "TAN (gnomic) projection"},
+		{"CRVAL1", "NaN", "'NaN     '", "RA  of reference point"},
+		{"IMAGEH", "1.5", "1.5", "Image height, in pixels."},
+		{"HISTORY", "Created by solve-field", "Created by solve-field", ""},
+	}
+	for _, tt := range tests {
+		card := NewCard(tt.key, tt.value)
+		if card.Value != tt.wantValue {
+			t.Errorf("%s = %q: expected value %s, got %s", tt.key, tt.value, tt.wantValue, card.Value)
+		}
+		if card.Comment != tt.wantComment {
+			t.Errorf("%s: expected comment %q, got %q", tt.key, tt.wantComment, card.Comment)
+		}
+	}
+}
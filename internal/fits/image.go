@@ -0,0 +1,101 @@
+package fits
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Image reads the first plane of the image in HDU i, taking every step-th
+// pixel of every step-th row, in the order they are stored: the first pixel
+// is at FITS pixel (1, 1). BSCALE and BZERO are applied, and blank pixels are
+// NaN.
+func (f *File) Image(i, step int) (width, height int, pixels []float32, err error) {
+	if i < 0 || i >= len(f.HDUs) || !f.HDUs[i].IsImage() {
+		return 0, 0, nil, fmt.Errorf("HDU %d holds no image", i)
+	}
+	hdu := f.HDUs[i]
+	if xtension, _ := hdu.Header.Get("XTENSION"); strings.Trim(xtension, "' ") == "BINTABLE" {
+		return 0, 0, nil, errors.New("compressed images are not supported")
+	}
+	bitpix, err := hdu.Header.Int("BITPIX", 0)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	naxis1, err := hdu.Header.Int("NAXIS1", 0)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	naxis2, err := hdu.Header.Int("NAXIS2", 0)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	scale, err := hdu.Header.Float("BSCALE", 1)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	zero, err := hdu.Header.Float("BZERO", 0)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	_, hasBlank := hdu.Header.Get("BLANK")
+	blank, err := hdu.Header.Int("BLANK", 0)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var decode func([]byte) float64
+	switch bitpix {
+	case 8:
+		decode = func(b []byte) float64 { return float64(b[0]) }
+	case 16:
+		decode = func(b []byte) float64 { return float64(int16(binary.BigEndian.Uint16(b))) }
+	case 32:
+		decode = func(b []byte) float64 { return float64(int32(binary.BigEndian.Uint32(b))) }
+	case 64:
+		decode = func(b []byte) float64 { return float64(int64(binary.BigEndian.Uint64(b))) }
+	case -32:
+		decode = func(b []byte) float64 { return float64(math.Float32frombits(binary.BigEndian.Uint32(b))) }
+	case -64:
+		decode = func(b []byte) float64 { return math.Float64frombits(binary.BigEndian.Uint64(b)) }
+	default:
+		return 0, 0, nil, fmt.Errorf("invalid BITPIX value %d", bitpix)
+	}
+
+	step = max(step, 1)
+	size := int(max(bitpix, -bitpix) / 8)
+	width, height = int(naxis1+int64(step)-1)/step, int(naxis2+int64(step)-1)/step
+	pixels = make([]float32, 0, width*height)
+	row := make([]byte, int(naxis1)*size)
+	for y := 0; y < int(naxis2); y += step {
+		if _, err := f.src.ReadAt(row, hdu.dataOffset+int64(y)*int64(len(row))); err != nil {
+			return 0, 0, nil, err
+		}
+		for x := 0; x < int(naxis1); x += step {
+			b := row[x*size : (x+1)*size]
+			value := decode(b)
+			if bitpix > 0 && hasBlank && int64(value) == blank {
+				pixels = append(pixels, float32(math.NaN()))
+				continue
+			}
+			pixels = append(pixels, float32(zero+scale*value))
+		}
+	}
+	return width, height, pixels, nil
+}
+
+// Float returns the numeric value of key, or def if it is not set
+func (h Header) Float(key string, def float64) (float64, error) {
+	value, ok := h.Get(key)
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q", key, value)
+	}
+	return n, nil
+}
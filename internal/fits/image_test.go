@@ -0,0 +1,56 @@
+package fits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestFile_Image(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(Header{
+		{Key: "SIMPLE", Value: "T"},
+		{Key: "BITPIX", Value: "16"},
+		{Key: "NAXIS", Value: "3"},
+		{Key: "NAXIS1", Value: "3"},
+		{Key: "NAXIS2", Value: "2"},
+		{Key: "NAXIS3", Value: "2"},
+		{Key: "BZERO", Value: "32768"},
+		{Key: "BLANK", Value: "-32768"},
+	}.Encode())
+	for _, v := range []int16{-32768, -32767, 0, 1, 2, 3, 9, 9, 9, 9, 9, 9} {
+		binary.Write(&buf, binary.BigEndian, v) //nolint:errcheck // Writes to a buffer do not fail
+	}
+	buf.Write(make([]byte, BlockSize-24))
+
+	f, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	width, height, pixels, err := f.Image(0, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if width != 3 || height != 2 || len(pixels) != 6 {
+		t.Fatalf("expected the first 3x2 plane, got %dx%d with %d pixels", width, height, len(pixels))
+	}
+	if !math.IsNaN(float64(pixels[0])) {
+		t.Errorf("expected a blank pixel to be NaN, got %f", pixels[0])
+	}
+	if pixels[1] != 1 || pixels[2] != 32768 || pixels[5] != 32771 {
+		t.Errorf("expected BZERO to be applied, got %v", pixels)
+	}
+
+	width, height, pixels, err = f.Image(0, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if width != 2 || height != 1 || pixels[1] != 32768 {
+		t.Errorf("expected every other pixel, got %dx%d %v", width, height, pixels)
+	}
+
+	if _, _, _, err := f.Image(1, 1); err == nil {
+		t.Error("expected an error for a missing HDU")
+	}
+}
@@ -3,24 +3,31 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
 	"github.com/DiarmuidKelly/astrometry-go-client/fov"
 )
 
+// analyzeImage is the EXIF analysis, replaced in tests
+var analyzeImage = fov.AnalyzeImage
+
 // AnalyseHandler handles image analysis requests (EXIF extraction + FOV calculation)
 type AnalyseHandler struct {
+	workspaces    *workspace.Manager
 	maxUploadSize int64
 }
 
 // NewAnalyseHandler creates a new analyse handler
-func NewAnalyseHandler(maxUploadSize int64) *AnalyseHandler {
+func NewAnalyseHandler(workspaces *workspace.Manager, maxUploadSize int64) *AnalyseHandler {
 	return &AnalyseHandler{
+		workspaces:    workspaces,
 		maxUploadSize: maxUploadSize,
 	}
 }
@@ -69,62 +76,44 @@ func (h *AnalyseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Limit upload size
-	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
-
-	// Parse multipart form
-	if err := r.ParseMultipartForm(h.maxUploadSize); err != nil {
-		respondAnalyseError(w, "Failed to parse form", http.StatusBadRequest)
-		return
-	}
-
-	// Get uploaded file
-	file, header, err := r.FormFile("image")
-	if err != nil {
-		respondAnalyseError(w, "Missing or invalid 'image' field", http.StatusBadRequest)
-		return
-	}
-	defer file.Close() //nolint:errcheck // Error from Close on read is not critical
-
-	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	validExts := map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
-	if !validExts[ext] {
-		respondAnalyseError(w, "Invalid file type. Supported: jpg, jpeg, png", http.StatusBadRequest)
-		return
-	}
-
-	// Save to temporary file in shared directory (must match client's TempDir config)
-	tempDir := "/shared-data"
-	tempFile := filepath.Join(tempDir, fmt.Sprintf("analyse_%d%s", os.Getpid(), ext))
-	defer os.Remove(tempFile) //nolint:errcheck // Cleanup failure is not critical
-
-	out, err := os.Create(tempFile)
+	// Each request gets its own workspace on the shared volume
+	ws, err := h.workspaces.Create("analyse")
 	if err != nil {
+		log.Printf("Failed to create workspace: %v", err)
 		respondAnalyseError(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
-	defer out.Close() //nolint:errcheck // Deferred close errors are not critical
+	defer releaseWorkspace(ws)
 
-	if _, err := io.Copy(out, file); err != nil {
-		respondAnalyseError(w, "Failed to save file", http.StatusInternalServerError)
-		return
-	}
-	if err := out.Close(); err != nil {
-		respondAnalyseError(w, "Failed to save file", http.StatusInternalServerError)
+	tempFile, header, err := saveUpload(w, r, h.maxUploadSize, ws, analyseFormats)
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondAnalyseError(w, message, statusCode)
 		return
 	}
 
 	// Analyse the image
 	log.Printf("Analysing image: %s (%.2f KB)", header.Filename, float64(header.Size)/1024)
-	info, err := fov.AnalyzeImage(tempFile)
+	info, err := analyzeImage(tempFile)
 	if err != nil {
 		log.Printf("Analysis failed: %v", err)
 		respondAnalyseError(w, fmt.Sprintf("Failed to analyse image: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Prepare response
+	response := newAnalyseResponse(info)
+	log.Printf("Analysis complete: Camera=%s %s, FocalLength=%.0fmm, FOV=%.2f°x%.2f°",
+		info.Make, info.Model, info.FocalLength, info.FOV.WidthDegrees, info.FOV.HeightDegrees)
+
+	// Send JSON response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// newAnalyseResponse converts an image analysis into the API response
+func newAnalyseResponse(info *fov.ImageInfo) *AnalyseResponse {
 	response := &AnalyseResponse{
 		Success:      true,
 		Make:         info.Make,
@@ -147,15 +136,52 @@ func (h *AnalyseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		response.ScaleLow = info.ScaleLow
 		response.ScaleHigh = info.ScaleHigh
 	}
+	return response
+}
 
-	log.Printf("Analysis complete: Camera=%s %s, FocalLength=%.0fmm, FOV=%.2f°x%.2f°",
-		info.Make, info.Model, info.FocalLength, info.FOV.WidthDegrees, info.FOV.HeightDegrees)
+// newJobAnalyseResponse reports the analysis a job's scale bounds were chosen with
+func newJobAnalyseResponse(analysis *jobs.Analysis) *AnalyseResponse {
+	switch {
+	case analysis == nil:
+		return nil
+	case analysis.Info == nil:
+		return &AnalyseResponse{Success: false, Error: analysis.Error}
+	default:
+		return newAnalyseResponse(analysis.Info)
+	}
+}
 
-	// Send JSON response
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Failed to encode response: %v", err)
+// parseAutoScale reports whether the request asked for its scale bounds to be
+// taken from the image's EXIF analysis
+func parseAutoScale(r *http.Request) bool {
+	autoScale, _ := strconv.ParseBool(r.FormValue("auto_scale"))
+	return autoScale
+}
+
+// autoScale analyses the image at imagePath and, if scaled is false, sets
+// opts' scale bounds to the recommended ones. A failed analysis is reported
+// in the result and leaves opts alone.
+func autoScale(imagePath string, opts *client.SolveOptions, scaled bool) *jobs.Analysis {
+	if !analyseFormats.exts[strings.ToLower(filepath.Ext(imagePath))] {
+		return &jobs.Analysis{Error: "EXIF analysis is only supported for jpg, jpeg and png images"}
 	}
+	info, err := analyzeImage(imagePath)
+	if err != nil {
+		log.Printf("Auto-scale analysis failed: %v", err)
+		return &jobs.Analysis{Error: fmt.Sprintf("Failed to analyse image: %v", err)}
+	}
+	if !scaled && info.FOV.WidthDegrees > 0 {
+		opts.ScaleLow = info.ScaleLow
+		opts.ScaleHigh = info.ScaleHigh
+		opts.ScaleUnits = "arcminwidth"
+		log.Printf("Auto-scale: %s %s at %.0fmm, scale %.1f-%.1f arcmin", info.Make, info.Model, info.FocalLength, info.ScaleLow, info.ScaleHigh)
+	}
+	return &jobs.Analysis{Info: info}
+}
+
+// scaleGiven reports whether the request set a scale bound itself
+func scaleGiven(r *http.Request) bool {
+	return r.FormValue("scale_low") != "" || r.FormValue("scale_high") != ""
 }
 
 func respondAnalyseError(w http.ResponseWriter, message string, statusCode int) {
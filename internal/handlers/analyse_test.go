@@ -13,16 +13,11 @@ import (
 )
 
 func TestAnalyseHandler_Success(t *testing.T) {
-	// Ensure /shared-data exists for the test
-	if err := os.MkdirAll("/shared-data", 0755); err != nil {
-		t.Skip("Cannot create /shared-data directory, skipping test")
-	}
-
 	// Create a test JPEG with EXIF data
 	testImage := createTestJPEG(t)
 	defer os.Remove(testImage)
 
-	handler := NewAnalyseHandler(50 * 1024 * 1024)
+	handler := NewAnalyseHandler(newTestWorkspaces(t), 50*1024*1024)
 
 	body, contentType := createMultipartRequest(t, "image", testImage)
 	req := httptest.NewRequest(http.MethodPost, "/analyse", body)
@@ -49,7 +44,7 @@ func TestAnalyseHandler_Success(t *testing.T) {
 }
 
 func TestAnalyseHandler_MethodNotAllowed(t *testing.T) {
-	handler := NewAnalyseHandler(50 * 1024 * 1024)
+	handler := NewAnalyseHandler(newTestWorkspaces(t), 50*1024*1024)
 
 	req := httptest.NewRequest(http.MethodGet, "/analyse", nil)
 	w := httptest.NewRecorder()
@@ -75,7 +70,7 @@ func TestAnalyseHandler_MethodNotAllowed(t *testing.T) {
 }
 
 func TestAnalyseHandler_MissingImage(t *testing.T) {
-	handler := NewAnalyseHandler(50 * 1024 * 1024)
+	handler := NewAnalyseHandler(newTestWorkspaces(t), 50*1024*1024)
 
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
@@ -102,7 +97,7 @@ func TestAnalyseHandler_MissingImage(t *testing.T) {
 }
 
 func TestAnalyseHandler_InvalidFileType(t *testing.T) {
-	handler := NewAnalyseHandler(50 * 1024 * 1024)
+	handler := NewAnalyseHandler(newTestWorkspaces(t), 50*1024*1024)
 
 	// Create a test file with invalid extension
 	testFile := filepath.Join(os.TempDir(), "test.txt")
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/annotate"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/wcs"
+)
+
+// Bounds of the font_size field in preview pixels
+const (
+	minFontSize = 6
+	maxFontSize = 72
+)
+
+// annotateOptions are the fields of a solve with output=annotated
+type annotateOptions struct {
+	annotate.Options
+	svg bool
+}
+
+// parseAnnotateOptions reads the annotation_format, layers and font_size
+// fields
+func parseAnnotateOptions(r *http.Request) (annotateOptions, error) {
+	var opts annotateOptions
+	switch r.FormValue("annotation_format") {
+	case "", "png":
+	case "svg":
+		opts.svg = true
+	default:
+		return opts, &uploadError{"Invalid 'annotation_format' field: must be png or svg", http.StatusBadRequest}
+	}
+
+	if value := r.FormValue("layers"); value != "" {
+		for _, layer := range strings.Split(value, ",") {
+			layer = strings.TrimSpace(layer)
+			known := false
+			for _, l := range annotate.Layers {
+				known = known || l == layer
+			}
+			if !known {
+				return opts, &uploadError{"Invalid 'layers' field: must be a comma-separated list of " + strings.Join(annotate.Layers, ", "), http.StatusBadRequest}
+			}
+			opts.Layers = append(opts.Layers, layer)
+		}
+	}
+
+	if value := r.FormValue("font_size"); value != "" {
+		size, err := strconv.ParseFloat(value, 64)
+		if err != nil || size < minFontSize || size > maxFontSize {
+			return opts, &uploadError{"Invalid 'font_size' field: must be a number from 6 to 72", http.StatusBadRequest}
+		}
+		opts.FontSize = size
+	}
+	return opts, nil
+}
+
+// isFITS reports whether an upload is a FITS file, going by its extension
+func isFITS(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".fits" || ext == ".fit"
+}
+
+// checkImage checks that a JPEG or PNG upload can be decoded for its preview,
+// from its header
+func checkImage(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close() //nolint:errcheck // Error from Close on read is not critical
+	var tooLarge *annotate.TooLargeError
+	if err := annotate.CheckSize(file); errors.As(err, &tooLarge) {
+		return &uploadError{"Image is too large to annotate: " + err.Error(), http.StatusRequestEntityTooLarge}
+	} else if err != nil {
+		return &uploadError{"Cannot read the image to annotate: " + err.Error(), http.StatusBadRequest}
+	}
+	return nil
+}
+
+// respondAnnotated sends a preview of the uploaded image with the grid,
+// constellations and deep-sky objects of a finished job's field drawn on it.
+// upload is set for FITS files and nil for other images.
+func respondAnnotated(w http.ResponseWriter, filename string, job *jobs.Job, path string, upload *fitsUpload, opts annotateOptions) {
+	if !hasWCS(w, job) {
+		return
+	}
+	unprocessable := func(err error) {
+		response := newJobSolveResponse(job)
+		response.Error = "Cannot annotate the solution: " + err.Error()
+		writeJSON(w, http.StatusUnprocessableEntity, response)
+	}
+	solution, err := wcs.Parse(job.Result.WCSHeader)
+	if err != nil {
+		unprocessable(err)
+		return
+	}
+
+	var preview *annotate.Preview
+	if upload != nil {
+		preview, err = annotate.FITSPreview(upload.fits, upload.hdu, annotate.MaxPreviewSize)
+	} else {
+		var file *os.File
+		if file, err = os.Open(path); err == nil {
+			preview, err = annotate.DecodePreview(file, annotate.MaxPreviewSize)
+			file.Close() //nolint:errcheck // Error from Close on read is not critical
+		}
+	}
+	if err != nil {
+		unprocessable(err)
+		return
+	}
+
+	annotation := annotate.New(preview, solution, opts.Options)
+	var data bytes.Buffer
+	contentType, ext := "image/png", ".png"
+	if opts.svg {
+		contentType, ext = "image/svg+xml", ".svg"
+		err = annotation.WriteSVG(&data)
+	} else {
+		err = annotation.WritePNG(&data)
+	}
+	if err != nil {
+		log.Printf("Failed to render annotated image: %v", err)
+		respondError(w, "Failed to render the annotated image", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachmentName(filename, "-annotated"+ext)}))
+	w.Header().Set("Content-Length", strconv.Itoa(data.Len()))
+	w.Write(data.Bytes()) //nolint:errcheck // Client went away
+}
@@ -0,0 +1,582 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// batchConcurrency is how many of a batch's images are handed to the solver
+// queue at once; the queue itself decides how many actually run
+const batchConcurrency = 4
+
+// BatchHandler handles requests to solve several images at once
+type BatchHandler struct {
+	jobs          *jobs.Manager
+	workspaces    *workspace.Manager
+	maxUploadSize int64
+	maxBatchSize  int64
+	maxExtracted  int64
+	maxFiles      int
+}
+
+// NewBatchHandler creates a new batch handler. Each image may be up to
+// maxUploadSize bytes, the whole request up to maxBatchSize bytes, the images
+// unpacked from its archives up to maxExtracted bytes between them, and a
+// batch may hold up to maxFiles images.
+func NewBatchHandler(manager *jobs.Manager, workspaces *workspace.Manager, maxUploadSize, maxBatchSize, maxExtracted int64, maxFiles int) *BatchHandler {
+	return &BatchHandler{
+		jobs:          manager,
+		workspaces:    workspaces,
+		maxUploadSize: maxUploadSize,
+		maxBatchSize:  maxBatchSize,
+		maxExtracted:  maxExtracted,
+		maxFiles:      maxFiles,
+	}
+}
+
+// BatchResponse represents the batch solve response
+type BatchResponse struct {
+	Results []BatchFileResult `json:"results,omitempty"`
+	Summary *BatchSummary     `json:"summary,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// BatchFileResult is the outcome for one image of a batch
+type BatchFileResult struct {
+	Filename string         `json:"filename"`
+	Result   *SolveResponse `json:"result,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// BatchSummary aggregates the solved images of a batch
+type BatchSummary struct {
+	Total            int          `json:"total"`
+	Solved           int          `json:"solved"`
+	MedianPixelScale float64      `json:"median_pixel_scale,omitempty"`
+	MedianCenter     *Coordinates `json:"median_center,omitempty"`
+}
+
+// Coordinates is a position on the sky in degrees (J2000)
+type Coordinates struct {
+	RA  float64 `json:"ra"`
+	Dec float64 `json:"dec"`
+}
+
+// batchFile is an image of a batch, saved in its own workspace unless it was rejected
+type batchFile struct {
+	name string
+	path string
+	ws   *workspace.Workspace
+	err  string
+}
+
+// ServeHTTP godoc
+//
+//	@Summary		Plate-solve a batch of images
+//	@Description	Solves several images in one request, given as multiple image parts or as a single zip, tar or tar.gz archive. The solve parameters of /solve apply to every image; the overrides field can change them per file. Returns a result per file and a summary of the solved images.
+//	@Tags			Solving
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			image				formData	file			false	"Image file (JPG, JPEG, PNG, FITS, FIT), repeatable, or a zip/tar/tar.gz archive of images"
+//	@Param			archive				formData	file			false	"Zip, tar or tar.gz archive of images"
+//	@Param			overrides			formData	string			false	"JSON object mapping file names to solve parameters, e.g. {\"m42.fits\": {\"scale_low\": 1.2}}"
+//	@Param			scale_low			formData	number			false	"Lower bound of image scale"
+//	@Param			scale_high			formData	number			false	"Upper bound of image scale"
+//	@Param			scale_units			formData	string			false	"Units for scale bounds (degwidth, arcminwidth, arcsecperpix)"	default(arcminwidth)
+//	@Param			downsample_factor	formData	int				false	"Downsample factor (higher = faster but less accurate)"	default(2)
+//	@Param			depth_low			formData	int				false	"Minimum number of quads to try"	default(10)
+//	@Param			depth_high			formData	int				false	"Maximum number of quads to try"	default(20)
+//	@Param			ra					formData	string			false	"Right Ascension hint (J2000): decimal degrees, or hours as 05:35:17, 05 35 17 or 5h35m17s"
+//	@Param			dec					formData	string			false	"Declination hint (J2000): decimal degrees, or -05:23:28, -05 23 28 or -5d23m28s"
+//	@Param			ra_units			formData	string			false	"Units of ra: degrees or hours (default: degrees when decimal, hours when sexagesimal)"
+//	@Param			target				formData	string			false	"Object name to use as the position hint instead of ra/dec, e.g. M42, NGC 1976 or Betelgeuse"
+//	@Param			radius				formData	number			false	"Search radius in degrees around ra/dec or target, 10 if not set"
+//	@Param			no_cache			formData	boolean			false	"Solve every image even if cached results exist"	default(false)
+//	@Param			strategy			formData	string			false	"single, or escalate to retry unsolved images with looser options"	default(single)
+//	@Param			ladder				formData	string			false	"Comma-separated escalation steps (widen_scale, drop_scale, increase_depth, change_downsample, drop_hint)"
+//	@Param			time_budget			formData	number			false	"Total time in seconds for all escalation attempts of each image"
+//	@Param			include				formData	string			false	"Comma-separated optional parts of the result: objects for the deep-sky objects in the field, stars for the bright stars, coordinates for the galactic, ecliptic and horizontal coordinates of the field centre, footprint for the corners, s_region, GeoJSON and HEALPix cells of the field"
+//	@Param			star_mag_limit		formData	number			false	"Faintest magnitude of the stars listed with include=stars, at most 3"
+//	@Param			healpix_order		formData	int				false	"HEALPix order of the cells listed with include=footprint, lowered if the field covers more than 10000 cells"	default(8)
+//	@Param			latitude			formData	number			false	"Observer latitude in degrees for include=coordinates, north positive; taken from EXIF GPS data if not given"
+//	@Param			longitude			formData	number			false	"Observer longitude in degrees for include=coordinates, east positive; taken from EXIF GPS data if not given"
+//	@Param			observed_at			formData	string			false	"Time the image was taken for include=coordinates and epoch=jnow (RFC 3339); taken from EXIF data if not given"
+//	@Param			epoch				formData	string			false	"jnow adds the field centre on the true equator and equinox of the time the image was taken; ra and dec stay J2000"	Enums(j2000, jnow)	default(j2000)
+//	@Param			aberration			formData	boolean			false	"Apply the annual aberration with epoch=jnow"	default(false)
+//	@Success		200					{object}	BatchResponse	"Batch complete (check each result)"
+//	@Failure		400					{object}	BatchResponse	"Bad request"
+//	@Failure		405					{object}	BatchResponse	"Method not allowed"
+//	@Failure		413					{object}	BatchResponse	"Batch too large, or its archives unpack to too much data"
+//	@Failure		500					{object}	BatchResponse	"Internal server error"
+//	@Router			/solve/batch [post]
+func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondBatchError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBatchSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondBatchError(w, fmt.Sprintf("Batch too large (max %d MB)", h.maxBatchSize>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		respondBatchError(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll() //nolint:errcheck // Best-effort removal of spooled parts
+
+	opts := parseSolveOptions(r)
+	if err := parseHint(r, opts); err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondBatchError(w, message, statusCode)
+		return
+	}
+	escalation, err := parseStrategy(r)
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondBatchError(w, message, statusCode)
+		return
+	}
+	include, err := parseInclude(r)
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondBatchError(w, message, statusCode)
+		return
+	}
+	overrides := map[string]json.RawMessage{}
+	if val := r.FormValue("overrides"); val != "" {
+		if err := json.Unmarshal([]byte(val), &overrides); err != nil {
+			respondBatchError(w, "Invalid 'overrides' field: must be a JSON object keyed by file name", http.StatusBadRequest)
+			return
+		}
+	}
+
+	files, err := h.collect(r.MultipartForm.File)
+	defer func() {
+		for _, f := range files {
+			if f.ws != nil {
+				releaseWorkspace(f.ws)
+			}
+		}
+	}()
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondBatchError(w, message, statusCode)
+		return
+	}
+
+	// Resolve each file's request up front so a bad override fails the whole batch
+	reqs := make([]jobs.Request, len(files))
+	for i, f := range files {
+		reqs[i] = jobs.Request{
+			Filename:   f.name,
+			ImagePath:  f.path,
+			Workspace:  f.ws,
+			Options:    opts,
+			NoCache:    parseNoCache(r),
+			Escalation: escalation,
+			Include:    observeImage(include, f.path),
+		}
+		scaled := scaleGiven(r)
+		if raw, ok := overrides[f.name]; ok {
+			if reqs[i].Options, err = applyOverrides(opts, raw); err != nil {
+				respondBatchError(w, fmt.Sprintf("Invalid override for %s: %v", f.name, err), http.StatusBadRequest)
+				return
+			}
+			scaled = scaled || overridesScale(raw)
+			delete(overrides, f.name)
+		}
+		if parseAutoScale(r) && f.err == "" {
+			fileOpts := *reqs[i].Options
+			reqs[i].Options = &fileOpts
+			reqs[i].Analysis = autoScale(f.path, &fileOpts, scaled)
+		}
+	}
+	for name := range overrides {
+		respondBatchError(w, fmt.Sprintf("Override for %s does not match any file in the batch", name), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Solving batch of %d images", len(files))
+	results := h.solve(r.Context(), files, reqs)
+
+	extendWriteDeadline(w)
+	writeJSON(w, http.StatusOK, &BatchResponse{
+		Results: results,
+		Summary: summarizeBatch(results),
+	})
+}
+
+// collect saves every image of the request, unpacking archives, into its own workspace
+func (h *BatchHandler) collect(parts map[string][]*multipart.FileHeader) ([]batchFile, error) {
+	var files []batchFile
+	extracted := &extractedCounter{max: h.maxExtracted}
+	for _, field := range []string{"image", "archive"} {
+		for _, header := range parts[field] {
+			var err error
+			if archiveKind(header.Filename) != "" {
+				files, err = h.extract(files, header, extracted)
+			} else {
+				open := func() (io.ReadCloser, error) { return header.Open() }
+				files, err = h.add(files, header.Filename, header.Size, open)
+			}
+			if err != nil {
+				return files, err
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, &uploadError{"Missing 'image' or 'archive' field: the batch holds no images", http.StatusBadRequest}
+	}
+	return files, nil
+}
+
+// add saves one image of the batch. Unsupported or oversized files are kept as
+// rejected entries so the client sees why they were not solved.
+func (h *BatchHandler) add(files []batchFile, name string, size int64, open func() (io.ReadCloser, error)) ([]batchFile, error) {
+	if len(files) >= h.maxFiles {
+		return files, &uploadError{fmt.Sprintf("Too many files in batch (max %d)", h.maxFiles), http.StatusBadRequest}
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case !solveFormats.exts[ext]:
+		return append(files, batchFile{name: name, err: solveFormats.invalid}), nil
+	case size > h.maxUploadSize:
+		return append(files, batchFile{name: name, err: fmt.Sprintf("File too large (max %d MB)", h.maxUploadSize>>20)}), nil
+	}
+
+	src, err := open()
+	if err != nil {
+		var tooLarge *uploadError
+		if errors.As(err, &tooLarge) {
+			return files, tooLarge
+		}
+		return files, &uploadError{"Failed to read " + name, http.StatusBadRequest}
+	}
+	defer src.Close() //nolint:errcheck // Error from Close on read is not critical
+
+	ws, err := h.workspaces.Create("batch")
+	if err != nil {
+		log.Printf("Failed to create workspace: %v", err)
+		return files, &uploadError{"Failed to save file", http.StatusInternalServerError}
+	}
+	files = append(files, batchFile{name: name, path: ws.Path("image" + ext), ws: ws})
+	if err := writeFile(ws.Path("image"+ext), src); err != nil {
+		var tooLarge *uploadError
+		if errors.As(err, &tooLarge) {
+			return files, tooLarge
+		}
+		return files, &uploadError{"Failed to save file", http.StatusInternalServerError}
+	}
+	return files, nil
+}
+
+// extractedCounter keeps the running total of bytes unpacked from a batch's
+// archives, so that archives which expand far beyond their upload size are
+// rejected before they fill the disk
+type extractedCounter struct {
+	n   int64
+	max int64
+}
+
+// tooLarge returns the error for a batch whose archives hold more than max bytes
+func (c *extractedCounter) tooLarge() error {
+	return &uploadError{fmt.Sprintf("Archives unpack to too much data (max %d MB)", c.max>>20), http.StatusRequestEntityTooLarge}
+}
+
+// open wraps an archive entry's open function so that reading it counts
+// towards the total, failing once it passes max. An entry whose declared size
+// would already pass it is refused up front.
+func (c *extractedCounter) open(size int64, open func() (io.ReadCloser, error)) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		if c.n+size > c.max {
+			return nil, c.tooLarge()
+		}
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &countingReader{ReadCloser: rc, counter: c}, nil
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	counter *extractedCounter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.n += int64(n)
+	if r.counter.n > r.counter.max {
+		return n, r.counter.tooLarge()
+	}
+	return n, err
+}
+
+// extract adds the images found in an uploaded archive, skipping anything that is
+// not a regular file with a supported extension (directories, macOS metadata,
+// notes). The images it unpacks count towards extracted.
+func (h *BatchHandler) extract(files []batchFile, header *multipart.FileHeader, extracted *extractedCounter) ([]batchFile, error) {
+	archive, err := header.Open()
+	if err != nil {
+		return files, &uploadError{"Failed to read " + header.Filename, http.StatusBadRequest}
+	}
+	defer archive.Close() //nolint:errcheck // Error from Close on read is not critical
+
+	invalid := &uploadError{"Invalid archive " + header.Filename, http.StatusBadRequest}
+	kind := archiveKind(header.Filename)
+	if kind == "zip" {
+		zr, err := zip.NewReader(archive, header.Size)
+		if err != nil {
+			return files, invalid
+		}
+		for _, entry := range zr.File {
+			if !entry.Mode().IsRegular() || !archivedImage(entry.Name) {
+				continue
+			}
+			size := int64(entry.UncompressedSize64)
+			if files, err = h.add(files, entry.Name, size, extracted.open(size, entry.Open)); err != nil {
+				return files, err
+			}
+		}
+		return files, nil
+	}
+
+	var src io.Reader = archive
+	if kind == "tar.gz" {
+		gz, err := gzip.NewReader(archive)
+		if err != nil {
+			return files, invalid
+		}
+		defer gz.Close() //nolint:errcheck // Error from Close on read is not critical
+		src = gz
+	}
+	tr := tar.NewReader(src)
+	for {
+		entry, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, invalid
+		}
+		if entry.Typeflag != tar.TypeReg || !archivedImage(entry.Name) {
+			continue
+		}
+		open := func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }
+		if files, err = h.add(files, entry.Name, entry.Size, extracted.open(entry.Size, open)); err != nil {
+			return files, err
+		}
+	}
+}
+
+// solve runs every accepted file through the solver with its request and
+// returns the results in file order
+func (h *BatchHandler) solve(ctx context.Context, files []batchFile, reqs []jobs.Request) []BatchFileResult {
+	results := make([]BatchFileResult, len(files))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, f := range files {
+		results[i].Filename = f.name
+		if f.err != "" {
+			results[i].Error = f.err
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			req := reqs[i]
+			req.ID = jobs.NewID()
+			job, err := h.run(ctx, req)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Result = newJobSolveResponse(&job)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// run solves one image, waiting for room whenever the solver queue is full
+func (h *BatchHandler) run(ctx context.Context, req jobs.Request) (jobs.Job, error) {
+	for {
+		job, err := h.jobs.Run(ctx, req)
+		var full *queue.FullError
+		if !errors.As(err, &full) {
+			return job, err
+		}
+		select {
+		case <-time.After(full.RetryAfter):
+		case <-ctx.Done():
+			return jobs.Job{}, ctx.Err()
+		}
+	}
+}
+
+// summarizeBatch counts the solved files and takes the median of their pixel scales and centers
+func summarizeBatch(results []BatchFileResult) *BatchSummary {
+	summary := &BatchSummary{Total: len(results)}
+	var scales, ras, decs []float64
+	for _, res := range results {
+		if res.Result == nil || !res.Result.Solved {
+			continue
+		}
+		summary.Solved++
+		scales = append(scales, res.Result.PixelScale)
+		ras = append(ras, res.Result.RA)
+		decs = append(decs, res.Result.Dec)
+	}
+	if summary.Solved == 0 {
+		return summary
+	}
+
+	summary.MedianPixelScale = median(scales)
+	summary.MedianCenter = &Coordinates{RA: medianRA(ras), Dec: median(decs)}
+	return summary
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// medianRA takes the median of right ascensions, which wrap at 360 degrees:
+// they are unwrapped around their circular mean first so that e.g. 359.9 and
+// 0.1 have a median of 0
+func medianRA(ras []float64) float64 {
+	var x, y float64
+	for _, ra := range ras {
+		x += math.Cos(ra * math.Pi / 180)
+		y += math.Sin(ra * math.Pi / 180)
+	}
+	mean := math.Atan2(y, x) * 180 / math.Pi
+
+	unwrapped := make([]float64, len(ras))
+	for i, ra := range ras {
+		unwrapped[i] = mean + math.Remainder(ra-mean, 360)
+	}
+	m := math.Mod(median(unwrapped), 360)
+	if m < 0 {
+		m += 360
+	}
+	if m >= 360 {
+		// A tiny negative median rounds up to 360
+		m = 0
+	}
+	return m
+}
+
+// overridesScale reports whether a file's overrides set a scale bound
+func overridesScale(raw json.RawMessage) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+	_, low := fields["scale_low"]
+	_, high := fields["scale_high"]
+	return low || high
+}
+
+// applyOverrides returns a copy of base with the fields present in raw, a JSON
+// object using SolveRequest's field names, replaced
+func applyOverrides(base *client.SolveOptions, raw json.RawMessage) (*client.SolveOptions, error) {
+	req := newSolveRequest(base)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	opts := *base
+	opts.ScaleLow = req.ScaleLow
+	opts.ScaleHigh = req.ScaleHigh
+	opts.ScaleUnits = req.ScaleUnits
+	opts.DownsampleFactor = req.DownsampleFactor
+	opts.DepthLow = req.DepthLow
+	opts.DepthHigh = req.DepthHigh
+	opts.RA = req.RA
+	opts.Dec = req.Dec
+	opts.Radius = req.Radius
+	// A position given without a radius is searched around as in a request
+	if overridesPosition(raw) {
+		opts.Radius = hintRadius(opts.Radius)
+	}
+	return &opts, nil
+}
+
+// overridesPosition reports whether a file's overrides set a position hint
+func overridesPosition(raw json.RawMessage) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+	_, ra := fields["ra"]
+	_, dec := fields["dec"]
+	return ra || dec
+}
+
+// archiveKind returns "zip", "tar" or "tar.gz" for archive file names, or ""
+func archiveKind(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// archivedImage reports whether an archive entry looks like an image to solve
+func archivedImage(name string) bool {
+	base := path.Base(name)
+	if strings.HasPrefix(base, ".") || strings.Contains(name, "__MACOSX/") {
+		return false
+	}
+	return solveFormats.exts[strings.ToLower(path.Ext(base))]
+}
+
+func respondBatchError(w http.ResponseWriter, message string, statusCode int) {
+	writeJSON(w, statusCode, &BatchResponse{Error: message})
+}
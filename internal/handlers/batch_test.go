@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+type batchPart struct {
+	field string
+	name  string
+	data  []byte
+}
+
+func createBatchRequest(t *testing.T, parts []batchPart, fields map[string]string) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, p := range parts {
+		part, err := writer.CreateFormFile(p.field, p.name)
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		if _, err := part.Write(p.data); err != nil {
+			t.Fatalf("failed to write form file: %v", err)
+		}
+	}
+	for key, val := range fields {
+		if err := writer.WriteField(key, val); err != nil {
+			t.Fatalf("failed to write field: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/solve/batch", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func testJPEGData(t *testing.T) []byte {
+	t.Helper()
+	path := createTestJPEG(t)
+	defer os.Remove(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read test JPEG: %v", err)
+	}
+	return data
+}
+
+// fieldSolver solves images by name, recording the options each was solved with
+type fieldSolver struct {
+	mu      sync.Mutex
+	results map[string]*client.Result
+	opts    map[string]*client.SolveOptions
+	names   map[string]string
+}
+
+func newFieldSolver(results map[string]*client.Result) *fieldSolver {
+	return &fieldSolver{results: results, opts: map[string]*client.SolveOptions{}, names: map[string]string{}}
+}
+
+func (s *fieldSolver) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.names[filepath.Dir(imagePath)]
+	s.opts[name] = opts
+	if result, ok := s.results[name]; ok {
+		return result, nil
+	}
+	return &client.Result{Solved: false}, nil
+}
+
+func decodeBatch(t *testing.T, w *httptest.ResponseRecorder) BatchResponse {
+	t.Helper()
+	var response BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return response
+}
+
+func TestBatchHandler_MultipleImages(t *testing.T) {
+	var mu sync.Mutex
+	var seen []*client.SolveOptions
+	results := []*client.Result{
+		{Solved: true, RA: 359.8, Dec: 10, PixelScale: 1.0},
+		{Solved: true, RA: 0.4, Dec: 12, PixelScale: 3.0},
+		{Solved: true, RA: 0.2, Dec: 11, PixelScale: 2.0},
+	}
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, opts)
+			// Results are handed out by downsample factor so each file gets a known one
+			return results[opts.DownsampleFactor-1], nil
+		},
+	}
+	handler := NewBatchHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024, 500*1024*1024, 1024*1024*1024, 10)
+
+	jpeg := testJPEGData(t)
+	req := createBatchRequest(t, []batchPart{
+		{"image", "a.jpg", jpeg},
+		{"image", "b.jpg", jpeg},
+		{"image", "c.jpg", jpeg},
+		{"image", "notes.txt", []byte("not an image")},
+	}, map[string]string{
+		"downsample_factor": "1",
+		"scale_low":         "5",
+		"overrides":         `{"b.jpg": {"downsample_factor": 2}, "c.jpg": {"downsample_factor": 3, "scale_low": 7}}`,
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	response := decodeBatch(t, w)
+
+	if len(response.Results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(response.Results))
+	}
+	for i, name := range []string{"a.jpg", "b.jpg", "c.jpg", "notes.txt"} {
+		if response.Results[i].Filename != name {
+			t.Errorf("result %d: expected %s, got %s", i, name, response.Results[i].Filename)
+		}
+	}
+	if response.Results[1].Result == nil || response.Results[1].Result.PixelScale != 3.0 {
+		t.Errorf("expected b.jpg to be solved with its override, got %+v", response.Results[1].Result)
+	}
+	if response.Results[3].Error == "" || response.Results[3].Result != nil {
+		t.Errorf("expected notes.txt to be rejected, got %+v", response.Results[3])
+	}
+	for _, opts := range seen {
+		expected := 5.0
+		if opts.DownsampleFactor == 3 {
+			expected = 7
+		}
+		if opts.ScaleLow != expected {
+			t.Errorf("downsample %d: expected scale_low %v, got %v", opts.DownsampleFactor, expected, opts.ScaleLow)
+		}
+	}
+
+	summary := response.Summary
+	if summary == nil || summary.Total != 4 || summary.Solved != 3 {
+		t.Fatalf("expected 3 of 4 solved, got %+v", summary)
+	}
+	if summary.MedianPixelScale != 2.0 {
+		t.Errorf("expected median pixel scale 2.0, got %v", summary.MedianPixelScale)
+	}
+	if summary.MedianCenter == nil || math.Abs(summary.MedianCenter.RA-0.2) > 1e-9 || summary.MedianCenter.Dec != 11 {
+		t.Errorf("expected median center (0.2, 11), got %+v", summary.MedianCenter)
+	}
+}
+
+func TestBatchHandler_Archives(t *testing.T) {
+	jpeg := testJPEGData(t)
+
+	zipped := &bytes.Buffer{}
+	zw := zip.NewWriter(zipped)
+	for _, name := range []string{"night1/m42_001.jpg", "night1/m42_002.jpg", "__MACOSX/night1/._m42_001.jpg", "README.txt"} {
+		f, _ := zw.Create(name)
+		f.Write(jpeg) //nolint:errcheck // Writes to a buffer
+	}
+	zw.Close() //nolint:errcheck // Writes to a buffer
+
+	tarred := &bytes.Buffer{}
+	gz := gzip.NewWriter(tarred)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"m31.jpg", ".hidden.jpg"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(jpeg)), Typeflag: tar.TypeReg}) //nolint:errcheck // Writes to a buffer
+		tw.Write(jpeg)                                                                                     //nolint:errcheck // Writes to a buffer
+	}
+	tw.Close() //nolint:errcheck // Writes to a buffer
+	gz.Close() //nolint:errcheck // Writes to a buffer
+
+	for name, tc := range map[string]struct {
+		part     batchPart
+		expected []string
+	}{
+		"zip":    {batchPart{"archive", "subs.zip", zipped.Bytes()}, []string{"night1/m42_001.jpg", "night1/m42_002.jpg"}},
+		"tar.gz": {batchPart{"image", "subs.tar.gz", tarred.Bytes()}, []string{"m31.jpg"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler := NewBatchHandler(newTestManager(t, &MockAstroClient{}), newTestWorkspaces(t), 50*1024*1024, 500*1024*1024, 1024*1024*1024, 10)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, createBatchRequest(t, []batchPart{tc.part}, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			response := decodeBatch(t, w)
+			if len(response.Results) != len(tc.expected) {
+				t.Fatalf("expected %d results, got %+v", len(tc.expected), response.Results)
+			}
+			for i, name := range tc.expected {
+				if response.Results[i].Filename != name || response.Results[i].Result == nil || !response.Results[i].Result.Solved {
+					t.Errorf("expected %s to be solved, got %+v", name, response.Results[i])
+				}
+			}
+		})
+	}
+
+	// The images of all the batch's archives count towards the extraction cap
+	workspaces := newTestWorkspaces(t)
+	handler := NewBatchHandler(newTestManager(t, &MockAstroClient{}), workspaces, 50*1024*1024, 500*1024*1024, int64(2*len(jpeg)), 10)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, createBatchRequest(t, []batchPart{{"archive", "subs.zip", zipped.Bytes()}, {"archive", "subs.tar.gz", tarred.Bytes()}}, nil))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d: %s", w.Code, w.Body.String())
+	}
+	if n := workspaces.Active(); n != 0 {
+		t.Errorf("expected workspaces to be released, %d still active", n)
+	}
+}
+
+func TestExtractedCounter(t *testing.T) {
+	c := &extractedCounter{max: 10}
+	open := func(data string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(data)), nil }
+	}
+	rc, err := c.open(6, open("123456"))()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := io.ReadAll(rc); err != nil || string(data) != "123456" {
+		t.Errorf("expected the entry to be read, got %q, %v", data, err)
+	}
+	if _, err := c.open(6, open("123456"))(); err == nil {
+		t.Error("expected an entry declared past the cap to be refused")
+	}
+	// An entry larger than it claims to be is stopped once it passes the cap
+	rc, _ = c.open(1, open("123456"))()
+	if _, err := io.ReadAll(rc); err == nil {
+		t.Error("expected reading past the cap to fail")
+	} else if _, statusCode := uploadErrorStatus(err); statusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d", statusCode)
+	}
+}
+
+func TestBatchHandler_BadRequests(t *testing.T) {
+	jpeg := testJPEGData(t)
+	for name, tc := range map[string]struct {
+		parts  []batchPart
+		fields map[string]string
+	}{
+		"no images":          {nil, map[string]string{"scale_low": "1"}},
+		"too many files":     {[]batchPart{{"image", "a.jpg", jpeg}, {"image", "b.jpg", jpeg}, {"image", "c.jpg", jpeg}}, nil},
+		"malformed archive":  {[]batchPart{{"archive", "subs.zip", []byte("not a zip")}}, nil},
+		"unknown override":   {[]batchPart{{"image", "a.jpg", jpeg}}, map[string]string{"overrides": `{"z.jpg": {"scale_low": 1}}`}},
+		"invalid override":   {[]batchPart{{"image", "a.jpg", jpeg}}, map[string]string{"overrides": `{"a.jpg": {"scale_lo": 1}}`}},
+		"overrides not JSON": {[]batchPart{{"image", "a.jpg", jpeg}}, map[string]string{"overrides": `a.jpg=1`}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			workspaces := newTestWorkspaces(t)
+			handler := NewBatchHandler(newTestManager(t, &MockAstroClient{}), workspaces, 50*1024*1024, 500*1024*1024, 1024*1024*1024, 2)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, createBatchRequest(t, tc.parts, tc.fields))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+			}
+			if decodeBatch(t, w).Error == "" {
+				t.Error("expected an error message")
+			}
+			if n := workspaces.Active(); n != 0 {
+				t.Errorf("expected workspaces to be released, %d still active", n)
+			}
+		})
+	}
+}
+
+func TestMedianRA(t *testing.T) {
+	for _, tc := range []struct {
+		ras      []float64
+		expected float64
+	}{
+		{[]float64{10, 20, 30}, 20},
+		{[]float64{359, 1, 3}, 1},
+		{[]float64{358, 359, 1, 2}, 0},
+		{[]float64{180}, 180},
+	} {
+		if got := medianRA(tc.ras); math.Abs(got-tc.expected) > 1e-9 {
+			t.Errorf("medianRA(%v): expected %v, got %v", tc.ras, tc.expected, got)
+		}
+	}
+}
+
+func TestApplyOverrides_Position(t *testing.T) {
+	base := client.DefaultSolveOptions()
+	// A position without a radius is searched within the default radius
+	opts, err := applyOverrides(base, json.RawMessage(`{"ra": 0, "dec": 10}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.RA != 0 || opts.Dec != 10 || opts.Radius != defaultHintRadius {
+		t.Errorf("expected ra 0 dec 10 radius %v, got %v %v %v", defaultHintRadius, opts.RA, opts.Dec, opts.Radius)
+	}
+	if opts, _ = applyOverrides(base, json.RawMessage(`{"ra": 0, "dec": 10, "radius": 2}`)); opts.Radius != 2 {
+		t.Errorf("expected radius 2, got %v", opts.Radius)
+	}
+	if opts, _ = applyOverrides(base, json.RawMessage(`{"depth_low": 5}`)); opts.Radius != 0 {
+		t.Errorf("expected no hint, got radius %v", opts.Radius)
+	}
+}
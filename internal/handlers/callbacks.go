@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/webhook"
+)
+
+// CallbackDeliverer posts the SolveResponse of finished jobs to their callback URL
+type CallbackDeliverer struct {
+	sender *webhook.Sender
+}
+
+// NewCallbackDeliverer creates a deliverer sending callbacks through sender
+func NewCallbackDeliverer(sender *webhook.Sender) *CallbackDeliverer {
+	return &CallbackDeliverer{
+		sender: sender,
+	}
+}
+
+// CheckURL reports whether url may be used as a callback URL
+func (d *CallbackDeliverer) CheckURL(url string) error {
+	return d.sender.CheckURL(url)
+}
+
+// Deliver posts the job's SolveResponse, signed, to its callback URL
+func (d *CallbackDeliverer) Deliver(ctx context.Context, job jobs.Job, record func(jobs.Delivery)) error {
+	body, err := json.Marshal(newJobSolveResponse(&job))
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set(solveIDHeader, job.ID)
+
+	return d.sender.Send(ctx, job.Callback.URL, header, body, func(a webhook.Attempt) {
+		delivery := jobs.Delivery{
+			Attempt:    a.Number,
+			At:         a.At,
+			StatusCode: a.StatusCode,
+		}
+		if a.Err != nil {
+			delivery.Error = a.Err.Error()
+		}
+		record(delivery)
+	})
+}
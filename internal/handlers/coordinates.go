@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/coord"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/exif"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+)
+
+// Sources of an observer's location and time
+const (
+	sourceRequest = "request"
+	sourceEXIF    = "exif"
+	// sourceEXIFLocal is the camera's clock reading when the EXIF data gives
+	// no time zone. It is good enough for JNow but could be hours out, so no
+	// horizontal coordinates are worked out from it.
+	sourceEXIFLocal = "exif_local"
+	// sourceSolve is the time the solve was asked for, used for JNow when the
+	// time the image was taken is not known
+	sourceSolve = "solve"
+)
+
+// FieldCoordinates are the coordinates of the solved field's centre in other
+// frames
+type FieldCoordinates struct {
+	Galactic GalacticCoordinates `json:"galactic"`
+	Ecliptic EclipticCoordinates `json:"ecliptic"`
+	// Horizontal is left out unless the observer's location and time are
+	// known
+	Horizontal *HorizontalCoordinates `json:"horizontal,omitempty"`
+}
+
+// GalacticCoordinates are galactic longitude and latitude in degrees
+type GalacticCoordinates struct {
+	L float64 `json:"l"`
+	B float64 `json:"b"`
+}
+
+// EclipticCoordinates are longitude and latitude in degrees on the mean
+// ecliptic and equinox of J2000
+type EclipticCoordinates struct {
+	Lon float64 `json:"lon"`
+	Lat float64 `json:"lat"`
+}
+
+// HorizontalCoordinates are where the field centre was in the observer's sky
+type HorizontalCoordinates struct {
+	Latitude       float64   `json:"latitude"`
+	Longitude      float64   `json:"longitude"`
+	Time           time.Time `json:"time"`
+	LocationSource string    `json:"location_source"`
+	TimeSource     string    `json:"time_source"`
+	// Altitude is geometric, without refraction, and Azimuth is measured from
+	// north through east, both in degrees
+	Altitude float64 `json:"altitude"`
+	Azimuth  float64 `json:"azimuth"`
+	// HourAngle is in hours, negative east of the meridian
+	HourAngle float64 `json:"hour_angle"`
+	// Airmass is left out when the field centre is below the horizon
+	Airmass          *float64 `json:"airmass,omitempty"`
+	ParallacticAngle float64  `json:"parallactic_angle"`
+}
+
+// JNowPosition is the field centre on the true equator and equinox of the
+// time the image was taken
+type JNowPosition struct {
+	RA  float64 `json:"ra"`
+	Dec float64 `json:"dec"`
+	// Time is the epoch, and TimeSource "request", "exif" or "solve" when the
+	// time the image was taken is not known and the time of the solve is used
+	Time       time.Time `json:"time"`
+	TimeSource string    `json:"time_source"`
+	Aberration bool      `json:"aberration"`
+}
+
+// parseObserver reads the latitude, longitude and observed_at fields. The
+// parts left out may be filled in from the image's EXIF data by observeImage.
+func parseObserver(r *http.Request) (*jobs.Observer, error) {
+	observer := &jobs.Observer{}
+	lat, lon := r.FormValue("latitude"), r.FormValue("longitude")
+	if (lat == "") != (lon == "") {
+		return nil, &uploadError{"The 'latitude' and 'longitude' fields must be set together", http.StatusBadRequest}
+	}
+	if lat != "" {
+		var err error
+		observer.Latitude, err = strconv.ParseFloat(lat, 64)
+		if err != nil || !(math.Abs(observer.Latitude) <= 90) {
+			return nil, &uploadError{"Invalid 'latitude' field: must be a number from -90 to 90", http.StatusBadRequest}
+		}
+		observer.Longitude, err = strconv.ParseFloat(lon, 64)
+		if err != nil || !(math.Abs(observer.Longitude) <= 180) {
+			return nil, &uploadError{"Invalid 'longitude' field: must be a number from -180 to 180", http.StatusBadRequest}
+		}
+		observer.LocationSource = sourceRequest
+	}
+	if value := r.FormValue("observed_at"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, &uploadError{"Invalid 'observed_at' field: must be an RFC 3339 time, e.g. 2026-01-15T21:30:00Z", http.StatusBadRequest}
+		}
+		observer.Time = t.UTC()
+		observer.TimeSource = sourceRequest
+	}
+	return observer, nil
+}
+
+// observeImage returns include with the parts of its observer the request
+// left out filled in from the EXIF data of the image at path. The observer is
+// dropped if neither its location nor its time is then known.
+func observeImage(include *jobs.Include, path string) *jobs.Include {
+	if include == nil || include.Observer == nil {
+		return include
+	}
+	observer := *include.Observer
+	if observer.LocationSource == "" || observer.TimeSource == "" {
+		if info, err := exif.ReadFile(path); err == nil {
+			if observer.LocationSource == "" && info.Location != nil {
+				observer.Latitude = info.Location.Latitude
+				observer.Longitude = info.Location.Longitude
+				observer.LocationSource = sourceEXIF
+			}
+			switch {
+			case observer.TimeSource != "":
+			case !info.Time.IsZero():
+				observer.Time = info.Time
+				observer.TimeSource = sourceEXIF
+			case !info.LocalTime.IsZero():
+				observer.Time = info.LocalTime
+				observer.TimeSource = sourceEXIFLocal
+			}
+		}
+	}
+
+	observed := *include
+	observed.Observer = nil
+	if observer.LocationSource != "" || observer.TimeSource != "" {
+		observed.Observer = &observer
+	}
+	return &observed
+}
+
+// fieldCoordinates converts the J2000 field centre to galactic, ecliptic and,
+// with an observer whose location and time (with its time zone) are known,
+// horizontal coordinates
+func fieldCoordinates(ra, dec float64, observer *jobs.Observer) *FieldCoordinates {
+	l, b := coord.Galactic(ra, dec)
+	lon, lat := coord.Ecliptic(ra, dec)
+	coordinates := &FieldCoordinates{
+		Galactic: GalacticCoordinates{L: l, B: b},
+		Ecliptic: EclipticCoordinates{Lon: lon, Lat: lat},
+	}
+	if observer == nil || observer.LocationSource == "" || observer.TimeSource == "" || observer.TimeSource == sourceEXIFLocal {
+		return coordinates
+	}
+
+	// The hour angle is taken from the position of date
+	raNow, decNow := coord.Precess(ra, dec, observer.Time)
+	lst := coord.SiderealTime(observer.Time, observer.Longitude)
+	h := coord.ToHorizontal(raNow, decNow, observer.Latitude, lst)
+	coordinates.Horizontal = &HorizontalCoordinates{
+		Latitude:         observer.Latitude,
+		Longitude:        observer.Longitude,
+		Time:             observer.Time,
+		LocationSource:   observer.LocationSource,
+		TimeSource:       observer.TimeSource,
+		Altitude:         h.Altitude,
+		Azimuth:          h.Azimuth,
+		HourAngle:        h.HourAngle,
+		ParallacticAngle: h.ParallacticAngle,
+	}
+	if airmass, ok := coord.Airmass(h.Altitude); ok {
+		coordinates.Horizontal.Airmass = &airmass
+	}
+	return coordinates
+}
+
+// fieldJNow converts the J2000 field centre to JNow at the observer's time,
+// or at solved if that is not known
+func fieldJNow(ra, dec float64, include *jobs.Include, solved time.Time) *JNowPosition {
+	position := &JNowPosition{Time: solved.UTC(), TimeSource: sourceSolve, Aberration: include.Aberration}
+	if observer := include.Observer; observer != nil && observer.TimeSource != "" {
+		position.Time, position.TimeSource = observer.Time, observer.TimeSource
+	}
+	position.RA, position.Dec = coord.ToJNow(ra, dec, position.Time, include.Aberration)
+	return position
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solvelog"
+)
+
+// SolveDiagnostics is what solve-field reported about a solve, read from its
+// output, and how long the solve took
+type SolveDiagnostics struct {
+	solvelog.Diagnostics
+	// Timings is left out for results served from the cache
+	Timings *SolveTimings `json:"timings,omitempty"`
+}
+
+// SolveTimings are the phases of a solve in seconds. solve-field does not
+// time its output, so they are measured by the server.
+type SolveTimings struct {
+	// Queue is the time spent waiting for a solver slot
+	Queue float64 `json:"queue"`
+	// Solve is the time from getting a slot to the result, across every
+	// attempt of an escalating solve
+	Solve float64 `json:"solve"`
+	Total float64 `json:"total"`
+	// Phases splits the solve into the phases its output reported, as the
+	// output arrived. It is left out for solvers that do not stream output.
+	Phases map[solvelog.Phase]float64 `json:"phases,omitempty"`
+}
+
+// newSolveDiagnostics returns the diagnostics of a finished job, or nil if
+// there are none
+func newSolveDiagnostics(job *jobs.Job) *SolveDiagnostics {
+	diagnostics := &SolveDiagnostics{}
+	found := false
+	if job.Result != nil {
+		if d := solvelog.Diagnose(job.Result.RawOutput); d != nil {
+			diagnostics.Diagnostics = *d
+			found = true
+		}
+	}
+	if !job.SolvingAt.IsZero() && !job.FinishedAt.IsZero() {
+		diagnostics.Timings = &SolveTimings{
+			Queue:  job.SolvingAt.Sub(job.CreatedAt).Seconds(),
+			Solve:  job.FinishedAt.Sub(job.SolvingAt).Seconds(),
+			Total:  job.FinishedAt.Sub(job.CreatedAt).Seconds(),
+			Phases: job.Phases,
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return diagnostics
+}
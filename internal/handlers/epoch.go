@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/coord"
+)
+
+// maxEpochBody bounds the form of an epoch conversion
+const maxEpochBody = 64 * 1024
+
+// EpochHandler converts positions between J2000 and JNow
+type EpochHandler struct{}
+
+// NewEpochHandler creates a new epoch conversion handler
+func NewEpochHandler() *EpochHandler {
+	return &EpochHandler{}
+}
+
+// EpochResponse is a position in both epochs
+type EpochResponse struct {
+	Success bool `json:"success"`
+	// Time is the epoch of the JNow position
+	Time       *time.Time     `json:"time,omitempty"`
+	Aberration bool           `json:"aberration,omitempty"`
+	J2000      *EpochPosition `json:"j2000,omitempty"`
+	JNow       *EpochPosition `json:"jnow,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// EpochPosition is a position in degrees
+type EpochPosition struct {
+	RA  float64 `json:"ra"`
+	Dec float64 `json:"dec"`
+}
+
+// ServeHTTP godoc
+//
+//	@Summary		Convert between J2000 and JNow
+//	@Description	Converts a position between J2000 and JNow, the true equator and equinox of an observation time, as many mounts and drivers expect. Precession (IAU 1976) and nutation (main terms of IAU 1980, good to about 0.5") are applied, and the annual aberration with aberration=true. The position is given as for the /solve hint: ra and dec (decimal or sexagesimal) or a catalog target. Fields may be sent as query parameters or as a form. The response holds the position in both epochs.
+//	@Tags			Coordinates
+//	@Accept			x-www-form-urlencoded,multipart/form-data
+//	@Produce		json
+//	@Param			ra			query		string			false	"Right Ascension, decimal or sexagesimal (e.g. 05:35:17.3)"
+//	@Param			dec			query		string			false	"Declination, decimal or sexagesimal (e.g. -05:23:28)"
+//	@Param			ra_units	query		string			false	"Units of a bare ra: degrees or hours"	Enums(degrees, hours)
+//	@Param			target		query		string			false	"Catalog object or star to convert instead of ra/dec, e.g. M42"
+//	@Param			from		query		string			false	"Epoch of the given position"				Enums(j2000, jnow)	default(j2000)
+//	@Param			observed_at	query		string			false	"Observation time (RFC 3339); defaults to now"
+//	@Param			aberration	query		boolean			false	"Apply the annual aberration"				default(false)
+//	@Success		200			{object}	EpochResponse	"Position in both epochs"
+//	@Failure		400			{object}	EpochResponse	"Bad request"
+//	@Failure		405			{object}	EpochResponse	"Method not allowed"
+//	@Router			/epoch [get]
+//	@Router			/epoch [post]
+func (h *EpochHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		respondEpochError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxEpochBody)
+
+	ra, dec, ok, err := parsePosition(r)
+	if err == nil && !ok {
+		err = &uploadError{"Missing position: send ra and dec, or target", http.StatusBadRequest}
+	}
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondEpochError(w, message, statusCode)
+		return
+	}
+	from, err := parseEpoch(r.FormValue("from"), "from")
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondEpochError(w, message, statusCode)
+		return
+	}
+	aberration, err := parseAberration(r)
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondEpochError(w, message, statusCode)
+		return
+	}
+	when := time.Now().UTC()
+	if value := r.FormValue("observed_at"); value != "" {
+		if when, err = time.Parse(time.RFC3339, value); err != nil {
+			respondEpochError(w, "Invalid 'observed_at' field: must be an RFC 3339 time, e.g. 2026-01-15T21:30:00Z", http.StatusBadRequest)
+			return
+		}
+		when = when.UTC()
+	}
+
+	response := &EpochResponse{Success: true, Time: &when, Aberration: aberration}
+	if from == coord.EpochJNow {
+		response.JNow = &EpochPosition{RA: ra, Dec: dec}
+		ra, dec = coord.FromJNow(ra, dec, when, aberration)
+		response.J2000 = &EpochPosition{RA: ra, Dec: dec}
+	} else {
+		response.J2000 = &EpochPosition{RA: ra, Dec: dec}
+		ra, dec = coord.ToJNow(ra, dec, when, aberration)
+		response.JNow = &EpochPosition{RA: ra, Dec: dec}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// parseEpoch reads an epoch field, which is empty, j2000 or jnow
+func parseEpoch(value, field string) (string, error) {
+	switch epoch := strings.ToLower(value); epoch {
+	case "", coord.EpochJ2000, coord.EpochJNow:
+		return epoch, nil
+	default:
+		return "", &uploadError{"Invalid '" + field + "' field: must be j2000 or jnow", http.StatusBadRequest}
+	}
+}
+
+// parseAberration reads the aberration field
+func parseAberration(r *http.Request) (bool, error) {
+	value := r.FormValue("aberration")
+	if value == "" {
+		return false, nil
+	}
+	aberration, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &uploadError{"Invalid 'aberration' field: must be true or false", http.StatusBadRequest}
+	}
+	return aberration, nil
+}
+
+func respondEpochError(w http.ResponseWriter, message string, statusCode int) {
+	writeJSON(w, statusCode, &EpochResponse{Error: message})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getEpoch(t *testing.T, query url.Values) (*httptest.ResponseRecorder, EpochResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/epoch?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	NewEpochHandler().ServeHTTP(w, req)
+
+	var response EpochResponse
+	if err := json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return w, response
+}
+
+func TestEpochHandler(t *testing.T) {
+	// theta Persei, from Meeus, Astronomical Algorithms, example 23.a
+	w, response := getEpoch(t, url.Values{
+		"ra": {"41.054063"}, "dec": {"49.227750"}, "observed_at": {"2028-11-13T04:33:36Z"}, "aberration": {"true"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if response.JNow == nil || math.Abs(response.JNow.RA-41.559958) > 0.5/3600 || math.Abs(response.JNow.Dec-49.352069) > 0.5/3600 {
+		t.Errorf("expected JNow 41.559958 49.352069, got %+v", response.JNow)
+	}
+	if response.J2000 == nil || response.J2000.RA != 41.054063 || !response.Aberration {
+		t.Errorf("expected the J2000 position back with aberration, got %+v", response)
+	}
+	if response.Time == nil || !response.Time.Equal(time.Date(2028, 11, 13, 4, 33, 36, 0, time.UTC)) {
+		t.Errorf("expected the observation time, got %v", response.Time)
+	}
+
+	// Converting the JNow position back gives the J2000 one
+	jnow := response.JNow
+	_, response = getEpoch(t, url.Values{
+		"ra": {strconv.FormatFloat(jnow.RA, 'f', -1, 64)}, "dec": {strconv.FormatFloat(jnow.Dec, 'f', -1, 64)}, "from": {"JNow"},
+		"observed_at": {"2028-11-13T04:33:36Z"}, "aberration": {"true"},
+	})
+	if response.J2000 == nil || math.Abs(response.J2000.RA-41.054063) > 1e-6 || math.Abs(response.J2000.Dec-49.227750) > 1e-6 {
+		t.Errorf("expected J2000 41.054063 49.227750, got %+v", response.J2000)
+	}
+
+	// A target is converted from its catalog position, at the current time
+	w, response = getEpoch(t, url.Values{"target": {"M42"}})
+	if w.Code != http.StatusOK || response.Time == nil || time.Since(*response.Time) > time.Minute {
+		t.Errorf("expected M42 converted at the current time, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestEpochHandler_Invalid(t *testing.T) {
+	for name, query := range map[string]url.Values{
+		"no position": {"from": {"j2000"}},
+		"bad ra":      {"ra": {"25h"}, "dec": {"0"}},
+		"bad from":    {"ra": {"10"}, "dec": {"0"}, "from": {"b1950"}},
+		"bad time":    {"ra": {"10"}, "dec": {"0"}, "observed_at": {"yesterday"}},
+		"bad flag":    {"ra": {"10"}, "dec": {"0"}, "aberration": {"maybe"}},
+	} {
+		w, response := getEpoch(t, query)
+		if w.Code != http.StatusBadRequest || response.Success || response.Error == "" {
+			t.Errorf("%s: expected status 400 with an error, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/epoch", nil)
+	w := httptest.NewRecorder()
+	NewEpochHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/escalate"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+)
+
+// EscalationResponse reports the attempts of a solve made with strategy=escalate
+type EscalationResponse struct {
+	// SolvedBy is the number of the attempt that found the solution, if any
+	SolvedBy   int               `json:"solved_by,omitempty"`
+	Ladder     []escalate.Step   `json:"ladder"`
+	TimeBudget float64           `json:"time_budget"`
+	Elapsed    float64           `json:"elapsed"`
+	Attempts   []AttemptResponse `json:"attempts"`
+}
+
+// AttemptResponse is one solve of an escalation
+type AttemptResponse struct {
+	Number   int           `json:"number"`
+	Step     escalate.Step `json:"step"`
+	Options  SolveRequest  `json:"options"`
+	Solved   bool          `json:"solved"`
+	Duration float64       `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// parseStrategy returns the escalation plan requested by the strategy, ladder
+// and time_budget fields, or nil for a single solve
+func parseStrategy(r *http.Request) (*escalate.Plan, error) {
+	switch r.FormValue("strategy") {
+	case "", "single":
+		return nil, nil
+	case "escalate":
+	default:
+		return nil, &uploadError{"Invalid 'strategy' field: must be single or escalate", http.StatusBadRequest}
+	}
+
+	plan := &escalate.Plan{}
+	if val := r.FormValue("ladder"); val != "" {
+		ladder, err := escalate.ParseLadder(val)
+		if err != nil {
+			return nil, &uploadError{"Invalid 'ladder' field: " + err.Error(), http.StatusBadRequest}
+		}
+		plan.Ladder = ladder
+	}
+	if val := r.FormValue("time_budget"); val != "" {
+		seconds, err := strconv.ParseFloat(val, 64)
+		if err != nil || seconds <= 0 {
+			return nil, &uploadError{"Invalid 'time_budget' field: must be a positive number of seconds", http.StatusBadRequest}
+		}
+		plan.Budget = time.Duration(seconds * float64(time.Second))
+	}
+	return plan, nil
+}
+
+// newEscalationResponse reports an escalating job's attempts, or returns nil
+// if the job did not escalate
+func newEscalationResponse(job *jobs.Job) *EscalationResponse {
+	if job.Escalation == nil || job.Cached {
+		return nil
+	}
+	response := &EscalationResponse{
+		Ladder:     job.Escalation.Ladder,
+		TimeBudget: job.Escalation.Budget.Seconds(),
+		Attempts:   make([]AttemptResponse, len(job.Attempts)),
+	}
+	for i, a := range job.Attempts {
+		response.Attempts[i] = newAttemptResponse(a)
+		response.Elapsed += a.Duration.Seconds()
+		if a.Solved {
+			response.SolvedBy = a.Number
+		}
+	}
+	return response
+}
+
+func newAttemptResponse(a escalate.Attempt) AttemptResponse {
+	return AttemptResponse{
+		Number:   a.Number,
+		Step:     a.Step,
+		Options:  newSolveRequest(a.Options),
+		Solved:   a.Solved,
+		Duration: a.Duration.Seconds(),
+		Error:    a.Error,
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventKeepAlive is how often a comment is sent on an idle event stream so
+// proxies do not drop the connection during long solves
+const eventKeepAlive = 15 * time.Second
+
+// wantsEventStream reports whether the client asked for Server-Sent Events
+func wantsEventStream(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
+
+type serverEvent struct {
+	name string
+	data []byte
+}
+
+// eventStream writes Server-Sent Events to a response. Events may be sent from
+// any goroutine without blocking; a single goroutine writes them out. Nothing,
+// not even the headers, is written until open is called, so the handler can
+// still send an ordinary error response instead.
+type eventStream struct {
+	w    http.ResponseWriter
+	wake chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	pending []serverEvent
+	opened  bool
+	closed  bool
+}
+
+// newEventStream starts the goroutine writing events to w
+func newEventStream(w http.ResponseWriter) *eventStream {
+	s := &eventStream{
+		w:    w,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// send queues an event with data encoded as JSON
+func (s *eventStream) send(name string, data any) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", name, err)
+		return
+	}
+	s.mu.Lock()
+	s.pending = append(s.pending, serverEvent{name: name, data: b})
+	s.mu.Unlock()
+	s.notify()
+}
+
+// open commits the response and starts writing events. It is safe to call more than once.
+func (s *eventStream) open() {
+	s.mu.Lock()
+	s.opened = true
+	s.mu.Unlock()
+	s.notify()
+}
+
+// close writes any remaining events and waits for the writer to finish. It
+// reports whether the stream was opened; if not, nothing was written to w.
+func (s *eventStream) close() bool {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.notify()
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opened
+}
+
+func (s *eventStream) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *eventStream) run() {
+	defer close(s.done)
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	started := false
+	for {
+		idle := false
+		select {
+		case <-s.wake:
+		case <-keepAlive.C:
+			idle = true
+		}
+
+		s.mu.Lock()
+		opened, closed := s.opened, s.closed
+		var events []serverEvent
+		if opened {
+			events, s.pending = s.pending, nil
+		}
+		s.mu.Unlock()
+
+		if opened {
+			if !started {
+				s.w.Header().Set("Content-Type", "text/event-stream")
+				s.w.Header().Set("Cache-Control", "no-cache")
+				s.w.Header().Set("X-Accel-Buffering", "no")
+				s.w.WriteHeader(http.StatusOK)
+				started = true
+			}
+			s.write(events, idle)
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+func (s *eventStream) write(events []serverEvent, idle bool) {
+	extendWriteDeadline(s.w)
+	if idle && len(events) == 0 {
+		fmt.Fprint(s.w, ": keep-alive\n\n") //nolint:errcheck // Write errors surface when the client disconnects
+	}
+	for _, e := range events {
+		fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", e.name, e.data) //nolint:errcheck // Write errors surface when the client disconnects
+	}
+	if err := http.NewResponseController(s.w).Flush(); err != nil {
+		log.Printf("Failed to flush event stream: %v", err)
+	}
+}
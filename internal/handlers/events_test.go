@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solvelog"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solver"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+type testEvent struct {
+	name string
+	data string
+}
+
+// readEvents reads Server-Sent Events until the stream ends
+func readEvents(t *testing.T, resp *http.Response) []testEvent {
+	t.Helper()
+	var events []testEvent
+	var current testEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		case line == "" && current.name != "":
+			events = append(events, current)
+			current = testEvent{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	return events
+}
+
+func postEventStream(t *testing.T, solveClient jobs.Solver) (*http.Response, []testEvent) {
+	t.Helper()
+	server := httptest.NewServer(NewSolveHandler(newTestManager(t, solveClient), newTestWorkspaces(t), 50*1024*1024))
+	t.Cleanup(server.Close)
+
+	testImage := createTestJPEG(t)
+	t.Cleanup(func() { os.Remove(testImage) }) //nolint:errcheck // Test cleanup
+	body, contentType := createMultipartRequest(t, "image", testImage)
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("solve request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() }) //nolint:errcheck // Test cleanup
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	return resp, readEvents(t, resp)
+}
+
+func phases(t *testing.T, events []testEvent) []string {
+	t.Helper()
+	var names []string
+	for _, e := range events {
+		if e.name != "phase" {
+			continue
+		}
+		var event solvelog.Event
+		if err := json.Unmarshal([]byte(e.data), &event); err != nil {
+			t.Fatalf("failed to decode phase event %q: %v", e.data, err)
+		}
+		names = append(names, string(event.Phase))
+	}
+	return names
+}
+
+func TestSolveHandler_EventStream(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			solver.Output(ctx, "Extracting sources...")
+			solver.Output(ctx, "Field 1 did not solve (index index-4208.fits, field objects 1-10).")
+			solver.Output(ctx, "Field 1: solved with index index-4207-01.fits.")
+			return &client.Result{Solved: true, RA: 83.8, Dec: -5.4}, nil
+		},
+	}
+	resp, events := postEventStream(t, queue.NewClient(mockClient, queue.NewLimiter(1, 1)))
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected Content-Type text/event-stream, got %q", ct)
+	}
+	if resp.Header.Get("X-Solve-ID") == "" {
+		t.Error("expected X-Solve-ID header")
+	}
+
+	got := strings.Join(phases(t, events), ",")
+	expected := "upload_saved,started,source_extraction,trying_index,solved"
+	if got != expected {
+		t.Errorf("expected phases %s, got %s", expected, got)
+	}
+
+	last := events[len(events)-1]
+	if last.name != "result" {
+		t.Fatalf("expected final result event, got %q", last.name)
+	}
+	var response SolveResponse
+	if err := json.Unmarshal([]byte(last.data), &response); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if !response.Solved || response.RA != 83.8 {
+		t.Errorf("expected solved result, got %+v", response)
+	}
+}
+
+func TestSolveHandler_EventStreamFromRawOutput(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: false, RawOutput: "Extracting sources...\nsimplexy: found 12 sources.\nDid not solve (or no WCS file was written)."}, nil
+		},
+	}
+	_, events := postEventStream(t, mockClient)
+
+	got := strings.Join(phases(t, events), ",")
+	expected := "upload_saved,source_extraction,source_extraction,failed"
+	if got != expected {
+		t.Errorf("expected phases %s, got %s", expected, got)
+	}
+	if last := events[len(events)-1]; last.name != "result" {
+		t.Errorf("expected final result event, got %q", last.name)
+	}
+}
+
+func TestSolveHandler_EventStreamQueueFull(t *testing.T) {
+	limiter := queue.NewLimiter(1, 0)
+	release, err := limiter.Acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to occupy solver slot: %v", err)
+	}
+	defer release()
+
+	resp, _ := postEventStream(t, queue.NewClient(&MockAstroClient{}, limiter))
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON error response, got %q", ct)
+	}
+}
+
+func TestWantsEventStream(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                                    false,
+		"application/json":                    false,
+		"text/event-stream":                   true,
+		"application/json, text/event-stream": true,
+		"text/event-stream; charset=utf-8":    true,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/solve", nil)
+		req.Header.Set("Accept", accept)
+		if got := wantsEventStream(req); got != expected {
+			t.Errorf("Accept %q: expected %v, got %v", accept, expected, got)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/catalog"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/coord"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/wcs"
+)
+
+// Values of the include field
+const (
+	includeObjects     = "objects"
+	includeStars       = "stars"
+	includeCoordinates = "coordinates"
+	includeFootprint   = "footprint"
+)
+
+// FieldObject is a deep-sky object from the offline catalog that is in the
+// solved field
+type FieldObject struct {
+	// ID is the Messier or Caldwell number, or else the NGC, IC or other
+	// designation
+	ID string `json:"id"`
+	// Names are the object's other designations, e.g. its NGC number
+	Names      []string `json:"names,omitempty"`
+	CommonName string   `json:"common_name,omitempty"`
+	Type       string   `json:"type"`
+	RA         float64  `json:"ra"`
+	Dec        float64  `json:"dec"`
+	// Magnitude is the visual magnitude, left out when it is not known
+	Magnitude float64 `json:"magnitude,omitempty"`
+	// MajorAxis and MinorAxis are the object's angular size in arcminutes
+	MajorAxis     float64 `json:"major_axis"`
+	MinorAxis     float64 `json:"minor_axis"`
+	Constellation string  `json:"constellation"`
+	// X and Y are the 1-based pixel position of the object's centre, which
+	// may be off the image for an object larger than the field
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	InImage bool    `json:"in_image"`
+}
+
+// FieldStar is a bright star from the offline catalog that is on the solved
+// image
+type FieldStar struct {
+	// Designation is the Bayer or Flamsteed designation, e.g. "alf Ori" or
+	// "41 Ari"
+	Designation   string  `json:"designation"`
+	Name          string  `json:"name,omitempty"`
+	Constellation string  `json:"constellation"`
+	RA            float64 `json:"ra"`
+	Dec           float64 `json:"dec"`
+	Magnitude     float64 `json:"magnitude"`
+	// X and Y are the 1-based pixel position of the star
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// parseInclude reads the comma-separated parts of the result asked for in the
+// include field and the epoch field, or returns nil if there are none
+func parseInclude(r *http.Request) (*jobs.Include, error) {
+	include := &jobs.Include{}
+	if value := r.FormValue("include"); value != "" {
+		for _, part := range strings.Split(value, ",") {
+			switch strings.TrimSpace(part) {
+			case includeObjects:
+				include.Objects = true
+			case includeStars:
+				include.Stars = true
+			case includeCoordinates:
+				include.Coordinates = true
+			case includeFootprint:
+				include.Footprint = true
+			default:
+				return nil, &uploadError{"Invalid 'include' field: must be a comma-separated list of objects, stars, coordinates, footprint", http.StatusBadRequest}
+			}
+		}
+	}
+	var err error
+	if include.Epoch, err = parseEpoch(r.FormValue("epoch"), "epoch"); err != nil {
+		return nil, err
+	}
+	if include.Epoch == coord.EpochJ2000 {
+		// J2000 is what the solution is in already
+		include.Epoch = ""
+	}
+	if *include == (jobs.Include{}) {
+		return nil, nil
+	}
+
+	if value := r.FormValue("star_mag_limit"); value != "" && include.Stars {
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(limit) || math.IsInf(limit, 0) {
+			return nil, &uploadError{"Invalid 'star_mag_limit' field: must be a magnitude", http.StatusBadRequest}
+		}
+		// Fainter limits would list only the few fainter stars the catalog
+		// happens to hold, as if they were all there are
+		if limit > catalog.StarsCompleteMag {
+			return nil, &uploadError{fmt.Sprintf("Invalid 'star_mag_limit' field: the star catalog is only complete to magnitude %g", catalog.StarsCompleteMag), http.StatusBadRequest}
+		}
+		include.StarMagLimit = &limit
+	}
+	if include.Footprint {
+		if include.HEALPixOrder, err = parseHEALPixOrder(r); err != nil {
+			return nil, err
+		}
+	}
+	if include.Epoch == coord.EpochJNow {
+		if include.Aberration, err = parseAberration(r); err != nil {
+			return nil, err
+		}
+	}
+	if include.Coordinates || include.Epoch == coord.EpochJNow {
+		if include.Observer, err = parseObserver(r); err != nil {
+			return nil, err
+		}
+	}
+	return include, nil
+}
+
+// addIncluded adds the optional parts a job asked for to its response. They
+// are worked out from the solution, so nothing is added to an unsolved
+// response.
+func addIncluded(response *SolveResponse, job *jobs.Job) {
+	if job.Include == nil || job.Result == nil || !job.Result.Solved {
+		return
+	}
+	if job.Include.Coordinates {
+		response.Coordinates = fieldCoordinates(job.Result.RA, job.Result.Dec, job.Include.Observer)
+	}
+	if job.Include.Epoch == coord.EpochJNow {
+		response.JNow = fieldJNow(job.Result.RA, job.Result.Dec, job.Include, job.CreatedAt)
+	}
+	solution, err := wcs.Parse(job.Result.WCSHeader)
+	if err != nil || solution.Width <= 0 || solution.Height <= 0 {
+		return
+	}
+	if job.Include.Objects {
+		response.Objects = fieldObjects(solution)
+	}
+	if job.Include.Stars {
+		limit := math.Inf(1)
+		if job.Include.StarMagLimit != nil {
+			limit = *job.Include.StarMagLimit
+		}
+		response.Stars = fieldStars(solution, limit)
+	}
+	if job.Include.Footprint {
+		response.Footprint = fieldFootprint(solution, job.Include.HEALPixOrder)
+	}
+}
+
+// fieldObjects returns the catalog objects whose centre is on the image or
+// whose extent overlaps it, brightest first
+func fieldObjects(solution *wcs.WCS) []FieldObject {
+	scale := solution.PixelScale() / 60
+	centerRA, centerDec := solution.Center()
+	radius := solution.Radius() * 60
+	objects := []FieldObject{}
+	for _, o := range catalog.Objects() {
+		// Objects far from the field are skipped before projecting them
+		if wcs.Separation(centerRA, centerDec, o.RA, o.Dec)*60 > radius+o.Size[0]/2 {
+			continue
+		}
+		x, y, ok := solution.SkyToPixel(o.RA, o.Dec)
+		if !ok {
+			continue
+		}
+		in := solution.Contains(x, y)
+		if !in && outsideBy(solution, x, y) > o.Size[0]/2/scale {
+			continue
+		}
+		objects = append(objects, FieldObject{
+			ID:            o.ID,
+			Names:         o.Names,
+			CommonName:    o.CommonName,
+			Type:          o.Type,
+			RA:            o.RA,
+			Dec:           o.Dec,
+			Magnitude:     o.Mag,
+			MajorAxis:     o.Size[0],
+			MinorAxis:     o.Size[1],
+			Constellation: o.Constellation,
+			X:             x,
+			Y:             y,
+			InImage:       in,
+		})
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return brighter(objects[i].Magnitude, objects[j].Magnitude)
+	})
+	return objects
+}
+
+// fieldStars returns the catalog stars on the image no fainter than limit,
+// brightest first
+func fieldStars(solution *wcs.WCS, limit float64) []FieldStar {
+	centerRA, centerDec := solution.Center()
+	radius := solution.Radius()
+	stars := []FieldStar{}
+	for _, s := range catalog.Stars() {
+		if s.Mag > limit || wcs.Separation(centerRA, centerDec, s.RA, s.Dec) > radius {
+			continue
+		}
+		x, y, ok := solution.SkyToPixel(s.RA, s.Dec)
+		if !ok || !solution.Contains(x, y) {
+			continue
+		}
+		stars = append(stars, FieldStar{
+			Designation:   s.Designation,
+			Name:          s.Name,
+			Constellation: s.Constellation,
+			RA:            s.RA,
+			Dec:           s.Dec,
+			Magnitude:     s.Mag,
+			X:             x,
+			Y:             y,
+		})
+	}
+	sort.SliceStable(stars, func(i, j int) bool {
+		return stars[i].Magnitude < stars[j].Magnitude
+	})
+	return stars
+}
+
+// outsideBy returns how far in pixels a point lies outside the image
+func outsideBy(solution *wcs.WCS, x, y float64) float64 {
+	dx := max(0.5-x, x-float64(solution.Width)-0.5, 0)
+	dy := max(0.5-y, y-float64(solution.Height)-0.5, 0)
+	return math.Hypot(dx, dy)
+}
+
+// brighter orders magnitudes brightest first, with unknown (0) magnitudes last
+func brighter(a, b float64) bool {
+	if a == 0 || b == 0 {
+		return a != 0 && b == 0
+	}
+	return a < b
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/healpix"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/wcs"
+)
+
+func TestFieldObjects(t *testing.T) {
+	// A 1024x768 field of about 17'x13' centred on M42
+	solution, err := wcs.Parse(testWCSHeader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	objects := fieldObjects(solution)
+
+	found := map[string]FieldObject{}
+	for _, o := range objects {
+		found[o.ID] = o
+	}
+	m42, ok := found["M42"]
+	if !ok {
+		t.Fatalf("expected M42 in the field, got %+v", objects)
+	}
+	if !m42.InImage || m42.CommonName != "Orion Nebula" || m42.MajorAxis != 85 || m42.Names[0] != "NGC 1976" {
+		t.Errorf("expected M42 in the image with its catalog data, got %+v", m42)
+	}
+	// M43's centre is 7' north of M42, just off the image, but it overlaps it
+	if m43, ok := found["M43"]; !ok || m43.InImage {
+		t.Errorf("expected M43 to overlap the field from outside, got %+v", m43)
+	}
+	if _, ok := found["NGC 1977"]; ok {
+		t.Error("expected NGC 1977, half a degree north, to be left out")
+	}
+	if objects[0].ID != "M42" {
+		t.Errorf("expected the brightest object first, got %s", objects[0].ID)
+	}
+}
+
+func TestBrighter(t *testing.T) {
+	if !brighter(4, 9) || brighter(9, 4) {
+		t.Error("expected lower magnitudes first")
+	}
+	if !brighter(12, 0) || brighter(0, 12) || brighter(0, 0) {
+		t.Error("expected unknown magnitudes last")
+	}
+}
+
+func TestFieldStars(t *testing.T) {
+	// A 5x4 degree field of Orion's belt, north up
+	solution, err := wcs.Parse(map[string]string{
+		"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN",
+		"CRVAL1": "84", "CRVAL2": "-1", "CRPIX1": "500.5", "CRPIX2": "400.5",
+		"CD1_1": "-0.005", "CD1_2": "0", "CD2_1": "0", "CD2_2": "-0.005",
+		"IMAGEW": "1000", "IMAGEH": "800",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, s := range fieldStars(solution, math.Inf(1)) {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "Alnilam,Alnitak,Mintaka" {
+		t.Errorf("expected the belt stars brightest first, got %v", names)
+	}
+
+	stars := fieldStars(solution, 2)
+	if len(stars) != 2 {
+		t.Fatalf("expected 2 stars brighter than magnitude 2, got %+v", stars)
+	}
+	alnilam := stars[0]
+	if alnilam.Designation != "eps Ori" || alnilam.Constellation != "Ori" || math.Abs(alnilam.X-490.1) > 0.5 || math.Abs(alnilam.Y-440.4) > 0.5 {
+		t.Errorf("expected Alnilam at about (490.1, 440.4), got %+v", alnilam)
+	}
+}
+
+func TestFieldFootprint(t *testing.T) {
+	// The 17'x13' field on M42, whose CD matrix mirrors it
+	solution, err := wcs.Parse(testWCSHeader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	footprint := fieldFootprint(solution, 12)
+	if len(footprint.Corners) != 4 || footprint.Corners[2].X != 1024.5 || footprint.Corners[2].Y != 768.5 {
+		t.Fatalf("expected the four corners in pixel order, got %+v", footprint.Corners)
+	}
+	fields := strings.Fields(footprint.SRegion)
+	if len(fields) != 10 || fields[0] != "POLYGON" || fields[1] != "ICRS" {
+		t.Fatalf("expected an STC-S polygon of four corners, got %q", footprint.SRegion)
+	}
+	var ring [][2]float64
+	for i := 2; i < len(fields); i += 2 {
+		ra, _ := strconv.ParseFloat(fields[i], 64)
+		dec, _ := strconv.ParseFloat(fields[i+1], 64)
+		ring = append(ring, [2]float64{ra, dec})
+	}
+	if !clockwise(ring) {
+		t.Errorf("expected the s_region counter-clockwise from inside the sky, got %q", footprint.SRegion)
+	}
+	if footprint.GeoJSON.Type != "Polygon" {
+		t.Fatalf("expected a GeoJSON polygon, got %+v", footprint.GeoJSON)
+	}
+	if geo := footprint.GeoJSON.Coordinates.([][][2]float64)[0]; len(geo) != 5 || geo[0] != geo[4] || clockwise(geo[:4]) {
+		t.Errorf("expected a closed counter-clockwise GeoJSON ring, got %v", geo)
+	}
+	h := footprint.HEALPix
+	if h.Order != 12 || h.Scheme != "nested" || !slices.Contains(h.Cells, healpix.Cell(12, 83.8221, -5.3911)) {
+		t.Errorf("expected the order-12 cells around M42, got %+v", h)
+	}
+
+	// A 60x40 degree field, north up, across RA 180 and with too many cells
+	// at order 8
+	solution, err = wcs.Parse(map[string]string{
+		"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN",
+		"CRVAL1": "180", "CRVAL2": "20", "CRPIX1": "3000.5", "CRPIX2": "2000.5",
+		"CD1_1": "-0.01", "CD1_2": "0", "CD2_1": "0", "CD2_2": "0.01",
+		"IMAGEW": "6000", "IMAGEH": "4000",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	footprint = fieldFootprint(solution, 8)
+	if h := footprint.HEALPix; h.Order >= 8 || len(h.Cells) > maxHEALPixCells || len(h.Cells) < maxHEALPixCells/16 {
+		t.Errorf("expected a coarser order with at most %d cells, got order %d with %d", maxHEALPixCells, h.Order, len(h.Cells))
+	}
+	polygons, ok := footprint.GeoJSON.Coordinates.([][][][2]float64)
+	if footprint.GeoJSON.Type != "MultiPolygon" || !ok || len(polygons) != 2 {
+		t.Fatalf("expected the footprint split at RA 180, got %+v", footprint.GeoJSON)
+	}
+	for _, polygon := range polygons {
+		ring := polygon[0]
+		if ring[0] != ring[len(ring)-1] || clockwise(ring[:len(ring)-1]) {
+			t.Errorf("expected a closed counter-clockwise ring, got %v", ring)
+		}
+		for _, p := range ring {
+			if p[0] < -180 || p[0] > 180 {
+				t.Errorf("expected longitudes from -180 to 180, got %v", ring)
+				break
+			}
+		}
+	}
+
+	// A field on the north celestial pole is closed off along it
+	solution, err = wcs.Parse(map[string]string{
+		"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN",
+		"CRVAL1": "100", "CRVAL2": "90", "CRPIX1": "500.5", "CRPIX2": "400.5",
+		"CD1_1": "-0.001", "CD1_2": "0", "CD2_1": "0", "CD2_2": "0.001",
+		"IMAGEW": "1000", "IMAGEH": "800",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	footprint = fieldFootprint(solution, 8)
+	if !slices.Contains(footprint.HEALPix.Cells, healpix.Cell(8, 0, 90)) {
+		t.Errorf("expected the cell on the pole, got %v", footprint.HEALPix.Cells)
+	}
+	var poles int
+	for _, polygon := range footprint.GeoJSON.Coordinates.([][][][2]float64) {
+		for _, p := range polygon[0] {
+			if p[1] == 90 {
+				poles++
+			}
+		}
+	}
+	if poles != 4 {
+		t.Errorf("expected both parts of the ring to reach the pole, got %+v", footprint.GeoJSON)
+	}
+}
+
+// clockwise reports whether a ring of RA and Dec turns clockwise with RA
+// increasing to the right
+func clockwise(ring [][2]float64) bool {
+	var area float64
+	for i, a := range ring {
+		b := ring[(i+1)%len(ring)]
+		area += wrapLongitude(b[0]-a[0]) * (b[1] + a[1])
+	}
+	return area > 0
+}
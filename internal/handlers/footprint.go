@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/healpix"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/wcs"
+)
+
+const (
+	// defaultHEALPixOrder gives cells about 14 arcminutes across
+	defaultHEALPixOrder = 8
+	// maxHEALPixCells bounds the cells listed for a footprint; a coarser order
+	// is used for a field that would cover more
+	maxHEALPixCells = 10000
+)
+
+// Footprint is the outline of the solved image on the sky
+type Footprint struct {
+	// Corners are the outer corners of the image, in pixel order from (0.5,
+	// 0.5) through (width+0.5, 0.5) and (width+0.5, height+0.5) to (0.5,
+	// height+0.5)
+	Corners []FootprintCorner `json:"corners"`
+	// SRegion is the corners as an IVOA STC-S polygon, counter-clockwise as
+	// seen from the centre of the sky
+	SRegion string          `json:"s_region"`
+	GeoJSON GeoJSONGeometry `json:"geojson"`
+	HEALPix HEALPixCoverage `json:"healpix"`
+}
+
+// FootprintCorner is a corner of the image, in 1-based pixels and degrees
+type FootprintCorner struct {
+	X   float64 `json:"x"`
+	Y   float64 `json:"y"`
+	RA  float64 `json:"ra"`
+	Dec float64 `json:"dec"`
+}
+
+// GeoJSONGeometry is a GeoJSON (RFC 7946) Polygon, or a MultiPolygon when the
+// footprint crosses RA 180, with RA from -180 to 180 as longitude and Dec as
+// latitude
+type GeoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// HEALPixCoverage is the HEALPix cells the footprint covers or touches
+type HEALPixCoverage struct {
+	// Order is the order used, which is lower than the one asked for if the
+	// field would cover more than maxHEALPixCells cells at it
+	Order  int      `json:"order"`
+	Scheme string   `json:"scheme"`
+	Cells  []uint64 `json:"cells"`
+}
+
+// parseHEALPixOrder reads the healpix_order field
+func parseHEALPixOrder(r *http.Request) (int, error) {
+	value := r.FormValue("healpix_order")
+	if value == "" {
+		return defaultHEALPixOrder, nil
+	}
+	order, err := strconv.Atoi(value)
+	if err != nil || order < 0 || order > healpix.MaxOrder {
+		return 0, &uploadError{"Invalid 'healpix_order' field: must be an integer from 0 to " + strconv.Itoa(healpix.MaxOrder), http.StatusBadRequest}
+	}
+	return order, nil
+}
+
+// fieldFootprint returns the outline of the image on the sky, with the
+// HEALPix cells it covers at order or a coarser one
+func fieldFootprint(solution *wcs.WCS, order int) *Footprint {
+	right, bottom := float64(solution.Width)+0.5, float64(solution.Height)+0.5
+	pixels := [4][2]float64{{0.5, 0.5}, {right, 0.5}, {right, bottom}, {0.5, bottom}}
+	corners := solution.Corners()
+	footprint := &Footprint{}
+	for i, corner := range corners {
+		footprint.Corners = append(footprint.Corners, FootprintCorner{X: pixels[i][0], Y: pixels[i][1], RA: corner[0], Dec: corner[1]})
+	}
+
+	// The pixel order runs counter-clockwise as seen from the centre of the
+	// sky, with east to the left of north, unless the image is mirrored
+	ring := corners[:]
+	det := solution.CD[0][0]*solution.CD[1][1] - solution.CD[0][1]*solution.CD[1][0]
+	if det > 0 {
+		ring = [][2]float64{corners[0], corners[3], corners[2], corners[1]}
+	}
+	parts := []string{"POLYGON ICRS"}
+	for _, corner := range ring {
+		parts = append(parts, formatDegrees(corner[0]), formatDegrees(corner[1]))
+	}
+	footprint.SRegion = strings.Join(parts, " ")
+
+	// GeoJSON rings are counter-clockwise with longitude increasing to the
+	// right, as seen from outside the sky
+	outside := slices.Clone(ring)
+	slices.Reverse(outside)
+	footprint.GeoJSON = geoJSONFootprint(outside)
+
+	footprint.HEALPix = HEALPixCoverage{Order: order, Scheme: "nested"}
+	for ; order >= 0; order-- {
+		if cells, ok := healpix.QueryPolygon(order, ring, maxHEALPixCells); ok {
+			footprint.HEALPix.Order, footprint.HEALPix.Cells = order, cells
+			break
+		}
+	}
+	return footprint
+}
+
+// geoJSONFootprint returns the corners, counter-clockwise as seen from
+// outside the sky, as a GeoJSON geometry. Its edges are straight in RA and
+// Dec, so the ring is taken round a celestial pole on the image, and split in
+// two where it crosses RA 180.
+func geoJSONFootprint(corners [][2]float64) GeoJSONGeometry {
+	// Longitudes are unwrapped from the first corner, so each edge takes the
+	// short way round
+	ring := [][2]float64{{wrapLongitude(corners[0][0]), corners[0][1]}}
+	for _, corner := range corners[1:] {
+		last := ring[len(ring)-1]
+		ring = append(ring, [2]float64{last[0] + wrapLongitude(corner[0]-last[0]), corner[1]})
+	}
+	last := ring[len(ring)-1]
+	end := last[0] + wrapLongitude(corners[0][0]-last[0])
+	if turn := end - ring[0][0]; math.Abs(turn) > 180 {
+		// The ring has gone round a pole, eastwards round the north one or
+		// westwards round the south one, and is closed off along it
+		pole := math.Copysign(90, turn)
+		ring = append(ring, [2]float64{end, corners[0][1]}, [2]float64{end, pole}, [2]float64{ring[0][0], pole})
+	}
+	ring = append(ring, ring[0])
+
+	west, east := math.Inf(1), math.Inf(-1)
+	for _, p := range ring {
+		west, east = min(west, p[0]), max(east, p[0])
+	}
+	var edge float64
+	switch {
+	case east > 180:
+		edge = 180
+	case west < -180:
+		edge = -180
+	default:
+		return GeoJSONGeometry{Type: "Polygon", Coordinates: [][][2]float64{ring}}
+	}
+	inner := clipLongitude(ring, edge, func(lon float64) bool { return math.Abs(lon) <= 180 })
+	outer := clipLongitude(ring, edge, func(lon float64) bool { return math.Abs(lon) >= 180 })
+	for i := range outer {
+		outer[i][0] -= math.Copysign(360, edge)
+	}
+	return GeoJSONGeometry{Type: "MultiPolygon", Coordinates: [][][][2]float64{{inner}, {outer}}}
+}
+
+// clipLongitude returns the part of a closed ring on the side of the
+// meridian at edge that keep holds for, still closed
+func clipLongitude(ring [][2]float64, edge float64, keep func(lon float64) bool) [][2]float64 {
+	var clipped [][2]float64
+	for i, b := range ring[1:] {
+		a := ring[i]
+		if keep(a[0]) {
+			clipped = append(clipped, a)
+		}
+		if (a[0]-edge)*(b[0]-edge) < 0 {
+			t := (edge - a[0]) / (b[0] - a[0])
+			clipped = append(clipped, [2]float64{edge, a[1] + t*(b[1]-a[1])})
+		}
+	}
+	return append(clipped, clipped[0])
+}
+
+// wrapLongitude wraps an angle in degrees to [-180, 180)
+func wrapLongitude(lon float64) float64 {
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
+
+// formatDegrees formats an angle in degrees to about 0.4 milliarcseconds
+func formatDegrees(value float64) string {
+	return strconv.FormatFloat(value, 'f', 7, 64)
+}
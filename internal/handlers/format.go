@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/fits"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+)
+
+// Response formats of a solve
+const (
+	formatJSON = "json"
+	formatWCS  = "wcs"
+	formatFITS = "fits"
+	// formatAnnotated is an image of the solved field with its grid,
+	// constellations and deep-sky objects drawn on it
+	formatAnnotated = "annotated"
+)
+
+// parseFormat reads the response format from the format and output fields,
+// falling back to the Accept header. Files are only sent in reply to the
+// request, so they cannot be combined with a callback or an event stream.
+func parseFormat(r *http.Request) (string, error) {
+	format := r.FormValue("format")
+	switch format {
+	case "", formatJSON, formatWCS:
+	default:
+		return "", &uploadError{"Invalid 'format' field: must be json or wcs", http.StatusBadRequest}
+	}
+	output := r.FormValue("output")
+	switch output {
+	case "", formatJSON, formatFITS, formatAnnotated:
+	default:
+		return "", &uploadError{"Invalid 'output' field: must be json, fits or annotated", http.StatusBadRequest}
+	}
+
+	switch {
+	case (output == formatFITS || output == formatAnnotated) && format == formatWCS:
+		return "", &uploadError{"Only one of format=wcs and output=" + output + " may be set", http.StatusBadRequest}
+	case output == formatFITS || output == formatAnnotated:
+		format = output
+	case format == "" && acceptsFITS(r):
+		format = formatWCS
+	case format == "":
+		format = formatJSON
+	}
+
+	if format != formatJSON && (r.FormValue("callback_url") != "" || wantsEventStream(r)) {
+		return "", &uploadError{"A file cannot be sent with callback_url or as an event stream", http.StatusBadRequest}
+	}
+	return format, nil
+}
+
+// acceptsFITS reports whether the client asked for a FITS file
+func acceptsFITS(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/fits" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasWCS reports whether a finished job has a WCS to send. If not, it sends
+// the job's SolveResponse with a 422.
+func hasWCS(w http.ResponseWriter, job *jobs.Job) bool {
+	if job.Result != nil && job.Result.Solved && len(job.Result.WCSHeader) > 0 {
+		return true
+	}
+	response := newJobSolveResponse(job)
+	if response.Error == "" {
+		response.Error = "No WCS solution for the image"
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, response)
+	return false
+}
+
+// attachmentName returns the name of a file sent in reply to an upload: the
+// upload's name without its extension, followed by suffix
+func attachmentName(filename, suffix string) string {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	if name == "" || name == "." || name == "/" {
+		name = "solution"
+	}
+	return name + suffix
+}
+
+func setAttachment(w http.ResponseWriter, name string, size int64) {
+	w.Header().Set("Content-Type", "application/fits")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+}
+
+// respondWCS sends the WCS of a finished job as a header-only FITS file named
+// after the uploaded image
+func respondWCS(w http.ResponseWriter, filename string, job *jobs.Job) {
+	if !hasWCS(w, job) {
+		return
+	}
+	data := fits.WCSHeader(job.Result.WCSHeader).Encode()
+	setAttachment(w, attachmentName(filename, ".wcs"), int64(len(data)))
+	w.Write(data) //nolint:errcheck // Client went away
+}
+
+// fitsUpload is an uploaded FITS file the WCS of its solve is written into
+type fitsUpload struct {
+	file *os.File
+	fits *fits.File
+	// hdu is the index of the HDU that gets the WCS
+	hdu int
+}
+
+// openFITSUpload reads the headers of the uploaded FITS file at path and picks
+// the HDU named by the hdu field, or else the first one holding an image
+func openFITSUpload(path, hduField string) (*fitsUpload, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	upload, err := readFITSUpload(file, hduField)
+	if err != nil {
+		file.Close() //nolint:errcheck // Error from Close on read is not critical
+		return nil, err
+	}
+	return upload, nil
+}
+
+func readFITSUpload(file *os.File, hduField string) (*fitsUpload, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f, err := fits.Read(file, info.Size())
+	if err != nil {
+		return nil, &uploadError{"Cannot read the FITS file: " + err.Error(), http.StatusBadRequest}
+	}
+
+	upload := &fitsUpload{file: file, fits: f, hdu: -1}
+	if hduField == "" {
+		for i, hdu := range f.HDUs {
+			if hdu.IsImage() {
+				upload.hdu = i
+				return upload, nil
+			}
+		}
+		return nil, &uploadError{"The FITS file holds no image", http.StatusBadRequest}
+	}
+
+	upload.hdu, err = strconv.Atoi(hduField)
+	if err != nil || upload.hdu < 0 || upload.hdu >= len(f.HDUs) {
+		return nil, &uploadError{fmt.Sprintf("Invalid 'hdu' field: must be an HDU number from 0 to %d", len(f.HDUs)-1), http.StatusBadRequest}
+	}
+	if !f.HDUs[upload.hdu].IsImage() {
+		return nil, &uploadError{fmt.Sprintf("HDU %d holds no image", upload.hdu), http.StatusBadRequest}
+	}
+	return upload, nil
+}
+
+func (u *fitsUpload) close() {
+	u.file.Close() //nolint:errcheck // Error from Close on read is not critical
+}
+
+// respondFITS sends the uploaded FITS file with the WCS of a finished job
+// written into the chosen HDU, and every other HDU as it was
+func respondFITS(w http.ResponseWriter, filename string, job *jobs.Job, upload *fitsUpload) {
+	if !hasWCS(w, job) {
+		return
+	}
+	hdu := upload.fits.HDUs[upload.hdu]
+	hdu.Header = hdu.Header.WithWCS(job.Result.WCSHeader,
+		"WCS from astrometry-api-server solve "+job.ID,
+		fmt.Sprintf("Solved %s, RA %.6f Dec %.6f", job.FinishedAt.UTC().Format(time.RFC3339), job.Result.RA, job.Result.Dec),
+	)
+
+	setAttachment(w, attachmentName(filename, "-solved"+filepath.Ext(filename)), upload.fits.Size())
+	if _, err := upload.fits.WriteTo(w); err != nil {
+		log.Printf("Failed to send FITS file: %v", err)
+	}
+}
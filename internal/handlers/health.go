@@ -1,34 +1,66 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"sync"
 	"time"
 )
 
+const (
+	// healthCheckTimeout bounds the solver health check of a /health request
+	healthCheckTimeout = 5 * time.Second
+	// healthCacheInterval is how long the result of a solver health check is
+	// reused, so frequent probes do not each run a check on every solver
+	healthCacheInterval = 10 * time.Second
+)
+
+// HealthChecker reports whether the solver backend can run solves
+type HealthChecker interface {
+	Name() string
+	Health(ctx context.Context) error
+}
+
 // HealthHandler handles health check requests
 type HealthHandler struct {
 	startTime time.Time
+	solver    HealthChecker
+
+	// mu serialises solver checks; checkedAt and checkErr are the last one
+	mu        sync.Mutex
+	checkedAt time.Time
+	checkErr  error
 }
 
-// NewHealthHandler creates a new health handler
-func NewHealthHandler() *HealthHandler {
+// NewHealthHandler creates a new health handler. The solver backend is
+// checked at most every healthCacheInterval unless it is nil.
+func NewHealthHandler(solver HealthChecker) *HealthHandler {
 	return &HealthHandler{
 		startTime: time.Now(),
+		solver:    solver,
 	}
 }
 
 // HealthResponse represents the health check response
 type HealthResponse struct {
-	Status  string  `json:"status"`
-	Uptime  float64 `json:"uptime_seconds"`
-	Version string  `json:"version"`
+	Status  string          `json:"status"`
+	Uptime  float64         `json:"uptime_seconds"`
+	Version string          `json:"version"`
+	Solver  *SolverResponse `json:"solver,omitempty"`
+}
+
+// SolverResponse reports the health of the solver backend
+type SolverResponse struct {
+	Backend string `json:"backend"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
 }
 
 // ServeHTTP godoc
 //
 //	@Summary		Health check
-//	@Description	Returns server health status and uptime
+//	@Description	Returns server health status and uptime, and whether the solver backend can run solves. The status is "degraded" when it cannot.
 //	@Tags			Health
 //	@Produce		json
 //	@Success		200	{object}	HealthResponse	"Server is healthy"
@@ -48,8 +80,36 @@ func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Version: "0.1.0", // This should be read from VERSION file in production
 	}
 
+	if h.solver != nil {
+		response.Solver = &SolverResponse{Backend: h.solver.Name(), Healthy: true}
+		if err := h.solverHealth(r.Context()); err != nil {
+			// The server itself is up, so this is still a 200
+			response.Status = "degraded"
+			response.Solver.Healthy = false
+			response.Solver.Error = err.Error()
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
+
+// solverHealth returns the result of the last solver health check, checking
+// again if it is older than healthCacheInterval. Requests arriving during a
+// check wait for it and share its result.
+func (h *HealthHandler) solverHealth(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.checkedAt.IsZero() && time.Since(h.checkedAt) < healthCacheInterval {
+		return h.checkErr
+	}
+
+	// The result is shared, so it must not depend on this request going away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthCheckTimeout)
+	defer cancel()
+	h.checkErr = h.solver.Health(ctx)
+	h.checkedAt = time.Now()
+	return h.checkErr
+}
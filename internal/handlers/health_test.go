@@ -1,14 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestHealthHandler_Success(t *testing.T) {
-	handler := NewHealthHandler()
+	handler := NewHealthHandler(nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
 	w := httptest.NewRecorder()
@@ -38,7 +41,7 @@ func TestHealthHandler_Success(t *testing.T) {
 }
 
 func TestHealthHandler_MethodNotAllowed(t *testing.T) {
-	handler := NewHealthHandler()
+	handler := NewHealthHandler(nil)
 
 	req := httptest.NewRequest(http.MethodPost, "/health", nil)
 	w := httptest.NewRecorder()
@@ -49,3 +52,91 @@ func TestHealthHandler_MethodNotAllowed(t *testing.T) {
 		t.Errorf("expected status 405, got %d", w.Code)
 	}
 }
+
+type fakeChecker struct {
+	err    error
+	checks int
+}
+
+func (f *fakeChecker) Name() string {
+	return "fake"
+}
+
+func (f *fakeChecker) Health(ctx context.Context) error {
+	f.checks++
+	return f.err
+}
+
+func TestHealthHandler_Solver(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  string
+		healthy bool
+	}{
+		{"healthy", nil, "healthy", true},
+		{"unhealthy", errors.New("solve-field not found"), "degraded", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHealthHandler(&fakeChecker{err: tt.err})
+
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status 200, got %d", w.Code)
+			}
+
+			var response HealthResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if response.Status != tt.status {
+				t.Errorf("expected status '%s', got '%s'", tt.status, response.Status)
+			}
+			if response.Solver == nil {
+				t.Fatal("expected solver health to be reported")
+			}
+			if response.Solver.Backend != "fake" {
+				t.Errorf("expected backend 'fake', got '%s'", response.Solver.Backend)
+			}
+			if response.Solver.Healthy != tt.healthy {
+				t.Errorf("expected healthy %v, got %v", tt.healthy, response.Solver.Healthy)
+			}
+			if tt.err != nil && response.Solver.Error != tt.err.Error() {
+				t.Errorf("expected error '%v', got '%s'", tt.err, response.Solver.Error)
+			}
+		})
+	}
+}
+
+func TestHealthHandler_CachesSolverCheck(t *testing.T) {
+	checker := &fakeChecker{err: errors.New("container not running")}
+	handler := NewHealthHandler(checker)
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var response HealthResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Status != "degraded" {
+			t.Errorf("expected status 'degraded', got '%s'", response.Status)
+		}
+	}
+	if checker.checks != 1 {
+		t.Errorf("expected the solver to be checked once, got %d checks", checker.checks)
+	}
+
+	// An expired result is checked again
+	handler.checkedAt = time.Now().Add(-healthCacheInterval)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if checker.checks != 2 {
+		t.Errorf("expected the solver to be checked again, got %d checks", checker.checks)
+	}
+}
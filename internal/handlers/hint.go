@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/catalog"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/coord"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// defaultHintRadius is the search radius in degrees of a position hint given
+// without one. The solver ignores a position without a radius.
+const defaultHintRadius = 10.0
+
+// parseHint sets the position hint of opts from the ra and dec fields, or from
+// the catalog position of the object named in the target field, searching
+// within the radius field or else defaultHintRadius of it
+func parseHint(r *http.Request, opts *client.SolveOptions) error {
+	radius := 0.0
+	if value := r.FormValue("radius"); value != "" {
+		var err error
+		radius, err = strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(radius) || radius <= 0 || radius > 180 {
+			return &uploadError{"Invalid 'radius' field: must be a number of degrees from 0 to 180", http.StatusBadRequest}
+		}
+	}
+	ra, dec, ok, err := parsePosition(r)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if radius != 0 {
+			return &uploadError{"The 'radius' field needs a position from 'ra' and 'dec' or 'target'", http.StatusBadRequest}
+		}
+		return nil
+	}
+	opts.RA, opts.Dec, opts.Radius = ra, dec, hintRadius(radius)
+	return nil
+}
+
+// hintRadius returns the search radius of a position hint, defaultHintRadius
+// if radius is not set
+func hintRadius(radius float64) float64 {
+	if radius <= 0 {
+		return defaultHintRadius
+	}
+	return radius
+}
+
+// parsePosition reads a position in degrees from the ra, dec and ra_units
+// fields, or from the catalog position of the object named in the target
+// field. ok is false when none of them is set.
+func parsePosition(r *http.Request) (ra, dec float64, ok bool, err error) {
+	raValue, decValue, target := r.FormValue("ra"), r.FormValue("dec"), r.FormValue("target")
+	if target != "" {
+		if raValue != "" || decValue != "" {
+			return 0, 0, false, &uploadError{"Only one of target and ra/dec may be set", http.StatusBadRequest}
+		}
+		p, found := catalog.Resolve(target)
+		if !found {
+			return 0, 0, false, &uploadError{fmt.Sprintf("Unknown 'target': %q is not in the catalog", target), http.StatusBadRequest}
+		}
+		return p.RA, p.Dec, true, nil
+	}
+
+	units := r.FormValue("ra_units")
+	switch units {
+	case "", coord.Degrees, coord.Hours:
+	default:
+		return 0, 0, false, &uploadError{"Invalid 'ra_units' field: must be degrees or hours", http.StatusBadRequest}
+	}
+	if raValue == "" && decValue == "" {
+		return 0, 0, false, nil
+	}
+	if raValue == "" || decValue == "" {
+		return 0, 0, false, &uploadError{"The 'ra' and 'dec' fields must be set together", http.StatusBadRequest}
+	}
+
+	if ra, err = coord.ParseRA(raValue, units); err != nil {
+		return 0, 0, false, &uploadError{"Invalid 'ra' field: " + err.Error(), http.StatusBadRequest}
+	}
+	if dec, err = coord.ParseDec(decValue); err != nil {
+		return 0, 0, false, &uploadError{"Invalid 'dec' field: " + err.Error(), http.StatusBadRequest}
+	}
+	return ra, dec, true, nil
+}
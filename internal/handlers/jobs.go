@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
+)
+
+// JobsHandler handles asynchronous solve job requests
+type JobsHandler struct {
+	manager       *jobs.Manager
+	workspaces    *workspace.Manager
+	maxUploadSize int64
+}
+
+// NewJobsHandler creates a new jobs handler
+func NewJobsHandler(manager *jobs.Manager, workspaces *workspace.Manager, maxUploadSize int64) *JobsHandler {
+	return &JobsHandler{
+		manager:       manager,
+		workspaces:    workspaces,
+		maxUploadSize: maxUploadSize,
+	}
+}
+
+// JobResponse represents the state of an asynchronous solve job
+type JobResponse struct {
+	ID            string         `json:"id,omitempty"`
+	Status        string         `json:"status,omitempty"`
+	QueuePosition int            `json:"queue_position,omitempty"`
+	CreatedAt     *time.Time     `json:"created_at,omitempty"`
+	StartedAt     *time.Time     `json:"started_at,omitempty"`
+	FinishedAt    *time.Time     `json:"finished_at,omitempty"`
+	Result        *SolveResponse `json:"result,omitempty"`
+	Callback      *jobs.Callback `json:"callback,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// ServeHTTP routes POST /jobs and GET /jobs/{id}
+func (h *JobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		h.submit(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		h.get(w, id)
+	default:
+		respondJobError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// submit godoc
+//
+//	@Summary		Submit an asynchronous plate-solve job
+//	@Description	Accepts the same fields as /solve, queues the solve and returns a job ID immediately. Poll GET /jobs/{id} for the result. Jobs are persisted and resumed if the server restarts.
+//	@Tags			Solving
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			image				formData	file			true	"Image file (JPG, JPEG, PNG, FITS, FIT)"
+//	@Param			scale_low			formData	number			false	"Lower bound of image scale"
+//	@Param			scale_high			formData	number			false	"Upper bound of image scale"
+//	@Param			scale_units			formData	string			false	"Units for scale bounds (degwidth, arcminwidth, arcsecperpix)"	default(arcminwidth)
+//	@Param			downsample_factor	formData	int				false	"Downsample factor (higher = faster but less accurate)"	default(2)
+//	@Param			depth_low			formData	int				false	"Minimum number of quads to try"	default(10)
+//	@Param			depth_high			formData	int				false	"Maximum number of quads to try"	default(20)
+//	@Param			ra					formData	string			false	"Right Ascension hint (J2000): decimal degrees, or hours as 05:35:17, 05 35 17 or 5h35m17s"
+//	@Param			dec					formData	string			false	"Declination hint (J2000): decimal degrees, or -05:23:28, -05 23 28 or -5d23m28s"
+//	@Param			ra_units			formData	string			false	"Units of ra: degrees or hours (default: degrees when decimal, hours when sexagesimal)"
+//	@Param			target				formData	string			false	"Object name to use as the position hint instead of ra/dec, e.g. M42, NGC 1976 or Betelgeuse"
+//	@Param			radius				formData	number			false	"Search radius in degrees around ra/dec or target, 10 if not set"
+//	@Param			callback_url		formData	string			false	"URL to POST the signed SolveResponse to when the job finishes"
+//	@Param			no_cache			formData	boolean			false	"Solve the image even if a cached result exists"	default(false)
+//	@Param			auto_scale			formData	boolean			false	"Take scale_low/scale_high from the image's EXIF analysis unless given, and include the analysis in the result"	default(false)
+//	@Param			strategy			formData	string			false	"single, or escalate to retry an unsolved image with looser options"	default(single)
+//	@Param			ladder				formData	string			false	"Comma-separated escalation steps (widen_scale, drop_scale, increase_depth, change_downsample, drop_hint)"
+//	@Param			time_budget			formData	number			false	"Total time in seconds for all escalation attempts"
+//	@Param			include				formData	string			false	"Comma-separated optional parts of the result: objects for the deep-sky objects in the field, stars for the bright stars, coordinates for the galactic, ecliptic and horizontal coordinates of the field centre, footprint for the corners, s_region, GeoJSON and HEALPix cells of the field"
+//	@Param			star_mag_limit		formData	number			false	"Faintest magnitude of the stars listed with include=stars, at most 3"
+//	@Param			healpix_order		formData	int				false	"HEALPix order of the cells listed with include=footprint, lowered if the field covers more than 10000 cells"	default(8)
+//	@Param			latitude			formData	number			false	"Observer latitude in degrees for include=coordinates, north positive; taken from EXIF GPS data if not given"
+//	@Param			longitude			formData	number			false	"Observer longitude in degrees for include=coordinates, east positive; taken from EXIF GPS data if not given"
+//	@Param			observed_at			formData	string			false	"Time the image was taken for include=coordinates and epoch=jnow (RFC 3339); taken from EXIF data if not given"
+//	@Param			epoch				formData	string			false	"jnow adds the field centre on the true equator and equinox of the time the image was taken; ra and dec stay J2000"	Enums(j2000, jnow)	default(j2000)
+//	@Param			aberration			formData	boolean			false	"Apply the annual aberration with epoch=jnow"	default(false)
+//	@Success		202					{object}	JobResponse		"Job accepted"
+//	@Failure		400					{object}	JobResponse		"Bad request (including a callback URL that is not allowed)"
+//	@Failure		405					{object}	JobResponse		"Method not allowed"
+//	@Failure		413					{object}	JobResponse		"File too large"
+//	@Failure		503					{object}	JobResponse		"Job queue is full"
+//	@Router			/jobs [post]
+func (h *JobsHandler) submit(w http.ResponseWriter, r *http.Request) {
+	// The workspace outlives the request and is released when the job finishes
+	ws, err := h.workspaces.Create("job")
+	if err != nil {
+		log.Printf("Failed to create workspace: %v", err)
+		respondJobError(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+
+	imagePath, header, err := saveUpload(w, r, h.maxUploadSize, ws, solveFormats)
+	if err != nil {
+		releaseWorkspace(ws)
+		message, statusCode := uploadErrorStatus(err)
+		respondJobError(w, message, statusCode)
+		return
+	}
+
+	escalation, err := parseStrategy(r)
+	if err != nil {
+		releaseWorkspace(ws)
+		message, statusCode := uploadErrorStatus(err)
+		respondJobError(w, message, statusCode)
+		return
+	}
+	include, err := parseInclude(r)
+	if err != nil {
+		releaseWorkspace(ws)
+		message, statusCode := uploadErrorStatus(err)
+		respondJobError(w, message, statusCode)
+		return
+	}
+	opts := parseSolveOptions(r)
+	if err := parseHint(r, opts); err != nil {
+		releaseWorkspace(ws)
+		message, statusCode := uploadErrorStatus(err)
+		respondJobError(w, message, statusCode)
+		return
+	}
+
+	req := jobs.Request{
+		ID:          jobs.NewID(),
+		Filename:    header.Filename,
+		ImagePath:   imagePath,
+		Workspace:   ws,
+		Options:     opts,
+		CallbackURL: r.FormValue("callback_url"),
+		NoCache:     parseNoCache(r),
+		Escalation:  escalation,
+		Include:     observeImage(include, imagePath),
+	}
+	if parseAutoScale(r) {
+		req.Analysis = autoScale(imagePath, req.Options, scaleGiven(r))
+	}
+	submitJob(w, h.manager, req)
+}
+
+// submitJob queues req and responds with the accepted job or the reason it was
+// rejected. The job manager takes ownership of the request's workspace.
+func submitJob(w http.ResponseWriter, manager *jobs.Manager, req jobs.Request) {
+	job, err := manager.Submit(req)
+	switch {
+	case errors.Is(err, jobs.ErrInvalidCallback):
+		respondJobError(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Rejected job for %s: %v", req.Filename, err)
+		respondJobError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	log.Printf("Queued job %s: %s", job.ID, req.Filename)
+	writeJSON(w, http.StatusAccepted, newJobResponse(&job))
+}
+
+// get godoc
+//
+//	@Summary		Get the status of a solve job
+//	@Description	Returns the job status (queued, running, solved, failed) and the solve result once the job has finished, and the callback delivery log if a callback URL was given. Also accepts the ID returned by /solve, including for solves completed before a server restart.
+//	@Tags			Solving
+//	@Produce		json
+//	@Param			id	path		string		true	"Job ID"
+//	@Success		200	{object}	JobResponse	"Job status"
+//	@Failure		404	{object}	JobResponse	"Job not found"
+//	@Failure		405	{object}	JobResponse	"Method not allowed"
+//	@Router			/jobs/{id} [get]
+func (h *JobsHandler) get(w http.ResponseWriter, id string) {
+	job, ok := h.manager.Get(id)
+	if !ok {
+		respondJobError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newJobResponse(&job))
+}
+
+func newJobResponse(job *jobs.Job) *JobResponse {
+	response := &JobResponse{
+		ID:            job.ID,
+		Status:        string(job.Status),
+		QueuePosition: job.QueuePosition,
+		CreatedAt:     timePtr(job.CreatedAt),
+		StartedAt:     timePtr(job.StartedAt),
+		Callback:      job.Callback,
+		Error:         job.Error,
+	}
+	if job.Done() {
+		response.FinishedAt = timePtr(job.FinishedAt)
+		response.Result = newJobSolveResponse(job)
+	}
+	return response
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+func respondJobError(w http.ResponseWriter, message string, statusCode int) {
+	writeJSON(w, statusCode, &JobResponse{Error: message})
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/webhook"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+func TestJobsHandler_SubmitAndGet(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: true, RA: 83.421, Dec: -5.891}, nil
+		},
+	}
+	manager := newTestManager(t, mockClient)
+
+	handler := NewJobsHandler(manager, newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	body, contentType := createMultipartRequest(t, "image", testImage)
+	req := httptest.NewRequest(http.MethodPost, "/jobs", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", w.Code)
+	}
+
+	var submitted JobResponse
+	if err := json.NewDecoder(w.Body).Decode(&submitted); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if submitted.ID == "" {
+		t.Fatal("expected job ID")
+	}
+
+	var response JobResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		req = httptest.NewRequest(http.MethodGet, "/jobs/"+submitted.ID, nil)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		response = JobResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Result != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if response.Status != string(jobs.StatusSolved) {
+		t.Errorf("expected status solved, got %s", response.Status)
+	}
+	if response.Result == nil || response.Result.RA != 83.421 {
+		t.Errorf("expected result with RA 83.421, got %+v", response.Result)
+	}
+}
+
+func TestJobsHandler_NotFound(t *testing.T) {
+	manager := newTestManager(t, &MockAstroClient{})
+	handler := NewJobsHandler(manager, newTestWorkspaces(t), 50*1024*1024)
+
+	req := httptest.NewRequest(http.MethodGet, "/jobs/does-not-exist", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestJobsHandler_MethodNotAllowed(t *testing.T) {
+	manager := newTestManager(t, &MockAstroClient{})
+	handler := NewJobsHandler(manager, newTestWorkspaces(t), 50*1024*1024)
+
+	req := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
+
+func TestSolveHandler_Callback(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	manager := newTestManager(t, &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: true, RA: 83.8}, nil
+		},
+	})
+	sender := webhook.NewSender("secret", []string{"127.0.0.1"}, 3, time.Millisecond)
+	manager.SetDeliverer(NewCallbackDeliverer(sender))
+	handler := NewSolveHandler(manager, newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	body, contentType := createMultipartRequestWithParams(t, "image", testImage, map[string]string{
+		"callback_url": receiver.URL + "/solved",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var accepted JobResponse
+	if err := json.NewDecoder(w.Body).Decode(&accepted); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	r := <-received
+	payload := <-bodies
+	if !webhook.Verify([]byte("secret"), payload, r.Header.Get(webhook.SignatureHeader)) {
+		t.Error("expected a valid signature")
+	}
+	if id := r.Header.Get("X-Solve-ID"); id != accepted.ID {
+		t.Errorf("expected X-Solve-ID %s, got %s", accepted.ID, id)
+	}
+	var response SolveResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		t.Fatalf("failed to decode callback payload: %v", err)
+	}
+	if !response.Solved || response.RA != 83.8 || response.ID != accepted.ID {
+		t.Errorf("expected solved payload, got %+v", response)
+	}
+}
+
+func TestJobsHandler_CallbackNotAllowed(t *testing.T) {
+	manager := newTestManager(t, &MockAstroClient{})
+	manager.SetDeliverer(NewCallbackDeliverer(webhook.NewSender("secret", []string{"hooks.example.com"}, 3, time.Second)))
+	handler := NewJobsHandler(manager, newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	body, contentType := createMultipartRequestWithParams(t, "image", testImage, map[string]string{
+		"callback_url": "http://169.254.169.254/latest/meta-data",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/jobs", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
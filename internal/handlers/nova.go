@@ -0,0 +1,664 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // nova reports the SHA-1 of uploads; it is not used for security
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/fits"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/store"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// novaSessionTTL is how long a nova session stays valid after login
+const novaSessionTTL = 24 * time.Hour
+
+// novaTimeLayout is how nova formats submission times
+const novaTimeLayout = "2006-01-02 15:04:05.000000"
+
+// NovaHandler emulates the nova.astrometry.net JSON API, so clients written
+// for it (KStars/Ekos, NINA, astrometry.net's client.py) can use this server
+// as an offline replacement. Uploads become jobs; nova's numeric submission and
+// job IDs (one number for both) are mapped to them in a store, so they keep
+// working and keep increasing across restarts.
+type NovaHandler struct {
+	manager       *jobs.Manager
+	workspaces    *workspace.Manager
+	store         *store.Store
+	maxUploadSize int64
+	apiKey        string
+
+	mu          sync.Mutex
+	sessions    map[string]time.Time
+	submissions map[int]string
+	lastID      int
+	// uploads counts uploads since startup, to prune every novaPruneEvery
+	uploads int
+
+	lastIDMu sync.Mutex
+}
+
+// novaSniffLen is how much of an upload is read to tell its type, as much as
+// http.DetectContentType looks at
+const novaSniffLen = 512
+
+// novaPruneEvery is how many uploads pass between prunes of the submissions
+// whose jobs are gone. Pruning looks up every submission's job, so it is not
+// done on each upload.
+const novaPruneEvery = 100
+
+// novaLastIDKey is the store key of the last submission ID handed out; the
+// other records are keyed by submission ID
+const novaLastIDKey = "last"
+
+// novaRecord is the stored job of a submission, or the last submission ID
+type novaRecord struct {
+	JobID string `json:"job_id,omitempty"`
+	ID    int    `json:"id,omitempty"`
+}
+
+// NewNovaHandler creates a nova API handler, loading the submissions kept in
+// st; st may be nil to keep them in memory only. An empty apiKey accepts any
+// key at login.
+func NewNovaHandler(manager *jobs.Manager, workspaces *workspace.Manager, st *store.Store, maxUploadSize int64, apiKey string) (*NovaHandler, error) {
+	h := &NovaHandler{
+		manager:       manager,
+		workspaces:    workspaces,
+		store:         st,
+		maxUploadSize: maxUploadSize,
+		apiKey:        apiKey,
+		sessions:      make(map[string]time.Time),
+		submissions:   make(map[int]string),
+	}
+	if st == nil {
+		return h, nil
+	}
+	keys, err := st.Keys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var record novaRecord
+		if err := st.Get(key, &record); err != nil {
+			log.Printf("Skipping unreadable nova submission %s: %v", key, err)
+			continue
+		}
+		if key == novaLastIDKey {
+			h.lastID = max(h.lastID, record.ID)
+		} else if id, err := strconv.Atoi(key); err == nil && record.JobID != "" {
+			h.submissions[id] = record.JobID
+			h.lastID = max(h.lastID, id)
+		}
+	}
+	h.prune()
+	return h, nil
+}
+
+// NovaCalibration is nova's description of a solution
+type NovaCalibration struct {
+	RA           float64 `json:"ra"`
+	Dec          float64 `json:"dec"`
+	Radius       float64 `json:"radius"`
+	PixScale     float64 `json:"pixscale"`
+	Orientation  float64 `json:"orientation"`
+	Parity       float64 `json:"parity"`
+	WidthArcsec  float64 `json:"width_arcsec"`
+	HeightArcsec float64 `json:"height_arcsec"`
+}
+
+// NovaJobInfo is the response of GET /api/jobs/{id}/info
+type NovaJobInfo struct {
+	Status           string           `json:"status"`
+	OriginalFilename string           `json:"original_filename"`
+	ObjectsInField   []string         `json:"objects_in_field"`
+	MachineTags      []string         `json:"machine_tags"`
+	Tags             []string         `json:"tags"`
+	Calibration      *NovaCalibration `json:"calibration"`
+}
+
+// NovaSubmission is the response of GET /api/submissions/{id}
+type NovaSubmission struct {
+	User               int     `json:"user"`
+	ProcessingStarted  string  `json:"processing_started"`
+	ProcessingFinished string  `json:"processing_finished"`
+	UserImages         []int   `json:"user_images"`
+	Jobs               []int   `json:"jobs"`
+	JobCalibrations    [][]int `json:"job_calibrations"`
+}
+
+// novaUploadRequest holds the solve settings of a nova upload's request-json
+type novaUploadRequest struct {
+	Session          string      `json:"session"`
+	ScaleUnits       string      `json:"scale_units"`
+	ScaleType        string      `json:"scale_type"`
+	ScaleLower       *novaNumber `json:"scale_lower"`
+	ScaleUpper       *novaNumber `json:"scale_upper"`
+	ScaleEst         *novaNumber `json:"scale_est"`
+	ScaleErr         *novaNumber `json:"scale_err"`
+	CenterRA         *novaNumber `json:"center_ra"`
+	CenterDec        *novaNumber `json:"center_dec"`
+	Radius           *novaNumber `json:"radius"`
+	DownsampleFactor *novaNumber `json:"downsample_factor"`
+}
+
+// novaNumber is a number that clients send either as a JSON number or as a string
+type novaNumber float64
+
+func (n *novaNumber) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*n = novaNumber(f)
+	return nil
+}
+
+// ServeHTTP routes the nova API under /api/ and WCS file downloads under /wcs_file/
+func (h *NovaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if id, ok := strings.CutPrefix(r.URL.Path, "/wcs_file/"); ok {
+		if r.Method != http.MethodGet {
+			respondNovaError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.wcsFile(w, id)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "login" && r.Method == http.MethodPost:
+		h.login(w, r)
+	case len(parts) == 1 && parts[0] == "upload" && r.Method == http.MethodPost:
+		h.upload(w, r)
+	case len(parts) == 2 && parts[0] == "submissions" && r.Method == http.MethodGet:
+		h.submission(w, parts[1])
+	case len(parts) == 2 && parts[0] == "jobs" && r.Method == http.MethodGet:
+		h.jobStatus(w, parts[1])
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "info" && r.Method == http.MethodGet:
+		h.jobInfo(w, parts[1])
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "calibration" && r.Method == http.MethodGet:
+		h.calibration(w, parts[1])
+	case len(parts) == 1 && (parts[0] == "login" || parts[0] == "upload"),
+		len(parts) == 2 && (parts[0] == "submissions" || parts[0] == "jobs"),
+		len(parts) == 3 && parts[0] == "jobs" && (parts[2] == "info" || parts[2] == "calibration"):
+		respondNovaError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		respondNovaError(w, "Unknown API call", http.StatusNotFound)
+	}
+}
+
+// login godoc
+//
+//	@Summary		Log in (nova.astrometry.net compatible)
+//	@Description	Exchanges an API key for a session key to send with uploads. The request-json form field holds {"apikey": "..."}. Any key is accepted unless NOVA_API_KEY is set. Errors are reported with status "error" and an errormessage, as nova does.
+//	@Tags			Nova
+//	@Accept			x-www-form-urlencoded
+//	@Produce		json
+//	@Param			request-json	formData	string	true	"{\"apikey\": \"...\"}"
+//	@Success		200				{object}	map[string]string	"status, message and session"
+//	@Router			/api/login [post]
+func (h *NovaHandler) login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		APIKey string `json:"apikey"`
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("request-json")), &req); err != nil {
+		respondNovaError(w, "request-json is missing or invalid", http.StatusOK)
+		return
+	}
+	if h.apiKey != "" && req.APIKey != h.apiKey {
+		respondNovaError(w, "bad apikey", http.StatusOK)
+		return
+	}
+
+	session := make([]byte, 16)
+	if _, err := rand.Read(session); err != nil {
+		respondNovaError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	key := hex.EncodeToString(session)
+
+	now := time.Now()
+	h.mu.Lock()
+	for s, created := range h.sessions {
+		if now.Sub(created) > novaSessionTTL {
+			delete(h.sessions, s)
+		}
+	}
+	h.sessions[key] = now
+	h.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "success",
+		"message": "authenticated user: offline",
+		"session": key,
+	})
+}
+
+// upload godoc
+//
+//	@Summary		Upload an image (nova.astrometry.net compatible)
+//	@Description	Queues a solve of the file part. The request-json part holds the session and nova's solve settings: scale_units, scale_type (ul with scale_lower/scale_upper, or ev with scale_est/scale_err in percent), center_ra, center_dec, radius and downsample_factor. Other settings are ignored. Poll /api/submissions/{subid} for the job.
+//	@Tags			Nova
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			request-json	formData	string	true	"Session and solve settings"
+//	@Param			file			formData	file	true	"Image file (JPG, PNG or FITS)"
+//	@Success		200				{object}	map[string]any	"status, subid and hash"
+//	@Failure		413				{object}	map[string]string	"File too large"
+//	@Failure		503				{object}	map[string]string	"Job queue is full"
+//	@Router			/api/upload [post]
+func (h *NovaHandler) upload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+	if err := r.ParseMultipartForm(h.maxUploadSize); err != nil {
+		respondNovaError(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	var req novaUploadRequest
+	if err := json.Unmarshal([]byte(r.FormValue("request-json")), &req); err != nil {
+		respondNovaError(w, "request-json is missing or invalid", http.StatusOK)
+		return
+	}
+	if !h.validSession(req.Session) {
+		respondNovaError(w, "no session with key \""+req.Session+"\"", http.StatusOK)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondNovaError(w, "Missing or invalid 'file' field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close() //nolint:errcheck // Error from Close on read is not critical
+	// The start of the file is enough to tell its type; the rest is streamed
+	// to the workspace
+	src := bufio.NewReaderSize(file, novaSniffLen)
+	head, err := src.Peek(novaSniffLen)
+	if err != nil && err != io.EOF {
+		respondNovaError(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	ext := novaImageExt(header.Filename, head)
+	if ext == "" {
+		respondNovaError(w, solveFormats.invalid, http.StatusBadRequest)
+		return
+	}
+
+	// The workspace outlives the request and is released when the job finishes
+	ws, err := h.workspaces.Create("nova")
+	if err != nil {
+		log.Printf("Failed to create workspace: %v", err)
+		respondNovaError(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+	imagePath := ws.Path("image" + ext)
+	hash := sha1.New() //nolint:gosec // See import
+	if err := writeFile(imagePath, io.TeeReader(src, hash)); err != nil {
+		releaseWorkspace(ws)
+		respondNovaError(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+
+	job, err := h.manager.Submit(jobs.Request{
+		ID:        jobs.NewID(),
+		Filename:  header.Filename,
+		ImagePath: imagePath,
+		Workspace: ws,
+		Options:   req.options(),
+	})
+	if err != nil {
+		log.Printf("Rejected nova upload %s: %v", header.Filename, err)
+		respondNovaError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	h.mu.Lock()
+	h.lastID++
+	id := h.lastID
+	h.submissions[id] = job.ID
+	h.uploads++
+	pruneDue := h.uploads%novaPruneEvery == 0
+	h.mu.Unlock()
+	h.persist(id, job.ID)
+	if pruneDue {
+		h.prune()
+	}
+
+	log.Printf("Queued nova submission %d as job %s: %s", id, job.ID, header.Filename)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": "success",
+		"subid":  id,
+		"hash":   hex.EncodeToString(hash.Sum(nil)),
+	})
+}
+
+// submission godoc
+//
+//	@Summary		Get a submission (nova.astrometry.net compatible)
+//	@Description	Returns the submission's job, which has the same ID, and its calibration once solved.
+//	@Tags			Nova
+//	@Produce		json
+//	@Param			id	path		int				true	"Submission ID"
+//	@Success		200	{object}	NovaSubmission	"Submission"
+//	@Failure		404	{object}	map[string]string	"Submission not found"
+//	@Router			/api/submissions/{id} [get]
+func (h *NovaHandler) submission(w http.ResponseWriter, rawID string) {
+	id, job, ok := h.job(rawID)
+	if !ok {
+		respondNovaError(w, "Submission not found", http.StatusNotFound)
+		return
+	}
+
+	response := &NovaSubmission{
+		ProcessingStarted:  novaTime(job.StartedAt),
+		ProcessingFinished: "None",
+		UserImages:         []int{id},
+		Jobs:               []int{id},
+		JobCalibrations:    [][]int{},
+	}
+	if job.Done() {
+		response.ProcessingFinished = novaTime(job.FinishedAt)
+	}
+	if job.Status == jobs.StatusSolved {
+		response.JobCalibrations = [][]int{{id, id}}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// jobStatus godoc
+//
+//	@Summary		Get a job's status (nova.astrometry.net compatible)
+//	@Description	Returns status solving while the job is queued or running, then success or failure.
+//	@Tags			Nova
+//	@Produce		json
+//	@Param			id	path		int					true	"Job ID"
+//	@Success		200	{object}	map[string]string	"status"
+//	@Failure		404	{object}	map[string]string	"Job not found"
+//	@Router			/api/jobs/{id} [get]
+func (h *NovaHandler) jobStatus(w http.ResponseWriter, rawID string) {
+	_, job, ok := h.job(rawID)
+	if !ok {
+		respondNovaError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": novaStatus(&job)})
+}
+
+// jobInfo godoc
+//
+//	@Summary		Get a job's results (nova.astrometry.net compatible)
+//	@Description	Returns the job's status, original file name and calibration (null unless solved).
+//	@Tags			Nova
+//	@Produce		json
+//	@Param			id	path		int				true	"Job ID"
+//	@Success		200	{object}	NovaJobInfo		"Job results"
+//	@Failure		404	{object}	map[string]string	"Job not found"
+//	@Router			/api/jobs/{id}/info [get]
+func (h *NovaHandler) jobInfo(w http.ResponseWriter, rawID string) {
+	_, job, ok := h.job(rawID)
+	if !ok {
+		respondNovaError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, &NovaJobInfo{
+		Status:           novaStatus(&job),
+		OriginalFilename: job.Filename,
+		ObjectsInField:   []string{},
+		MachineTags:      []string{},
+		Tags:             []string{},
+		Calibration:      newNovaCalibration(&job),
+	})
+}
+
+// calibration godoc
+//
+//	@Summary		Get a job's calibration (nova.astrometry.net compatible)
+//	@Description	Returns the solution: center ra and dec, radius of the field, pixscale in arcsec per pixel, orientation in degrees E of N and parity.
+//	@Tags			Nova
+//	@Produce		json
+//	@Param			id	path		int				true	"Job ID"
+//	@Success		200	{object}	NovaCalibration	"Calibration"
+//	@Failure		404	{object}	map[string]string	"Job not found or not solved"
+//	@Router			/api/jobs/{id}/calibration [get]
+func (h *NovaHandler) calibration(w http.ResponseWriter, rawID string) {
+	_, job, ok := h.job(rawID)
+	if !ok {
+		respondNovaError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	calibration := newNovaCalibration(&job)
+	if calibration == nil {
+		respondNovaError(w, "Job has no calibration", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, calibration)
+}
+
+// wcsFile godoc
+//
+//	@Summary		Download a job's WCS file (nova.astrometry.net compatible)
+//	@Description	Returns the solution's WCS as a header-only FITS file.
+//	@Tags			Nova
+//	@Produce		application/fits
+//	@Param			id	path		int		true	"Job ID"
+//	@Success		200	{file}		file	"WCS file"
+//	@Failure		404	{object}	map[string]string	"Job not found or not solved"
+//	@Router			/wcs_file/{id} [get]
+func (h *NovaHandler) wcsFile(w http.ResponseWriter, rawID string) {
+	_, job, ok := h.job(rawID)
+	if !ok {
+		respondNovaError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != jobs.StatusSolved || job.Result == nil || len(job.Result.WCSHeader) == 0 {
+		respondNovaError(w, "Job has no WCS file", http.StatusNotFound)
+		return
+	}
+
+	data := fits.WCSHeader(job.Result.WCSHeader).Encode()
+	w.Header().Set("Content-Type", "application/fits")
+	w.Header().Set("Content-Disposition", `attachment; filename="wcs.fits"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data) //nolint:errcheck // Client went away
+}
+
+func (h *NovaHandler) validSession(session string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	created, ok := h.sessions[session]
+	return ok && time.Since(created) <= novaSessionTTL
+}
+
+// job returns the nova ID parsed from rawID and its job
+func (h *NovaHandler) job(rawID string) (int, jobs.Job, bool) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		return 0, jobs.Job{}, false
+	}
+	h.mu.Lock()
+	jobID, ok := h.submissions[id]
+	h.mu.Unlock()
+	if !ok {
+		return 0, jobs.Job{}, false
+	}
+	job, ok := h.manager.Get(jobID)
+	return id, job, ok
+}
+
+// persist stores the job of a new submission and the last ID handed out.
+// The job keeps running if they cannot be stored; the submission is then
+// only known until the server restarts.
+func (h *NovaHandler) persist(id int, jobID string) {
+	if h.store == nil {
+		return
+	}
+	if err := h.store.Put(strconv.Itoa(id), novaRecord{JobID: jobID}); err != nil {
+		log.Printf("Failed to persist nova submission %d: %v", id, err)
+	}
+	// Writes of the last ID are serialised so a lower one never lands last
+	h.lastIDMu.Lock()
+	defer h.lastIDMu.Unlock()
+	h.mu.Lock()
+	last := h.lastID
+	h.mu.Unlock()
+	if err := h.store.Put(novaLastIDKey, novaRecord{ID: last}); err != nil {
+		log.Printf("Failed to persist last nova submission ID: %v", err)
+	}
+}
+
+// prune forgets submissions whose jobs the job manager no longer keeps
+func (h *NovaHandler) prune() {
+	h.mu.Lock()
+	submissions := make(map[int]string, len(h.submissions))
+	for id, jobID := range h.submissions {
+		submissions[id] = jobID
+	}
+	h.mu.Unlock()
+
+	// Looking jobs up may read the job store, so it is done without the lock
+	var stale []int
+	for id, jobID := range submissions {
+		if _, ok := h.manager.Get(jobID); !ok {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	h.mu.Lock()
+	for _, id := range stale {
+		delete(h.submissions, id)
+	}
+	h.mu.Unlock()
+	if h.store == nil {
+		return
+	}
+	for _, id := range stale {
+		if err := h.store.Delete(strconv.Itoa(id)); err != nil {
+			log.Printf("Failed to delete nova submission %d: %v", id, err)
+		}
+	}
+}
+
+// options converts nova's solve settings to solve options
+func (req *novaUploadRequest) options() *client.SolveOptions {
+	opts := client.DefaultSolveOptions()
+	if req.ScaleUnits != "" {
+		opts.ScaleUnits = req.ScaleUnits
+	}
+	switch {
+	case req.ScaleType == "ev" && req.ScaleEst != nil:
+		est, errPercent := float64(*req.ScaleEst), 0.0
+		if req.ScaleErr != nil {
+			errPercent = float64(*req.ScaleErr)
+		}
+		opts.ScaleLow = est * (1 - errPercent/100)
+		opts.ScaleHigh = est * (1 + errPercent/100)
+	case req.ScaleLower != nil && req.ScaleUpper != nil:
+		opts.ScaleLow = float64(*req.ScaleLower)
+		opts.ScaleHigh = float64(*req.ScaleUpper)
+	}
+	if req.CenterRA != nil && req.CenterDec != nil {
+		opts.RA = float64(*req.CenterRA)
+		opts.Dec = float64(*req.CenterDec)
+		opts.Radius = defaultHintRadius
+		if req.Radius != nil && *req.Radius > 0 {
+			opts.Radius = float64(*req.Radius)
+		}
+	}
+	if req.DownsampleFactor != nil && *req.DownsampleFactor >= 1 {
+		opts.DownsampleFactor = int(*req.DownsampleFactor)
+	}
+	return opts
+}
+
+// novaImageExt returns the extension to save an upload with, from its file
+// name or, as nova clients do not always send one, its content
+func novaImageExt(filename string, data []byte) string {
+	if ext := strings.ToLower(filepath.Ext(filename)); solveFormats.exts[ext] {
+		return ext
+	}
+	if bytes.HasPrefix(data, []byte("SIMPLE  =")) {
+		return ".fits"
+	}
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	}
+	return ""
+}
+
+func novaStatus(job *jobs.Job) string {
+	switch job.Status {
+	case jobs.StatusSolved:
+		return "success"
+	case jobs.StatusFailed, jobs.StatusCancelled:
+		return "failure"
+	}
+	return "solving"
+}
+
+func novaTime(t time.Time) string {
+	if t.IsZero() {
+		return "None"
+	}
+	return t.UTC().Format(novaTimeLayout)
+}
+
+// newNovaCalibration returns the calibration of a solved job, or nil
+func newNovaCalibration(job *jobs.Job) *NovaCalibration {
+	if job.Status != jobs.StatusSolved || job.Result == nil {
+		return nil
+	}
+	result := job.Result
+	return &NovaCalibration{
+		RA:           result.RA,
+		Dec:          result.Dec,
+		Radius:       math.Hypot(result.FieldWidth, result.FieldHeight) / 2,
+		PixScale:     result.PixelScale,
+		Orientation:  result.Rotation,
+		Parity:       novaParity(result.WCSHeader),
+		WidthArcsec:  result.FieldWidth * 3600,
+		HeightArcsec: result.FieldHeight * 3600,
+	}
+}
+
+// novaParity returns nova's parity of a WCS: 1 if the determinant of its CD
+// matrix is not negative, otherwise -1
+func novaParity(wcs map[string]string) float64 {
+	cd := make(map[string]float64)
+	for _, key := range []string{"CD1_1", "CD1_2", "CD2_1", "CD2_2"} {
+		cd[key], _ = strconv.ParseFloat(strings.TrimSpace(wcs[key]), 64)
+	}
+	if cd["CD1_1"]*cd["CD2_2"]-cd["CD1_2"]*cd["CD2_1"] < 0 {
+		return -1
+	}
+	return 1
+}
+
+func respondNovaError(w http.ResponseWriter, message string, statusCode int) {
+	writeJSON(w, statusCode, map[string]string{
+		"status":       "error",
+		"errormessage": message,
+	})
+}
@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec // nova reports the SHA-1 of uploads
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/store"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// novaRecording is a recorded session of a nova client against nova.astrometry.net.
+// In expected responses, "*" matches anything and "{{name}}" captures a value
+// the first time it appears and must match it afterwards; requests use the
+// captured values.
+type novaRecording struct {
+	Client string `json:"client"`
+	// ScaleUnits and Options are the solve options the upload must result in
+	ScaleUnits string             `json:"scale_units"`
+	Options    map[string]float64 `json:"options"`
+	Exchanges  []struct {
+		Request struct {
+			Method    string            `json:"method"`
+			Path      string            `json:"path"`
+			Form      map[string]string `json:"form"`
+			Multipart map[string]string `json:"multipart"`
+			File      string            `json:"file"`
+		} `json:"request"`
+		// Poll repeats the request until the response matches, as clients do while solving
+		Poll     bool                 `json:"poll"`
+		Response novaRecordedResponse `json:"response"`
+	} `json:"exchanges"`
+}
+
+// novaRecordedResponse is the response recorded for a request
+type novaRecordedResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	JSON    any               `json:"json"`
+	// FITS holds cards expected in a FITS file response
+	FITS map[string]string `json:"fits"`
+}
+
+// novaTestResult is the solution returned by the mock solver for every recording
+var novaTestResult = &client.Result{
+	Solved:      true,
+	RA:          83.822,
+	Dec:         -5.391,
+	PixelScale:  1.2,
+	Rotation:    92.5,
+	FieldWidth:  1.5,
+	FieldHeight: 1.0,
+	WCSHeader: map[string]string{
+		"CTYPE1": "RA---TAN",
+		"CTYPE2": "DEC--TAN",
+		"CRVAL1": "83.822",
+		"CRVAL2": "-5.391",
+		"CRPIX1": "2250.5",
+		"CRPIX2": "1500.5",
+		"CD1_1":  "-0.000333333",
+		"CD1_2":  "0",
+		"CD2_1":  "0",
+		"CD2_2":  "0.000333333",
+	},
+}
+
+func TestNovaHandler_RecordedSessions(t *testing.T) {
+	recordings, err := filepath.Glob("testdata/nova/*.json")
+	if err != nil || len(recordings) == 0 {
+		t.Fatalf("no recorded nova sessions found: %v", err)
+	}
+
+	for _, path := range recordings {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var recording novaRecording
+			if err := json.Unmarshal(data, &recording); err != nil {
+				t.Fatalf("invalid recording: %v", err)
+			}
+			replayNova(t, &recording)
+		})
+	}
+}
+
+func replayNova(t *testing.T, recording *novaRecording) {
+	var mu sync.Mutex
+	var solved []*client.SolveOptions
+	mock := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			mu.Lock()
+			solved = append(solved, opts)
+			mu.Unlock()
+			result := *novaTestResult
+			return &result, nil
+		},
+	}
+	handler := newTestNovaHandler(t, newTestManager(t, mock), nil, 10*1024*1024, "")
+	mux := http.NewServeMux()
+	mux.Handle("/api/", handler)
+	mux.Handle("/wcs_file/", handler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	vars := make(map[string]string)
+	substitute := func(s string) string {
+		for name, value := range vars {
+			s = strings.ReplaceAll(s, "{{"+name+"}}", value)
+		}
+		return s
+	}
+
+	for i, exchange := range recording.Exchanges {
+		req := exchange.Request
+		step := fmt.Sprintf("exchange %d (%s %s)", i+1, req.Method, req.Path)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var body io.Reader
+			var contentType string
+			switch {
+			case req.Form != nil:
+				form := url.Values{}
+				for k, v := range req.Form {
+					form.Set(k, substitute(v))
+				}
+				body, contentType = strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+			case req.Multipart != nil:
+				body, contentType = novaMultipart(t, req.Multipart, req.File, substitute)
+			}
+
+			httpReq, err := http.NewRequest(req.Method, server.URL+substitute(req.Path), body)
+			if err != nil {
+				t.Fatalf("%s: %v", step, err)
+			}
+			if contentType != "" {
+				httpReq.Header.Set("Content-Type", contentType)
+			}
+			resp, err := http.DefaultClient.Do(httpReq)
+			if err != nil {
+				t.Fatalf("%s: %v", step, err)
+			}
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close() //nolint:errcheck // Test cleanup
+
+			// Captures are only kept from a matching response
+			captured := make(map[string]string)
+			for k, v := range vars {
+				captured[k] = v
+			}
+			mismatch := novaMismatch(resp, respBody, &exchange.Response, captured)
+			if mismatch == "" {
+				vars = captured
+				break
+			}
+			if !exchange.Poll || time.Now().After(deadline) {
+				t.Fatalf("%s: %s\nresponse: %s", step, mismatch, respBody)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(solved) != 1 {
+		t.Fatalf("expected 1 solve, got %d", len(solved))
+	}
+	opts := solved[0]
+	got := map[string]float64{
+		"scale_low":         opts.ScaleLow,
+		"scale_high":        opts.ScaleHigh,
+		"downsample_factor": float64(opts.DownsampleFactor),
+		"ra":                opts.RA,
+		"dec":               opts.Dec,
+		"radius":            opts.Radius,
+	}
+	if opts.ScaleUnits != recording.ScaleUnits {
+		t.Errorf("expected scale_units %s, got %s", recording.ScaleUnits, opts.ScaleUnits)
+	}
+	for name, want := range recording.Options {
+		if !closeTo(got[name], want) {
+			t.Errorf("expected %s %v, got %v", name, want, got[name])
+		}
+	}
+}
+
+func newTestNovaHandler(t *testing.T, manager *jobs.Manager, st *store.Store, maxUploadSize int64, apiKey string) *NovaHandler {
+	handler, err := NewNovaHandler(manager, newTestWorkspaces(t), st, maxUploadSize, apiKey)
+	if err != nil {
+		t.Fatalf("failed to create nova handler: %v", err)
+	}
+	return handler
+}
+
+func TestNovaHandler_PersistsSubmissions(t *testing.T) {
+	jobStore, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	novaStore, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The managers are not started, so the jobs stay queued
+	restart := func() *NovaHandler {
+		manager := jobs.NewManager(&MockAstroClient{}, jobStore, 1, 4, 0)
+		handler := newTestNovaHandler(t, manager, novaStore, 1024*1024, "")
+		handler.sessions["session"] = time.Now()
+		return handler
+	}
+	upload := func(handler *NovaHandler) int {
+		body, contentType := novaMultipart(t, map[string]string{"request-json": `{"session": "session"}`}, "m42.jpg", func(s string) string { return s })
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var response struct {
+			Status string `json:"status"`
+			SubID  int    `json:"subid"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil || response.Status != "success" {
+			t.Fatalf("expected a successful upload, got %s", w.Body)
+		}
+		return response.SubID
+	}
+	found := func(handler *NovaHandler, id int) bool {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/submissions/%d", id), nil))
+		return w.Code == http.StatusOK
+	}
+
+	if id := upload(restart()); id != 1 {
+		t.Fatalf("expected submission 1, got %d", id)
+	}
+	handler := restart()
+	if !found(handler, 1) {
+		t.Error("expected submission 1 to survive a restart")
+	}
+	if id := upload(handler); id != 2 {
+		t.Errorf("expected submission 2 after a restart, got %d", id)
+	}
+
+	// Once their jobs are gone, the submissions are forgotten but their IDs
+	// are not handed out again
+	keys, err := jobStore.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		jobStore.Delete(key) //nolint:errcheck // Checked below through the handler
+	}
+	handler = restart()
+	if found(handler, 1) || found(handler, 2) {
+		t.Error("expected submissions without jobs to be forgotten")
+	}
+	if err := novaStore.Get("1", &novaRecord{}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected the forgotten submission to be deleted from the store, got %v", err)
+	}
+	if id := upload(handler); id != 3 {
+		t.Errorf("expected submission 3, got %d", id)
+	}
+}
+
+func TestNovaHandler_Upload(t *testing.T) {
+	// The manager is not started, so the job stays queued with its image
+	manager := jobs.NewManager(&MockAstroClient{}, nil, 1, 4, 0)
+	handler := newTestNovaHandler(t, manager, nil, 1024*1024, "")
+	handler.sessions["session"] = time.Now()
+
+	data, err := os.ReadFile(createTestJPEG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("request-json", `{"session": "session"}`) //nolint:errcheck // Writes to a buffer
+	part, _ := writer.CreateFormFile("file", "upload")
+	part.Write(data) //nolint:errcheck // Writes to a buffer
+	writer.Close()   //nolint:errcheck // Writes to a buffer
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var response struct {
+		SubID int    `json:"subid"`
+		Hash  string `json:"hash"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if sum := sha1.Sum(data); response.Hash != hex.EncodeToString(sum[:]) { //nolint:gosec // As in nova.go
+		t.Errorf("expected the SHA-1 of the upload, got %q", response.Hash)
+	}
+
+	// An upload without an extension is saved with the one its content shows
+	job, ok := manager.Get(handler.submissions[response.SubID])
+	if !ok {
+		t.Fatal("expected the upload to be queued as a job")
+	}
+	saved, err := os.ReadFile(job.ImagePath)
+	if filepath.Ext(job.ImagePath) != ".jpg" || err != nil || !bytes.Equal(saved, data) {
+		t.Errorf("expected the upload to be saved whole as a .jpg, got %s (%d bytes, %v)", job.ImagePath, len(saved), err)
+	}
+}
+
+func novaMultipart(t *testing.T, fields map[string]string, file string, substitute func(string) string) (io.Reader, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range fields {
+		if err := writer.WriteField(k, substitute(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if file != "" {
+		data, err := os.ReadFile(createTestJPEG(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, err := writer.CreateFormFile("file", file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data) //nolint:errcheck // Writes to a buffer
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body, writer.FormDataContentType()
+}
+
+// novaMismatch describes how the response differs from the recorded one, or returns ""
+func novaMismatch(resp *http.Response, body []byte, want *novaRecordedResponse, vars map[string]string) string {
+	if resp.StatusCode != want.Status {
+		return fmt.Sprintf("expected status %d, got %d", want.Status, resp.StatusCode)
+	}
+	for k, v := range want.Headers {
+		if got := resp.Header.Get(k); got != v {
+			return fmt.Sprintf("expected %s %q, got %q", k, v, got)
+		}
+	}
+	if want.JSON != nil {
+		var got any
+		if err := json.Unmarshal(body, &got); err != nil {
+			return fmt.Sprintf("invalid JSON: %v", err)
+		}
+		if mismatch := matchNova("$", want.JSON, got, vars); mismatch != "" {
+			return mismatch
+		}
+	}
+	if want.FITS != nil {
+		if len(body)%2880 != 0 {
+			return fmt.Sprintf("expected whole FITS blocks, got %d bytes", len(body))
+		}
+		cards := make(map[string]string)
+		for i := 0; i+80 <= len(body); i += 80 {
+			card := string(body[i : i+80])
+			if key, value, ok := strings.Cut(card, "="); ok && len(key) == 8 {
+				value, _, _ = strings.Cut(value, " /")
+				cards[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+		for k, v := range want.FITS {
+			if cards[k] != v {
+				return fmt.Sprintf("expected FITS card %s = %s, got %q", k, v, cards[k])
+			}
+		}
+	}
+	return ""
+}
+
+func matchNova(path string, want, got any, vars map[string]string) string {
+	switch want := want.(type) {
+	case string:
+		if want == "*" {
+			return ""
+		}
+		if name, ok := strings.CutPrefix(want, "{{"); ok {
+			name = strings.TrimSuffix(name, "}}")
+			value := fmt.Sprint(got)
+			if bound, ok := vars[name]; ok && bound != value {
+				return fmt.Sprintf("%s: expected %s = %s, got %s", path, name, bound, value)
+			}
+			vars[name] = value
+			return ""
+		}
+		if got != want {
+			return fmt.Sprintf("%s: expected %q, got %v", path, want, got)
+		}
+	case float64:
+		if f, ok := got.(float64); !ok || !closeTo(f, want) {
+			return fmt.Sprintf("%s: expected %v, got %v", path, want, got)
+		}
+	case map[string]any:
+		obj, ok := got.(map[string]any)
+		if !ok {
+			return fmt.Sprintf("%s: expected object, got %v", path, got)
+		}
+		for k, v := range want {
+			if _, ok := obj[k]; !ok {
+				return fmt.Sprintf("%s: missing %s", path, k)
+			}
+			if mismatch := matchNova(path+"."+k, v, obj[k], vars); mismatch != "" {
+				return mismatch
+			}
+		}
+	case []any:
+		arr, ok := got.([]any)
+		if !ok || len(arr) != len(want) {
+			return fmt.Sprintf("%s: expected %d elements, got %v", path, len(want), got)
+		}
+		for i := range want {
+			if mismatch := matchNova(fmt.Sprintf("%s[%d]", path, i), want[i], arr[i], vars); mismatch != "" {
+				return mismatch
+			}
+		}
+	default:
+		if !reflect.DeepEqual(want, got) {
+			return fmt.Sprintf("%s: expected %v, got %v", path, want, got)
+		}
+	}
+	return ""
+}
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-3*math.Max(1, math.Abs(want))
+}
+
+func TestNovaHandler_APIKey(t *testing.T) {
+	handler := newTestNovaHandler(t, newTestManager(t, &MockAstroClient{}), nil, 1024, "secret")
+
+	tests := []struct {
+		body   string
+		status string
+	}{
+		{`{"apikey": "secret"}`, "success"},
+		{`{"apikey": "wrong"}`, "error"},
+		{`not json`, "error"},
+	}
+	for _, tt := range tests {
+		form := url.Values{"request-json": {tt.body}}
+		req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var response map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response["status"] != tt.status {
+			t.Errorf("login with %s: expected status %s, got %v", tt.body, tt.status, response)
+		}
+	}
+}
+
+func TestNovaHandler_NotFound(t *testing.T) {
+	handler := newTestNovaHandler(t, newTestManager(t, &MockAstroClient{}), nil, 1024, "")
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/api/submissions/1", http.StatusNotFound},
+		{http.MethodGet, "/api/jobs/abc/info", http.StatusNotFound},
+		{http.MethodGet, "/api/jobs/7/calibration", http.StatusNotFound},
+		{http.MethodGet, "/wcs_file/7", http.StatusNotFound},
+		{http.MethodGet, "/api/url_upload", http.StatusNotFound},
+		{http.MethodGet, "/api/login", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/jobs/1", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.code, w.Code)
+		}
+	}
+}
+
+func TestNovaImageExt(t *testing.T) {
+	fitsData := []byte("SIMPLE  =                    T")
+	tests := []struct {
+		filename string
+		data     []byte
+		ext      string
+	}{
+		{"m42.FIT", nil, ".fit"},
+		{"upload", fitsData, ".fits"},
+		{"upload.tmp", []byte("\x89PNG\r\n\x1a\n"), ".png"},
+		{"notes.txt", []byte("hello"), ""},
+	}
+	for _, tt := range tests {
+		if ext := novaImageExt(tt.filename, tt.data); ext != tt.ext {
+			t.Errorf("novaImageExt(%q): expected %q, got %q", tt.filename, tt.ext, ext)
+		}
+	}
+}
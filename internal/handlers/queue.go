@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+)
+
+// QueueHandler reports the solver queue load
+type QueueHandler struct {
+	limiter *queue.Limiter
+}
+
+// NewQueueHandler creates a new queue status handler
+func NewQueueHandler(limiter *queue.Limiter) *QueueHandler {
+	return &QueueHandler{
+		limiter: limiter,
+	}
+}
+
+// QueueResponse represents the solver queue status
+type QueueResponse struct {
+	Running       int `json:"running"`
+	Queued        int `json:"queued"`
+	MaxConcurrent int `json:"max_concurrent"`
+	MaxQueue      int `json:"max_queue"`
+	RetryAfter    int `json:"retry_after_seconds"`
+}
+
+// ServeHTTP godoc
+//
+//	@Summary		Solver queue status
+//	@Description	Returns how many solves are running and waiting, the configured limits, and an estimate of how long a new solve would wait.
+//	@Tags			Solving
+//	@Produce		json
+//	@Success		200	{object}	QueueResponse	"Queue status"
+//	@Failure		405	{string}	string			"Method not allowed"
+//	@Router			/queue [get]
+func (h *QueueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := h.limiter.Stats()
+	response := &QueueResponse{
+		Running:       stats.Running,
+		Queued:        stats.Queued,
+		MaxConcurrent: stats.MaxConcurrent,
+		MaxQueue:      stats.MaxQueue,
+	}
+	if stats.Running >= stats.MaxConcurrent {
+		response.RetryAfter = int(h.limiter.RetryAfter().Seconds())
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -3,18 +3,29 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/escalate"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solvelog"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solver"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
 	client "github.com/DiarmuidKelly/astrometry-go-client"
 )
 
+// solveIDHeader carries the ID of a solve, which can be used to cancel it
+const solveIDHeader = "X-Solve-ID"
+
+// cacheHeader reports whether a solve was served from the result cache (HIT) or not (MISS)
+const cacheHeader = "X-Cache"
+
 // AstrometryClient interface for testing
 type AstrometryClient interface {
 	Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error)
@@ -22,14 +33,17 @@ type AstrometryClient interface {
 
 // SolveHandler handles plate-solving requests
 type SolveHandler struct {
-	client        AstrometryClient
+	jobs          *jobs.Manager
+	workspaces    *workspace.Manager
 	maxUploadSize int64
 }
 
-// NewSolveHandler creates a new solve handler
-func NewSolveHandler(c AstrometryClient, maxUploadSize int64) *SolveHandler {
+// NewSolveHandler creates a new solve handler. Solves run in the request's
+// goroutine but are recorded by the job manager so they can be fetched by ID.
+func NewSolveHandler(manager *jobs.Manager, workspaces *workspace.Manager, maxUploadSize int64) *SolveHandler {
 	return &SolveHandler{
-		client:        c,
+		jobs:          manager,
+		workspaces:    workspaces,
 		maxUploadSize: maxUploadSize,
 	}
 }
@@ -49,26 +63,37 @@ type SolveRequest struct {
 
 // SolveResponse represents the solve response
 type SolveResponse struct {
-	Solved      bool              `json:"solved"`
-	RA          float64           `json:"ra,omitempty"`
-	Dec         float64           `json:"dec,omitempty"`
-	PixelScale  float64           `json:"pixel_scale,omitempty"`
-	Rotation    float64           `json:"rotation,omitempty"`
-	FieldWidth  float64           `json:"field_width,omitempty"`
-	FieldHeight float64           `json:"field_height,omitempty"`
-	WCSHeader   map[string]string `json:"wcs_header,omitempty"`
-	SolveTime   float64           `json:"solve_time,omitempty"`
-	RawOutput   string            `json:"raw_output,omitempty"`
-	Error       string            `json:"error,omitempty"`
+	ID          string              `json:"id,omitempty"`
+	Solved      bool                `json:"solved"`
+	Cancelled   bool                `json:"cancelled,omitempty"`
+	Cached      bool                `json:"cached,omitempty"`
+	Escalation  *EscalationResponse `json:"escalation,omitempty"`
+	Analysis    *AnalyseResponse    `json:"analysis,omitempty"`
+	RA          float64             `json:"ra,omitempty"`
+	Dec         float64             `json:"dec,omitempty"`
+	PixelScale  float64             `json:"pixel_scale,omitempty"`
+	Rotation    float64             `json:"rotation,omitempty"`
+	FieldWidth  float64             `json:"field_width,omitempty"`
+	FieldHeight float64             `json:"field_height,omitempty"`
+	WCSHeader   map[string]string   `json:"wcs_header,omitempty"`
+	Objects     []FieldObject       `json:"objects,omitzero"`
+	Stars       []FieldStar         `json:"stars,omitzero"`
+	Coordinates *FieldCoordinates   `json:"coordinates,omitempty"`
+	JNow        *JNowPosition       `json:"jnow,omitempty"`
+	Footprint   *Footprint          `json:"footprint,omitempty"`
+	SolveTime   float64             `json:"solve_time,omitempty"`
+	Diagnostics *SolveDiagnostics   `json:"diagnostics,omitempty"`
+	RawOutput   string              `json:"raw_output,omitempty"`
+	Error       string              `json:"error,omitempty"`
 }
 
 // ServeHTTP godoc
 //
 //	@Summary		Plate-solve an astronomical image using offline Astrometry.net engine
-//	@Description	Performs plate-solving using the offline Astrometry.net solving engine to determine celestial coordinates and orientation. Recommended: First call /analyse to get optimal scale parameters for 3-5x faster solving.
+//	@Description	Performs plate-solving using the offline Astrometry.net solving engine to determine celestial coordinates and orientation. Recommended: First call /analyse to get optimal scale parameters for 3-5x faster solving. The solve ID is sent in the X-Solve-ID header as soon as the solve is accepted and can be used to cancel it with DELETE /solves/{id}. Uploads already solved with the same options are answered from the result cache, marked with X-Cache: HIT; set no_cache to solve again. Send Accept: text/event-stream to receive progress as Server-Sent Events (queue, phase and a final result event). Set format=wcs, or send Accept: application/fits, to receive the solution as a header-only FITS WCS file instead, or set output=fits to receive an uploaded FITS file back with the WCS written into it. Set output=annotated to receive a stretched preview of the image (PNG, or SVG with annotation_format=svg) with an RA/Dec grid, constellation lines and labelled deep-sky objects drawn on it.
 //	@Tags			Solving
 //	@Accept			multipart/form-data
-//	@Produce		json
+//	@Produce		json,text/event-stream,application/fits,image/png,image/svg+xml
 //	@Param			image				formData	file			true	"Image file (JPG, JPEG, PNG, FITS, FIT)"
 //	@Param			scale_low			formData	number			false	"Lower bound of image scale"
 //	@Param			scale_high			formData	number			false	"Upper bound of image scale"
@@ -76,15 +101,43 @@ type SolveResponse struct {
 //	@Param			downsample_factor	formData	int				false	"Downsample factor (higher = faster but less accurate)"	default(2)
 //	@Param			depth_low			formData	int				false	"Minimum number of quads to try"	default(10)
 //	@Param			depth_high			formData	int				false	"Maximum number of quads to try"	default(20)
-//	@Param			ra					formData	number			false	"Right Ascension hint in degrees (J2000)"
-//	@Param			dec					formData	number			false	"Declination hint in degrees (J2000)"
-//	@Param			radius				formData	number			false	"Search radius in degrees (requires ra/dec)"
+//	@Param			ra					formData	string			false	"Right Ascension hint (J2000): decimal degrees, or hours as 05:35:17, 05 35 17 or 5h35m17s"
+//	@Param			dec					formData	string			false	"Declination hint (J2000): decimal degrees, or -05:23:28, -05 23 28 or -5d23m28s"
+//	@Param			ra_units			formData	string			false	"Units of ra: degrees or hours (default: degrees when decimal, hours when sexagesimal)"
+//	@Param			target				formData	string			false	"Object name to use as the position hint instead of ra/dec, e.g. M42, NGC 1976 or Betelgeuse"
+//	@Param			radius				formData	number			false	"Search radius in degrees around ra/dec or target, 10 if not set"
 //	@Param			keep_temp_files		formData	boolean			false	"Preserve temporary files for debugging"	default(false)
+//	@Param			no_cache			formData	boolean			false	"Solve the image even if a cached result exists"	default(false)
+//	@Param			auto_scale			formData	boolean			false	"Take scale_low/scale_high from the image's EXIF analysis unless given, and include the analysis in the response"	default(false)
+//	@Param			strategy			formData	string			false	"single, or escalate to retry an unsolved image with looser options"	default(single)
+//	@Param			ladder				formData	string			false	"Comma-separated escalation steps (widen_scale, drop_scale, increase_depth, change_downsample, drop_hint)"
+//	@Param			time_budget			formData	number			false	"Total time in seconds for all escalation attempts"
+//	@Param			callback_url		formData	string			false	"Return 202 immediately and POST the signed SolveResponse to this URL when the solve finishes"
+//	@Param			format				formData	string			false	"json, or wcs for the solution as a header-only FITS file (also selected by Accept: application/fits)"	default(json)
+//	@Param			output				formData	string			false	"json, fits for the uploaded FITS file with the solution's WCS written into it, or annotated for an annotated preview of the solved field"	default(json)
+//	@Param			hdu					formData	int				false	"HDU of a FITS upload to write the WCS into with output=fits, or to annotate with output=annotated (default: the first image HDU)"
+//	@Param			include				formData	string			false	"Comma-separated optional parts of the response: objects for the deep-sky objects in the field, stars for the bright stars, coordinates for the galactic, ecliptic and horizontal coordinates of the field centre, footprint for the corners, s_region, GeoJSON and HEALPix cells of the field"
+//	@Param			star_mag_limit		formData	number			false	"Faintest magnitude of the stars listed with include=stars, at most 3"
+//	@Param			healpix_order		formData	int				false	"HEALPix order of the cells listed with include=footprint, lowered if the field covers more than 10000 cells"	default(8)
+//	@Param			latitude			formData	number			false	"Observer latitude in degrees for include=coordinates, north positive; taken from EXIF GPS data if not given"
+//	@Param			longitude			formData	number			false	"Observer longitude in degrees for include=coordinates, east positive; taken from EXIF GPS data if not given"
+//	@Param			observed_at			formData	string			false	"Time the image was taken for include=coordinates and epoch=jnow (RFC 3339); taken from EXIF data if not given"
+//	@Param			epoch				formData	string			false	"jnow adds the field centre on the true equator and equinox of the time the image was taken; ra and dec stay J2000"	Enums(j2000, jnow)	default(j2000)
+//	@Param			aberration			formData	boolean			false	"Apply the annual aberration with epoch=jnow"	default(false)
+//	@Param			annotation_format	formData	string			false	"Image format with output=annotated: png or svg"	default(png)
+//	@Param			layers				formData	string			false	"Comma-separated layers to draw with output=annotated (grid, constellations, dso)"	default(grid,constellations,dso)
+//	@Param			font_size			formData	number			false	"Label height in preview pixels with output=annotated, from 6 to 72"	default(14)
 //	@Success		200					{object}	SolveResponse	"Solve complete (check solved field)"
+//	@Success		202					{object}	JobResponse		"Solve queued; the result will be posted to callback_url"
+//	@Header			200					{string}		X-Solve-ID		"Solve ID"
+//	@Header			200					{string}		X-Cache			"HIT if the result came from the cache, otherwise MISS"
 //	@Failure		400					{object}	SolveResponse	"Bad request"
 //	@Failure		405					{object}	SolveResponse	"Method not allowed"
 //	@Failure		413					{object}	SolveResponse	"File too large"
+//	@Failure		422					{object}	SolveResponse	"No solution to send as a file"
+//	@Failure		429					{object}	SolveResponse	"Solver queue is full (see Retry-After header)"
 //	@Failure		500					{object}	SolveResponse	"Internal server error"
+//	@Failure		503					{object}	SolveResponse	"Server is shutting down"
 //	@Router			/solve [post]
 func (h *SolveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -92,83 +145,140 @@ func (h *SolveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Limit upload size
-	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
-
-	// Parse multipart form
-	if err := r.ParseMultipartForm(h.maxUploadSize); err != nil {
-		respondError(w, "Failed to parse form", http.StatusBadRequest)
+	// Each request gets its own workspace on the shared volume
+	ws, err := h.workspaces.Create("solve")
+	if err != nil {
+		log.Printf("Failed to create workspace: %v", err)
+		respondError(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
+	// Callback solves hand the workspace over to the job manager
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			releaseWorkspace(ws)
+		}
+	}()
 
-	// Get uploaded file
-	file, header, err := r.FormFile("image")
+	tempFile, header, err := saveUpload(w, r, h.maxUploadSize, ws, solveFormats)
 	if err != nil {
-		respondError(w, "Missing or invalid 'image' field", http.StatusBadRequest)
+		message, statusCode := uploadErrorStatus(err)
+		respondError(w, message, statusCode)
 		return
 	}
-	defer file.Close() //nolint:errcheck // Error from Close on read is not critical
 
-	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	validExts := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".fits": true, ".fit": true}
-	if !validExts[ext] {
-		respondError(w, "Invalid file type. Supported: jpg, jpeg, png, fits, fit", http.StatusBadRequest)
+	// Parse solve options from form fields
+	opts := parseSolveOptions(r)
+	if err := parseHint(r, opts); err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondError(w, message, statusCode)
 		return
 	}
-
-	// Save to temporary file in shared directory (must match client's TempDir config)
-	tempDir := "/shared-data"
-	tempFile := filepath.Join(tempDir, fmt.Sprintf("astro_%d%s", os.Getpid(), ext))
-	defer os.Remove(tempFile) //nolint:errcheck // Cleanup failure is not critical
-
-	out, err := os.Create(tempFile)
+	escalation, err := parseStrategy(r)
 	if err != nil {
-		respondError(w, "Failed to save file", http.StatusInternalServerError)
+		message, statusCode := uploadErrorStatus(err)
+		respondError(w, message, statusCode)
 		return
 	}
-	defer out.Close() //nolint:errcheck // Deferred close errors are not critical
-
-	if _, err := io.Copy(out, file); err != nil {
-		respondError(w, "Failed to save file", http.StatusInternalServerError)
+	format, err := parseFormat(r)
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondError(w, message, statusCode)
 		return
 	}
-	if err := out.Close(); err != nil {
-		respondError(w, "Failed to save file", http.StatusInternalServerError)
+	include, err := parseInclude(r)
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondError(w, message, statusCode)
 		return
 	}
-
-	// Parse solve options from form fields
-	opts := h.parseSolveOptions(r)
+	var annotateOpts annotateOptions
+	if format == formatAnnotated {
+		if annotateOpts, err = parseAnnotateOptions(r); err == nil && !isFITS(header.Filename) {
+			err = checkImage(tempFile)
+		}
+		if err != nil {
+			message, statusCode := uploadErrorStatus(err)
+			respondError(w, message, statusCode)
+			return
+		}
+	}
+	// The upload is checked before solving it, so a bad FITS file fails fast
+	var upload *fitsUpload
+	if format == formatFITS || format == formatAnnotated && isFITS(header.Filename) {
+		upload, err = openFITSUpload(tempFile, r.FormValue("hdu"))
+		if err != nil {
+			message, statusCode := uploadErrorStatus(err)
+			respondError(w, message, statusCode)
+			return
+		}
+		defer upload.close()
+	}
 
 	// Solve the image
-	log.Printf("Solving image: %s (%.2f KB)", header.Filename, float64(header.Size)/1024)
-	result, err := h.client.Solve(r.Context(), tempFile, opts)
+	id := jobs.NewID()
+	w.Header().Set(solveIDHeader, id)
+	req := jobs.Request{
+		ID:         id,
+		Filename:   header.Filename,
+		ImagePath:  tempFile,
+		Workspace:  ws,
+		Options:    opts,
+		NoCache:    parseNoCache(r),
+		Escalation: escalation,
+		Include:    observeImage(include, tempFile),
+	}
+	if parseAutoScale(r) {
+		req.Analysis = autoScale(tempFile, opts, scaleGiven(r))
+	}
+	log.Printf("Solving image %s: %s (%.2f KB)", id, header.Filename, float64(header.Size)/1024)
 
-	// Prepare response
-	response := &SolveResponse{}
+	// With a callback URL the caller does not wait: the result is posted to it
+	if callbackURL := r.FormValue("callback_url"); callbackURL != "" {
+		req.CallbackURL = callbackURL
+		handedOver = true
+		submitJob(w, h.jobs, req)
+		return
+	}
+
+	// A cache hit never waits for the solver, so the header is only ever
+	// changed to HIT before anything has been sent
+	w.Header().Set(cacheHeader, "MISS")
+
+	if wantsEventStream(r) {
+		h.solveWithEvents(w, r, req)
+		return
+	}
+
+	// The ID header goes out as soon as the solver queue accepts the solve,
+	// so the caller can cancel it while it runs. A file's status and content
+	// type depend on the result, so its headers wait for it.
+	early := sendHeaderEarly(w)
+	if format == formatJSON {
+		req.PositionFunc = func(int) { early.send() }
+	}
+	job, err := h.jobs.Run(r.Context(), req)
+	early.stop()
+	extendWriteDeadline(w)
 	if err != nil {
-		log.Printf("Solve failed: %v", err)
-		response.Solved = false
-		response.Error = err.Error()
-	} else {
-		response.Solved = result.Solved
-		response.SolveTime = result.SolveTime
-		response.RawOutput = result.RawOutput
-		if result.Solved {
-			response.RA = result.RA
-			response.Dec = result.Dec
-			response.PixelScale = result.PixelScale
-			response.Rotation = result.Rotation
-			response.FieldWidth = result.FieldWidth
-			response.FieldHeight = result.FieldHeight
-			response.WCSHeader = result.WCSHeader
-			log.Printf("Solved: RA=%.6f, Dec=%.6f, PixelScale=%.2f, Time=%.2fs",
-				result.RA, result.Dec, result.PixelScale, result.SolveTime)
-		} else {
-			log.Printf("No solution found (Time=%.2fs)", result.SolveTime)
-		}
+		respondRejected(w, header.Filename, err)
+		return
 	}
+	if job.Cached {
+		w.Header().Set(cacheHeader, "HIT")
+	}
+	switch format {
+	case formatWCS:
+		respondWCS(w, header.Filename, &job)
+		return
+	case formatFITS:
+		respondFITS(w, header.Filename, &job, upload)
+		return
+	case formatAnnotated:
+		respondAnnotated(w, header.Filename, &job, tempFile, upload, annotateOpts)
+		return
+	}
+	response := newJobSolveResponse(&job)
 
 	// Send JSON response
 	w.Header().Set("Content-Type", "application/json")
@@ -177,7 +287,104 @@ func (h *SolveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *SolveHandler) parseSolveOptions(r *http.Request) *client.SolveOptions {
+// solveWithEvents runs the solve while streaming its progress as Server-Sent
+// Events: "queue" events while it waits for the solver, "phase" events derived
+// from the solver output, an "attempt" event after each attempt of an
+// escalating solve and a final "result" event with the SolveResponse
+func (h *SolveHandler) solveWithEvents(w http.ResponseWriter, r *http.Request, req jobs.Request) {
+	events := newEventStream(w)
+	events.send("phase", solvelog.Event{Phase: solvelog.PhaseUploadSaved})
+
+	// Nothing is written until the solver queue accepts the solve, so a full
+	// queue still gets an ordinary 429
+	lastPosition := -1
+	req.PositionFunc = func(position int) {
+		events.open()
+		if position == lastPosition {
+			return
+		}
+		lastPosition = position
+		if position > 0 {
+			events.send("queue", queueEvent{Position: position})
+		} else {
+			events.send("phase", solvelog.Event{Phase: solvelog.PhaseStarted})
+		}
+	}
+
+	var parser solvelog.Parser
+	streamed := false
+	ctx := solver.WithOutputFunc(r.Context(), func(line string) {
+		streamed = true
+		if event, ok := parser.Line(line); ok {
+			events.send("phase", event)
+		}
+	})
+	ctx = escalate.WithAttemptFunc(ctx, func(a escalate.Attempt) {
+		events.send("attempt", newAttemptResponse(a))
+		if !a.Solved {
+			// The next attempt starts over from the solver queue
+			parser = solvelog.Parser{}
+			lastPosition = -1
+		}
+	})
+
+	job, err := h.jobs.Run(ctx, req)
+	if err != nil {
+		if !events.close() {
+			respondRejected(w, req.Filename, err)
+		}
+		return
+	}
+	if job.Cached {
+		w.Header().Set(cacheHeader, "HIT")
+	}
+	events.open()
+
+	if !streamed && job.Result != nil {
+		// The solver only returned its output at the end
+		for _, line := range strings.Split(job.Result.RawOutput, "\n") {
+			if event, ok := parser.Line(line); ok {
+				events.send("phase", event)
+			}
+		}
+	}
+	if !parser.Done() {
+		events.send("phase", solvelog.Event{Phase: finalPhase(job.Status)})
+	}
+	events.send("result", newJobSolveResponse(&job))
+	events.close()
+}
+
+// queueEvent reports a solve's place in the solver queue (1 = next)
+type queueEvent struct {
+	Position int `json:"position"`
+}
+
+// finalPhase is the phase reported for a finished job whose output did not say how it ended
+func finalPhase(status jobs.Status) solvelog.Phase {
+	switch status {
+	case jobs.StatusSolved:
+		return solvelog.PhaseSolved
+	case jobs.StatusCancelled:
+		return solvelog.PhaseCancelled
+	default:
+		return solvelog.PhaseFailed
+	}
+}
+
+// respondRejected sends the error response for a solve the job manager did not accept
+func respondRejected(w http.ResponseWriter, filename string, err error) {
+	var full *queue.FullError
+	if errors.As(err, &full) {
+		log.Printf("Rejected solve for %s: %v", filename, err)
+		w.Header().Set("Retry-After", retryAfterSeconds(full.RetryAfter))
+		respondError(w, "Solver is busy, retry later", http.StatusTooManyRequests)
+		return
+	}
+	respondError(w, "Server is shutting down", http.StatusServiceUnavailable)
+}
+
+func parseSolveOptions(r *http.Request) *client.SolveOptions {
 	opts := client.DefaultSolveOptions()
 
 	// Parse optional parameters
@@ -209,21 +416,6 @@ func (h *SolveHandler) parseSolveOptions(r *http.Request) *client.SolveOptions {
 			opts.DepthHigh = i
 		}
 	}
-	if val := r.FormValue("ra"); val != "" {
-		if f, err := strconv.ParseFloat(val, 64); err == nil {
-			opts.RA = f
-		}
-	}
-	if val := r.FormValue("dec"); val != "" {
-		if f, err := strconv.ParseFloat(val, 64); err == nil {
-			opts.Dec = f
-		}
-	}
-	if val := r.FormValue("radius"); val != "" {
-		if f, err := strconv.ParseFloat(val, 64); err == nil {
-			opts.Radius = f
-		}
-	}
 	if val := r.FormValue("keep_temp_files"); val != "" {
 		if b, err := strconv.ParseBool(val); err == nil {
 			opts.KeepTempFiles = b
@@ -233,6 +425,134 @@ func (h *SolveHandler) parseSolveOptions(r *http.Request) *client.SolveOptions {
 	return opts
 }
 
+// parseNoCache reports whether the request asked to bypass the result cache
+func parseNoCache(r *http.Request) bool {
+	noCache, _ := strconv.ParseBool(r.FormValue("no_cache"))
+	return noCache
+}
+
+// newSolveRequest reports solve options using the request's field names
+func newSolveRequest(opts *client.SolveOptions) SolveRequest {
+	return SolveRequest{
+		ScaleLow:         opts.ScaleLow,
+		ScaleHigh:        opts.ScaleHigh,
+		ScaleUnits:       opts.ScaleUnits,
+		DownsampleFactor: opts.DownsampleFactor,
+		DepthLow:         opts.DepthLow,
+		DepthHigh:        opts.DepthHigh,
+		RA:               opts.RA,
+		Dec:              opts.Dec,
+		Radius:           opts.Radius,
+	}
+}
+
+// newSolveResponse converts a client result (or error) into the API response
+func newSolveResponse(result *client.Result, err error) *SolveResponse {
+	response := &SolveResponse{}
+	if err != nil {
+		response.Solved = false
+		response.Error = err.Error()
+		return response
+	}
+
+	response.Solved = result.Solved
+	response.SolveTime = result.SolveTime
+	response.RawOutput = result.RawOutput
+	if result.Solved {
+		response.RA = result.RA
+		response.Dec = result.Dec
+		response.PixelScale = result.PixelScale
+		response.Rotation = result.Rotation
+		response.FieldWidth = result.FieldWidth
+		response.FieldHeight = result.FieldHeight
+		response.WCSHeader = result.WCSHeader
+	}
+	return response
+}
+
+// retryAfterSeconds formats a duration for the Retry-After header, rounding up
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// newJobSolveResponse builds the solve response for a finished job
+func newJobSolveResponse(job *jobs.Job) *SolveResponse {
+	var err error
+	if job.Error != "" {
+		err = errors.New(job.Error)
+	}
+	response := newSolveResponse(job.Result, err)
+	response.ID = job.ID
+	response.Cancelled = job.Status == jobs.StatusCancelled
+	response.Cached = job.Cached
+	response.Escalation = newEscalationResponse(job)
+	response.Analysis = newJobAnalyseResponse(job.Analysis)
+	response.Diagnostics = newSolveDiagnostics(job)
+	addIncluded(response, job)
+	return response
+}
+
+// earlyHeader commits a 200 response's headers while the handler is still busy
+type earlyHeader struct {
+	once  sync.Once
+	ready chan struct{}
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+// sendHeaderEarly starts waiting to send w's headers. Only success responses
+// may follow send; stop must be called before writing anything else to w.
+func sendHeaderEarly(w http.ResponseWriter) *earlyHeader {
+	e := &earlyHeader{
+		ready: make(chan struct{}),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(e.done)
+		select {
+		case <-e.ready:
+		case <-e.quit:
+			return
+		}
+		extendWriteDeadline(w)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			log.Printf("Failed to flush response headers: %v", err)
+		}
+	}()
+	return e
+}
+
+// send flushes the headers; it is safe to call more than once
+func (e *earlyHeader) send() {
+	e.once.Do(func() { close(e.ready) })
+}
+
+// stop waits for a pending send to finish, or cancels it if send was never called
+func (e *earlyHeader) stop() {
+	close(e.quit)
+	<-e.done
+}
+
+// writeTimeout is how long a solve's response, or each of its events, may
+// take to write. The server's WriteTimeout runs from the start of a request
+// and a solve can outlast it, so the deadline is moved on before writing.
+const writeTimeout = time.Minute
+
+// extendWriteDeadline gives w another writeTimeout to write in
+func extendWriteDeadline(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Failed to extend the write deadline: %v", err)
+	}
+}
+
 func respondError(w http.ResponseWriter, message string, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -3,25 +3,34 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/png"
 	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/cache"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/fits"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
 	client "github.com/DiarmuidKelly/astrometry-go-client"
+	"github.com/DiarmuidKelly/astrometry-go-client/fov"
 )
 
 func TestSolveHandler_Success(t *testing.T) {
-	// Ensure /shared-data exists for the test
-	if err := os.MkdirAll("/shared-data", 0755); err != nil {
-		t.Skip("Cannot create /shared-data directory, skipping test")
-	}
-
 	mockClient := &MockAstroClient{
 		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
 			return &client.Result{
@@ -35,7 +44,7 @@ func TestSolveHandler_Success(t *testing.T) {
 		},
 	}
 
-	handler := NewSolveHandler(mockClient, 50*1024*1024)
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
 
 	testImage := createTestJPEG(t)
 	defer os.Remove(testImage)
@@ -66,11 +75,6 @@ func TestSolveHandler_Success(t *testing.T) {
 }
 
 func TestSolveHandler_NoSolution(t *testing.T) {
-	// Ensure /shared-data exists for the test
-	if err := os.MkdirAll("/shared-data", 0755); err != nil {
-		t.Skip("Cannot create /shared-data directory, skipping test")
-	}
-
 	mockClient := &MockAstroClient{
 		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
 			return &client.Result{
@@ -81,7 +85,7 @@ func TestSolveHandler_NoSolution(t *testing.T) {
 		},
 	}
 
-	handler := NewSolveHandler(mockClient, 50*1024*1024)
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
 
 	testImage := createTestJPEG(t)
 	defer os.Remove(testImage)
@@ -117,7 +121,7 @@ func TestSolveHandler_NoSolution(t *testing.T) {
 
 func TestSolveHandler_MethodNotAllowed(t *testing.T) {
 	mockClient := &MockAstroClient{}
-	handler := NewSolveHandler(mockClient, 50*1024*1024)
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
 
 	req := httptest.NewRequest(http.MethodGet, "/solve", nil)
 	w := httptest.NewRecorder()
@@ -144,7 +148,7 @@ func TestSolveHandler_MethodNotAllowed(t *testing.T) {
 
 func TestSolveHandler_MissingImage(t *testing.T) {
 	mockClient := &MockAstroClient{}
-	handler := NewSolveHandler(mockClient, 50*1024*1024)
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
 
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
@@ -172,7 +176,7 @@ func TestSolveHandler_MissingImage(t *testing.T) {
 
 func TestSolveHandler_InvalidFileType(t *testing.T) {
 	mockClient := &MockAstroClient{}
-	handler := NewSolveHandler(mockClient, 50*1024*1024)
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
 
 	// Create a test file with invalid extension
 	testFile := filepath.Join(os.TempDir(), "test.txt")
@@ -207,11 +211,6 @@ func TestSolveHandler_InvalidFileType(t *testing.T) {
 }
 
 func TestSolveHandler_ParameterParsing(t *testing.T) {
-	// Ensure /shared-data exists for the test
-	if err := os.MkdirAll("/shared-data", 0755); err != nil {
-		t.Skip("Cannot create /shared-data directory, skipping test")
-	}
-
 	var capturedOpts *client.SolveOptions
 	mockClient := &MockAstroClient{
 		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
@@ -220,7 +219,7 @@ func TestSolveHandler_ParameterParsing(t *testing.T) {
 		},
 	}
 
-	handler := NewSolveHandler(mockClient, 50*1024*1024)
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
 
 	testImage := createTestJPEG(t)
 	defer os.Remove(testImage)
@@ -262,6 +261,88 @@ func TestSolveHandler_ParameterParsing(t *testing.T) {
 	}
 }
 
+func TestSolveHandler_PositionHint(t *testing.T) {
+	tests := map[string]struct {
+		params  map[string]string
+		ra, dec float64
+	}{
+		"sexagesimal": {map[string]string{"ra": "05:35:17.3", "dec": "-05:23:28"}, 83.822083, -5.391111},
+		"hms":         {map[string]string{"ra": "5h35m17.3s", "dec": "-5d23m28s"}, 83.822083, -5.391111},
+		"hours":       {map[string]string{"ra": "5.5", "dec": "10", "ra_units": "hours"}, 82.5, 10},
+		"target":      {map[string]string{"target": "M42"}, 83.82, -5.39},
+		"star":        {map[string]string{"target": "Betelgeuse", "radius": "5"}, 88.79, 7.41},
+	}
+	for name, tt := range tests {
+		var capturedOpts *client.SolveOptions
+		mockClient := &MockAstroClient{
+			SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+				capturedOpts = opts
+				return &client.Result{Solved: true}, nil
+			},
+		}
+		handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+
+		testImage := createTestJPEG(t)
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, tt.params)
+		os.Remove(testImage)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || capturedOpts == nil {
+			t.Errorf("%s: expected status 200, got %d: %s", name, w.Code, w.Body.String())
+			continue
+		}
+		if math.Abs(capturedOpts.RA-tt.ra) > 0.01 || math.Abs(capturedOpts.Dec-tt.dec) > 0.01 {
+			t.Errorf("%s: expected RA %f Dec %f, got %f %f", name, tt.ra, tt.dec, capturedOpts.RA, capturedOpts.Dec)
+		}
+		// A hint without a radius is searched within the default radius
+		radius := defaultHintRadius
+		if value, ok := tt.params["radius"]; ok {
+			radius, _ = strconv.ParseFloat(value, 64)
+		}
+		if capturedOpts.Radius != radius {
+			t.Errorf("%s: expected radius %f, got %f", name, radius, capturedOpts.Radius)
+		}
+	}
+}
+
+func TestSolveHandler_PositionHintInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"bad ra":         {"ra": "25h", "dec": "10"},
+		"bad dec":        {"ra": "10", "dec": "-91"},
+		"lone ra":        {"ra": "10"},
+		"bad units":      {"ra": "10", "dec": "10", "ra_units": "radians"},
+		"target and ra":  {"target": "M42", "ra": "10", "dec": "10"},
+		"unknown target": {"target": "Planet X"},
+		"bad radius":     {"ra": "10", "dec": "10", "radius": "wide"},
+		"zero radius":    {"target": "M42", "radius": "0"},
+		"lone radius":    {"radius": "5"},
+	}
+	for name, params := range tests {
+		mockClient := &MockAstroClient{
+			SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+				t.Errorf("%s: expected no solve", name)
+				return &client.Result{Solved: true}, nil
+			},
+		}
+		handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+
+		testImage := createTestJPEG(t)
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, params)
+		os.Remove(testImage)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", name, w.Code)
+		}
+	}
+}
+
 // Helper function to create multipart request with additional params
 func createMultipartRequestWithParams(t *testing.T, fieldName, filePath string, params map[string]string) (io.Reader, string) {
 	body := &bytes.Buffer{}
@@ -293,3 +374,925 @@ func createMultipartRequestWithParams(t *testing.T, fieldName, filePath string,
 	writer.Close()
 	return body, writer.FormDataContentType()
 }
+
+func TestSolveHandler_IsolatedWorkspaces(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			if _, err := os.Stat(imagePath); err != nil {
+				t.Errorf("expected uploaded image at %s: %v", imagePath, err)
+			}
+			mu.Lock()
+			seen[filepath.Dir(imagePath)] = true
+			mu.Unlock()
+			return &client.Result{Solved: true}, nil
+		},
+	}
+
+	workspaces := newTestWorkspaces(t)
+	handler := NewSolveHandler(newTestManager(t, mockClient), workspaces, 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		body, contentType := createMultipartRequest(t, "image", testImage)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/solve", body)
+			req.Header.Set("Content-Type", contentType)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 4 {
+		t.Errorf("expected 4 distinct workspaces, got %d", len(seen))
+	}
+	for dir := range seen {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("expected workspace %s to be removed after the request", dir)
+		}
+	}
+	if workspaces.Active() != 0 {
+		t.Errorf("expected no active workspaces, got %d", workspaces.Active())
+	}
+}
+
+func TestSolveHandler_QueueFull(t *testing.T) {
+	block := make(chan struct{})
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			<-block
+			return &client.Result{Solved: true}, nil
+		},
+	}
+	limiter := queue.NewLimiter(1, 0)
+	handler := NewSolveHandler(newTestManager(t, queue.NewClient(mockClient, limiter)), newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	// Occupy the only solver slot
+	body, contentType := createMultipartRequest(t, "image", testImage)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	for limiter.Stats().Running == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	body, contentType = createMultipartRequest(t, "image", testImage)
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+	close(block)
+	<-done
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+}
+
+func TestSolveHandler_Cache(t *testing.T) {
+	var solves atomic.Int32
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			solves.Add(1)
+			return &client.Result{Solved: true, RA: 83.421}, nil
+		},
+	}
+	manager := newTestManager(t, mockClient)
+	manager.SetCache(cache.New(10, time.Hour))
+	handler := NewSolveHandler(manager, newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	for i, tc := range []struct {
+		params   map[string]string
+		expected string
+	}{
+		{nil, "MISS"},
+		{nil, "HIT"},
+		{map[string]string{"no_cache": "true"}, "MISS"},
+		{map[string]string{"downsample_factor": "4"}, "MISS"},
+	} {
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, tc.params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if got := w.Header().Get("X-Cache"); got != tc.expected {
+			t.Errorf("request %d: expected X-Cache %s, got %s", i, tc.expected, got)
+		}
+		var response SolveResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if !response.Solved || response.RA != 83.421 || response.Cached != (tc.expected == "HIT") {
+			t.Errorf("request %d: unexpected response %+v", i, response)
+		}
+	}
+	if n := solves.Load(); n != 3 {
+		t.Errorf("expected 3 solves, got %d", n)
+	}
+}
+
+func TestSolveHandler_Escalate(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: opts.RA == 0, RA: 83.421}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	body, contentType := createMultipartRequestWithParams(t, "image", testImage, map[string]string{
+		"ra":          "80",
+		"dec":         "-5",
+		"radius":      "2",
+		"strategy":    "escalate",
+		"ladder":      "widen_scale,drop_hint",
+		"time_budget": "60",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var response SolveResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !response.Solved {
+		t.Fatalf("expected solved true, got error: %s", response.Error)
+	}
+	escalation := response.Escalation
+	if escalation == nil {
+		t.Fatal("expected an escalation report")
+	}
+	if escalation.SolvedBy != 2 || escalation.TimeBudget != 60 || len(escalation.Attempts) != 2 {
+		t.Errorf("expected the second of 2 attempts to solve within 60s, got %+v", escalation)
+	}
+	if last := escalation.Attempts[1]; last.Step != "drop_hint" || last.Options.RA != 0 || last.Options.Radius != 0 {
+		t.Errorf("expected the position hint to be dropped, got %+v", last)
+	}
+	if first := escalation.Attempts[0]; first.Step != "initial" || first.Options.RA != 80 || first.Solved {
+		t.Errorf("expected an unsolved first attempt with the hint, got %+v", first)
+	}
+}
+
+func TestSolveHandler_InvalidStrategy(t *testing.T) {
+	handler := NewSolveHandler(newTestManager(t, &MockAstroClient{}), newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	for _, params := range []map[string]string{
+		{"strategy": "guess"},
+		{"strategy": "escalate", "ladder": "pray"},
+		{"strategy": "escalate", "time_budget": "-1"},
+	} {
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %d", params, w.Code)
+		}
+	}
+}
+
+func TestSolveHandler_AutoScale(t *testing.T) {
+	analyzeImage = func(path string) (*fov.ImageInfo, error) {
+		return &fov.ImageInfo{
+			Make:        "Canon",
+			Model:       "EOS 6D",
+			FocalLength: 50,
+			HasEXIF:     true,
+			FOV:         fov.FOV{WidthDegrees: 40.3, HeightDegrees: 27.0, WidthArcmin: 2418, HeightArcmin: 1620},
+			ScaleLow:    2176,
+			ScaleHigh:   2660,
+		}, nil
+	}
+	defer func() { analyzeImage = fov.AnalyzeImage }()
+
+	var seen *client.SolveOptions
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			seen = opts
+			return &client.Result{Solved: true}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	for _, tc := range []struct {
+		params   map[string]string
+		expected float64
+	}{
+		{map[string]string{"auto_scale": "true"}, 2176},
+		{map[string]string{"auto_scale": "true", "scale_low": "100", "scale_high": "200"}, 100},
+	} {
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, tc.params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var response SolveResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if seen == nil || seen.ScaleLow != tc.expected || seen.ScaleUnits != "arcminwidth" {
+			t.Errorf("%v: expected scale_low %v in arcminwidth, got %+v", tc.params, tc.expected, seen)
+		}
+		analysis := response.Analysis
+		if analysis == nil || !analysis.Success || analysis.Model != "EOS 6D" || analysis.FOV == nil || analysis.ScaleLow != 2176 {
+			t.Errorf("%v: expected the analysis in the response, got %+v", tc.params, analysis)
+		}
+	}
+}
+
+func TestSolveHandler_AutoScaleWithoutEXIF(t *testing.T) {
+	var seen *client.SolveOptions
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			seen = opts
+			return &client.Result{Solved: true}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+
+	// The minimal test JPEG has no EXIF, so the solve goes ahead without a scale
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	body, contentType := createMultipartRequestWithParams(t, "image", testImage, map[string]string{"auto_scale": "true"})
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var response SolveResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !response.Solved {
+		t.Errorf("expected solved true, got error: %s", response.Error)
+	}
+	if response.Analysis == nil || response.Analysis.Success || response.Analysis.Error == "" {
+		t.Errorf("expected a failed analysis in the response, got %+v", response.Analysis)
+	}
+	if seen == nil || seen.ScaleLow != 0 || seen.ScaleHigh != 0 {
+		t.Errorf("expected no scale bounds, got %+v", seen)
+	}
+}
+
+func TestSolveHandler_WCSFormat(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{
+				Solved: true,
+				RA:     83.822,
+				Dec:    -5.391,
+				WCSHeader: map[string]string{
+					"CTYPE1": "RA---TAN",
+					"CRVAL1": "83.822",
+					"CRPIX1": "512",
+					"IMAGEW": "1024",
+				},
+			}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	for name, tc := range map[string]struct {
+		params map[string]string
+		accept string
+	}{
+		"field":  {params: map[string]string{"format": "wcs", "no_cache": "true"}},
+		"accept": {params: map[string]string{"no_cache": "true"}, accept: "application/fits"},
+	} {
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, tc.params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", name, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/fits" {
+			t.Errorf("%s: expected Content-Type application/fits, got %s", name, got)
+		}
+		wantName := strings.TrimSuffix(filepath.Base(testImage), filepath.Ext(testImage)) + ".wcs"
+		if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, wantName) {
+			t.Errorf("%s: expected attachment named %s, got %s", name, wantName, got)
+		}
+		if w.Header().Get(solveIDHeader) == "" {
+			t.Errorf("%s: expected %s header", name, solveIDHeader)
+		}
+
+		data := w.Body.String()
+		if len(data)%2880 != 0 {
+			t.Errorf("%s: expected whole FITS blocks, got %d bytes", name, len(data))
+		}
+		for _, card := range []string{
+			"SIMPLE  =                    T",
+			"CTYPE1  = 'RA---TAN'",
+			"CRPIX1  =                512.0",
+			"IMAGEW  =                 1024",
+		} {
+			if !strings.Contains(data, card) {
+				t.Errorf("%s: expected card %q", name, card)
+			}
+		}
+	}
+}
+
+func TestSolveHandler_WCSFormatNoSolution(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: false, RawOutput: "Did not solve"}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	body, contentType := createMultipartRequestWithParams(t, "image", testImage, map[string]string{"format": "wcs"})
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", w.Code)
+	}
+	var response SolveResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Solved || response.Error == "" {
+		t.Errorf("expected an unsolved response with an error, got %+v", response)
+	}
+}
+
+func TestSolveHandler_InvalidFormat(t *testing.T) {
+	handler := NewSolveHandler(newTestManager(t, &MockAstroClient{}), newTestWorkspaces(t), 50*1024*1024)
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	for _, tc := range []struct {
+		params map[string]string
+		accept string
+	}{
+		{params: map[string]string{"format": "xml"}},
+		{params: map[string]string{"format": "wcs", "callback_url": "http://example.com/hook"}},
+		{params: map[string]string{"format": "wcs"}, accept: "text/event-stream"},
+	} {
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, tc.params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %d", tc.params, w.Code)
+		}
+	}
+}
+
+// createTestFITS writes a FITS file with an empty primary HDU and a 2x2 image extension
+func createTestFITS(t *testing.T) string {
+	var data bytes.Buffer
+	data.Write(fits.Header{
+		{Key: "SIMPLE", Value: "T"},
+		{Key: "BITPIX", Value: "8"},
+		{Key: "NAXIS", Value: "0"},
+		{Key: "EXTEND", Value: "T"},
+	}.Encode())
+	data.Write(fits.Header{
+		{Key: "XTENSION", Value: "'IMAGE'"},
+		{Key: "BITPIX", Value: "8"},
+		{Key: "NAXIS", Value: "2"},
+		{Key: "NAXIS1", Value: "2"},
+		{Key: "NAXIS2", Value: "2"},
+		{Key: "PCOUNT", Value: "0"},
+		{Key: "GCOUNT", Value: "1"},
+		{Key: "CDELT1", Value: "-0.1"},
+	}.Encode())
+	data.Write([]byte{1, 2, 3, 4})
+	data.Write(make([]byte, fits.BlockSize-4))
+
+	path := filepath.Join(t.TempDir(), "m42.fits")
+	if err := os.WriteFile(path, data.Bytes(), 0o600); err != nil {
+		t.Fatalf("failed to write FITS file: %v", err)
+	}
+	return path
+}
+
+func TestSolveHandler_FITSOutput(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{
+				Solved: true,
+				RA:     83.822,
+				Dec:    -5.391,
+				WCSHeader: map[string]string{
+					"CTYPE1": "RA---TAN",
+					"CRVAL1": "83.822",
+					"CD1_1":  "-0.0003",
+				},
+			}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+	testFITS := createTestFITS(t)
+
+	body, contentType := createMultipartRequestWithParams(t, "image", testFITS, map[string]string{"output": "fits"})
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, "m42-solved.fits") {
+		t.Errorf("expected attachment named m42-solved.fits, got %s", got)
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("expected Content-Length %d, got %s", w.Body.Len(), got)
+	}
+
+	data := w.Body.Bytes()
+	f, err := fits.Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if len(f.HDUs) != 2 {
+		t.Fatalf("expected 2 HDUs, got %d", len(f.HDUs))
+	}
+	if _, ok := f.HDUs[0].Header.Get("CTYPE1"); ok {
+		t.Error("expected the WCS in the image extension, not the primary HDU")
+	}
+	image := f.HDUs[1].Header
+	if value, _ := image.Get("CTYPE1"); value != "'RA---TAN'" {
+		t.Errorf("expected CTYPE1 'RA---TAN', got %s", value)
+	}
+	if _, ok := image.Get("CDELT1"); ok {
+		t.Error("expected the stale CDELT1 to be removed")
+	}
+	if value, _ := image.Get("HISTORY"); !strings.Contains(value, w.Header().Get(solveIDHeader)) {
+		t.Errorf("expected a history card naming the solve, got %q", value)
+	}
+}
+
+func TestSolveHandler_FITSOutputInvalid(t *testing.T) {
+	handler := NewSolveHandler(newTestManager(t, &MockAstroClient{}), newTestWorkspaces(t), 50*1024*1024)
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+	testFITS := createTestFITS(t)
+
+	for _, tc := range []struct {
+		image  string
+		params map[string]string
+	}{
+		{testImage, map[string]string{"output": "fits"}},
+		{testFITS, map[string]string{"output": "fits", "hdu": "0"}},
+		{testFITS, map[string]string{"output": "fits", "hdu": "2"}},
+		{testFITS, map[string]string{"output": "fits", "format": "wcs"}},
+		{testFITS, map[string]string{"output": "png"}},
+	} {
+		body, contentType := createMultipartRequestWithParams(t, "image", tc.image, tc.params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %d", tc.params, w.Code)
+		}
+	}
+}
+
+// annotateTestClient solves any image as a 2 degree field around M42 with the
+// given size in pixels
+func annotateTestClient(size string) *MockAstroClient {
+	return &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{
+				Solved: true,
+				RA:     83.822,
+				Dec:    -5.391,
+				WCSHeader: map[string]string{
+					"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN",
+					"CRVAL1": "83.822", "CRVAL2": "-5.391",
+					"CRPIX1": "1", "CRPIX2": "1",
+					"CD1_1": "-1", "CD1_2": "0", "CD2_1": "0", "CD2_2": "-1",
+					"IMAGEW": size, "IMAGEH": size,
+				},
+			}, nil
+		},
+	}
+}
+
+func TestSolveHandler_AnnotatedOutput(t *testing.T) {
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+	testFITS := createTestFITS(t)
+
+	for name, tc := range map[string]struct {
+		image       string
+		size        string
+		params      map[string]string
+		contentType string
+		filename    string
+	}{
+		"png":  {testImage, "1", map[string]string{"output": "annotated"}, "image/png", "test_handler-annotated.png"},
+		"svg":  {testImage, "1", map[string]string{"output": "annotated", "annotation_format": "svg", "layers": "grid, dso", "font_size": "8"}, "image/svg+xml", "test_handler-annotated.svg"},
+		"fits": {testFITS, "2", map[string]string{"output": "annotated"}, "image/png", "m42-annotated.png"},
+	} {
+		handler := NewSolveHandler(newTestManager(t, annotateTestClient(tc.size)), newTestWorkspaces(t), 50*1024*1024)
+		body, contentType := createMultipartRequestWithParams(t, "image", tc.image, tc.params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", name, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Type"); got != tc.contentType {
+			t.Errorf("%s: expected Content-Type %s, got %s", name, tc.contentType, got)
+		}
+		if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, tc.filename) {
+			t.Errorf("%s: expected a file named %s, got %s", name, tc.filename, got)
+		}
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+			t.Errorf("%s: expected Content-Length %d, got %s", name, w.Body.Len(), got)
+		}
+
+		if tc.contentType == "image/png" {
+			img, err := png.Decode(w.Body)
+			if err != nil {
+				t.Fatalf("%s: failed to decode PNG: %v", name, err)
+			}
+			if got := strconv.Itoa(img.Bounds().Dx()); got != tc.size {
+				t.Errorf("%s: expected a preview %s pixels wide like the upload, got %s", name, tc.size, got)
+			}
+		} else if !strings.Contains(w.Body.String(), "<svg") {
+			t.Errorf("%s: expected an SVG image, got %s", name, w.Body.String())
+		}
+	}
+}
+
+func TestSolveHandler_AnnotatedOutputInvalid(t *testing.T) {
+	handler := NewSolveHandler(newTestManager(t, annotateTestClient("1")), newTestWorkspaces(t), 50*1024*1024)
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	notImage := filepath.Join(t.TempDir(), "broken.png")
+	if err := os.WriteFile(notImage, []byte("not a png"), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	for _, tc := range []struct {
+		image  string
+		params map[string]string
+	}{
+		{testImage, map[string]string{"output": "annotated", "annotation_format": "gif"}},
+		{testImage, map[string]string{"output": "annotated", "layers": "grid,stars"}},
+		{testImage, map[string]string{"output": "annotated", "font_size": "2"}},
+		{testImage, map[string]string{"output": "annotated", "format": "wcs"}},
+		{notImage, map[string]string{"output": "annotated"}},
+	} {
+		body, contentType := createMultipartRequestWithParams(t, "image", tc.image, tc.params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %d: %s", tc.params, w.Code, w.Body.String())
+		}
+	}
+
+	// An image too large to decode is rejected from its header
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	largeImage := filepath.Join(t.TempDir(), "large.png")
+	if err := os.WriteFile(largeImage, data, 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	body, contentType := createMultipartRequestWithParams(t, "image", largeImage, map[string]string{"output": "annotated"})
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for a 100000x100000 image, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSolveHandler_OutlastsWriteTimeout(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			time.Sleep(300 * time.Millisecond)
+			return &client.Result{Solved: true, RA: 83.8221, Dec: -5.3911}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	for _, accept := range []string{"application/json", "text/event-stream"} {
+		body, contentType := createMultipartRequest(t, "image", testImage)
+		req, err := http.NewRequest(http.MethodPost, server.URL, body)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: expected a response after the write timeout, got %v", accept, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || !strings.Contains(string(data), `"solved":true`) {
+			t.Errorf("%s: expected the whole result, got %q, %v", accept, data, err)
+		}
+	}
+}
+
+func TestSolveHandler_Include(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: true, RA: 83.8221, Dec: -5.3911, WCSHeader: testWCSHeader}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	for _, tc := range []struct {
+		params map[string]string
+		want   int
+	}{
+		{map[string]string{"include": "objects, stars,footprint", "star_mag_limit": "2.5", "healpix_order": "10", "no_cache": "true"}, http.StatusOK},
+		{map[string]string{"include": "objects,planets"}, http.StatusBadRequest},
+		{map[string]string{"include": "stars", "star_mag_limit": "bright"}, http.StatusBadRequest},
+		{map[string]string{"include": "stars", "star_mag_limit": "6"}, http.StatusBadRequest},
+		{map[string]string{"include": "footprint", "healpix_order": "30"}, http.StatusBadRequest},
+	} {
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, tc.params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.want {
+			t.Fatalf("%v: expected status %d, got %d: %s", tc.params, tc.want, w.Code, w.Body.String())
+		}
+		if tc.want != http.StatusOK {
+			continue
+		}
+		var response SolveResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(response.Objects) == 0 || response.Objects[0].ID != "M42" {
+			t.Errorf("expected M42 in the objects, got %+v", response.Objects)
+		}
+		// No catalog star is in the small field, but the list is still sent
+		if response.Stars == nil || len(response.Stars) != 0 {
+			t.Errorf("expected an empty list of stars, got %+v", response.Stars)
+		}
+		if f := response.Footprint; f == nil || f.HEALPix.Order != 10 || len(f.HEALPix.Cells) == 0 || !strings.HasPrefix(f.SRegion, "POLYGON ICRS ") {
+			t.Errorf("expected the footprint with order-10 cells, got %+v", f)
+		}
+	}
+
+	// Without include, no objects or stars are listed
+	body, contentType := createMultipartRequestWithParams(t, "image", testImage, map[string]string{"no_cache": "true"})
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if strings.Contains(w.Body.String(), `"objects"`) || strings.Contains(w.Body.String(), `"stars"`) || strings.Contains(w.Body.String(), `"footprint"`) {
+		t.Errorf("expected no objects, stars or footprint without include, got %s", w.Body.String())
+	}
+}
+
+func TestSolveHandler_EpochJNow(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: true, RA: 41.054063, Dec: 49.227750}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	solve := func(params map[string]string) (*httptest.ResponseRecorder, SolveResponse) {
+		params["no_cache"] = "true"
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var response SolveResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+		}
+		return w, response
+	}
+
+	w, response := solve(map[string]string{"epoch": "jnow", "aberration": "true", "observed_at": "2028-11-13T04:33:36Z"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	jnow := response.JNow
+	if jnow == nil || math.Abs(jnow.RA-41.559958) > 0.5/3600 || math.Abs(jnow.Dec-49.352069) > 0.5/3600 || jnow.TimeSource != "request" || !jnow.Aberration {
+		t.Errorf("expected JNow 41.559958 49.352069 at the requested time, got %+v", jnow)
+	}
+	if response.RA != 41.054063 || response.Coordinates != nil {
+		t.Errorf("expected ra and dec to stay J2000 without coordinates, got %+v", response)
+	}
+
+	// Without a time in the request or the image, the time of the solve is used
+	before := time.Now().Add(-time.Second)
+	_, response = solve(map[string]string{"epoch": "jnow"})
+	if jnow := response.JNow; jnow == nil || jnow.TimeSource != "solve" || jnow.Time.Before(before) || jnow.Aberration {
+		t.Errorf("expected JNow at the time of the solve, got %+v", jnow)
+	}
+
+	_, response = solve(map[string]string{"epoch": "j2000"})
+	if response.JNow != nil {
+		t.Errorf("expected no JNow position for epoch=j2000, got %+v", response.JNow)
+	}
+	for _, params := range []map[string]string{{"epoch": "b1950"}, {"epoch": "jnow", "aberration": "yes please"}} {
+		if w, _ := solve(params); w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %d", params, w.Code)
+		}
+	}
+}
+
+func TestSolveHandler_IncludeCoordinates(t *testing.T) {
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: true, RA: 83.8221, Dec: -5.3911, WCSHeader: testWCSHeader}, nil
+		},
+	}
+	handler := NewSolveHandler(newTestManager(t, mockClient), newTestWorkspaces(t), 50*1024*1024)
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	solve := func(params map[string]string) *httptest.ResponseRecorder {
+		params["include"] = "coordinates"
+		params["no_cache"] = "true"
+		body, contentType := createMultipartRequestWithParams(t, "image", testImage, params)
+		req := httptest.NewRequest(http.MethodPost, "/solve", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// M42 from Dublin, close to the meridian
+	w := solve(map[string]string{"latitude": "53.35", "longitude": "-6.26", "observed_at": "2026-01-15T22:00:00Z"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response SolveResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	c := response.Coordinates
+	if c == nil || math.Abs(c.Galactic.L-209.01) > 0.01 || math.Abs(c.Galactic.B+19.38) > 0.01 {
+		t.Fatalf("expected galactic coordinates 209.01, -19.38, got %+v", c)
+	}
+	h := c.Horizontal
+	if h == nil || h.LocationSource != "request" || h.TimeSource != "request" {
+		t.Fatalf("expected horizontal coordinates from the request, got %+v", h)
+	}
+	if math.Abs(h.Altitude-31.2) > 1 || math.Abs(h.Azimuth-180) > 10 || math.Abs(h.HourAngle) > 0.5 {
+		t.Errorf("expected M42 near the meridian at altitude 31, got %+v", h)
+	}
+	if h.Airmass == nil || math.Abs(*h.Airmass-1.93) > 0.1 {
+		t.Errorf("expected an airmass near 1.93, got %v", h.Airmass)
+	}
+
+	// Without a location in the request or the image, only the time is known
+	w = solve(map[string]string{"observed_at": "2026-01-15T22:00:00Z"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"galactic"`) || strings.Contains(w.Body.String(), `"horizontal"`) {
+		t.Errorf("expected coordinates without horizontal ones, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A camera time without a time zone is not enough for horizontal coordinates
+	observer := &jobs.Observer{Latitude: 53.35, Longitude: -6.26, Time: time.Date(2026, 1, 15, 22, 0, 0, 0, time.UTC), LocationSource: "request", TimeSource: "exif_local"}
+	if c := fieldCoordinates(83.8221, -5.3911, observer); c.Horizontal != nil {
+		t.Errorf("expected no horizontal coordinates from a local time, got %+v", c.Horizontal)
+	}
+
+	for _, params := range []map[string]string{
+		{"latitude": "91", "longitude": "0"},
+		{"latitude": "53.35"},
+		{"latitude": "53.35", "longitude": "west"},
+		{"observed_at": "2026-01-15 22:00"},
+	} {
+		if w := solve(params); w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %d", params, w.Code)
+		}
+	}
+}
+
+func TestSolveHandler_Diagnostics(t *testing.T) {
+	output := `Extracting sources...
+simplexy: found 1523 sources.
+Field 1 did not solve (index index-4208.fits, field objects 1-10).
+  log-odds ratio 142.5 (1.2e+62), 35 match, 0 conflict, 64 distractors, 99 index.
+Field 1: solved with index index-4207-01.fits.`
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			return &client.Result{Solved: true, RA: 83.8221, Dec: -5.3911, RawOutput: output}, nil
+		},
+	}
+	solver := queue.NewClient(mockClient, queue.NewLimiter(1, 1))
+	handler := NewSolveHandler(newTestManager(t, solver), newTestWorkspaces(t), 50*1024*1024)
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+
+	body, contentType := createMultipartRequest(t, "image", testImage)
+	req := httptest.NewRequest(http.MethodPost, "/solve", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response SolveResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	d := response.Diagnostics
+	if d == nil {
+		t.Fatal("expected diagnostics")
+	}
+	if d.Sources == nil || *d.Sources != 1523 || d.Index != "index-4207-01.fits" || len(d.IndexesTried) != 2 || d.QuadDepth != 10 {
+		t.Errorf("expected the sources and indexes from the output, got %+v", d.Diagnostics)
+	}
+	if d.Match == nil || d.Match.LogOdds != 142.5 || d.Match.Matched != 35 || d.Match.Distractors != 64 {
+		t.Errorf("expected the match from the output, got %+v", d.Match)
+	}
+	if d.Timings == nil || d.Timings.Total < d.Timings.Solve || d.Timings.Queue < 0 {
+		t.Errorf("expected the solve timings, got %+v", d.Timings)
+	}
+}
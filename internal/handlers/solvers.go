@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solver"
+)
+
+// SolverPoolHandler reports the load and health of each solver target
+type SolverPoolHandler struct {
+	pool  *solver.Pool
+	token string
+}
+
+// NewSolverPoolHandler creates a new solver pool status handler. Requests
+// must send token as a bearer token; with an empty token every request is
+// refused.
+func NewSolverPoolHandler(pool *solver.Pool, token string) *SolverPoolHandler {
+	return &SolverPoolHandler{
+		pool:  pool,
+		token: token,
+	}
+}
+
+// SolverPoolResponse represents the status of the solver pool
+type SolverPoolResponse struct {
+	Healthy int                    `json:"healthy"`
+	Targets []SolverTargetResponse `json:"targets"`
+}
+
+// SolverTargetResponse represents the load and health of one solver target
+type SolverTargetResponse struct {
+	Name                string     `json:"name"`
+	Backend             string     `json:"backend"`
+	Running             int        `json:"running"`
+	MaxConcurrent       int        `json:"max_concurrent,omitempty"`
+	Solves              int        `json:"solves"`
+	Failures            int        `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Healthy             bool       `json:"healthy"`
+	UnhealthySince      *time.Time `json:"unhealthy_since,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// ServeHTTP godoc
+//
+//	@Summary		Solver pool status
+//	@Description	Returns each solver target with the solves running on it, its solve and failure counts and whether it is healthy. Set check=true to run every target's health check first, which also marks targets that pass it healthy again.
+//	@Tags			Admin
+//	@Produce		json
+//	@Security		AdminToken
+//	@Param			check	query		bool				false	"Run health checks first"
+//	@Success		200		{object}	SolverPoolResponse	"Solver pool status"
+//	@Failure		401		{string}	string				"Missing or wrong admin token"
+//	@Failure		405		{string}	string				"Method not allowed"
+//	@Router			/admin/solvers [get]
+func (h *SolverPoolHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if check, _ := strconv.ParseBool(r.URL.Query().Get("check")); check {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		h.pool.Check(ctx)
+		cancel()
+	}
+
+	response := &SolverPoolResponse{Targets: []SolverTargetResponse{}}
+	for _, t := range h.pool.Stats() {
+		target := SolverTargetResponse{
+			Name:                t.Name,
+			Backend:             t.Backend,
+			Running:             t.Running,
+			MaxConcurrent:       t.MaxConcurrent,
+			Solves:              t.Solves,
+			Failures:            t.Failures,
+			ConsecutiveFailures: t.ConsecutiveFailures,
+			Healthy:             t.Healthy,
+			LastError:           t.LastError,
+		}
+		if t.Healthy {
+			response.Healthy++
+		} else {
+			target.UnhealthySince = &t.UnhealthySince
+		}
+		response.Targets = append(response.Targets, target)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// authorized reports whether the request carries the admin token
+func (h *SolverPoolHandler) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solver"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+type fakeBackend struct {
+	fakeChecker
+}
+
+func (f *fakeBackend) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	return &client.Result{Solved: true}, nil
+}
+
+func TestSolverPoolHandler(t *testing.T) {
+	pool, err := solver.NewPool([]solver.Target{
+		{Name: "solver-1", Backend: &fakeBackend{}},
+		{Name: "solver-2", Backend: &fakeBackend{fakeChecker{err: errors.New("container is not running")}}},
+	}, 3, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	if _, err := pool.Solve(context.Background(), "image.jpg", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := NewSolverPoolHandler(pool, "admin-token")
+
+	// Without a check, both targets are still healthy
+	req := httptest.NewRequest(http.MethodGet, "/admin/solvers", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var response SolverPoolResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Healthy != 2 || len(response.Targets) != 2 {
+		t.Fatalf("expected 2 healthy targets, got %+v", response)
+	}
+	if response.Targets[0].Name != "solver-1" || response.Targets[0].Solves != 1 {
+		t.Errorf("expected solver-1 to have run the solve, got %+v", response.Targets[0])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/solvers?check=true", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	response = SolverPoolResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Healthy != 1 {
+		t.Errorf("expected 1 healthy target, got %d", response.Healthy)
+	}
+	target := response.Targets[1]
+	if target.Healthy || target.UnhealthySince == nil || target.LastError != "container is not running" {
+		t.Errorf("expected solver-2 to be reported unhealthy, got %+v", target)
+	}
+}
+
+func TestSolverPoolHandler_MethodNotAllowed(t *testing.T) {
+	pool, _ := solver.NewPool([]solver.Target{{Name: "solver-1", Backend: &fakeBackend{}}}, 3, time.Minute)
+	handler := NewSolverPoolHandler(pool, "admin-token")
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/solvers", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
+
+func TestSolverPoolHandler_Unauthorized(t *testing.T) {
+	backend := &fakeBackend{}
+	pool, _ := solver.NewPool([]solver.Target{{Name: "solver-1", Backend: backend}}, 3, time.Minute)
+
+	for _, tc := range []struct {
+		token, header string
+	}{
+		{"admin-token", ""},
+		{"admin-token", "Bearer wrong-token"},
+		{"admin-token", "admin-token"},
+		{"", "Bearer "},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/solvers?check=true", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		NewSolverPoolHandler(pool, tc.token).ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("token %q, header %q: expected status 401, got %d", tc.token, tc.header, w.Code)
+		}
+	}
+	if backend.checks != 0 {
+		t.Errorf("expected no health checks without the token, got %d", backend.checks)
+	}
+}
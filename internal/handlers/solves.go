@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+)
+
+// SolvesHandler handles requests for individual solves by ID
+type SolvesHandler struct {
+	manager *jobs.Manager
+}
+
+// NewSolvesHandler creates a new solves handler
+func NewSolvesHandler(manager *jobs.Manager) *SolvesHandler {
+	return &SolvesHandler{
+		manager: manager,
+	}
+}
+
+// ServeHTTP routes DELETE /solves/{id}
+func (h *SolvesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/solves"), "/")
+
+	if id == "" || r.Method != http.MethodDelete {
+		respondJobError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.cancel(w, r, id)
+}
+
+// cancel godoc
+//
+//	@Summary		Cancel a solve
+//	@Description	Cancels a queued or running solve, using the ID from the X-Solve-ID header of /solve or from /jobs. The solver process is killed, the workspace removed and the original caller receives a result with cancelled set to true.
+//	@Tags			Solving
+//	@Produce		json
+//	@Param			id	path		string		true	"Solve ID"
+//	@Success		200	{object}	JobResponse	"Solve cancelled"
+//	@Failure		404	{object}	JobResponse	"Solve not found"
+//	@Failure		405	{object}	JobResponse	"Method not allowed"
+//	@Failure		409	{object}	JobResponse	"Solve has already finished"
+//	@Router			/solves/{id} [delete]
+func (h *SolvesHandler) cancel(w http.ResponseWriter, r *http.Request, id string) {
+	job, err := h.manager.Cancel(r.Context(), id)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		respondJobError(w, "Solve not found", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		response := newJobResponse(&job)
+		response.Error = "Solve has already finished"
+		writeJSON(w, http.StatusConflict, response)
+		return
+	case err != nil:
+		// The caller went away while waiting for the solve to stop
+		log.Printf("Cancel of solve %s interrupted: %v", id, err)
+		return
+	}
+
+	log.Printf("Cancelled solve %s", id)
+	writeJSON(w, http.StatusOK, newJobResponse(&job))
+}
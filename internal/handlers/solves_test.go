@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+func TestSolvesHandler_CancelRunningSolve(t *testing.T) {
+	started := make(chan string, 1)
+	mockClient := &MockAstroClient{
+		SolveFunc: func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			started <- imagePath
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	manager := newTestManager(t, queue.NewClient(mockClient, queue.NewLimiter(1, 1)))
+
+	mux := http.NewServeMux()
+	mux.Handle("/solve", NewSolveHandler(manager, newTestWorkspaces(t), 50*1024*1024))
+	mux.Handle("/solves/", NewSolvesHandler(manager))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testImage := createTestJPEG(t)
+	defer os.Remove(testImage)
+	body, contentType := createMultipartRequest(t, "image", testImage)
+
+	// The ID header arrives while the solve is still running
+	resp, err := http.Post(server.URL+"/solve", contentType, body)
+	if err != nil {
+		t.Fatalf("solve request failed: %v", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // Test cleanup
+	id := resp.Header.Get("X-Solve-ID")
+	if id == "" {
+		t.Fatal("expected X-Solve-ID header")
+	}
+	imagePath := <-started
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/solves/"+id, nil)
+	cancelResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cancel request failed: %v", err)
+	}
+	defer cancelResp.Body.Close() //nolint:errcheck // Test cleanup
+	if cancelResp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", cancelResp.StatusCode)
+	}
+	var cancelled JobResponse
+	if err := json.NewDecoder(cancelResp.Body).Decode(&cancelled); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if cancelled.Status != "cancelled" {
+		t.Errorf("expected status cancelled, got %q", cancelled.Status)
+	}
+
+	// The original caller gets a cancelled result
+	var response SolveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !response.Cancelled || response.Solved {
+		t.Errorf("expected cancelled result, got %+v", response)
+	}
+	if response.ID != id {
+		t.Errorf("expected ID %s, got %s", id, response.ID)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(filepath.Dir(imagePath)); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected workspace to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Cancelling again conflicts
+	req, _ = http.NewRequest(http.MethodDelete, server.URL+"/solves/"+id, nil)
+	againResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cancel request failed: %v", err)
+	}
+	againResp.Body.Close() //nolint:errcheck // Test cleanup
+	if againResp.StatusCode != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", againResp.StatusCode)
+	}
+}
+
+func TestSolvesHandler_NotFound(t *testing.T) {
+	handler := NewSolvesHandler(newTestManager(t, &MockAstroClient{}))
+
+	req := httptest.NewRequest(http.MethodDelete, "/solves/does-not-exist", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestSolvesHandler_MethodNotAllowed(t *testing.T) {
+	handler := NewSolvesHandler(newTestManager(t, &MockAstroClient{}))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/solves/abc", nil),
+		httptest.NewRequest(http.MethodDelete, "/solves/", nil),
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: expected status 405, got %d", req.Method, req.URL.Path, w.Code)
+		}
+	}
+}
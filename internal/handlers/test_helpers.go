@@ -8,7 +8,11 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/jobs"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/store"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
 	client "github.com/DiarmuidKelly/astrometry-go-client"
 )
 
@@ -24,6 +28,27 @@ func (m *MockAstroClient) Solve(ctx context.Context, imagePath string, opts *cli
 	return &client.Result{Solved: true}, nil
 }
 
+// newTestWorkspaces creates a workspace manager rooted in a per-test temp directory
+func newTestWorkspaces(t *testing.T) *workspace.Manager {
+	m, err := workspace.NewManager(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create workspace manager: %v", err)
+	}
+	return m
+}
+
+// newTestManager creates a started job manager backed by a per-test store
+func newTestManager(t *testing.T, solver jobs.Solver) *jobs.Manager {
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open job store: %v", err)
+	}
+	m := jobs.NewManager(solver, st, 1, 4, 0)
+	m.Start()
+	t.Cleanup(func() { m.Stop(context.Background()) }) //nolint:errcheck // Test cleanup
+	return m
+}
+
 // createTestJPEG creates a minimal test JPEG file
 func createTestJPEG(t *testing.T) string {
 	// Create a minimal JPEG file (1x1 pixel red square)
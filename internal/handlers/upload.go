@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
+)
+
+// uploadFormats lists the file extensions a handler accepts
+type uploadFormats struct {
+	exts    map[string]bool
+	invalid string
+}
+
+// solveFormats are the upload formats accepted for plate-solving
+var solveFormats = uploadFormats{
+	exts:    map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".fits": true, ".fit": true},
+	invalid: "Invalid file type. Supported: jpg, jpeg, png, fits, fit",
+}
+
+// analyseFormats are the upload formats that can carry EXIF data
+var analyseFormats = uploadFormats{
+	exts:    map[string]bool{".jpg": true, ".jpeg": true, ".png": true},
+	invalid: "Invalid file type. Supported: jpg, jpeg, png",
+}
+
+// uploadError is a rejected upload, carrying the message and status for the client
+type uploadError struct {
+	message    string
+	statusCode int
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+// saveUpload parses the multipart form and saves the "image" part into the
+// workspace, keeping the upload's extension, and returns the saved path
+func saveUpload(w http.ResponseWriter, r *http.Request, maxUploadSize int64, ws *workspace.Workspace, formats uploadFormats) (string, *multipart.FileHeader, error) {
+	// Limit upload size
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	// Parse multipart form
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		return "", nil, &uploadError{"Failed to parse form", http.StatusBadRequest}
+	}
+
+	// Get uploaded file
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		return "", nil, &uploadError{"Missing or invalid 'image' field", http.StatusBadRequest}
+	}
+	defer file.Close() //nolint:errcheck // Error from Close on read is not critical
+
+	// Validate file extension
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if !formats.exts[ext] {
+		return "", nil, &uploadError{formats.invalid, http.StatusBadRequest}
+	}
+
+	path := ws.Path("image" + ext)
+	if err := writeFile(path, file); err != nil {
+		return "", nil, &uploadError{"Failed to save file", http.StatusInternalServerError}
+	}
+	return path, header, nil
+}
+
+func writeFile(path string, src io.Reader) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close() //nolint:errcheck // Deferred close errors are not critical
+
+	if _, err := io.Copy(out, src); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// uploadErrorStatus returns the client message and status code for a saveUpload failure
+func uploadErrorStatus(err error) (string, int) {
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		return ue.message, ue.statusCode
+	}
+	return err.Error(), http.StatusInternalServerError
+}
+
+// releaseWorkspace removes a request's workspace, logging rather than failing on error
+func releaseWorkspace(ws *workspace.Workspace) {
+	if err := ws.Release(); err != nil {
+		log.Printf("Failed to remove workspace %s: %v", ws.ID, err)
+	}
+}
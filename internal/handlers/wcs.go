@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/fits"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/wcs"
+)
+
+// maxWCSPoints is the most points converted in one request
+const maxWCSPoints = 100000
+
+// WCSHandler converts points between pixel and sky coordinates with a WCS
+// sent by the client, such as the wcs_header of a solve
+type WCSHandler struct {
+	maxUploadSize int64
+}
+
+// NewWCSHandler creates a new WCS handler
+func NewWCSHandler(maxUploadSize int64) *WCSHandler {
+	return &WCSHandler{
+		maxUploadSize: maxUploadSize,
+	}
+}
+
+// WCSRequest is the JSON body of a coordinate conversion
+type WCSRequest struct {
+	// WCSHeader holds the WCS keywords, as in the wcs_header of a solve
+	WCSHeader headerValues `json:"wcs_header"`
+	Points    []WCSPoint   `json:"points"`
+}
+
+// WCSPoint is a position in the image and on the sky. Pixels are 1-based, as
+// in FITS, and the sky position is in degrees.
+type WCSPoint struct {
+	X   *float64 `json:"x"`
+	Y   *float64 `json:"y"`
+	RA  *float64 `json:"ra"`
+	Dec *float64 `json:"dec"`
+	// InImage is whether the pixel lies on the image, or nil when the WCS
+	// does not give the image size
+	InImage *bool `json:"in_image,omitempty"`
+}
+
+// WCSResponse is the result of a coordinate conversion
+type WCSResponse struct {
+	Success     bool       `json:"success"`
+	ImageWidth  int        `json:"image_width,omitempty"`
+	ImageHeight int        `json:"image_height,omitempty"`
+	PixelScale  float64    `json:"pixel_scale,omitempty"`
+	Points      []WCSPoint `json:"points,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// headerValues are header keywords and their values. Values may be sent as
+// JSON strings, numbers or booleans.
+type headerValues map[string]string
+
+// UnmarshalJSON reads an object of keywords, keeping numbers as written
+func (h *headerValues) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*h = make(headerValues, len(raw))
+	for key, value := range raw {
+		var s string
+		var b bool
+		switch {
+		case json.Unmarshal(value, &s) == nil:
+			(*h)[key] = s
+		case json.Unmarshal(value, &b) == nil:
+			// FITS writes logical values as T and F
+			(*h)[key] = "F"
+			if b {
+				(*h)[key] = "T"
+			}
+		default:
+			var n json.Number
+			if err := json.Unmarshal(value, &n); err != nil {
+				return fmt.Errorf("invalid value for %s: %s", key, value)
+			}
+			(*h)[key] = n.String()
+		}
+	}
+	return nil
+}
+
+// ServeHTTP routes POST /wcs/pix2sky and /wcs/sky2pix
+func (h *WCSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var toSky bool
+	switch r.URL.Path {
+	case "/wcs/pix2sky":
+		toSky = true
+	case "/wcs/sky2pix":
+	default:
+		respondWCSError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		respondWCSError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := h.parseRequest(w, r)
+	if err != nil {
+		message, statusCode := uploadErrorStatus(err)
+		respondWCSError(w, message, statusCode)
+		return
+	}
+	solution, err := wcs.Parse(req.WCSHeader)
+	if err != nil {
+		respondWCSError(w, "Invalid WCS: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if toSky {
+		h.pix2sky(w, solution, req.Points)
+	} else {
+		h.sky2pix(w, solution, req.Points)
+	}
+}
+
+// pix2sky godoc
+//
+//	@Summary		Convert pixels to sky coordinates
+//	@Description	Converts pixel positions to RA/Dec (J2000, degrees) with a TAN or TAN-SIP WCS, such as the wcs_header returned by /solve. Pixels are 1-based as in FITS, with (1, 1) the centre of the first pixel. Send a JSON WCSRequest with x and y set on each point, or a multipart form with the WCS as a FITS header file in wcs_file (e.g. a .wcs file from format=wcs) or as JSON in wcs_header, and the points as JSON in points. in_image tells whether each pixel lies on the image, and is left out when the WCS gives no image size.
+//	@Tags			WCS
+//	@Accept			json,multipart/form-data
+//	@Produce		json
+//	@Param			request		body		WCSRequest	false	"WCS and points (JSON)"
+//	@Param			wcs_file	formData	file		false	"FITS file holding the WCS (multipart)"
+//	@Param			wcs_header	formData	string		false	"WCS keywords as a JSON object (multipart)"
+//	@Param			points		formData	string		false	"Points as a JSON array (multipart)"
+//	@Success		200			{object}	WCSResponse	"Points with ra and dec set"
+//	@Failure		400			{object}	WCSResponse	"Bad request or unsupported WCS"
+//	@Failure		405			{object}	WCSResponse	"Method not allowed"
+//	@Failure		413			{object}	WCSResponse	"Request too large"
+//	@Router			/wcs/pix2sky [post]
+func (h *WCSHandler) pix2sky(w http.ResponseWriter, solution *wcs.WCS, points []WCSPoint) {
+	result := make([]WCSPoint, len(points))
+	for i, p := range points {
+		if p.X == nil || p.Y == nil || !finite(*p.X) || !finite(*p.Y) {
+			respondWCSError(w, fmt.Sprintf("Point %d needs numeric x and y", i), http.StatusBadRequest)
+			return
+		}
+		ra, dec := solution.PixelToSky(*p.X, *p.Y)
+		result[i] = WCSPoint{X: p.X, Y: p.Y, RA: &ra, Dec: &dec, InImage: inImage(solution, *p.X, *p.Y)}
+	}
+	writeJSON(w, http.StatusOK, newWCSResponse(solution, result))
+}
+
+// sky2pix godoc
+//
+//	@Summary		Convert sky coordinates to pixels
+//	@Description	Converts RA/Dec positions (J2000, degrees) to 1-based pixels with a TAN or TAN-SIP WCS, taking the WCS and points as /wcs/pix2sky does with ra and dec set on each point. A position more than 90 degrees from the image centre cannot be projected: its x and y are null and in_image is false.
+//	@Tags			WCS
+//	@Accept			json,multipart/form-data
+//	@Produce		json
+//	@Param			request		body		WCSRequest	false	"WCS and points (JSON)"
+//	@Param			wcs_file	formData	file		false	"FITS file holding the WCS (multipart)"
+//	@Param			wcs_header	formData	string		false	"WCS keywords as a JSON object (multipart)"
+//	@Param			points		formData	string		false	"Points as a JSON array (multipart)"
+//	@Success		200			{object}	WCSResponse	"Points with x and y set"
+//	@Failure		400			{object}	WCSResponse	"Bad request or unsupported WCS"
+//	@Failure		405			{object}	WCSResponse	"Method not allowed"
+//	@Failure		413			{object}	WCSResponse	"Request too large"
+//	@Router			/wcs/sky2pix [post]
+func (h *WCSHandler) sky2pix(w http.ResponseWriter, solution *wcs.WCS, points []WCSPoint) {
+	result := make([]WCSPoint, len(points))
+	for i, p := range points {
+		if p.RA == nil || p.Dec == nil || !finite(*p.RA) || !finite(*p.Dec) || math.Abs(*p.Dec) > 90 {
+			respondWCSError(w, fmt.Sprintf("Point %d needs numeric ra and dec, with dec from -90 to 90", i), http.StatusBadRequest)
+			return
+		}
+		result[i] = WCSPoint{RA: p.RA, Dec: p.Dec}
+		x, y, ok := solution.SkyToPixel(*p.RA, *p.Dec)
+		if !ok {
+			if solution.Width > 0 && solution.Height > 0 {
+				result[i].InImage = new(bool)
+			}
+			continue
+		}
+		result[i].X, result[i].Y = &x, &y
+		result[i].InImage = inImage(solution, x, y)
+	}
+	writeJSON(w, http.StatusOK, newWCSResponse(solution, result))
+}
+
+// parseRequest reads the WCS and points from a JSON body or a multipart form
+func (h *WCSHandler) parseRequest(w http.ResponseWriter, r *http.Request) (*WCSRequest, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+	req := &WCSRequest{}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(h.maxUploadSize); err != nil {
+			return nil, tooLargeOr(err, "Failed to parse form")
+		}
+		if err := decodeField(r.FormValue("points"), &req.Points); err != nil {
+			return nil, &uploadError{"Invalid 'points' field: must be a JSON array of points", http.StatusBadRequest}
+		}
+		if value := r.FormValue("wcs_header"); value != "" {
+			if err := decodeField(value, &req.WCSHeader); err != nil {
+				return nil, &uploadError{"Invalid 'wcs_header' field: must be a JSON object of keywords", http.StatusBadRequest}
+			}
+		} else if file, header, err := r.FormFile("wcs_file"); err == nil {
+			defer file.Close() //nolint:errcheck // Error from Close on read is not critical
+			if req.WCSHeader, err = readWCSFile(file, header.Size); err != nil {
+				return nil, &uploadError{"Invalid 'wcs_file' field: " + err.Error(), http.StatusBadRequest}
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, tooLargeOr(err, "Invalid JSON body: "+err.Error())
+	}
+
+	switch {
+	case len(req.WCSHeader) == 0:
+		return nil, &uploadError{"Missing WCS: send wcs_header or wcs_file", http.StatusBadRequest}
+	case len(req.Points) == 0:
+		return nil, &uploadError{"Missing 'points': send at least one point", http.StatusBadRequest}
+	case len(req.Points) > maxWCSPoints:
+		return nil, &uploadError{fmt.Sprintf("Too many points: at most %d may be sent", maxWCSPoints), http.StatusBadRequest}
+	}
+	return req, nil
+}
+
+// tooLargeOr returns a 413 if err came from a body over the size limit, or a
+// 400 with message otherwise
+func tooLargeOr(err error, message string) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &uploadError{"Request too large", http.StatusRequestEntityTooLarge}
+	}
+	return &uploadError{message, http.StatusBadRequest}
+}
+
+// decodeField decodes the JSON in a form field into v
+func decodeField(value string, v any) error {
+	if value == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(value), v)
+}
+
+// readWCSFile returns the keywords of the first HDU of a FITS file that holds
+// a WCS, or of the primary HDU if none does
+func readWCSFile(src io.ReaderAt, size int64) (map[string]string, error) {
+	f, err := fits.Read(src, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, hdu := range f.HDUs {
+		if _, ok := hdu.Header.Get("CTYPE1"); ok {
+			return hdu.Header.Values(), nil
+		}
+	}
+	return f.HDUs[0].Header.Values(), nil
+}
+
+func newWCSResponse(solution *wcs.WCS, points []WCSPoint) *WCSResponse {
+	return &WCSResponse{
+		Success:     true,
+		ImageWidth:  solution.Width,
+		ImageHeight: solution.Height,
+		PixelScale:  solution.PixelScale(),
+		Points:      points,
+	}
+}
+
+// inImage reports whether a pixel lies on the image, or nil if the image size
+// is not known
+func inImage(solution *wcs.WCS, x, y float64) *bool {
+	if solution.Width <= 0 || solution.Height <= 0 {
+		return nil
+	}
+	in := solution.Contains(x, y)
+	return &in
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func respondWCSError(w http.ResponseWriter, message string, statusCode int) {
+	writeJSON(w, statusCode, &WCSResponse{Error: message})
+}
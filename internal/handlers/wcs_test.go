@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/fits"
+)
+
+// testWCSHeader is the wcs_header of a TAN-SIP solve of a 1024x768 image of M42
+var testWCSHeader = map[string]string{
+	"CTYPE1": "RA---TAN-SIP", "CTYPE2": "DEC--TAN-SIP",
+	"CRVAL1": "83.8221", "CRVAL2": "-5.3911",
+	"CRPIX1": "512.5", "CRPIX2": "384.5",
+	"CD1_1": "-0.000275", "CD1_2": "0", "CD2_1": "0", "CD2_2": "-0.000275",
+	"IMAGEW": "1024", "IMAGEH": "768",
+	"A_ORDER": "2", "A_2_0": "4.0E-07", "B_ORDER": "2", "B_0_2": "-3.2E-07",
+}
+
+func postWCS(t *testing.T, path string, body any) (*httptest.ResponseRecorder, WCSResponse) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	NewWCSHandler(1024*1024).ServeHTTP(w, req)
+
+	var response WCSResponse
+	if err := json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return w, response
+}
+
+func TestWCSHandler_RoundTrip(t *testing.T) {
+	w, response := postWCS(t, "/wcs/pix2sky", map[string]any{
+		"wcs_header": testWCSHeader,
+		"points":     []map[string]float64{{"x": 512.5, "y": 384.5}, {"x": 1, "y": 768}, {"x": 2000, "y": 10}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if response.ImageWidth != 1024 || response.ImageHeight != 768 || math.Abs(response.PixelScale-0.99) > 0.001 {
+		t.Errorf("expected a 1024x768 image at 0.99\"/px, got %+v", response)
+	}
+	if len(response.Points) != 3 {
+		t.Fatalf("expected 3 points, got %d", len(response.Points))
+	}
+	center := response.Points[0]
+	if math.Abs(*center.RA-83.8221) > 1e-9 || math.Abs(*center.Dec+5.3911) > 1e-9 {
+		t.Errorf("expected the reference pixel at CRVAL, got %f %f", *center.RA, *center.Dec)
+	}
+	if !*response.Points[1].InImage || *response.Points[2].InImage {
+		t.Error("expected only the first two points in the image")
+	}
+
+	corner := response.Points[1]
+	w, response = postWCS(t, "/wcs/sky2pix", map[string]any{
+		"wcs_header": testWCSHeader,
+		"points":     []map[string]float64{{"ra": *corner.RA, "dec": *corner.Dec}, {"ra": 263.8221, "dec": 5.3911}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	back := response.Points[0]
+	if back.X == nil || math.Abs(*back.X-1) > 1e-6 || math.Abs(*back.Y-768) > 1e-6 || !*back.InImage {
+		t.Errorf("expected pixel (1, 768) in the image back, got %+v", back)
+	}
+	if antipode := response.Points[1]; antipode.X != nil || antipode.Y != nil || *antipode.InImage {
+		t.Errorf("expected the antipode not to project, got %+v", antipode)
+	}
+}
+
+func TestWCSHandler_NumericHeader(t *testing.T) {
+	// Values may be JSON numbers, and without IMAGEW/IMAGEH in_image is left out
+	w, response := postWCS(t, "/wcs/pix2sky", map[string]any{
+		"wcs_header": map[string]any{
+			"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN", "CRVAL1": 10, "CRVAL2": 20,
+			"CRPIX1": 1, "CRPIX2": 1, "CDELT1": -0.001, "CDELT2": 0.001,
+		},
+		"points": []map[string]float64{{"x": 1, "y": 1}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if p := response.Points[0]; *p.RA != 10 || *p.Dec != 20 || p.InImage != nil {
+		t.Errorf("expected (10, 20) without in_image, got %+v", p)
+	}
+	if strings.Contains(w.Body.String(), "in_image") {
+		t.Error("expected in_image to be left out of the JSON")
+	}
+}
+
+func TestWCSHandler_FITSFile(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("wcs_file", "m42.wcs")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(fits.WCSHeader(testWCSHeader).Encode())               //nolint:errcheck // Writes to a buffer
+	writer.WriteField("points", `[{"ra": 83.8221, "dec": -5.3911}]`) //nolint:errcheck // Writes to a buffer
+	writer.Close()                                                   //nolint:errcheck // Writes to a buffer
+
+	req := httptest.NewRequest(http.MethodPost, "/wcs/sky2pix", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	NewWCSHandler(1024*1024).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response WCSResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if p := response.Points[0]; math.Abs(*p.X-512.5) > 1e-6 || math.Abs(*p.Y-384.5) > 1e-6 {
+		t.Errorf("expected the reference pixel, got %f %f", *p.X, *p.Y)
+	}
+}
+
+func TestWCSHandler_CraftedFITSFile(t *testing.T) {
+	// An extension with a negative GCOUNT once sent the reader back to the
+	// same header forever
+	data := fits.WCSHeader(testWCSHeader).Encode()
+	data = append(data, fits.Header{
+		{Key: "XTENSION", Value: "'BINTABLE'"}, {Key: "BITPIX", Value: "8"}, {Key: "NAXIS", Value: "2"},
+		{Key: "NAXIS1", Value: "8"}, {Key: "NAXIS2", Value: "2"}, {Key: "GCOUNT", Value: "-2"},
+	}.Encode()...)
+	data = append(data, make([]byte, 2*fits.BlockSize)...)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("wcs_file", "crafted.wcs")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(data)                                                 //nolint:errcheck // Writes to a buffer
+	writer.WriteField("points", `[{"ra": 83.8221, "dec": -5.3911}]`) //nolint:errcheck // Writes to a buffer
+	writer.Close()                                                   //nolint:errcheck // Writes to a buffer
+
+	req := httptest.NewRequest(http.MethodPost, "/wcs/sky2pix", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewWCSHandler(1024*1024).ServeHTTP(w, req)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request to finish")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWCSHandler_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		path string
+		body any
+		want int
+	}{
+		"no wcs":     {"/wcs/pix2sky", map[string]any{"points": []map[string]float64{{"x": 1, "y": 1}}}, http.StatusBadRequest},
+		"no points":  {"/wcs/pix2sky", map[string]any{"wcs_header": testWCSHeader}, http.StatusBadRequest},
+		"missing y":  {"/wcs/pix2sky", map[string]any{"wcs_header": testWCSHeader, "points": []map[string]float64{{"x": 1}}}, http.StatusBadRequest},
+		"bad dec":    {"/wcs/sky2pix", map[string]any{"wcs_header": testWCSHeader, "points": []map[string]float64{{"ra": 1, "dec": 91}}}, http.StatusBadRequest},
+		"projection": {"/wcs/pix2sky", map[string]any{"wcs_header": map[string]string{"CTYPE1": "RA---SIN", "CTYPE2": "DEC--SIN"}, "points": []map[string]float64{{"x": 1, "y": 1}}}, http.StatusBadRequest},
+		"not json":   {"/wcs/pix2sky", "points", http.StatusBadRequest},
+		"unknown op": {"/wcs/sky2gal", map[string]any{}, http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		w, response := postWCS(t, tt.path, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", name, tt.want, w.Code)
+		}
+		if response.Success || response.Error == "" {
+			t.Errorf("%s: expected an error, got %+v", name, response)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/wcs/pix2sky", nil)
+	w := httptest.NewRecorder()
+	NewWCSHandler(1024*1024).ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
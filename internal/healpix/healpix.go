@@ -0,0 +1,270 @@
+// Package healpix maps sky positions to HEALPix cells in the nested numbering
+// scheme, and finds the cells covered by a sky polygon
+package healpix
+
+import (
+	"math"
+)
+
+// MaxOrder is the finest order supported, at which cells are about 0.4
+// milliarcseconds across
+const MaxOrder = 29
+
+// Rings and longitudes of the corners of the 12 base cells, in the units of
+// the HEALPix reference implementation
+var (
+	jrll = [12]float64{2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4}
+	jpll = [12]float64{1, 3, 5, 7, 0, 2, 4, 6, 1, 3, 5, 7}
+)
+
+const deg = math.Pi / 180
+
+// NumCells returns the number of cells covering the sky at an order
+func NumCells(order int) uint64 {
+	return 12 << (2 * order)
+}
+
+// Cell returns the nested index of the cell at an order holding a position
+// in degrees
+func Cell(order int, ra, dec float64) uint64 {
+	nside := int64(1) << order
+	z := math.Sin(dec * deg)
+	za := math.Abs(z)
+	tt := math.Mod(ra/90, 4)
+	if tt < 0 {
+		tt += 4
+	}
+
+	if za <= 2.0/3 {
+		temp1 := float64(nside) * (0.5 + tt)
+		temp2 := float64(nside) * z * 0.75
+		jp := int64(temp1 - temp2)
+		jm := int64(temp1 + temp2)
+		ifp, ifm := jp>>order, jm>>order
+		var face int64
+		switch {
+		case ifp == ifm:
+			face = ifp | 4
+		case ifp < ifm:
+			face = ifp
+		default:
+			face = ifm + 8
+		}
+		ix := jm & (nside - 1)
+		iy := nside - (jp & (nside - 1)) - 1
+		return nest(order, ix, iy, face)
+	}
+
+	ntt := min(3, int64(tt))
+	tp := tt - float64(ntt)
+	// Near the poles 1-|z| loses precision, so the cosine of the declination
+	// is used instead
+	var tmp float64
+	if za < 0.99 {
+		tmp = float64(nside) * math.Sqrt(3*(1-za))
+	} else {
+		tmp = float64(nside) * math.Cos(dec*deg) / math.Sqrt((1+za)/3)
+	}
+	jp := min(int64(tp*tmp), nside-1)
+	jm := min(int64((1-tp)*tmp), nside-1)
+	if z >= 0 {
+		return nest(order, nside-jm-1, nside-jp-1, ntt)
+	}
+	return nest(order, jp, jm, ntt+8)
+}
+
+// Center returns the position of the centre of a cell in degrees
+func Center(order int, cell uint64) (ra, dec float64) {
+	return locate(order, cell, 0.5, 0.5)
+}
+
+// Corners returns the positions of the corners of a cell in degrees: north,
+// west, south and east
+func Corners(order int, cell uint64) [4][2]float64 {
+	var corners [4][2]float64
+	for i, offset := range [4][2]float64{{1, 1}, {0, 1}, {0, 0}, {1, 0}} {
+		corners[i][0], corners[i][1] = locate(order, cell, offset[0], offset[1])
+	}
+	return corners
+}
+
+// QueryPolygon returns the cells at an order that a convex polygon, given as
+// its vertices in degrees in either direction, covers or touches, in
+// ascending order. Cells along the edges are included if they may overlap
+// the polygon, so a few may only come close to it. It is false if there are
+// more than limit cells.
+func QueryPolygon(order int, vertices [][2]float64, limit int) ([]uint64, bool) {
+	p := newPolygon(vertices)
+	var cells []uint64
+	var visit func(level int, cell uint64) bool
+	visit = func(level int, cell uint64) bool {
+		center, radius := bounds(level, cell)
+		inside, distance := p.locate(center)
+		switch {
+		case !inside && distance > radius:
+			return true
+		case inside && distance > radius || level == order:
+			// The cell and all its descendants at order are covered
+			shift := 2 * (order - level)
+			first, last := cell<<shift, (cell+1)<<shift
+			if uint64(len(cells))+last-first > uint64(limit) {
+				return false
+			}
+			for c := first; c < last; c++ {
+				cells = append(cells, c)
+			}
+			return true
+		}
+		for child := cell * 4; child < cell*4+4; child++ {
+			if !visit(level+1, child) {
+				return false
+			}
+		}
+		return true
+	}
+	for base := range uint64(12) {
+		if !visit(0, base) {
+			return nil, false
+		}
+	}
+	return cells, true
+}
+
+// nest returns the nested index of the pixel (ix, iy) of a base cell
+func nest(order int, ix, iy, face int64) uint64 {
+	return uint64(face)<<(2*order) | spread(uint64(ix)) | spread(uint64(iy))<<1
+}
+
+// spread moves the bits of v to the even bit positions
+func spread(v uint64) uint64 {
+	var r uint64
+	for i := range 32 {
+		r |= (v >> i & 1) << (2 * i)
+	}
+	return r
+}
+
+// compact undoes spread
+func compact(v uint64) uint64 {
+	var r uint64
+	for i := range 32 {
+		r |= (v >> (2 * i) & 1) << i
+	}
+	return r
+}
+
+// locate returns the position in degrees of the point at (dx, dy) within a
+// cell, with (0, 0) its south corner and (1, 1) its north one
+func locate(order int, cell uint64, dx, dy float64) (ra, dec float64) {
+	nside := float64(uint64(1) << order)
+	face := cell >> (2 * order)
+	pixel := cell & (uint64(1)<<(2*order) - 1)
+	x := (float64(compact(pixel)) + dx) / nside
+	y := (float64(compact(pixel>>1)) + dy) / nside
+
+	jr := jrll[face] - x - y
+	var nr, z float64
+	switch {
+	case jr < 1:
+		nr = jr
+		z = 1 - nr*nr/3
+	case jr > 3:
+		nr = 4 - jr
+		z = nr*nr/3 - 1
+	default:
+		nr = 1
+		z = (2 - jr) * 2 / 3
+	}
+	tmp := jpll[face]*nr + x - y
+	if tmp < 0 {
+		tmp += 8
+	}
+	if tmp >= 8 {
+		tmp -= 8
+	}
+	if nr > 1e-15 {
+		ra = 45 * tmp / nr
+	}
+	return ra, math.Asin(max(-1, min(1, z))) / deg
+}
+
+// bounds returns the centre of a cell as a unit vector and the angle in
+// radians from it to the furthest point of the cell's edge
+func bounds(order int, cell uint64) ([3]float64, float64) {
+	center := vector(Center(order, cell))
+	radius := 0.0
+	for _, offset := range [8][2]float64{{0, 0}, {0, 0.5}, {0, 1}, {0.5, 1}, {1, 1}, {1, 0.5}, {1, 0}, {0.5, 0}} {
+		radius = max(radius, angle(center, vector(locate(order, cell, offset[0], offset[1]))))
+	}
+	// The edges between the points bulge slightly beyond them
+	return center, radius * 1.05
+}
+
+// polygon is a convex sky polygon as unit vectors, with the normals of its
+// edges
+type polygon struct {
+	vertices [][3]float64
+	normals  [][3]float64
+}
+
+func newPolygon(vertices [][2]float64) *polygon {
+	p := &polygon{}
+	for _, v := range vertices {
+		p.vertices = append(p.vertices, vector(v[0], v[1]))
+	}
+	for i, a := range p.vertices {
+		p.normals = append(p.normals, normalize(cross(a, p.vertices[(i+1)%len(p.vertices)])))
+	}
+	// The normals point inwards, whichever way round the vertices are given
+	if len(p.vertices) > 2 && dot(p.normals[0], p.vertices[2]) < 0 {
+		for i, n := range p.normals {
+			p.normals[i] = [3]float64{-n[0], -n[1], -n[2]}
+		}
+	}
+	return p
+}
+
+// locate reports whether v lies inside the polygon and its angular distance
+// in radians from the polygon's edge
+func (p *polygon) locate(v [3]float64) (inside bool, distance float64) {
+	inside = true
+	distance = math.Inf(1)
+	for i, n := range p.normals {
+		s := dot(n, v)
+		inside = inside && s >= 0
+		a, b := p.vertices[i], p.vertices[(i+1)%len(p.vertices)]
+		// The closest point of the edge's great circle may lie off the edge,
+		// in which case the nearer end is closest
+		foot := normalize([3]float64{v[0] - s*n[0], v[1] - s*n[1], v[2] - s*n[2]})
+		if edge := cross(a, b); dot(cross(a, foot), edge) >= 0 && dot(cross(foot, b), edge) >= 0 {
+			distance = min(distance, math.Asin(min(1, math.Abs(s))))
+		} else {
+			distance = min(distance, angle(v, a), angle(v, b))
+		}
+	}
+	return inside, distance
+}
+
+func vector(ra, dec float64) [3]float64 {
+	sinA, cosA := math.Sincos(ra * deg)
+	sinD, cosD := math.Sincos(dec * deg)
+	return [3]float64{cosD * cosA, cosD * sinA, sinD}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func normalize(v [3]float64) [3]float64 {
+	n := math.Sqrt(dot(v, v))
+	return [3]float64{v[0] / n, v[1] / n, v[2] / n}
+}
+
+// angle returns the angle between two unit vectors in radians
+func angle(a, b [3]float64) float64 {
+	return math.Atan2(math.Sqrt(dot(cross(a, b), cross(a, b))), dot(a, b))
+}
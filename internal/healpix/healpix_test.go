@@ -0,0 +1,106 @@
+package healpix
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestCell_RoundTrip(t *testing.T) {
+	for order := range 5 {
+		for cell := range NumCells(order) {
+			ra, dec := Center(order, cell)
+			if got := Cell(order, ra, dec); got != cell {
+				t.Fatalf("order %d: expected cell %d at its centre (%f, %f), got %d", order, cell, ra, dec, got)
+			}
+		}
+	}
+}
+
+func TestCell(t *testing.T) {
+	tests := []struct {
+		order   int
+		ra, dec float64
+		want    uint64
+	}{
+		{0, 0, 90, 0},
+		{0, 0, -90, 8},
+		{0, 0, 0, 4},
+		{0, 45, 41.8103, 0},
+		{0, 135, 41.8103, 1},
+		{0, 315, -41.8103, 11},
+		{1, 10, 10, 19},
+	}
+	for _, tt := range tests {
+		if got := Cell(tt.order, tt.ra, tt.dec); got != tt.want {
+			t.Errorf("order %d (%f, %f): expected %d, got %d", tt.order, tt.ra, tt.dec, tt.want, got)
+		}
+	}
+
+	// Each cell at one order holds the cells at the next with the same prefix
+	ra, dec := 83.8221, -5.3911
+	for order := range MaxOrder {
+		if Cell(order+1, ra, dec)>>2 != Cell(order, ra, dec) {
+			t.Fatalf("order %d: expected the parent of the cell at the next order", order)
+		}
+	}
+
+	if ra, dec := Center(0, 0); math.Abs(ra-45) > 1e-9 || math.Abs(dec-41.810315) > 1e-6 {
+		t.Errorf("expected cell 0 centred at (45, 41.810315), got (%f, %f)", ra, dec)
+	}
+	corners := Corners(0, 4)
+	if math.Abs(corners[0][1]-41.810315) > 1e-6 || math.Abs(corners[2][1]+41.810315) > 1e-6 {
+		t.Errorf("expected cell 4 to run from -41.81 to 41.81 in dec, got %v", corners)
+	}
+}
+
+func TestQueryPolygon(t *testing.T) {
+	// The corners of a 1x0.75 degree field around M42, tilted on the sky
+	field := [][2]float64{{84.40, -5.60}, {83.40, -5.75}, {83.30, -5.00}, {84.30, -4.85}}
+	const order = 8
+	cells, ok := QueryPolygon(order, field, 10000)
+	if !ok {
+		t.Fatal("expected the cells to fit the limit")
+	}
+	if !slices.IsSorted(cells) {
+		t.Error("expected the cells in ascending order")
+	}
+	if !slices.Contains(cells, Cell(order, 83.8221, -5.3911)) {
+		t.Error("expected the cell holding the field centre")
+	}
+
+	// Every cell whose centre is in the field is found, and every cell found
+	// is near it
+	p := newPolygon(field)
+	found := make(map[uint64]bool)
+	for _, cell := range cells {
+		found[cell] = true
+	}
+	for cell := range NumCells(order) {
+		center, radius := bounds(order, cell)
+		inside, distance := p.locate(center)
+		if inside && !found[cell] {
+			t.Errorf("expected cell %d, whose centre is in the field", cell)
+		}
+		if found[cell] && !inside && distance > radius {
+			t.Errorf("expected cell %d, %f degrees from the field, to be left out", cell, distance/deg)
+		}
+	}
+
+	// The order-8 cells are 0.23 degrees across, so the 0.75 square degree
+	// field touches a few dozen of them
+	if len(cells) < 14 || len(cells) > 60 {
+		t.Errorf("expected a few dozen cells, got %d", len(cells))
+	}
+
+	if _, ok := QueryPolygon(14, field, 10000); ok {
+		t.Error("expected more cells than the limit at order 14")
+	}
+
+	// Reversing the vertices gives the same cells
+	reversed := slices.Clone(field)
+	slices.Reverse(reversed)
+	if again, _ := QueryPolygon(order, reversed, 10000); !slices.Equal(again, cells) {
+		t.Errorf("expected the same cells for the reversed polygon, got %v and %v", again, cells)
+	}
+}
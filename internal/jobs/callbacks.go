@@ -0,0 +1,116 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// CallbackStatus describes the delivery of a job's result to its callback URL
+type CallbackStatus string
+
+// Callback statuses
+const (
+	CallbackPending   CallbackStatus = "pending"
+	CallbackDelivered CallbackStatus = "delivered"
+	CallbackFailed    CallbackStatus = "failed"
+)
+
+// ErrInvalidCallback is returned by Submit when the callback URL cannot be used
+var ErrInvalidCallback = errors.New("invalid callback URL")
+
+// Callback is the delivery log of a job's callback
+type Callback struct {
+	URL        string         `json:"url"`
+	Status     CallbackStatus `json:"status"`
+	Deliveries []Delivery     `json:"deliveries,omitempty"`
+}
+
+// Delivery records one attempt to deliver a job's result
+type Delivery struct {
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Deliverer sends finished jobs to their callback URLs
+type Deliverer interface {
+	// CheckURL reports whether url may be used as a callback URL
+	CheckURL(url string) error
+	// Deliver sends the job to its callback URL, retrying as it sees fit and
+	// passing every attempt to record. It returns nil once the job was delivered.
+	Deliver(ctx context.Context, job Job, record func(Delivery)) error
+}
+
+// SetDeliverer enables callbacks. It must be called before Recover for
+// callbacks interrupted by a restart to be resumed.
+func (m *Manager) SetDeliverer(d Deliverer) {
+	m.mu.Lock()
+	m.deliverer = d
+	m.mu.Unlock()
+}
+
+func (m *Manager) checkCallback(url string) error {
+	m.mu.RLock()
+	d := m.deliverer
+	m.mu.RUnlock()
+
+	if d == nil {
+		return fmt.Errorf("%w: callbacks are not enabled on this server", ErrInvalidCallback)
+	}
+	if err := d.CheckURL(url); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+	return nil
+}
+
+// callbackPending reports whether the job's result still has to be delivered
+func (j *Job) callbackPending() bool {
+	return j.Callback != nil && j.Callback.Status == CallbackPending
+}
+
+// deliverLocked starts delivering a finished job's result in the background.
+// Deliveries interrupted by shutdown stay pending and are resumed by Recover.
+func (m *Manager) deliverLocked(job *Job) {
+	if m.deliverer == nil {
+		log.Printf("Job %s: callbacks are disabled, not delivering to %s", job.ID, job.Callback.URL)
+		job.Callback.Status = CallbackFailed
+		m.persistLocked(job)
+		return
+	}
+
+	if m.stopped {
+		// Left pending for the next run
+		return
+	}
+
+	d := m.deliverer
+	snapshot := job.snapshot()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := d.Deliver(m.ctx, snapshot, func(d Delivery) {
+			m.mu.Lock()
+			job.Callback.Deliveries = append(job.Callback.Deliveries, d)
+			m.persistLocked(job)
+			m.unlock()
+		})
+		if m.ctx.Err() != nil {
+			return
+		}
+
+		m.mu.Lock()
+		defer m.unlock()
+		if err != nil {
+			job.Callback.Status = CallbackFailed
+			log.Printf("Job %s: callback to %s failed: %v", job.ID, job.Callback.URL, err)
+		} else {
+			job.Callback.Status = CallbackDelivered
+			log.Printf("Job %s: delivered to %s", job.ID, job.Callback.URL)
+		}
+		m.persistLocked(job)
+	}()
+}
@@ -0,0 +1,124 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// fakeDeliverer fails a number of attempts before delivering
+type fakeDeliverer struct {
+	failures int
+
+	mu        sync.Mutex
+	delivered []Job
+}
+
+func (d *fakeDeliverer) CheckURL(url string) error {
+	if url != "https://hooks.example.com/solved" {
+		return errors.New("host is not allowed")
+	}
+	return nil
+}
+
+func (d *fakeDeliverer) Deliver(ctx context.Context, job Job, record func(Delivery)) error {
+	for i := 1; i <= d.failures; i++ {
+		record(Delivery{Attempt: i, At: time.Now(), StatusCode: 503, Error: "unavailable"})
+	}
+	record(Delivery{Attempt: d.failures + 1, At: time.Now(), StatusCode: 200})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.delivered = append(d.delivered, job)
+	return nil
+}
+
+func waitForCallback(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := m.Get(id); ok && job.Callback != nil && job.Callback.Status != CallbackPending {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("callback for job %s was not delivered in time", id)
+	return Job{}
+}
+
+func TestManager_CallbackDelivered(t *testing.T) {
+	st := newTestStore(t)
+	deliverer := &fakeDeliverer{failures: 2}
+	m := NewManager(solverFunc(solved), st, 1, 4, 0)
+	m.SetDeliverer(deliverer)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	job, err := m.Submit(Request{ID: NewID(), ImagePath: "image.jpg", CallbackURL: "https://hooks.example.com/solved"})
+	if err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+
+	done := waitForCallback(t, m, job.ID)
+	if done.Callback.Status != CallbackDelivered {
+		t.Errorf("expected callback delivered, got %s", done.Callback.Status)
+	}
+	if len(done.Callback.Deliveries) != 3 {
+		t.Errorf("expected 3 logged deliveries, got %+v", done.Callback.Deliveries)
+	}
+	if len(deliverer.delivered) != 1 || deliverer.delivered[0].Status != StatusSolved {
+		t.Errorf("expected the solved job to be delivered, got %+v", deliverer.delivered)
+	}
+
+	// The delivery log survives a restart
+	var stored Job
+	if err := st.Get(job.ID, &stored); err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if stored.Callback == nil || stored.Callback.Status != CallbackDelivered || len(stored.Callback.Deliveries) != 3 {
+		t.Errorf("expected persisted delivery log, got %+v", stored.Callback)
+	}
+}
+
+func TestManager_CallbackRejected(t *testing.T) {
+	m := NewManager(solverFunc(solved), nil, 1, 4, 0)
+	if _, err := m.Submit(Request{ID: NewID(), CallbackURL: "https://hooks.example.com/solved"}); !errors.Is(err, ErrInvalidCallback) {
+		t.Errorf("expected ErrInvalidCallback without a deliverer, got %v", err)
+	}
+
+	m.SetDeliverer(&fakeDeliverer{})
+	if _, err := m.Submit(Request{ID: NewID(), CallbackURL: "https://evil.example.com/"}); !errors.Is(err, ErrInvalidCallback) {
+		t.Errorf("expected ErrInvalidCallback for a disallowed host, got %v", err)
+	}
+}
+
+func TestManager_RecoverPendingCallback(t *testing.T) {
+	st := newTestStore(t)
+	pending := Job{
+		ID:         NewID(),
+		Status:     StatusSolved,
+		Result:     &client.Result{Solved: true},
+		FinishedAt: time.Now(),
+		Callback:   &Callback{URL: "https://hooks.example.com/solved", Status: CallbackPending},
+	}
+	if err := st.Put(pending.ID, &pending); err != nil {
+		t.Fatalf("failed to store job: %v", err)
+	}
+
+	deliverer := &fakeDeliverer{}
+	m := NewManager(solverFunc(solved), st, 1, 4, 0)
+	m.SetDeliverer(deliverer)
+	if _, err := m.Recover(newTestWorkspaces(t, t.TempDir())); err != nil {
+		t.Fatalf("unexpected recover error: %v", err)
+	}
+	m.Start()
+	defer m.Stop(context.Background())
+
+	if done := waitForCallback(t, m, pending.ID); done.Callback.Status != CallbackDelivered {
+		t.Errorf("expected resumed callback to be delivered, got %s", done.Callback.Status)
+	}
+}
@@ -0,0 +1,914 @@
+// Package jobs runs plate-solves, recording each one in a durable store, either
+// synchronously for the caller or asynchronously on a bounded worker pool
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/cache"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/escalate"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solvelog"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solver"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/store"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+	"github.com/DiarmuidKelly/astrometry-go-client/fov"
+)
+
+// Status describes where a job is in its lifecycle
+type Status string
+
+// Job statuses
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSolved    Status = "solved"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// ErrQueueFull is returned by Submit when no more jobs can be accepted
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrStopped is returned by Submit and Run after the manager has been stopped
+var ErrStopped = errors.New("job manager is stopped")
+
+// ErrCancelled is recorded for jobs cancelled with Cancel
+var ErrCancelled = errors.New("solve was cancelled")
+
+// ErrNotFound is returned by Cancel for unknown job IDs
+var ErrNotFound = errors.New("job not found")
+
+// ErrFinished is returned by Cancel for jobs that have already finished
+var ErrFinished = errors.New("job has already finished")
+
+// errImageLost is recorded for unfinished jobs whose upload did not survive a restart
+var errImageLost = errors.New("uploaded image was lost while the server restarted")
+
+// Solver is the subset of the astrometry client used to run jobs
+type Solver interface {
+	Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error)
+}
+
+// Request describes a solve to run
+type Request struct {
+	ID        string
+	Filename  string
+	ImagePath string
+	Workspace *workspace.Workspace
+	Options   *client.SolveOptions
+	// CallbackURL, if set, receives the result once the job finishes
+	CallbackURL string
+	// NoCache makes the job solve the image even if a cached result exists
+	NoCache bool
+	// Escalation, if set, retries a solve that finds no solution with looser
+	// options. An empty ladder or zero budget is filled in from the manager's
+	// defaults, and the budget is capped by them.
+	Escalation *escalate.Plan
+	// Analysis is the EXIF analysis made to choose the scale bounds, if any
+	Analysis *Analysis
+	// Include selects optional parts of the result sent to the caller
+	Include *Include
+	// PositionFunc, if set, is called with the request's solver queue position
+	// while it waits for a slot, and with 0 once it starts solving
+	PositionFunc queue.PositionFunc
+}
+
+// Job is a snapshot of a submitted solve, as persisted in the store
+type Job struct {
+	ID        string               `json:"id"`
+	Status    Status               `json:"status"`
+	Filename  string               `json:"filename,omitempty"`
+	ImagePath string               `json:"image_path"`
+	Workspace string               `json:"workspace,omitempty"`
+	Options   *client.SolveOptions `json:"options,omitempty"`
+	// QueuePosition is the job's place in the solver queue while it waits
+	// for a free solver slot, or 0 once it is solving
+	QueuePosition int            `json:"queue_position,omitempty"`
+	Result        *client.Result `json:"result,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	StartedAt     time.Time      `json:"started_at,omitzero"`
+	// SolvingAt is when the job first got a solver slot, so the time before
+	// it was spent in the solver queue
+	SolvingAt  time.Time `json:"solving_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	// Phases is the time in seconds spent in each phase of the solve, timed
+	// as the solver's output arrived and summed over escalation attempts. It
+	// is left empty by solvers that only return their output at the end.
+	Phases map[solvelog.Phase]float64 `json:"phases,omitempty"`
+	// Callback tracks delivery of the result to the request's callback URL
+	Callback *Callback `json:"callback,omitempty"`
+	// NoCache is set for jobs that must not use a cached result
+	NoCache bool `json:"no_cache,omitempty"`
+	// Cached is set when the result was served from the cache instead of solving
+	Cached bool `json:"cached,omitempty"`
+	// Escalation is the plan of an escalating job, and Attempts its solves so far
+	Escalation *escalate.Plan     `json:"escalation,omitempty"`
+	Attempts   []escalate.Attempt `json:"attempts,omitempty"`
+	Analysis   *Analysis          `json:"analysis,omitempty"`
+	Include    *Include           `json:"include,omitempty"`
+}
+
+// Include selects optional parts of a job's result, which are worked out from
+// the solution each time the result is sent rather than stored with it
+type Include struct {
+	// Objects adds the deep-sky objects in the field
+	Objects bool `json:"objects,omitempty"`
+	// Stars adds the named and designated bright stars in the field, down to
+	// StarMagLimit if it is set
+	Stars        bool     `json:"stars,omitempty"`
+	StarMagLimit *float64 `json:"star_mag_limit,omitempty"`
+	// Coordinates adds the galactic and ecliptic coordinates of the field
+	// centre, and its horizontal coordinates as seen by Observer if its
+	// location and time are known
+	Coordinates bool `json:"coordinates,omitempty"`
+	// Epoch "jnow" adds the field centre on the true equator and equinox of
+	// the Observer's time, or of the job's creation if that is not known,
+	// with the annual aberration if Aberration is set
+	Epoch      string    `json:"epoch,omitempty"`
+	Aberration bool      `json:"aberration,omitempty"`
+	Observer   *Observer `json:"observer,omitempty"`
+	// Footprint adds the outline of the image on the sky, with the HEALPix
+	// cells it covers at HEALPixOrder
+	Footprint    bool `json:"footprint,omitempty"`
+	HEALPixOrder int  `json:"healpix_order,omitempty"`
+}
+
+// Observer is where and when an image was taken, from the request or the
+// image's EXIF data
+type Observer struct {
+	// Latitude and Longitude are in degrees, north and east positive
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Time      time.Time `json:"time,omitzero"`
+	// LocationSource and TimeSource are "request" or "exif", or empty when
+	// that part is not known. TimeSource is "exif_local" for an EXIF time
+	// without a time zone.
+	LocationSource string `json:"location_source,omitempty"`
+	TimeSource     string `json:"time_source,omitempty"`
+}
+
+// Analysis is the EXIF field-of-view analysis of a job's image, or the reason
+// it could not be made
+type Analysis struct {
+	Info  *fov.ImageInfo `json:"info,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// Done reports whether the job has finished, successfully or not
+func (j *Job) Done() bool {
+	return j.Status == StatusSolved || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+// snapshot returns a copy of the job that does not share mutable state with it
+func (j *Job) snapshot() Job {
+	snapshot := *j
+	if j.Callback != nil {
+		callback := *j.Callback
+		callback.Deliveries = append([]Delivery(nil), j.Callback.Deliveries...)
+		snapshot.Callback = &callback
+	}
+	snapshot.Attempts = append([]escalate.Attempt(nil), j.Attempts...)
+	snapshot.Phases = maps.Clone(j.Phases)
+	return snapshot
+}
+
+// task is a queued job together with the workspace holding its upload
+type task struct {
+	job *Job
+	ws  *workspace.Workspace
+}
+
+// solving tracks a job whose solve is in progress so it can be cancelled
+type solving struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+// Manager queues jobs and runs them on a fixed number of workers
+type Manager struct {
+	solver    Solver
+	store     *store.Store
+	workers   int
+	retention time.Duration
+
+	queue  chan *task
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.RWMutex
+	jobs    map[string]*Job
+	solving map[string]*solving
+	stopped bool
+	// pending holds the snapshots of jobs to write to the store, or nil for
+	// jobs to delete from it, until they are flushed once mu is released
+	pending map[string]*Job
+	// storeMu serialises flushes, so writes reach the store in order
+	storeMu sync.Mutex
+
+	deliverer  Deliverer
+	cache      *cache.Cache
+	escalation escalate.Plan
+}
+
+// NewManager creates a job manager with the given worker count and queue capacity.
+// Jobs are persisted in st so they survive restarts; st may be nil to keep jobs in
+// memory only. Finished jobs are kept for the retention period before being forgotten.
+func NewManager(solver Solver, st *store.Store, workers, queueSize int, retention time.Duration) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		solver:    solver,
+		store:     st,
+		workers:   workers,
+		retention: retention,
+		queue:     make(chan *task, queueSize),
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      make(map[string]*Job),
+		solving:   make(map[string]*solving),
+		pending:   make(map[string]*Job),
+		escalation: escalate.Plan{
+			Ladder: escalate.DefaultLadder,
+			Budget: escalate.DefaultBudget,
+		},
+	}
+}
+
+// SetCache makes solves reuse the results of earlier solves of the same image
+// with the same options. It must be called before the manager is used.
+func (m *Manager) SetCache(c *cache.Cache) {
+	m.cache = c
+}
+
+// SetEscalation sets the ladder and budget of escalating jobs that do not
+// choose their own; the budget also caps the budget they may choose. It must
+// be called before the manager is used.
+func (m *Manager) SetEscalation(plan escalate.Plan) {
+	m.escalation = plan
+}
+
+// NewID returns a random job identifier
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Start launches the worker goroutines
+func (m *Manager) Start() {
+	for i := 0; i < m.workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+	if m.retention > 0 {
+		m.wg.Add(1)
+		go m.janitor()
+	}
+}
+
+// Stop stops accepting jobs, cancels running solves and waits for workers to exit.
+// With a store, unfinished jobs keep their workspaces and are resumed by Recover
+// on the next start.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for {
+		select {
+		case t := <-m.queue:
+			m.abandon(t)
+		default:
+			return nil
+		}
+	}
+}
+
+// Recover re-queues jobs that were accepted but had not finished when the
+// server last stopped, adopting the workspaces that hold their uploads.
+// It must be called before stale workspaces are reaped.
+func (m *Manager) Recover(workspaces *workspace.Manager) (int, error) {
+	if m.store == nil {
+		return 0, nil
+	}
+	keys, err := m.store.Keys()
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, key := range keys {
+		job := &Job{}
+		if err := m.store.Get(key, job); err != nil {
+			log.Printf("Skipping unreadable job %s: %v", key, err)
+			continue
+		}
+		if job.Done() {
+			if job.Callback != nil && job.Callback.Status == CallbackPending {
+				m.mu.Lock()
+				m.jobs[job.ID] = job
+				m.deliverLocked(job)
+				m.unlock()
+			}
+			continue
+		}
+
+		ws, err := workspaces.Adopt(job.Workspace)
+		if err != nil {
+			log.Printf("Job %s: cannot resume: %v", job.ID, err)
+			m.mu.Lock()
+			m.finishLocked(job, nil, errImageLost)
+			m.unlock()
+			continue
+		}
+
+		m.mu.Lock()
+		job.Status = StatusQueued
+		job.StartedAt = time.Time{}
+		job.SolvingAt = time.Time{}
+		job.Phases = nil
+		job.QueuePosition = 0
+		m.jobs[job.ID] = job
+		m.persistLocked(job)
+		m.unlock()
+
+		// The backlog may be larger than the queue, so wait for room in the background
+		t := &task{job: job, ws: ws}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			select {
+			case m.queue <- t:
+			case <-m.ctx.Done():
+				m.abandon(t)
+			}
+		}()
+		recovered++
+	}
+	return recovered, nil
+}
+
+// Submit records the request and queues it for a worker. The request's
+// workspace is released once the job finishes or is rejected.
+func (m *Manager) Submit(req Request) (Job, error) {
+	if req.CallbackURL != "" {
+		if err := m.checkCallback(req.CallbackURL); err != nil {
+			releaseWorkspace(req.Workspace)
+			return Job{}, err
+		}
+	}
+	job := m.newJob(req)
+	t := &task{job: job, ws: req.Workspace}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.stopped {
+		t.release()
+		return Job{}, ErrStopped
+	}
+
+	select {
+	case m.queue <- t:
+	default:
+		t.release()
+		return Job{}, ErrQueueFull
+	}
+	m.jobs[job.ID] = job
+	m.persistLocked(job)
+	return job.snapshot(), nil
+}
+
+// Run records the request and solves it in the calling goroutine, returning the
+// finished job. Solve failures are reported in the job; an error is only returned
+// if the solve was not accepted (e.g. a *queue.FullError). The caller keeps
+// ownership of the request's workspace.
+func (m *Manager) Run(ctx context.Context, req Request) (Job, error) {
+	job := m.newJob(req)
+	job.Status = StatusRunning
+	job.StartedAt = job.CreatedAt
+	key, hit := m.lookup(job)
+
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return Job{}, ErrStopped
+	}
+	m.jobs[job.ID] = job
+	if hit != nil {
+		job.Cached = true
+		m.finishLocked(job, hit, nil)
+		snapshot := job.snapshot()
+		m.unlock()
+		return snapshot, nil
+	}
+	m.persistLocked(job)
+	ctx, s := m.trackLocked(ctx, job.ID)
+	m.unlock()
+	defer close(s.done)
+
+	result, err := m.attempt(ctx, job, req.PositionFunc, false)
+	result, err = cancelled(ctx, result, err)
+	m.remember(key, result, err)
+
+	m.mu.Lock()
+	defer m.unlock()
+	m.untrackLocked(job.ID)
+
+	var full *queue.FullError
+	if errors.As(err, &full) {
+		// Rejected before it started: forget it rather than record a failure
+		delete(m.jobs, job.ID)
+		m.deleteLocked(job.ID)
+		return Job{}, err
+	}
+	m.finishLocked(job, result, err)
+	return job.snapshot(), nil
+}
+
+// Cancel cancels a queued or running job and waits for its solve to stop.
+// It returns ErrNotFound for unknown jobs and ErrFinished, along with the
+// job, for jobs that have already finished.
+func (m *Manager) Cancel(ctx context.Context, id string) (Job, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		if stored, ok := m.Get(id); ok {
+			return stored, ErrFinished
+		}
+		return Job{}, ErrNotFound
+	}
+	if job.Done() {
+		snapshot := job.snapshot()
+		m.mu.Unlock()
+		return snapshot, ErrFinished
+	}
+
+	s, ok := m.solving[id]
+	if !ok {
+		// Still waiting for a worker, which will skip it
+		m.finishLocked(job, nil, ErrCancelled)
+		snapshot := job.snapshot()
+		m.unlock()
+		return snapshot, nil
+	}
+	s.cancel(ErrCancelled)
+	m.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+	snapshot, _ := m.Get(id)
+	return snapshot, nil
+}
+
+// Get returns a snapshot of the job with the given ID
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.RLock()
+	job, ok := m.jobs[id]
+	if ok {
+		snapshot := job.snapshot()
+		m.mu.RUnlock()
+		return snapshot, true
+	}
+	m.mu.RUnlock()
+
+	if m.store == nil {
+		return Job{}, false
+	}
+	var stored Job
+	if err := m.store.Get(id, &stored); err != nil {
+		if !errors.Is(err, store.ErrNotFound) && !errors.Is(err, store.ErrInvalidKey) {
+			log.Printf("Failed to load job %s: %v", id, err)
+		}
+		return Job{}, false
+	}
+	return stored, true
+}
+
+func (m *Manager) newJob(req Request) *Job {
+	job := &Job{
+		ID:        req.ID,
+		Status:    StatusQueued,
+		Filename:  req.Filename,
+		ImagePath: req.ImagePath,
+		Options:   req.Options,
+		CreatedAt: time.Now(),
+	}
+	if req.Workspace != nil {
+		job.Workspace = req.Workspace.Dir
+	}
+	if req.CallbackURL != "" {
+		job.Callback = &Callback{URL: req.CallbackURL, Status: CallbackPending}
+	}
+	job.NoCache = req.NoCache
+	job.Analysis = req.Analysis
+	job.Include = req.Include
+	if req.Escalation != nil {
+		plan := *req.Escalation
+		if len(plan.Ladder) == 0 {
+			plan.Ladder = m.escalation.Ladder
+		}
+		if plan.Budget <= 0 || plan.Budget > m.escalation.Budget {
+			plan.Budget = m.escalation.Budget
+		}
+		job.Escalation = &plan
+	}
+	return job
+}
+
+func (m *Manager) worker() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case t := <-m.queue:
+			m.run(t)
+		}
+	}
+}
+
+func (m *Manager) run(t *task) {
+	job := t.job
+	key, hit := m.lookup(job)
+
+	m.mu.Lock()
+	if job.Done() {
+		// Cancelled while it was queued
+		m.mu.Unlock()
+		t.release()
+		return
+	}
+	if hit != nil {
+		job.Cached = true
+		job.StartedAt = time.Now()
+		m.finishLocked(job, hit, nil)
+		m.unlock()
+		t.release()
+		return
+	}
+	job.Status = StatusRunning
+	job.StartedAt = time.Now()
+	m.persistLocked(job)
+	ctx, s := m.trackLocked(m.ctx, job.ID)
+	m.unlock()
+	defer close(s.done)
+
+	log.Printf("Job %s: solving %s", job.ID, job.ImagePath)
+	result, err := m.attempt(ctx, job, nil, true)
+	result, err = cancelled(ctx, result, err)
+	m.remember(key, result, err)
+
+	m.mu.Lock()
+	m.untrackLocked(job.ID)
+	m.mu.Unlock()
+
+	if !errors.Is(err, ErrCancelled) && m.ctx.Err() != nil && m.store != nil {
+		// Shutting down: leave the job to be resumed on the next start
+		m.mu.Lock()
+		job.Status = StatusQueued
+		job.StartedAt = time.Time{}
+		job.SolvingAt = time.Time{}
+		job.Phases = nil
+		job.QueuePosition = 0
+		m.persistLocked(job)
+		m.unlock()
+		m.abandon(t)
+		return
+	}
+
+	m.mu.Lock()
+	m.finishLocked(job, result, err)
+	m.unlock()
+	t.release()
+}
+
+// trackLocked registers a job as solving and returns the context its solve
+// must run with so that Cancel can stop it
+func (m *Manager) trackLocked(parent context.Context, id string) (context.Context, *solving) {
+	ctx, cancel := context.WithCancelCause(parent)
+	s := &solving{cancel: cancel, done: make(chan struct{})}
+	m.solving[id] = s
+	return ctx, s
+}
+
+func (m *Manager) untrackLocked(id string) {
+	if s, ok := m.solving[id]; ok {
+		s.cancel(nil)
+		delete(m.solving, id)
+	}
+}
+
+// attempt solves the job, escalating it if it has an escalation plan. A full
+// solver queue is waited out if wait is set, and otherwise fails the first
+// solve; later escalation attempts always wait.
+func (m *Manager) attempt(ctx context.Context, job *Job, notify queue.PositionFunc, wait bool) (*client.Result, error) {
+	solve := func(ctx context.Context, opts *client.SolveOptions, n int) (*client.Result, error) {
+		if wait || n > 1 {
+			return m.solveWithRetry(ctx, job, opts, notify)
+		}
+		return m.solve(ctx, job, opts, notify)
+	}
+	if job.Escalation == nil {
+		return solve(ctx, job.Options, 1)
+	}
+
+	m.mu.Lock()
+	job.Attempts = nil
+	m.mu.Unlock()
+	return job.Escalation.Run(ctx, job.Options, solve, func(a escalate.Attempt) {
+		m.mu.Lock()
+		job.Attempts = append(job.Attempts, a)
+		m.persistLocked(job)
+		m.unlock()
+		log.Printf("Job %s: attempt %d (%s) took %v, solved=%v", job.ID, a.Number, a.Step, a.Duration.Round(time.Millisecond), a.Solved)
+	})
+}
+
+// solve runs a single solve attempt with opts, tracking the job's solver queue
+// position and also reporting it to notify if set. The phases of the solve are
+// timed from its output, which is passed on to the OutputFunc of ctx.
+func (m *Manager) solve(ctx context.Context, job *Job, opts *client.SolveOptions, notify queue.PositionFunc) (*client.Result, error) {
+	var timer solvelog.Timer
+	streamed := false
+	addPhase := func(phase solvelog.Phase, d time.Duration, ok bool) {
+		if !ok || !streamed {
+			return
+		}
+		if job.Phases == nil {
+			job.Phases = make(map[solvelog.Phase]float64)
+		}
+		job.Phases[phase] += d.Seconds()
+	}
+
+	output := ctx
+	ctx = solver.WithOutputFunc(ctx, func(line string) {
+		m.mu.Lock()
+		streamed = true
+		addPhase(timer.Line(line, time.Now()))
+		m.mu.Unlock()
+		solver.Output(output, line)
+	})
+	ctx = queue.WithPositionFunc(ctx, func(position int) {
+		m.mu.Lock()
+		job.QueuePosition = position
+		if position == 0 {
+			if job.SolvingAt.IsZero() {
+				job.SolvingAt = time.Now()
+			}
+			timer.Start(time.Now())
+		}
+		m.mu.Unlock()
+		if notify != nil {
+			notify(position)
+		}
+	})
+	result, err := m.solver.Solve(ctx, job.ImagePath, opts)
+
+	m.mu.Lock()
+	addPhase(timer.Stop(time.Now()))
+	m.mu.Unlock()
+	return result, err
+}
+
+// solveWithRetry runs a solve attempt, waiting and retrying whenever the solver queue is full
+func (m *Manager) solveWithRetry(ctx context.Context, job *Job, opts *client.SolveOptions, notify queue.PositionFunc) (*client.Result, error) {
+	for {
+		result, err := m.solve(ctx, job, opts, notify)
+		var full *queue.FullError
+		if !errors.As(err, &full) {
+			return result, err
+		}
+
+		log.Printf("Job %s: solver queue full, retrying in %v", job.ID, full.RetryAfter)
+		select {
+		case <-time.After(full.RetryAfter):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lookup returns the key the job's result is cached under, or "" if the job
+// does not use the cache, along with the cached result if there is one
+func (m *Manager) lookup(job *Job) (string, *client.Result) {
+	if m.cache == nil || job.NoCache {
+		return "", nil
+	}
+	key, err := cache.Key(job.ImagePath, job.Options)
+	if err != nil {
+		log.Printf("Job %s: not using the cache: %v", job.ID, err)
+		return "", nil
+	}
+	if result, ok := m.cache.Get(key); ok {
+		log.Printf("Job %s: serving cached result", job.ID)
+		return key, result
+	}
+	return key, nil
+}
+
+// remember caches a successful solve. Failures are not cached as they may
+// have been caused by the solver rather than the image.
+func (m *Manager) remember(key string, result *client.Result, err error) {
+	if key == "" || err != nil || result == nil || !result.Solved {
+		return
+	}
+	m.cache.Put(key, result)
+}
+
+// cancelled replaces the outcome of a solve stopped by Cancel with ErrCancelled
+func cancelled(ctx context.Context, result *client.Result, err error) (*client.Result, error) {
+	if errors.Is(context.Cause(ctx), ErrCancelled) {
+		return nil, ErrCancelled
+	}
+	return result, err
+}
+
+func (m *Manager) finishLocked(job *Job, result *client.Result, err error) {
+	job.FinishedAt = time.Now()
+	job.QueuePosition = 0
+	job.Result = result
+	switch {
+	case errors.Is(err, ErrCancelled):
+		job.Status = StatusCancelled
+		job.Error = err.Error()
+		log.Printf("Job %s: cancelled", job.ID)
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		log.Printf("Job %s: solve failed: %v", job.ID, err)
+	case result != nil && result.Solved:
+		job.Status = StatusSolved
+		log.Printf("Job %s: solved RA=%.6f, Dec=%.6f, PixelScale=%.2f, Time=%.2fs",
+			job.ID, result.RA, result.Dec, result.PixelScale, result.SolveTime)
+	default:
+		job.Status = StatusFailed
+		log.Printf("Job %s: no solution found", job.ID)
+	}
+	m.persistLocked(job)
+	if job.Callback != nil {
+		m.deliverLocked(job)
+	}
+}
+
+// abandon gives up on a task during shutdown. With a store its workspace is kept
+// for the next run to resume; otherwise there is nothing to resume and it is removed.
+func (m *Manager) abandon(t *task) {
+	if m.store != nil && t.ws != nil {
+		t.ws.Detach()
+		return
+	}
+	t.release()
+}
+
+// persistLocked queues a snapshot of the job to be written to the store. The
+// write is made by flush, so callers release mu with unlock.
+func (m *Manager) persistLocked(job *Job) {
+	if m.store == nil {
+		return
+	}
+	snapshot := job.snapshot()
+	m.pending[job.ID] = &snapshot
+}
+
+// deleteLocked queues the job to be deleted from the store, as persistLocked
+func (m *Manager) deleteLocked(id string) {
+	if m.store == nil {
+		return
+	}
+	m.pending[id] = nil
+}
+
+// unlock releases mu and then flushes the store writes queued while it was held
+func (m *Manager) unlock() {
+	m.mu.Unlock()
+	m.flush()
+}
+
+// flush writes the queued jobs to the store without holding mu, so requests
+// for other jobs are not held up by the store's I/O
+func (m *Manager) flush() {
+	m.storeMu.Lock()
+	defer m.storeMu.Unlock()
+
+	m.mu.Lock()
+	pending := m.pending
+	if len(pending) > 0 {
+		m.pending = make(map[string]*Job)
+	}
+	m.mu.Unlock()
+
+	for id, job := range pending {
+		if job == nil {
+			if err := m.store.Delete(id); err != nil {
+				log.Printf("Failed to delete job %s: %v", id, err)
+			}
+		} else if err := m.store.Put(id, job); err != nil {
+			log.Printf("Failed to persist job %s: %v", id, err)
+		}
+	}
+}
+
+// janitor periodically forgets finished jobs older than the retention period
+func (m *Manager) janitor() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.retention / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.prune(now)
+		}
+	}
+}
+
+func (m *Manager) prune(now time.Time) {
+	m.mu.Lock()
+	for id, job := range m.jobs {
+		if job.Done() && !job.callbackPending() && now.Sub(job.FinishedAt) > m.retention {
+			delete(m.jobs, id)
+		}
+	}
+	m.mu.Unlock()
+
+	if m.store == nil {
+		return
+	}
+	// The store is scanned without mu held; a job that is active again by the
+	// time it is deleted is left alone
+	keys, err := m.store.Keys()
+	if err != nil {
+		log.Printf("Failed to list stored jobs: %v", err)
+		return
+	}
+	for _, key := range keys {
+		m.mu.RLock()
+		_, active := m.jobs[key]
+		m.mu.RUnlock()
+		if active {
+			continue
+		}
+		var job Job
+		if err := m.store.Get(key, &job); err != nil {
+			continue
+		}
+		if job.Done() && now.Sub(job.FinishedAt) > m.retention {
+			m.mu.Lock()
+			if _, active := m.jobs[key]; !active {
+				m.deleteLocked(key)
+			}
+			m.unlock()
+		}
+	}
+}
+
+func (t *task) release() {
+	releaseWorkspace(t.ws)
+}
+
+func releaseWorkspace(ws *workspace.Workspace) {
+	if ws == nil {
+		return
+	}
+	if err := ws.Release(); err != nil {
+		log.Printf("Failed to remove workspace %s: %v", ws.ID, err)
+	}
+}
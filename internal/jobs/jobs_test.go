@@ -0,0 +1,517 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/cache"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/escalate"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solvelog"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/solver"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/store"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/workspace"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+type solverFunc func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error)
+
+func (f solverFunc) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	return f(ctx, imagePath, opts)
+}
+
+func solved(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	return &client.Result{Solved: true, RA: 83.5, Dec: -5.9}, nil
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	return st
+}
+
+func newTestWorkspaces(t *testing.T, root string) *workspace.Manager {
+	t.Helper()
+	m, err := workspace.NewManager(root, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create workspace manager: %v", err)
+	}
+	return m
+}
+
+func waitForJob(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := m.Get(id); ok && job.Done() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish in time", id)
+	return Job{}
+}
+
+func TestManager_SolvedJob(t *testing.T) {
+	m := NewManager(solverFunc(solved), nil, 1, 4, 0)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	ws, err := newTestWorkspaces(t, t.TempDir()).Create("job")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+
+	job, err := m.Submit(Request{ID: NewID(), ImagePath: ws.Path("image.jpg"), Workspace: ws, Options: client.DefaultSolveOptions()})
+	if err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+	if job.Status != StatusQueued {
+		t.Errorf("expected status queued, got %s", job.Status)
+	}
+
+	done := waitForJob(t, m, job.ID)
+	if done.Status != StatusSolved {
+		t.Errorf("expected status solved, got %s", done.Status)
+	}
+	if done.Result == nil || done.Result.RA != 83.5 {
+		t.Errorf("expected result with RA 83.5, got %+v", done.Result)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(ws.Dir); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected workspace to be released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManager_FailedJob(t *testing.T) {
+	m := NewManager(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		return nil, errors.New("solver exploded")
+	}), nil, 1, 4, 0)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	job, err := m.Submit(Request{ID: NewID(), ImagePath: "image.jpg", Options: client.DefaultSolveOptions()})
+	if err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+
+	done := waitForJob(t, m, job.ID)
+	if done.Status != StatusFailed {
+		t.Errorf("expected status failed, got %s", done.Status)
+	}
+	if done.Error != "solver exploded" {
+		t.Errorf("expected solver error, got %q", done.Error)
+	}
+}
+
+func TestManager_QueueFull(t *testing.T) {
+	block := make(chan struct{})
+	m := NewManager(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		<-block
+		return &client.Result{}, nil
+	}), nil, 1, 1, 0)
+	m.Start()
+	defer m.Stop(context.Background())
+	defer close(block)
+
+	// The first job occupies the worker, the second fills the queue
+	if _, err := m.Submit(Request{ID: NewID(), ImagePath: "a.jpg"}); err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := m.Submit(Request{ID: NewID(), ImagePath: "b.jpg"}); err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+
+	if _, err := m.Submit(Request{ID: NewID(), ImagePath: "c.jpg"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestManager_RunIsPersisted(t *testing.T) {
+	st := newTestStore(t)
+	m := NewManager(solverFunc(solved), st, 1, 1, 0)
+
+	job, err := m.Run(context.Background(), Request{ID: NewID(), Filename: "orion.jpg", ImagePath: "image.jpg"})
+	if err != nil {
+		t.Fatalf("unexpected run error: %v", err)
+	}
+	if job.Status != StatusSolved {
+		t.Errorf("expected status solved, got %s", job.Status)
+	}
+
+	// A fresh manager over the same store (i.e. after a restart) still finds the result
+	restarted := NewManager(solverFunc(solved), st, 1, 1, 0)
+	stored, ok := restarted.Get(job.ID)
+	if !ok {
+		t.Fatal("expected job to be found in the store")
+	}
+	if stored.Status != StatusSolved || stored.Result == nil || stored.Result.RA != 83.5 {
+		t.Errorf("expected persisted solved result, got %+v", stored)
+	}
+	if stored.Filename != "orion.jpg" {
+		t.Errorf("expected filename to be persisted, got %q", stored.Filename)
+	}
+}
+
+func TestManager_SolvingAt(t *testing.T) {
+	// A solver slot is held so the job has to wait in the queue for it
+	limiter := queue.NewLimiter(1, 1)
+	release, err := limiter.Acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to acquire a slot: %v", err)
+	}
+	time.AfterFunc(50*time.Millisecond, release)
+	m := NewManager(queue.NewClient(solverFunc(solved), limiter), nil, 1, 1, 0)
+
+	job, err := m.Run(context.Background(), Request{ID: NewID(), ImagePath: "image.jpg"})
+	if err != nil {
+		t.Fatalf("unexpected run error: %v", err)
+	}
+	if job.SolvingAt.Sub(job.CreatedAt) < 50*time.Millisecond || job.FinishedAt.Before(job.SolvingAt) {
+		t.Errorf("expected the job to start solving after waiting for the slot, got created %v, solving %v, finished %v", job.CreatedAt, job.SolvingAt, job.FinishedAt)
+	}
+}
+
+func TestManager_Phases(t *testing.T) {
+	streaming := solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		solver.Output(ctx, "Extracting sources...")
+		time.Sleep(50 * time.Millisecond)
+		solver.Output(ctx, "Field 1 did not solve (index index-4208.fits, field objects 1-10).")
+		time.Sleep(50 * time.Millisecond)
+		solver.Output(ctx, "Field 1: solved with index index-4207-01.fits.")
+		return &client.Result{Solved: true}, nil
+	})
+	var lines []string
+	ctx := solver.WithOutputFunc(context.Background(), func(line string) { lines = append(lines, line) })
+	m := NewManager(queue.NewClient(streaming, queue.NewLimiter(1, 1)), nil, 1, 1, 0)
+
+	job, err := m.Run(ctx, Request{ID: NewID(), ImagePath: "image.jpg"})
+	if err != nil {
+		t.Fatalf("unexpected run error: %v", err)
+	}
+	if len(lines) != 3 {
+		t.Errorf("expected the output to be passed on, got %q", lines)
+	}
+	for _, phase := range []solvelog.Phase{solvelog.PhaseSourceExtraction, solvelog.PhaseTryingIndex} {
+		if d := job.Phases[phase]; d < 0.05 || d > 1 {
+			t.Errorf("expected %s to take about 0.05s, got %f", phase, d)
+		}
+	}
+	if _, ok := job.Phases[solvelog.PhaseSolved]; ok || len(job.Phases) != 3 {
+		t.Errorf("expected the started, source_extraction and trying_index phases, got %v", job.Phases)
+	}
+
+	// Output returned only at the end cannot be timed
+	m = NewManager(queue.NewClient(solverFunc(solved), queue.NewLimiter(1, 1)), nil, 1, 1, 0)
+	if job, err = m.Run(context.Background(), Request{ID: NewID(), ImagePath: "image.jpg"}); err != nil || job.Phases != nil {
+		t.Errorf("expected no phases, got %v, %v", job.Phases, err)
+	}
+}
+
+func TestManager_RecoverUnfinishedJobs(t *testing.T) {
+	st := newTestStore(t)
+	root := t.TempDir()
+
+	// First run: the solver never finishes before shutdown
+	started := make(chan struct{})
+	first := NewManager(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), st, 1, 4, 0)
+	first.Start()
+
+	firstWorkspaces := newTestWorkspaces(t, root)
+	ws, err := firstWorkspaces.Create("job")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	if err := os.WriteFile(ws.Path("image.jpg"), []byte("data"), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	job, err := first.Submit(Request{ID: NewID(), ImagePath: ws.Path("image.jpg"), Workspace: ws})
+	if err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+	<-started
+	if err := first.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected stop error: %v", err)
+	}
+	firstWorkspaces.Close()
+
+	if stored, _ := first.Get(job.ID); stored.Done() {
+		t.Fatalf("expected interrupted job to stay unfinished, got %s", stored.Status)
+	}
+
+	// A job whose workspace vanished cannot be resumed
+	lost := Job{ID: NewID(), Status: StatusRunning, ImagePath: root + "/ws-gone/image.jpg", Workspace: root + "/ws-gone"}
+	if err := st.Put(lost.ID, &lost); err != nil {
+		t.Fatalf("failed to store job: %v", err)
+	}
+
+	// Second run: the job is resumed from the store and its workspace adopted
+	var solvedPath string
+	second := NewManager(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		solvedPath = imagePath
+		return solved(ctx, imagePath, opts)
+	}), st, 1, 4, 0)
+	secondWorkspaces := newTestWorkspaces(t, root)
+
+	n, err := second.Recover(secondWorkspaces)
+	if err != nil {
+		t.Fatalf("unexpected recover error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 recovered job, got %d", n)
+	}
+	if reaped, _ := secondWorkspaces.Reap(); reaped != 0 {
+		t.Errorf("expected adopted workspace to survive reaping, reaped %d", reaped)
+	}
+
+	second.Start()
+	defer second.Stop(context.Background())
+
+	done := waitForJob(t, second, job.ID)
+	if done.Status != StatusSolved {
+		t.Errorf("expected resumed job to be solved, got %s (%s)", done.Status, done.Error)
+	}
+	if solvedPath != ws.Path("image.jpg") {
+		t.Errorf("expected resumed job to solve %s, got %s", ws.Path("image.jpg"), solvedPath)
+	}
+
+	failed, ok := second.Get(lost.ID)
+	if !ok || failed.Status != StatusFailed {
+		t.Errorf("expected job with lost image to be marked failed, got %+v", failed)
+	}
+}
+
+func TestManager_Prune(t *testing.T) {
+	st := newTestStore(t)
+	m := NewManager(nil, st, 1, 1, time.Minute)
+	now := time.Now()
+	for _, job := range []*Job{
+		{ID: "old", Status: StatusSolved, FinishedAt: now.Add(-2 * time.Minute)},
+		{ID: "new", Status: StatusSolved, FinishedAt: now},
+		{ID: "queued", Status: StatusQueued},
+	} {
+		m.jobs[job.ID] = job
+		m.persistLocked(job)
+	}
+	storedOnly := &Job{ID: "stored", Status: StatusFailed, FinishedAt: now.Add(-time.Hour)}
+	m.persistLocked(storedOnly)
+	m.flush()
+
+	m.prune(now)
+
+	for _, id := range []string{"old", "stored"} {
+		if _, ok := m.Get(id); ok {
+			t.Errorf("expected %s job to be pruned", id)
+		}
+	}
+	for _, id := range []string{"new", "queued"} {
+		if _, ok := m.Get(id); !ok {
+			t.Errorf("expected %s job to be kept", id)
+		}
+	}
+}
+
+func TestManager_StoreWritesOutsideLock(t *testing.T) {
+	st := newTestStore(t)
+	m := NewManager(solverFunc(solved), st, 1, 1, time.Minute)
+
+	// While a slow store write is in progress, the job can still be read
+	id := NewID()
+	m.storeMu.Lock()
+	submitted := make(chan Job)
+	go func() {
+		job, err := m.Submit(Request{ID: id, ImagePath: "image.jpg"})
+		if err != nil {
+			t.Errorf("unexpected submit error: %v", err)
+		}
+		submitted <- job
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	_, ok := m.Get(id)
+	for !ok && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		_, ok = m.Get(id)
+	}
+	if !ok {
+		t.Fatal("expected the job to be readable while its write waits")
+	}
+	if err := st.Get(id, &Job{}); err == nil {
+		t.Error("expected the job not to be written yet")
+	}
+	m.storeMu.Unlock()
+
+	<-submitted
+	var stored Job
+	if err := st.Get(id, &stored); err != nil || stored.Status != StatusQueued {
+		t.Errorf("expected the queued job to be written once Submit returns, got %+v, %v", stored, err)
+	}
+}
+
+func TestManager_CancelRunning(t *testing.T) {
+	started := make(chan struct{})
+	m := NewManager(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), newTestStore(t), 1, 1, 0)
+
+	id := NewID()
+	finished := make(chan Job)
+	go func() {
+		job, err := m.Run(context.Background(), Request{ID: id, ImagePath: "image.jpg"})
+		if err != nil {
+			t.Errorf("unexpected run error: %v", err)
+		}
+		finished <- job
+	}()
+	<-started
+
+	job, err := m.Cancel(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unexpected cancel error: %v", err)
+	}
+	if job.Status != StatusCancelled {
+		t.Errorf("expected status cancelled, got %s", job.Status)
+	}
+	if ran := <-finished; ran.Status != StatusCancelled {
+		t.Errorf("expected Run to return a cancelled job, got %s", ran.Status)
+	}
+
+	if _, err := m.Cancel(context.Background(), id); !errors.Is(err, ErrFinished) {
+		t.Errorf("expected ErrFinished, got %v", err)
+	}
+	if _, err := m.Cancel(context.Background(), NewID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestManager_CancelQueued(t *testing.T) {
+	block := make(chan struct{})
+	var solves []string
+	m := NewManager(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		solves = append(solves, imagePath)
+		<-block
+		return &client.Result{}, nil
+	}), nil, 1, 1, 0)
+	m.Start()
+	defer m.Stop(context.Background())
+
+	first, _ := m.Submit(Request{ID: NewID(), ImagePath: "a.jpg"})
+	time.Sleep(50 * time.Millisecond)
+	second, err := m.Submit(Request{ID: NewID(), ImagePath: "b.jpg"})
+	if err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+
+	job, err := m.Cancel(context.Background(), second.ID)
+	if err != nil {
+		t.Fatalf("unexpected cancel error: %v", err)
+	}
+	if job.Status != StatusCancelled {
+		t.Errorf("expected status cancelled, got %s", job.Status)
+	}
+
+	close(block)
+	waitForJob(t, m, first.ID)
+	time.Sleep(50 * time.Millisecond)
+	if len(solves) != 1 {
+		t.Errorf("expected the cancelled job not to be solved, got solves %v", solves)
+	}
+}
+
+func TestManager_Cache(t *testing.T) {
+	solves := 0
+	m := NewManager(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		solves++
+		return &client.Result{Solved: solves == 1 || solves > 2, RA: float64(solves)}, nil
+	}), nil, 1, 4, 0)
+	m.SetCache(cache.New(10, time.Hour))
+
+	image := filepath.Join(t.TempDir(), "image.jpg")
+	if err := os.WriteFile(image, []byte("image"), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	run := func(req Request) Job {
+		t.Helper()
+		req.ID = NewID()
+		req.ImagePath = image
+		job, err := m.Run(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return job
+	}
+
+	first := run(Request{Options: client.DefaultSolveOptions()})
+	if first.Cached || first.Result.RA != 1 {
+		t.Fatalf("expected the first run to solve, got %+v", first)
+	}
+
+	second := run(Request{Options: client.DefaultSolveOptions()})
+	if !second.Cached || second.Status != StatusSolved || second.Result.RA != 1 {
+		t.Errorf("expected the second run to be served from the cache, got %+v", second)
+	}
+	if solves != 1 {
+		t.Errorf("expected 1 solve, got %d", solves)
+	}
+
+	// Unsolved results are not cached, and no_cache solves again
+	opts := client.DefaultSolveOptions()
+	opts.DownsampleFactor = 4
+	if job := run(Request{Options: opts}); job.Cached || job.Status != StatusFailed {
+		t.Errorf("expected an unsolved run, got %+v", job)
+	}
+	if job := run(Request{Options: opts}); job.Cached || job.Status != StatusSolved {
+		t.Errorf("expected the unsolved result not to be cached, got %+v", job)
+	}
+	if job := run(Request{Options: client.DefaultSolveOptions(), NoCache: true}); job.Cached {
+		t.Errorf("expected no_cache to bypass the cache, got %+v", job)
+	}
+	if solves != 4 {
+		t.Errorf("expected 4 solves, got %d", solves)
+	}
+}
+
+func TestManager_Escalation(t *testing.T) {
+	m := NewManager(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		return &client.Result{Solved: opts.DownsampleFactor == 4}, nil
+	}), nil, 1, 4, 0)
+	m.SetEscalation(escalate.Plan{Ladder: []escalate.Step{escalate.IncreaseDepth, escalate.ChangeDownsample}, Budget: time.Minute})
+
+	job, err := m.Run(context.Background(), Request{
+		ID:         NewID(),
+		Options:    client.DefaultSolveOptions(),
+		Escalation: &escalate.Plan{Budget: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job.Status != StatusSolved {
+		t.Errorf("expected status solved, got %s", job.Status)
+	}
+	if job.Escalation == nil || len(job.Escalation.Ladder) != 2 || job.Escalation.Budget != time.Minute {
+		t.Errorf("expected the default ladder and a capped budget, got %+v", job.Escalation)
+	}
+	if len(job.Attempts) != 3 || job.Attempts[2].Step != escalate.ChangeDownsample || !job.Attempts[2].Solved {
+		t.Errorf("expected the third attempt to solve, got %+v", job.Attempts)
+	}
+}
@@ -25,8 +25,12 @@ func TestCORS_PreflightRequest(t *testing.T) {
 		t.Errorf("expected Access-Control-Allow-Origin '*', got '%s'", origin)
 	}
 
-	if methods := w.Header().Get("Access-Control-Allow-Methods"); methods != "GET, POST, OPTIONS" {
-		t.Errorf("expected Access-Control-Allow-Methods 'GET, POST, OPTIONS', got '%s'", methods)
+	if methods := w.Header().Get("Access-Control-Allow-Methods"); methods != "GET, POST, DELETE, OPTIONS" {
+		t.Errorf("expected Access-Control-Allow-Methods 'GET, POST, DELETE, OPTIONS', got '%s'", methods)
+	}
+
+	if exposed := w.Header().Get("Access-Control-Expose-Headers"); exposed != "X-Solve-ID, X-Cache, Retry-After" {
+		t.Errorf("expected Access-Control-Expose-Headers 'X-Solve-ID, X-Cache, Retry-After', got '%s'", exposed)
 	}
 
 	if headers := w.Header().Get("Access-Control-Allow-Headers"); headers != "Content-Type, Authorization" {
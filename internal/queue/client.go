@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"context"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// Solver is the subset of the astrometry client that the limiter wraps
+type Solver interface {
+	Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error)
+}
+
+// Client runs solves through a Limiter so the solver is never given more work
+// than it can handle
+type Client struct {
+	next    Solver
+	limiter *Limiter
+}
+
+// NewClient wraps next so every Solve first acquires a slot from limiter
+func NewClient(next Solver, limiter *Limiter) *Client {
+	return &Client{
+		next:    next,
+		limiter: limiter,
+	}
+}
+
+// Solve waits for a free slot and then runs the solve. It returns a *FullError
+// without waiting if the queue is full.
+func (c *Client) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	release, err := c.limiter.Acquire(ctx, positionFunc(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return c.next.Solve(ctx, imagePath, opts)
+}
+
+type positionKey struct{}
+
+// WithPositionFunc returns a context that reports the caller's queue position
+// to fn while a Solve made with it is waiting for a slot
+func WithPositionFunc(ctx context.Context, fn PositionFunc) context.Context {
+	return context.WithValue(ctx, positionKey{}, fn)
+}
+
+func positionFunc(ctx context.Context) PositionFunc {
+	fn, _ := ctx.Value(positionKey{}).(PositionFunc)
+	return fn
+}
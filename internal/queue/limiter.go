@@ -0,0 +1,199 @@
+// Package queue bounds how many solves run against the solver at once
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is matched (via errors.Is) by the error returned when the wait queue is full
+var ErrQueueFull = errors.New("solver queue is full")
+
+// defaultSolveEstimate is used to estimate waits before any solve has finished
+const defaultSolveEstimate = 30 * time.Second
+
+// FullError is returned by Acquire when the wait queue is full
+type FullError struct {
+	// RetryAfter is an estimate of when a slot is likely to be free
+	RetryAfter time.Duration
+}
+
+func (e *FullError) Error() string {
+	return ErrQueueFull.Error()
+}
+
+// Is makes errors.Is(err, ErrQueueFull) match a FullError
+func (e *FullError) Is(target error) bool {
+	return target == ErrQueueFull
+}
+
+// PositionFunc is called with a waiter's 1-based queue position whenever it
+// changes, and with 0 once the waiter is allowed to run. It is called with the
+// limiter's lock held, so it must be quick and must not call back into the limiter.
+type PositionFunc func(position int)
+
+// Stats is a snapshot of the limiter's load
+type Stats struct {
+	Running       int
+	Queued        int
+	MaxConcurrent int
+	MaxQueue      int
+}
+
+// Limiter is a semaphore with a bounded FIFO wait queue
+type Limiter struct {
+	maxConcurrent int
+	maxQueue      int
+
+	mu        sync.Mutex
+	running   int
+	waiters   []*waiter
+	avgSolve  time.Duration
+	completed int
+}
+
+type waiter struct {
+	ready  chan struct{}
+	notify PositionFunc
+}
+
+// NewLimiter creates a limiter allowing maxConcurrent holders and up to maxQueue waiters
+func NewLimiter(maxConcurrent, maxQueue int) *Limiter {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	return &Limiter{
+		maxConcurrent: maxConcurrent,
+		maxQueue:      maxQueue,
+		avgSolve:      defaultSolveEstimate,
+	}
+}
+
+// Acquire waits for a free slot. It fails immediately with a *FullError if the
+// wait queue is full, or with the context's error if ctx is done first.
+// The returned release function must be called once the slot is no longer needed.
+func (l *Limiter) Acquire(ctx context.Context, notify PositionFunc) (func(), error) {
+	if notify == nil {
+		notify = func(int) {}
+	}
+
+	l.mu.Lock()
+	if l.running < l.maxConcurrent && len(l.waiters) == 0 {
+		l.running++
+		notify(0)
+		l.mu.Unlock()
+		return l.releaseFunc(), nil
+	}
+	if len(l.waiters) >= l.maxQueue {
+		retryAfter := l.retryAfterLocked()
+		l.mu.Unlock()
+		return nil, &FullError{RetryAfter: retryAfter}
+	}
+	w := &waiter{ready: make(chan struct{}), notify: notify}
+	l.waiters = append(l.waiters, w)
+	notify(len(l.waiters))
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return l.releaseFunc(), nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	select {
+	case <-w.ready:
+		// The slot was handed over just as we gave up; pass it on
+		l.freeLocked()
+		l.mu.Unlock()
+		return nil, ctx.Err()
+	default:
+	}
+	for i, other := range l.waiters {
+		if other == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			break
+		}
+	}
+	l.notifyPositionsLocked()
+	l.mu.Unlock()
+
+	return nil, ctx.Err()
+}
+
+// Stats returns the current load
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{
+		Running:       l.running,
+		Queued:        len(l.waiters),
+		MaxConcurrent: l.maxConcurrent,
+		MaxQueue:      l.maxQueue,
+	}
+}
+
+// RetryAfter estimates how long a rejected caller should wait before retrying
+func (l *Limiter) RetryAfter() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.retryAfterLocked()
+}
+
+func (l *Limiter) retryAfterLocked() time.Duration {
+	// Roughly the time for everyone ahead to be served by the available slots
+	rounds := len(l.waiters)/l.maxConcurrent + 1
+	estimate := l.avgSolve * time.Duration(rounds)
+	if estimate < time.Second {
+		estimate = time.Second
+	}
+	return estimate
+}
+
+func (l *Limiter) releaseFunc() func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(time.Since(start)) })
+	}
+}
+
+func (l *Limiter) release(held time.Duration) {
+	l.mu.Lock()
+
+	// Exponentially weighted average of how long solves hold a slot
+	l.completed++
+	if l.completed == 1 {
+		l.avgSolve = held
+	} else {
+		l.avgSolve = (l.avgSolve*4 + held) / 5
+	}
+
+	l.freeLocked()
+	l.mu.Unlock()
+}
+
+// freeLocked gives up a slot, handing it straight to the next waiter if there is one
+func (l *Limiter) freeLocked() {
+	if len(l.waiters) == 0 {
+		l.running--
+		return
+	}
+
+	next := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	next.notify(0)
+	close(next.ready)
+	l.notifyPositionsLocked()
+}
+
+func (l *Limiter) notifyPositionsLocked() {
+	for i, w := range l.waiters {
+		w.notify(i + 1)
+	}
+}
@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter_QueuesAndRejects(t *testing.T) {
+	l := NewLimiter(1, 1)
+
+	release, err := l.Acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("unexpected acquire error: %v", err)
+	}
+
+	var mu sync.Mutex
+	var positions []int
+	acquired := make(chan func())
+	go func() {
+		r, err := l.Acquire(context.Background(), func(position int) {
+			mu.Lock()
+			positions = append(positions, position)
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Errorf("unexpected acquire error: %v", err)
+		}
+		acquired <- r
+	}()
+
+	waitFor(t, func() bool { return l.Stats().Queued == 1 })
+
+	_, err = l.Acquire(context.Background(), nil)
+	var full *FullError
+	if !errors.As(err, &full) {
+		t.Fatalf("expected FullError, got %v", err)
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Error("expected FullError to match ErrQueueFull")
+	}
+	if full.RetryAfter < time.Second {
+		t.Errorf("expected RetryAfter of at least 1s, got %v", full.RetryAfter)
+	}
+
+	release()
+	second := <-acquired
+
+	stats := l.Stats()
+	if stats.Running != 1 || stats.Queued != 0 {
+		t.Errorf("expected 1 running and 0 queued, got %+v", stats)
+	}
+
+	mu.Lock()
+	if len(positions) != 2 || positions[0] != 1 || positions[1] != 0 {
+		t.Errorf("expected positions [1 0], got %v", positions)
+	}
+	mu.Unlock()
+
+	second()
+	second()
+	if stats := l.Stats(); stats.Running != 0 {
+		t.Errorf("expected release to be idempotent, got %+v", stats)
+	}
+}
+
+func TestLimiter_CancelWhileWaiting(t *testing.T) {
+	l := NewLimiter(1, 2)
+
+	release, err := l.Acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("unexpected acquire error: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := l.Acquire(ctx, nil)
+		done <- err
+	}()
+
+	var lastPosition int
+	var mu sync.Mutex
+	go func() {
+		_, _ = l.Acquire(context.Background(), func(position int) {
+			mu.Lock()
+			lastPosition = position
+			mu.Unlock()
+		})
+	}()
+
+	waitFor(t, func() bool { return l.Stats().Queued == 2 })
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if stats := l.Stats(); stats.Queued != 1 {
+		t.Errorf("expected cancelled waiter to leave the queue, got %+v", stats)
+	}
+
+	mu.Lock()
+	if lastPosition != 1 {
+		t.Errorf("expected remaining waiter to move to position 1, got %d", lastPosition)
+	}
+	mu.Unlock()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
@@ -0,0 +1,112 @@
+package solvelog
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Diagnostics summarises what solve-field reported about a solve
+type Diagnostics struct {
+	// Sources is the number of sources found by source extraction
+	Sources *int `json:"sources,omitempty"`
+	// Index is the index file that solved the field
+	Index string `json:"index,omitempty"`
+	// IndexesTried are the index files tried, in the order first tried
+	IndexesTried []string `json:"indexes_tried,omitempty"`
+	// QuadDepth is how many of the brightest sources quads were built from:
+	// the end of the deepest range of field objects tried
+	QuadDepth int `json:"quad_depth,omitempty"`
+	// Match is the verification of the best match found
+	Match *Match `json:"match,omitempty"`
+}
+
+// Match is how well the stars of an index agreed with the sources of the
+// image for a candidate solution
+type Match struct {
+	// LogOdds is the natural log of the odds that the match is real;
+	// solve-field accepts a match from about 21 (odds of 1e9)
+	LogOdds float64 `json:"log_odds"`
+	// Matched, Conflicts and Distractors count the sources that matched an
+	// index star, that matched one already taken, and that matched none
+	Matched     int `json:"matched"`
+	Conflicts   int `json:"conflicts"`
+	Distractors int `json:"distractors"`
+	// IndexStars is the number of index stars in the field
+	IndexStars int `json:"index_stars"`
+}
+
+var (
+	fieldObjectsRe = regexp.MustCompile(`field objects \d+-(\d+)`)
+	logOddsRe      = regexp.MustCompile(`log-odds ratio (\S+) \(.*?\), (\d+) match, (\d+) conflict, (\d+) distractors?, (\d+) index`)
+)
+
+// Diagnose reads the diagnostics from a solve's output, or returns nil if
+// none of it is recognised. For a solved field the match reported just before
+// "solved with index" is kept, as that is the one solve-field accepted, and
+// otherwise the match with the highest log-odds.
+func Diagnose(output string) *Diagnostics {
+	var d Diagnostics
+	// last is the match reported most recently, and accepted is set once the
+	// field solves with it
+	var last *Match
+	accepted := false
+	tried := func(index string) {
+		if !slices.Contains(d.IndexesTried, index) {
+			d.IndexesTried = append(d.IndexesTried, index)
+		}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if m := sourcesRe.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			d.Sources = &n
+		}
+		if m := fieldObjectsRe.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			d.QuadDepth = max(d.QuadDepth, n)
+		}
+		if m := logOddsRe.FindStringSubmatch(line); m != nil {
+			if match, ok := parseMatch(m[1:]); ok && !accepted {
+				last = match
+				if d.Match == nil || match.LogOdds > d.Match.LogOdds {
+					d.Match = match
+				}
+			}
+		}
+		switch {
+		case solvedRe.MatchString(line):
+			d.Index = solvedRe.FindStringSubmatch(line)[1]
+			tried(d.Index)
+			if last != nil && !accepted {
+				d.Match = last
+			}
+			accepted = true
+		case tryingRe.MatchString(line):
+			tried(tryingRe.FindStringSubmatch(line)[1])
+		case triedIndexRe.MatchString(line):
+			tried(triedIndexRe.FindStringSubmatch(line)[1])
+		}
+	}
+	if d.Sources == nil && d.IndexesTried == nil && d.Match == nil && d.QuadDepth == 0 {
+		return nil
+	}
+	return &d
+}
+
+// parseMatch reads the log-odds, match, conflict, distractor and index counts
+// of a log-odds line
+func parseMatch(fields []string) (*Match, bool) {
+	logOdds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, false
+	}
+	var counts [4]int
+	for i, field := range fields[1:] {
+		if counts[i], err = strconv.Atoi(field); err != nil {
+			return nil, false
+		}
+	}
+	return &Match{LogOdds: logOdds, Matched: counts[0], Conflicts: counts[1], Distractors: counts[2], IndexStars: counts[3]}, true
+}
@@ -0,0 +1,63 @@
+package solvelog
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiagnose_SolvedOutput(t *testing.T) {
+	d := Diagnose(solvedOutput)
+	if d == nil {
+		t.Fatal("expected diagnostics")
+	}
+	if d.Sources == nil || *d.Sources != 1523 {
+		t.Errorf("expected 1523 sources, got %v", d.Sources)
+	}
+	if d.Index != "index-4207-01.fits" {
+		t.Errorf("expected index index-4207-01.fits, got %q", d.Index)
+	}
+	if want := []string{"index-4208.fits", "index-4207-00.fits", "index-4207-01.fits"}; !slices.Equal(d.IndexesTried, want) {
+		t.Errorf("expected indexes tried %v, got %v", want, d.IndexesTried)
+	}
+	if d.QuadDepth != 20 {
+		t.Errorf("expected quad depth 20, got %d", d.QuadDepth)
+	}
+	want := Match{LogOdds: 142.5, Matched: 35, Conflicts: 0, Distractors: 64, IndexStars: 99}
+	if d.Match == nil || *d.Match != want {
+		t.Errorf("expected match %+v, got %+v", want, d.Match)
+	}
+}
+
+func TestDiagnose_BestMatch(t *testing.T) {
+	d := Diagnose(`simplexy: found 0 sources.
+  log-odds ratio 12.25 (2.1e+05), 8 match, 1 conflict, 20 distractors, 30 index.
+  log-odds ratio 36.6525 (8.26047e+15), 10 match, 0 conflict, 12 distractors, 19 index.
+  log-odds ratio 14.5 (1.98e+06), 6 match, 2 conflict, 25 distractors, 22 index.
+Did not solve (or no WCS file was written).`)
+	if d == nil || d.Sources == nil || *d.Sources != 0 {
+		t.Fatalf("expected 0 sources to be reported, got %+v", d)
+	}
+	if d.Match == nil || d.Match.LogOdds != 36.6525 || d.Match.Matched != 10 || d.Match.IndexStars != 19 {
+		t.Errorf("expected the match with the highest log-odds, got %+v", d.Match)
+	}
+	if d.Index != "" {
+		t.Errorf("expected no solving index, got %q", d.Index)
+	}
+
+	// A solved field keeps the match it was solved with
+	d = Diagnose(`  log-odds ratio 36.6525 (8.26047e+15), 10 match, 0 conflict, 12 distractors, 19 index.
+  log-odds ratio 30.1 (1.2e+13), 9 match, 0 conflict, 14 distractors, 21 index.
+Field 1: solved with index index-4207-01.fits.
+  log-odds ratio 40.2 (2.9e+17), 12 match, 0 conflict, 10 distractors, 19 index.`)
+	if d == nil || d.Match == nil || d.Match.LogOdds != 30.1 || d.Index != "index-4207-01.fits" {
+		t.Errorf("expected the match solved with index-4207-01.fits, got %+v", d)
+	}
+}
+
+func TestDiagnose_Unrecognised(t *testing.T) {
+	for _, output := range []string{"", "Reading input file 1 of 1\nSolving..."} {
+		if d := Diagnose(output); d != nil {
+			t.Errorf("%q: expected no diagnostics, got %+v", output, d)
+		}
+	}
+}
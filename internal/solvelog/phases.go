@@ -0,0 +1,106 @@
+// Package solvelog interprets the output of solve-field
+package solvelog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Phase is a step of a solve that a client can show progress for
+type Phase string
+
+// Solve phases, in the order they normally happen
+const (
+	PhaseUploadSaved      Phase = "upload_saved"
+	PhaseStarted          Phase = "started"
+	PhaseSourceExtraction Phase = "source_extraction"
+	PhaseTryingIndex      Phase = "trying_index"
+	PhaseSolved           Phase = "solved"
+	PhaseFailed           Phase = "failed"
+	PhaseCancelled        Phase = "cancelled"
+)
+
+// Event reports that a solve has entered a phase
+type Event struct {
+	Phase Phase `json:"phase"`
+	// Index is the index file being tried or that solved the field
+	Index string `json:"index,omitempty"`
+	// Sources is the number of sources found by source extraction
+	Sources int `json:"sources,omitempty"`
+	// Line is the solver output the event was derived from
+	Line string `json:"line,omitempty"`
+}
+
+// Terminal reports whether the phase ends the solve
+func (p Phase) Terminal() bool {
+	return p == PhaseSolved || p == PhaseFailed || p == PhaseCancelled
+}
+
+var (
+	extractingRe = regexp.MustCompile(`^Extracting sources`)
+	sourcesRe    = regexp.MustCompile(`simplexy: found (\d+) sources`)
+	triedIndexRe = regexp.MustCompile(`did not solve \(index ([^,)]+)`)
+	tryingRe     = regexp.MustCompile(`^Trying index (\S+?)\.*$`)
+	solvedRe     = regexp.MustCompile(`solved with index (\S+?)\.?$`)
+	failedRe     = regexp.MustCompile(`^Did not solve`)
+)
+
+// Parser turns solve-field output, fed to it a line at a time, into phase
+// events. Repeated lines for the same phase only produce one event. A Parser
+// is not safe for concurrent use.
+type Parser struct {
+	last  Phase
+	index string
+	done  bool
+}
+
+// Line parses one line of output, returning the event it starts, if any
+func (p *Parser) Line(line string) (Event, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || p.done {
+		return Event{}, false
+	}
+
+	switch {
+	case solvedRe.MatchString(line):
+		return p.emit(Event{Phase: PhaseSolved, Index: solvedRe.FindStringSubmatch(line)[1], Line: line})
+	case failedRe.MatchString(line):
+		return p.emit(Event{Phase: PhaseFailed, Line: line})
+	case sourcesRe.MatchString(line):
+		n, _ := strconv.Atoi(sourcesRe.FindStringSubmatch(line)[1])
+		// Reported even if the extraction phase was already announced, now with the count
+		p.last = PhaseSourceExtraction
+		return Event{Phase: PhaseSourceExtraction, Sources: n, Line: line}, true
+	case extractingRe.MatchString(line):
+		return p.emit(Event{Phase: PhaseSourceExtraction, Line: line})
+	case tryingRe.MatchString(line):
+		return p.emitIndex(tryingRe.FindStringSubmatch(line)[1], line)
+	case triedIndexRe.MatchString(line):
+		return p.emitIndex(triedIndexRe.FindStringSubmatch(line)[1], line)
+	}
+	return Event{}, false
+}
+
+// Done reports whether a terminal phase has been seen
+func (p *Parser) Done() bool {
+	return p.done
+}
+
+func (p *Parser) emit(e Event) (Event, bool) {
+	if e.Phase == p.last {
+		return Event{}, false
+	}
+	p.last = e.Phase
+	p.done = e.Phase.Terminal()
+	return e, true
+}
+
+func (p *Parser) emitIndex(index, line string) (Event, bool) {
+	if p.last == PhaseTryingIndex && index == p.index {
+		return Event{}, false
+	}
+	p.last = PhaseTryingIndex
+	p.index = index
+	return Event{Phase: PhaseTryingIndex, Index: index, Line: line}, true
+}
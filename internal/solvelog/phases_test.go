@@ -0,0 +1,83 @@
+package solvelog
+
+import (
+	"strings"
+	"testing"
+)
+
+const solvedOutput = `Reading input file 1 of 1: "/shared-data/ws-solve-1/image.jpg"...
+Extracting sources...
+simplexy: found 1523 sources.
+Solving...
+Reading file "/shared-data/ws-solve-1/image.axy"...
+Field 1 did not solve (index index-4208.fits, field objects 1-10).
+Field 1 did not solve (index index-4208.fits, field objects 11-20).
+Field 1 did not solve (index index-4207-00.fits, field objects 1-10).
+  log-odds ratio 142.5 (1.2e+62), 35 match, 0 conflict, 64 distractors, 99 index.
+Field 1: solved with index index-4207-01.fits.
+Field 1 solved: writing to file /shared-data/ws-solve-1/image.solved to indicate this.
+Field center: (RA,Dec) = (83.8, -5.4) deg.`
+
+func parseAll(output string) []Event {
+	var p Parser
+	var events []Event
+	for _, line := range strings.Split(output, "\n") {
+		if e, ok := p.Line(line); ok {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestParser_SolvedOutput(t *testing.T) {
+	events := parseAll(solvedOutput)
+
+	expected := []Event{
+		{Phase: PhaseSourceExtraction},
+		{Phase: PhaseSourceExtraction, Sources: 1523},
+		{Phase: PhaseTryingIndex, Index: "index-4208.fits"},
+		{Phase: PhaseTryingIndex, Index: "index-4207-00.fits"},
+		{Phase: PhaseSolved, Index: "index-4207-01.fits"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, e := range expected {
+		got := events[i]
+		if got.Phase != e.Phase || got.Index != e.Index || got.Sources != e.Sources {
+			t.Errorf("event %d: expected %+v, got %+v", i, e, got)
+		}
+		if got.Line == "" {
+			t.Errorf("event %d: expected the source line to be kept", i)
+		}
+	}
+}
+
+func TestParser_FailedOutput(t *testing.T) {
+	events := parseAll(`Extracting sources...
+Field 1 did not solve (index index-4210.fits, field objects 1-10).
+Did not solve (or no WCS file was written).
+Field 1: solved with index index-4210.fits.`)
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %+v", len(events), events)
+	}
+	if last := events[len(events)-1]; last.Phase != PhaseFailed {
+		t.Errorf("expected last phase failed, got %s", last.Phase)
+	}
+}
+
+func TestPhase_Terminal(t *testing.T) {
+	for phase, terminal := range map[Phase]bool{
+		PhaseUploadSaved:      false,
+		PhaseTryingIndex:      false,
+		PhaseSolved:           true,
+		PhaseFailed:           true,
+		PhaseCancelled:        true,
+		PhaseSourceExtraction: false,
+	} {
+		if phase.Terminal() != terminal {
+			t.Errorf("expected %s terminal=%v", phase, terminal)
+		}
+	}
+}
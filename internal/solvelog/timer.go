@@ -0,0 +1,53 @@
+package solvelog
+
+import "time"
+
+// Timer times the phases of a solve from its output, fed to it a line at a
+// time as it is produced. A phase lasts from the line that starts it to the
+// line that starts the next one. A Timer is not safe for concurrent use.
+type Timer struct {
+	parser Parser
+	// phase is the phase being timed and since when, or "" if none is
+	phase Phase
+	since time.Time
+}
+
+// Start starts timing a solve at now in PhaseStarted, unless a solve is
+// already being timed
+func (t *Timer) Start(now time.Time) {
+	if t.phase != "" {
+		return
+	}
+	t.parser = Parser{}
+	t.phase = PhaseStarted
+	t.since = now
+}
+
+// Line parses a line of output produced at now. If it starts another phase,
+// the phase it ends is returned with how long it took.
+func (t *Timer) Line(line string, now time.Time) (Phase, time.Duration, bool) {
+	if t.phase == "" {
+		return "", 0, false
+	}
+	event, ok := t.parser.Line(line)
+	if !ok || event.Phase == t.phase {
+		return "", 0, false
+	}
+	phase, d := t.phase, now.Sub(t.since)
+	t.phase, t.since = event.Phase, now
+	if event.Phase.Terminal() {
+		t.phase = ""
+	}
+	return phase, d, true
+}
+
+// Stop stops timing at now, returning the phase it ends with how long it
+// took, if a solve was being timed
+func (t *Timer) Stop(now time.Time) (Phase, time.Duration, bool) {
+	if t.phase == "" {
+		return "", 0, false
+	}
+	phase, d := t.phase, now.Sub(t.since)
+	t.phase = ""
+	return phase, d, true
+}
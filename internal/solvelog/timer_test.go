@@ -0,0 +1,50 @@
+package solvelog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	start := time.Date(2026, 1, 15, 22, 0, 0, 0, time.UTC)
+	durations := map[Phase]time.Duration{}
+	record := func(phase Phase, d time.Duration, ok bool) {
+		if ok {
+			durations[phase] += d
+		}
+	}
+
+	var timer Timer
+	// Output before the solve starts is not timed
+	record(timer.Line("Extracting sources...", start))
+	timer.Start(start)
+	for i, line := range strings.Split(solvedOutput, "\n") {
+		record(timer.Line(line, start.Add(time.Duration(i)*time.Second)))
+	}
+	record(timer.Stop(start.Add(time.Minute)))
+
+	expected := map[Phase]time.Duration{
+		PhaseStarted:          time.Second,
+		PhaseSourceExtraction: 4 * time.Second,
+		PhaseTryingIndex:      4 * time.Second,
+	}
+	if len(durations) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, durations)
+	}
+	for phase, d := range expected {
+		if durations[phase] != d {
+			t.Errorf("expected %s to take %v, got %v", phase, d, durations[phase])
+		}
+	}
+
+	// A solve that stops without reaching a terminal phase ends in the phase it was in
+	timer.Start(start)
+	timer.Line("Extracting sources...", start.Add(time.Second))
+	if phase, d, ok := timer.Stop(start.Add(3 * time.Second)); !ok || phase != PhaseSourceExtraction || d != 2*time.Second {
+		t.Errorf("expected source_extraction to take 2s, got %s %v %v", phase, d, ok)
+	}
+	if _, _, ok := timer.Stop(start.Add(4 * time.Second)); ok {
+		t.Error("expected nothing to stop once stopped")
+	}
+}
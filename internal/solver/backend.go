@@ -0,0 +1,95 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend is a way of running solve-field
+type Backend interface {
+	Solver
+	// Name identifies the backend, e.g. in health reports
+	Name() string
+	// Health reports whether the backend can currently run solves
+	Health(ctx context.Context) error
+}
+
+// BackendError reports a solve that failed because of the backend rather
+// than the image: solve-field could not be run, or a solver worker could not
+// be reached
+type BackendError struct {
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// Config holds the settings of every backend; each backend uses the ones it needs
+type Config struct {
+	// IndexPath is where the astrometry index files are, as seen by solve-field
+	IndexPath string
+	// Timeout bounds a single solve
+	Timeout time.Duration
+	// SolveFieldPath is the solve-field executable run by the local backend
+	SolveFieldPath string
+	// ContainerName is the container the docker backend runs solve-field in
+	ContainerName string
+	// URL is the base URL of the server the remote backend sends solves to
+	URL string
+}
+
+// Factory creates a backend from the configuration
+type Factory func(cfg Config) (Backend, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		"local":  func(cfg Config) (Backend, error) { return NewLocal(cfg) },
+		"docker": func(cfg Config) (Backend, error) { return NewDocker(cfg) },
+		"remote": func(cfg Config) (Backend, error) { return NewRemote(cfg) },
+	}
+)
+
+// Register makes a backend available to New under name, replacing any
+// backend already registered under it
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Backends returns the names of the registered backends
+func Backends() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the backend registered under name
+func New(name string, cfg Config) (Backend, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown solver backend %q (available: %s)", name, strings.Join(Backends(), ", "))
+	}
+	backend, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s backend: %w", name, err)
+	}
+	return backend, nil
+}
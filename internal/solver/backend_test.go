@@ -0,0 +1,91 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew_UnknownBackend(t *testing.T) {
+	_, err := New("telescope", Config{})
+	if err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+	if !strings.Contains(err.Error(), "docker, local, remote") {
+		t.Errorf("expected available backends to be listed, got %v", err)
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		backend string
+		cfg     Config
+	}{
+		{"docker", Config{}},
+		{"remote", Config{}},
+		{"remote", Config{URL: "ftp://solver"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(tt.backend, tt.cfg); err == nil {
+			t.Errorf("expected error creating %s backend from %+v", tt.backend, tt.cfg)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("test", func(cfg Config) (Backend, error) {
+		return NewRemote(cfg)
+	})
+	backend, err := New("test", Config{URL: "http://solver:8080"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if backend.Name() != "remote" {
+		t.Errorf("expected remote backend, got %s", backend.Name())
+	}
+}
+
+func TestLocal_Health(t *testing.T) {
+	indexPath := t.TempDir()
+
+	local := &Local{solveFieldPath: filepath.Join(indexPath, "missing-solve-field"), indexPath: indexPath}
+	if err := local.Health(context.Background()); err == nil || !strings.Contains(err.Error(), "solve-field not found") {
+		t.Errorf("expected missing solve-field to be reported, got %v", err)
+	}
+
+	local = &Local{solveFieldPath: "sh", indexPath: filepath.Join(indexPath, "missing")}
+	if err := local.Health(context.Background()); err == nil || !strings.Contains(err.Error(), "index path") {
+		t.Errorf("expected missing index path to be reported, got %v", err)
+	}
+
+	local = &Local{solveFieldPath: "sh", indexPath: indexPath}
+	if err := local.Health(context.Background()); err != nil {
+		t.Errorf("expected healthy backend, got %v", err)
+	}
+}
+
+func TestDocker_Health(t *testing.T) {
+	var calls []string
+	docker := &Docker{container: "astrometry-solver", indexPath: "/data/indexes"}
+	docker.run = func(ctx context.Context, name string, args ...string) (int, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		return 0, nil
+	}
+
+	if err := docker.Health(context.Background()); err != nil {
+		t.Errorf("expected healthy backend, got %v", err)
+	}
+	if len(calls) != 1 || calls[0] != "docker exec astrometry-solver test -d /data/indexes" {
+		t.Errorf("expected index check in container, got %v", calls)
+	}
+
+	docker.run = func(ctx context.Context, name string, args ...string) (int, error) {
+		return 1, errors.New("container is not running")
+	}
+	if err := docker.Health(context.Background()); err == nil || !strings.Contains(err.Error(), "astrometry-solver") {
+		t.Errorf("expected unhealthy container to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package solver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/fits"
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/wcs"
+)
+
+// maxOutputLine bounds a single line of solve-field output
+const maxOutputLine = 1 << 20
+
+// waitDelay is how long a killed solve waits for its output to be closed
+// before the pipe is closed for it
+const waitDelay = 5 * time.Second
+
+// commandFunc returns the command running solve-field with args
+type commandFunc func(ctx context.Context, args ...string) *exec.Cmd
+
+// solveField runs solve-field itself rather than through the astrometry
+// client. The client only returns solve-field's output once it exits, so
+// progress events could only be replayed at the end; here each line is passed
+// on to the OutputFunc attached to the context as it is produced. The client
+// is still used for its option and result types.
+//
+// The config written for solve-field only names the index directory.
+// inparallel is left off, so a solve checks its index files one at a time on
+// one CPU, which is what MAX_CONCURRENT_SOLVES counts per target.
+type solveField struct {
+	command   commandFunc
+	indexPath string
+	timeout   time.Duration
+	// backendExit, if set, reports whether an exit code and output mean the
+	// command never got to run solve-field on the image
+	backendExit func(code int, output string) bool
+}
+
+// Solve runs solve-field on imagePath, writing its files next to the image
+func (s *solveField) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	if opts == nil {
+		opts = client.DefaultSolveOptions()
+	}
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	dir := filepath.Dir(imagePath)
+	// The workspace is shared with solve-field wherever it runs, so the index
+	// directory is passed to it in a config file there
+	configPath := filepath.Join(dir, "astrometry.cfg")
+	config := "add_path " + s.indexPath + "\nautoindex\n"
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		return nil, &BackendError{fmt.Errorf("failed to write solve-field config: %w", err)}
+	}
+
+	start := time.Now()
+	cmd := s.command(ctx, solveFieldArgs(imagePath, configPath, opts)...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, &BackendError{err}
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return nil, &BackendError{fmt.Errorf("failed to run solve-field: %w", err)}
+	}
+
+	// Reading stops when ctx is done, in case a process outside the killed
+	// process group still holds the output open
+	stop := context.AfterFunc(ctx, func() { stdout.Close() }) //nolint:errcheck // Only ends the read
+	defer stop()
+
+	var output strings.Builder
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, maxOutputLine)
+	for scanner.Scan() {
+		line := scanner.Text()
+		output.WriteString(line)
+		output.WriteByte('\n')
+		Output(ctx, line)
+	}
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		// Drain the rest so solve-field is not left blocked writing to the pipe
+		io.Copy(io.Discard, stdout) //nolint:errcheck // The output is already being discarded
+	}
+	err = cmd.Wait()
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case err != nil:
+		err = fmt.Errorf("solve-field: %w: %s", err, lastLines(output.String(), 5))
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || s.backendExit != nil && s.backendExit(exitErr.ExitCode(), output.String()) {
+			return nil, &BackendError{err}
+		}
+		return nil, err
+	case scanErr != nil:
+		return nil, fmt.Errorf("solve-field output: %w", scanErr)
+	}
+
+	result, err := readSolution(strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + ".wcs")
+	if err != nil {
+		return nil, err
+	}
+	result.SolveTime = time.Since(start).Seconds()
+	result.RawOutput = output.String()
+	return result, nil
+}
+
+// solveFieldArgs returns the solve-field arguments for a solve of imagePath
+// with opts, writing only the files the server reads
+func solveFieldArgs(imagePath, configPath string, opts *client.SolveOptions) []string {
+	args := []string{
+		"--config", configPath,
+		"--dir", filepath.Dir(imagePath),
+		"--new-fits", "none",
+		"--corr", "none",
+		"--rdls", "none",
+		"--match", "none",
+		"--index-xyls", "none",
+	}
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	if opts.Overwrite {
+		args = append(args, "--overwrite")
+	}
+	if opts.NoPlots {
+		args = append(args, "--no-plots")
+	}
+	if opts.ScaleLow > 0 && opts.ScaleHigh > 0 {
+		units := opts.ScaleUnits
+		if units == "" {
+			units = "arcminwidth"
+		}
+		args = append(args, "--scale-units", units, "--scale-low", formatFloat(opts.ScaleLow), "--scale-high", formatFloat(opts.ScaleHigh))
+	}
+	if opts.DownsampleFactor > 1 {
+		args = append(args, "--downsample", strconv.Itoa(opts.DownsampleFactor))
+	}
+	if opts.DepthLow > 0 && opts.DepthHigh >= opts.DepthLow {
+		args = append(args, "--depth", fmt.Sprintf("%d-%d", opts.DepthLow, opts.DepthHigh))
+	}
+	if opts.Radius > 0 {
+		args = append(args, "--ra", formatFloat(opts.RA), "--dec", formatFloat(opts.Dec), "--radius", formatFloat(opts.Radius))
+	}
+	return append(args, imagePath)
+}
+
+// readSolution reads the WCS file solve-field wrote for a solved image. A
+// missing file means the image did not solve.
+func readSolution(path string) (*client.Result, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &client.Result{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read solution: %w", err)
+	}
+	file, err := fits.Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid solution file: %w", err)
+	}
+	header := file.HDUs[0].Header.Values()
+	for key, value := range header {
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			header[key] = strings.TrimRight(strings.ReplaceAll(value[1:len(value)-1], "''", "'"), " ")
+		}
+	}
+	solution, err := wcs.Parse(header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid solution file: %w", err)
+	}
+
+	ra, dec := solution.Center()
+	scale := solution.PixelScale()
+	return &client.Result{
+		Solved:      true,
+		RA:          ra,
+		Dec:         dec,
+		PixelScale:  scale,
+		Rotation:    orientation(solution.CD),
+		FieldWidth:  float64(solution.Width) * scale / 3600,
+		FieldHeight: float64(solution.Height) * scale / 3600,
+		WCSHeader:   header,
+	}, nil
+}
+
+// orientation returns the angle of the image's up direction east of north in
+// degrees, as solve-field reports it
+func orientation(cd [2][2]float64) float64 {
+	parity := 1.0
+	if cd[0][0]*cd[1][1]-cd[0][1]*cd[1][0] < 0 {
+		parity = -1
+	}
+	return -math.Atan2(parity*cd[1][0]-cd[0][1], parity*cd[0][0]+cd[1][1]) * 180 / math.Pi
+}
+
+// lastLines returns the last n lines of output, for error messages
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	return strings.Join(lines[max(0, len(lines)-n):], "\n")
+}
@@ -0,0 +1,165 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/fits"
+)
+
+// newTestSolveField returns a solveField running script with sh, with the
+// solve-field arguments as its positional parameters
+func newTestSolveField(script string) *solveField {
+	return &solveField{
+		command: func(ctx context.Context, args ...string) *exec.Cmd {
+			return exec.CommandContext(ctx, "sh", append([]string{"-c", script, "solve-field"}, args...)...)
+		},
+		indexPath: "/usr/share/astrometry",
+	}
+}
+
+func TestSolveField_StreamsOutput(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "image.jpg")
+	release := filepath.Join(dir, "release")
+	solution := fits.WCSHeader(map[string]string{
+		"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN",
+		"CRVAL1": "83.8221", "CRVAL2": "-5.3911",
+		"CRPIX1": "500.5", "CRPIX2": "400.5",
+		"CD1_1": "-0.001", "CD1_2": "0", "CD2_1": "0", "CD2_2": "0.001",
+		"IMAGEW": "1000", "IMAGEH": "800",
+	})
+	if err := os.WriteFile(filepath.Join(dir, "image.wcs"), solution.Encode(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The script waits after its first line until the test has seen it
+	s := newTestSolveField(`echo "simplexy: found 1523 sources."
+while [ ! -e ` + release + ` ]; do sleep 0.01; done
+echo "Field 1: solved with index index-4207-01.fits."`)
+
+	done := make(chan struct{})
+	var lines []string
+	ctx := WithOutputFunc(context.Background(), func(line string) {
+		select {
+		case <-done:
+			t.Errorf("expected %q before the solve returned", line)
+		default:
+		}
+		lines = append(lines, line)
+		if len(lines) == 1 {
+			os.WriteFile(release, nil, 0o644) //nolint:errcheck // The solve times out if it fails
+		}
+	})
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := s.Solve(ctx, imagePath, client.DefaultSolveOptions())
+	close(done)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"simplexy: found 1523 sources.", "Field 1: solved with index index-4207-01.fits."}
+	if !slices.Equal(lines, want) {
+		t.Errorf("expected lines %q, got %q", want, lines)
+	}
+	if result.RawOutput != strings.Join(want, "\n")+"\n" {
+		t.Errorf("expected the output in the result, got %q", result.RawOutput)
+	}
+	if !result.Solved || math.Abs(result.RA-83.8221) > 1e-3 || math.Abs(result.Dec+5.3911) > 1e-3 {
+		t.Errorf("expected solution at (83.8221, -5.3911), got %+v", result)
+	}
+	if math.Abs(result.PixelScale-3.6) > 1e-9 || math.Abs(result.FieldWidth-1) > 1e-9 || math.Abs(result.FieldHeight-0.8) > 1e-9 {
+		t.Errorf("expected 3.6 arcsec pixels over 1x0.8 degrees, got %f over %fx%f", result.PixelScale, result.FieldWidth, result.FieldHeight)
+	}
+	if result.WCSHeader["CTYPE1"] != "RA---TAN" {
+		t.Errorf("expected unquoted header values, got %q", result.WCSHeader["CTYPE1"])
+	}
+}
+
+func TestSolveField_Args(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "image.jpg")
+	opts := client.DefaultSolveOptions()
+	opts.ScaleLow, opts.ScaleHigh = 30, 60
+	opts.RA, opts.Dec, opts.Radius = 83.82, -5.39, 2
+
+	result, err := newTestSolveField(`echo "$@"`).Solve(context.Background(), imagePath, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Solved {
+		t.Error("expected no solution without a WCS file")
+	}
+	for _, arg := range []string{
+		"--config " + filepath.Join(dir, "astrometry.cfg"),
+		"--dir " + dir,
+		"--scale-units arcminwidth --scale-low 30 --scale-high 60",
+		"--downsample 2",
+		"--depth 10-20",
+		"--ra 83.82 --dec -5.39 --radius 2",
+	} {
+		if !strings.Contains(result.RawOutput, arg) {
+			t.Errorf("expected %q in %q", arg, result.RawOutput)
+		}
+	}
+	if !strings.HasSuffix(strings.TrimSpace(result.RawOutput), imagePath) {
+		t.Errorf("expected the image last, got %q", result.RawOutput)
+	}
+	config, err := os.ReadFile(filepath.Join(dir, "astrometry.cfg"))
+	if err != nil || !strings.Contains(string(config), "add_path /usr/share/astrometry\n") {
+		t.Errorf("expected the index path in the config, got %q, %v", config, err)
+	}
+	if strings.Contains(string(config), "inparallel") {
+		t.Errorf("expected one CPU per solve, got %q", config)
+	}
+}
+
+func TestSolveField_Failure(t *testing.T) {
+	var backendErr *BackendError
+	s := newTestSolveField(`echo "cannot read image" >&2; exit 2`)
+	s.backendExit = dockerExecFailed
+	_, err := s.Solve(context.Background(), filepath.Join(t.TempDir(), "image.jpg"), nil)
+	if err == nil || !strings.Contains(err.Error(), "cannot read image") {
+		t.Errorf("expected the failure with its output, got %v", err)
+	}
+	if errors.As(err, &backendErr) {
+		t.Errorf("expected a failure on the image, got a backend error: %v", err)
+	}
+
+	// Failures to run solve-field at all are the backend's
+	s = newTestSolveField(`echo "Error response from daemon: container astrometry-solver is not running" >&2; exit 1`)
+	s.backendExit = dockerExecFailed
+	if _, err := s.Solve(context.Background(), filepath.Join(t.TempDir(), "image.jpg"), nil); !errors.As(err, &backendErr) {
+		t.Errorf("expected a backend error for a stopped container, got %v", err)
+	}
+	s.command = func(ctx context.Context, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, filepath.Join(t.TempDir(), "missing-solve-field"), args...)
+	}
+	if _, err := s.Solve(context.Background(), filepath.Join(t.TempDir(), "image.jpg"), nil); !errors.As(err, &backendErr) {
+		t.Errorf("expected a backend error for a missing solve-field, got %v", err)
+	}
+}
+
+func TestSolveField_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestSolveField(`sleep 10`).Solve(ctx, filepath.Join(t.TempDir(), "image.jpg"), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the solve to stop when cancelled, took %s", elapsed)
+	}
+}
@@ -0,0 +1,72 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// Docker runs solve-field in a long-running solver container through docker
+// exec. It needs access to the Docker socket; see SECURITY.md.
+type Docker struct {
+	solver    Solver
+	container string
+	indexPath string
+	run       runFunc
+}
+
+// NewDocker creates a backend running solve-field in the container cfg.ContainerName
+func NewDocker(cfg Config) (*Docker, error) {
+	if cfg.ContainerName == "" {
+		return nil, errors.New("no solver container name configured")
+	}
+	c := &solveField{
+		command: func(ctx context.Context, args ...string) *exec.Cmd {
+			return exec.CommandContext(ctx, "docker", append([]string{"exec", cfg.ContainerName, "solve-field"}, args...)...)
+		},
+		indexPath:   cfg.IndexPath,
+		timeout:     cfg.Timeout,
+		backendExit: dockerExecFailed,
+	}
+	return &Docker{
+		// Cancelled solves have to be killed inside the container
+		solver:    NewKiller(c, cfg.ContainerName),
+		container: cfg.ContainerName,
+		indexPath: cfg.IndexPath,
+		run:       runCommand,
+	}, nil
+}
+
+// dockerExecFailed reports whether docker exec failed to run solve-field, as
+// opposed to solve-field failing: the CLI could not reach the daemon, the
+// container is not running, or solve-field could not be started in it
+func dockerExecFailed(code int, output string) bool {
+	switch code {
+	case 125, 126, 127:
+		return true
+	}
+	return strings.Contains(output, "Error response from daemon") || strings.Contains(output, "Cannot connect to the Docker daemon")
+}
+
+// Name returns "docker"
+func (d *Docker) Name() string {
+	return "docker"
+}
+
+// Solve runs solve-field on imagePath inside the container, passing its
+// output on as it is produced
+func (d *Docker) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	return d.solver.Solve(ctx, imagePath, opts)
+}
+
+// Health checks that the container is running and can see the index directory
+func (d *Docker) Health(ctx context.Context) error {
+	if _, err := d.run(ctx, "docker", "exec", d.container, "test", "-d", d.indexPath); err != nil {
+		return fmt.Errorf("container %s: %w", d.container, err)
+	}
+	return nil
+}
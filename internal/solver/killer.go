@@ -0,0 +1,82 @@
+// Package solver adapts the astrometry client to the way the server runs solve-field
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// killTimeout bounds how long killing a cancelled solve may take
+const killTimeout = 10 * time.Second
+
+// Solver is the subset of the astrometry client used to run solves
+type Solver interface {
+	Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error)
+}
+
+// runFunc runs a command, returning its exit code when it ran but failed
+type runFunc func(ctx context.Context, name string, args ...string) (int, error)
+
+// Killer wraps a solver that runs solve-field in a container through docker exec.
+// Cancelling the context only stops the local docker CLI and leaves solve-field
+// running inside the container, so Killer also kills every process in the
+// container that was started on the solve's workspace.
+type Killer struct {
+	next      Solver
+	container string
+	run       runFunc
+}
+
+// NewKiller wraps next, whose solves run in the named container
+func NewKiller(next Solver, container string) *Killer {
+	return &Killer{
+		next:      next,
+		container: container,
+		run:       runCommand,
+	}
+}
+
+// Solve runs the solve and, if ctx is cancelled before it finishes, makes sure
+// the solver processes working on imagePath's directory are gone before returning
+func (k *Killer) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	result, err := k.next.Solve(ctx, imagePath, opts)
+	if ctx.Err() != nil {
+		if killErr := k.kill(filepath.Dir(imagePath)); killErr != nil {
+			log.Printf("Failed to kill solver processes for %s: %v", imagePath, killErr)
+		}
+	}
+	return result, err
+}
+
+// kill sends SIGKILL to every process in the container whose command line mentions dir
+func (k *Killer) kill(dir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+
+	code, err := k.run(ctx, "docker", "exec", k.container, "pkill", "-KILL", "-f", regexp.QuoteMeta(dir))
+	// pkill exits with 1 when nothing matched, i.e. solve-field had already exited
+	if err != nil && code != 1 {
+		return err
+	}
+	return nil
+}
+
+func runCommand(ctx context.Context, name string, args ...string) (int, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), fmt.Errorf("%s: %w: %s", name, err, out)
+		}
+		return -1, err
+	}
+	return 0, nil
+}
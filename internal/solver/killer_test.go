@@ -0,0 +1,73 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+type solverFunc func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error)
+
+func (f solverFunc) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	return f(ctx, imagePath, opts)
+}
+
+func newTestKiller(next Solver, calls *[]string, code int, err error) *Killer {
+	k := NewKiller(next, "astrometry-solver")
+	k.run = func(ctx context.Context, name string, args ...string) (int, error) {
+		*calls = append(*calls, name+" "+strings.Join(args, " "))
+		return code, err
+	}
+	return k
+}
+
+func TestKiller_KillsCancelledSolve(t *testing.T) {
+	var calls []string
+	k := newTestKiller(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), &calls, 0, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := k.Solve(ctx, "/shared-data/ws-solve-123/image.jpg", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	expected := `docker exec astrometry-solver pkill -KILL -f /shared-data/ws-solve-123`
+	if len(calls) != 1 || calls[0] != expected {
+		t.Errorf("expected %q, got %v", expected, calls)
+	}
+}
+
+func TestKiller_LeavesFinishedSolveAlone(t *testing.T) {
+	var calls []string
+	k := newTestKiller(solverFunc(func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		return &client.Result{Solved: true}, nil
+	}), &calls, 0, nil)
+
+	result, err := k.Solve(context.Background(), "/shared-data/ws-solve-123/image.jpg", nil)
+	if err != nil || !result.Solved {
+		t.Errorf("expected solved result, got %+v, %v", result, err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("expected no kill, got %v", calls)
+	}
+}
+
+func TestKiller_NothingToKill(t *testing.T) {
+	var calls []string
+	k := newTestKiller(nil, &calls, 1, errors.New("exit status 1"))
+
+	if err := k.kill("/shared-data/ws-solve-123"); err != nil {
+		t.Errorf("expected no error when no process matched, got %v", err)
+	}
+
+	k = newTestKiller(nil, &calls, 2, errors.New("exit status 2"))
+	if err := k.kill("/shared-data/ws-solve-123"); err == nil {
+		t.Error("expected error when pkill fails")
+	}
+}
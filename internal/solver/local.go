@@ -0,0 +1,65 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// Local runs solve-field directly on the host, so the server needs no access
+// to the Docker socket
+type Local struct {
+	solver         Solver
+	solveFieldPath string
+	indexPath      string
+}
+
+// NewLocal creates a backend running the solve-field executable at
+// cfg.SolveFieldPath (looked up in PATH if it has no directory)
+func NewLocal(cfg Config) (*Local, error) {
+	solveFieldPath := cfg.SolveFieldPath
+	if solveFieldPath == "" {
+		solveFieldPath = "solve-field"
+	}
+	return &Local{
+		solver: &solveField{
+			command: func(ctx context.Context, args ...string) *exec.Cmd {
+				return exec.CommandContext(ctx, solveFieldPath, args...)
+			},
+			indexPath: cfg.IndexPath,
+			timeout:   cfg.Timeout,
+		},
+		solveFieldPath: solveFieldPath,
+		indexPath:      cfg.IndexPath,
+	}, nil
+}
+
+// Name returns "local"
+func (l *Local) Name() string {
+	return "local"
+}
+
+// Solve runs solve-field on imagePath, passing its output on as it is
+// produced. Cancelling ctx kills it along with the processes it started.
+func (l *Local) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	return l.solver.Solve(ctx, imagePath, opts)
+}
+
+// Health checks that solve-field can be found and the index directory exists
+func (l *Local) Health(ctx context.Context) error {
+	if _, err := exec.LookPath(l.solveFieldPath); err != nil {
+		return fmt.Errorf("solve-field not found: %w", err)
+	}
+	info, err := os.Stat(l.indexPath)
+	if err != nil {
+		return fmt.Errorf("index path: %w", err)
+	}
+	if !info.IsDir() {
+		return errors.New("index path is not a directory")
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package solver
+
+import "context"
+
+// OutputFunc receives solver output a line at a time, as it is produced.
+// Calls for a single solve are made sequentially.
+type OutputFunc func(line string)
+
+type outputKey struct{}
+
+// WithOutputFunc returns a context that passes the output of a solve made with
+// it to fn. Solvers that only return their output at the end (e.g. the
+// astrometry client's RawOutput) do not call fn.
+func WithOutputFunc(ctx context.Context, fn OutputFunc) context.Context {
+	return context.WithValue(ctx, outputKey{}, fn)
+}
+
+// Output reports a line of solver output to the function attached to ctx, if any
+func Output(ctx context.Context, line string) {
+	if fn, ok := ctx.Value(outputKey{}).(OutputFunc); ok && fn != nil {
+		fn(line)
+	}
+}
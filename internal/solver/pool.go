@@ -0,0 +1,344 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// Default health settings of a pool
+const (
+	DefaultMaxFailures = 3
+	DefaultCooldown    = 30 * time.Second
+)
+
+// ErrNoTargets is reported when no pool target is healthy
+var ErrNoTargets = errors.New("no healthy solver targets")
+
+// ErrTargetsFull is returned by Solve when every target is already running as
+// many solves as it may
+var ErrTargetsFull = errors.New("every solver target is running its maximum of solves")
+
+// Target is one solver of a pool
+type Target struct {
+	// Name identifies the target, e.g. its container name or URL
+	Name    string
+	Backend Backend
+	// MaxConcurrent is how many solves the target runs at once, or 0 for no
+	// limit
+	MaxConcurrent int
+}
+
+// TargetStats is a snapshot of a pool target's load and health
+type TargetStats struct {
+	Name    string
+	Backend string
+	Running int
+	// MaxConcurrent is the target's limit on running solves, 0 if it has none
+	MaxConcurrent int
+	Solves        int
+	// Failures counts solves that failed with a backend error;
+	// ConsecutiveFailures only those since the last success
+	Failures            int
+	ConsecutiveFailures int
+	Healthy             bool
+	UnhealthySince      time.Time
+	LastError           string
+}
+
+type target struct {
+	Target
+	running             int
+	solves              int
+	failures            int
+	consecutiveFailures int
+	unhealthySince      time.Time
+	nextTrial           time.Time
+	lastError           string
+}
+
+func (t *target) healthy() bool {
+	return t.unhealthySince.IsZero()
+}
+
+// Pool spreads solves over several targets. Each solve goes to the healthy
+// target running the fewest solves, among those running fewer than their
+// MaxConcurrent. A target is marked unhealthy after
+// maxFailures consecutive solves failing with a *BackendError, or a failed
+// health check. Until a health check or a solve on it succeeds, it is only
+// given a trial solve once per cooldown, or solves no healthy target is left
+// to take. A solve that fails with a *BackendError or a full queue on one
+// target is retried on another; other failures, such as an image
+// solve-field cannot read or a solve running out of time, would fail the
+// same way anywhere and are returned as they are.
+type Pool struct {
+	maxFailures int
+	cooldown    time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	targets []*target
+}
+
+// NewPool creates a pool of targets, which must have distinct names
+func NewPool(targets []Target, maxFailures int, cooldown time.Duration) (*Pool, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("solver pool has no targets")
+	}
+	if maxFailures < 1 {
+		maxFailures = 1
+	}
+	p := &Pool{
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+		now:         time.Now,
+	}
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if seen[t.Name] {
+			return nil, fmt.Errorf("duplicate solver target %q", t.Name)
+		}
+		seen[t.Name] = true
+		p.targets = append(p.targets, &target{Target: t})
+	}
+	return p, nil
+}
+
+// Capacity returns how many solves the targets can run at once between them,
+// or 0 if a target has no limit
+func (p *Pool) Capacity() int {
+	capacity := 0
+	for _, t := range p.targets {
+		if t.MaxConcurrent <= 0 {
+			return 0
+		}
+		capacity += t.MaxConcurrent
+	}
+	return capacity
+}
+
+// Name returns the names of the targets' backends, e.g. "docker" or "docker+remote"
+func (p *Pool) Name() string {
+	var names []string
+	for _, t := range p.targets {
+		if name := t.Backend.Name(); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// Solve runs the solve on the least busy healthy target, moving on to the next
+// least busy target it has not tried yet if that target cannot run it
+func (p *Pool) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	tried := make(map[*target]bool)
+	var lastErr error
+	for {
+		t := p.acquire(tried)
+		if t == nil {
+			// Every target has been tried or is full
+			if lastErr == nil {
+				return nil, ErrTargetsFull
+			}
+			return nil, lastErr
+		}
+		tried[t] = true
+
+		result, err := t.Backend.Solve(ctx, imagePath, opts)
+		p.release(t, err, ctx.Err() != nil)
+		if err == nil || ctx.Err() != nil || !failover(err) {
+			return result, err
+		}
+		lastErr = fmt.Errorf("solver %s: %w", t.Name, err)
+	}
+}
+
+// acquire picks the least busy target not in tried with a free slot and counts
+// the solve as running on it
+func (p *Pool) acquire(tried map[*target]bool) *target {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	// Unhealthy targets compete with healthy ones once their cooldown is over,
+	// and are otherwise the last resort
+	cooling := func(t *target) bool {
+		return !t.healthy() && now.Before(t.nextTrial)
+	}
+	var best *target
+	for _, t := range p.targets {
+		if tried[t] || t.MaxConcurrent > 0 && t.running >= t.MaxConcurrent {
+			continue
+		}
+		if best == nil || cooling(best) && !cooling(t) ||
+			cooling(best) == cooling(t) && t.running < best.running {
+			best = t
+		}
+	}
+	if best != nil {
+		best.running++
+		best.solves++
+		if !best.healthy() {
+			// Only one trial per cooldown
+			best.nextTrial = now.Add(p.cooldown)
+		}
+	}
+	return best
+}
+
+// failover reports whether a solve that failed with err may succeed on
+// another target
+func failover(err error) bool {
+	var backendErr *BackendError
+	return errors.As(err, &backendErr) || errors.Is(err, queue.ErrQueueFull)
+}
+
+// release records the outcome of a solve on t. Only backend errors count
+// against it: cancelled solves and those that failed on the image say
+// nothing about the target, and a target with a full queue is busy, not
+// broken.
+func (p *Pool) release(t *target, err error, cancelled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t.running--
+	var backendErr *BackendError
+	switch {
+	case cancelled || errors.Is(err, queue.ErrQueueFull):
+	case errors.As(err, &backendErr):
+		t.failures++
+		t.consecutiveFailures++
+		t.lastError = err.Error()
+		if t.consecutiveFailures >= p.maxFailures && t.healthy() {
+			p.markUnhealthyLocked(t)
+		}
+	default:
+		t.consecutiveFailures = 0
+		t.unhealthySince = time.Time{}
+	}
+}
+
+// probe runs the health check of every target at once, returning their errors
+// in the order the targets were configured
+func (p *Pool) probe(ctx context.Context) []error {
+	errs := make([]error, len(p.targets))
+	var wg sync.WaitGroup
+	for i, t := range p.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = t.Backend.Health(ctx)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// Check runs the health check of every target, marking those that fail it
+// unhealthy and those that pass it healthy again
+func (p *Pool) Check(ctx context.Context) {
+	errs := p.probe(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.targets {
+		switch {
+		case errs[i] == nil:
+			t.consecutiveFailures = 0
+			t.unhealthySince = time.Time{}
+		case t.healthy():
+			t.lastError = errs[i].Error()
+			p.markUnhealthyLocked(t)
+		default:
+			t.lastError = errs[i].Error()
+		}
+	}
+}
+
+func (p *Pool) markUnhealthyLocked(t *target) {
+	t.unhealthySince = p.now()
+	t.nextTrial = t.unhealthySince.Add(p.cooldown)
+}
+
+// Health runs the health check of every target and reports the pool healthy
+// if any of them passes it. Unlike Check it does not change which targets
+// solves are sent to, so a liveness probe cannot take a target out of use.
+func (p *Pool) Health(ctx context.Context) error {
+	var failing []string
+	for i, err := range p.probe(ctx) {
+		if err == nil {
+			return nil
+		}
+		failing = append(failing, fmt.Sprintf("%s: %v", p.targets[i].Name, err))
+	}
+	return fmt.Errorf("%w (%s)", ErrNoTargets, strings.Join(failing, "; "))
+}
+
+// Stats returns a snapshot of every target, in the order they were configured
+func (p *Pool) Stats() []TargetStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]TargetStats, len(p.targets))
+	for i, t := range p.targets {
+		stats[i] = TargetStats{
+			Name:                t.Name,
+			Backend:             t.Backend.Name(),
+			Running:             t.running,
+			MaxConcurrent:       t.MaxConcurrent,
+			Solves:              t.solves,
+			Failures:            t.failures,
+			ConsecutiveFailures: t.consecutiveFailures,
+			Healthy:             t.healthy(),
+			UnhealthySince:      t.unhealthySince,
+			LastError:           t.lastError,
+		}
+	}
+	return stats
+}
+
+// NewTargets creates the targets of a pool. Each name is either the URL of a
+// solver worker, for the remote backend, or the name of a solver container, for
+// the docker backend. Without names, the pool has a single target created by
+// the named backend from cfg.
+func NewTargets(backend string, names []string, cfg Config) ([]Target, error) {
+	if len(names) == 0 {
+		b, err := New(backend, cfg)
+		if err != nil {
+			return nil, err
+		}
+		name := backend
+		switch backend {
+		case "docker":
+			name = cfg.ContainerName
+		case "remote":
+			name = cfg.URL
+		}
+		return []Target{{Name: name, Backend: b}}, nil
+	}
+
+	targets := make([]Target, 0, len(names))
+	for _, name := range names {
+		targetCfg := cfg
+		backend := "docker"
+		if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+			backend = "remote"
+			targetCfg.URL = name
+		} else {
+			targetCfg.ContainerName = name
+		}
+		b, err := New(backend, targetCfg)
+		if err != nil {
+			return nil, fmt.Errorf("solver target %s: %w", name, err)
+		}
+		targets = append(targets, Target{Name: name, Backend: b})
+	}
+	return targets, nil
+}
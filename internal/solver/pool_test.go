@@ -0,0 +1,338 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+type fakeBackend struct {
+	solverFunc
+	health error
+}
+
+func (f *fakeBackend) Name() string {
+	return "fake"
+}
+
+func (f *fakeBackend) Health(ctx context.Context) error {
+	return f.health
+}
+
+func solvedBy(name string) solverFunc {
+	return func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		return &client.Result{Solved: true, RawOutput: name}, nil
+	}
+}
+
+func failing(err error) solverFunc {
+	return func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+		return nil, err
+	}
+}
+
+func newTestPool(t *testing.T, backends map[string]*fakeBackend, names ...string) *Pool {
+	t.Helper()
+	var targets []Target
+	for _, name := range names {
+		targets = append(targets, Target{Name: name, Backend: backends[name]})
+	}
+	p, err := NewPool(targets, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	return p
+}
+
+func TestPool_LeastBusyFirst(t *testing.T) {
+	started := make(chan string)
+	finish := make(chan struct{})
+	blocking := func(name string) solverFunc {
+		return func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			started <- name
+			<-finish
+			return &client.Result{Solved: true}, nil
+		}
+	}
+	p := newTestPool(t, map[string]*fakeBackend{
+		"a": {solverFunc: blocking("a")},
+		"b": {solverFunc: blocking("b")},
+	}, "a", "b")
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Solve(context.Background(), "image.jpg", nil) //nolint:errcheck // Only the dispatch is tested
+		}()
+	}
+	got := map[string]bool{<-started: true, <-started: true}
+	if !got["a"] || !got["b"] {
+		t.Errorf("expected one solve on each target, got %v", got)
+	}
+	for _, s := range p.Stats() {
+		if s.Running != 1 {
+			t.Errorf("expected 1 solve running on %s, got %d", s.Name, s.Running)
+		}
+	}
+	close(finish)
+	wg.Wait()
+
+	for _, s := range p.Stats() {
+		if s.Running != 0 || s.Solves != 1 {
+			t.Errorf("expected %s to have finished 1 solve, got %+v", s.Name, s)
+		}
+	}
+}
+
+func TestPool_RetriesOnAnotherTarget(t *testing.T) {
+	p := newTestPool(t, map[string]*fakeBackend{
+		"a": {solverFunc: failing(&BackendError{errors.New("docker exec failed")})},
+		"b": {solverFunc: solvedBy("b")},
+	}, "a", "b")
+
+	result, err := p.Solve(context.Background(), "image.jpg", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RawOutput != "b" {
+		t.Errorf("expected solve to be retried on b, got %s", result.RawOutput)
+	}
+	stats := p.Stats()
+	if stats[0].Failures != 1 || stats[0].LastError != "docker exec failed" {
+		t.Errorf("expected failure to be recorded on a, got %+v", stats[0])
+	}
+	if !stats[0].Healthy {
+		t.Error("expected a to stay healthy after one failure")
+	}
+}
+
+func TestPool_ImageFailuresNotRetried(t *testing.T) {
+	var solves []string
+	recording := func(name string, err error) solverFunc {
+		return func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			solves = append(solves, name)
+			return nil, err
+		}
+	}
+	for _, failure := range []error{errors.New("solve-field: exit status 1: cannot read image"), context.DeadlineExceeded} {
+		solves = nil
+		p := newTestPool(t, map[string]*fakeBackend{
+			"a": {solverFunc: recording("a", failure)},
+			"b": {solverFunc: recording("b", failure)},
+		}, "a", "b")
+		for range 3 {
+			if _, err := p.Solve(context.Background(), "image.jpg", nil); !errors.Is(err, failure) {
+				t.Errorf("expected %v to be returned, got %v", failure, err)
+			}
+		}
+		if len(solves) != 3 || solves[0] != solves[1] || solves[1] != solves[2] {
+			t.Errorf("%v: expected each solve on a single target, got %v", failure, solves)
+		}
+		for _, s := range p.Stats() {
+			if !s.Healthy || s.Failures != 0 {
+				t.Errorf("%v: expected no failure to count against %s, got %+v", failure, s.Name, s)
+			}
+		}
+	}
+}
+
+func TestPool_MarksUnhealthy(t *testing.T) {
+	now := time.Now()
+	backends := map[string]*fakeBackend{
+		"a": {solverFunc: failing(&BackendError{errors.New("container gone")})},
+		"b": {solverFunc: solvedBy("b")},
+	}
+	p := newTestPool(t, backends, "a", "b")
+	p.now = func() time.Time { return now }
+
+	// a is tried first and fails twice, so it becomes unhealthy
+	for range 2 {
+		if result, err := p.Solve(context.Background(), "image.jpg", nil); err != nil || result.RawOutput != "b" {
+			t.Fatalf("expected b to take over, got %v, %v", result, err)
+		}
+	}
+	stats := p.Stats()
+	if stats[0].Healthy || stats[0].ConsecutiveFailures != 2 {
+		t.Errorf("expected a to be unhealthy after 2 failures, got %+v", stats[0])
+	}
+
+	// During its cooldown a is passed over
+	now = now.Add(10 * time.Second)
+	if result, _ := p.Solve(context.Background(), "image.jpg", nil); result.RawOutput != "b" {
+		t.Errorf("expected b to solve, got %s", result.RawOutput)
+	}
+	if stats := p.Stats(); stats[0].Solves != 2 {
+		t.Errorf("expected no solve on a during its cooldown, got %d", stats[0].Solves)
+	}
+
+	// Once the cooldown is over, a gets a trial
+	backends["a"].solverFunc = solvedBy("a")
+	now = now.Add(time.Minute)
+	if result, _ := p.Solve(context.Background(), "image.jpg", nil); result.RawOutput != "a" {
+		t.Errorf("expected a trial solve on a, got %s", result.RawOutput)
+	}
+	if !p.Stats()[0].Healthy {
+		t.Error("expected a to be healthy after its trial solve")
+	}
+}
+
+func TestPool_UnhealthyAsLastResort(t *testing.T) {
+	p := newTestPool(t, map[string]*fakeBackend{
+		"a": {solverFunc: solvedBy("a"), health: errors.New("not running")},
+	}, "a")
+	p.Check(context.Background())
+
+	result, err := p.Solve(context.Background(), "image.jpg", nil)
+	if err != nil || result.RawOutput != "a" {
+		t.Errorf("expected the only target to be used while unhealthy, got %v, %v", result, err)
+	}
+}
+
+func TestPool_MaxConcurrent(t *testing.T) {
+	started := make(chan string)
+	finish := make(chan struct{})
+	blocking := func(name string) solverFunc {
+		return func(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+			started <- name
+			<-finish
+			return &client.Result{Solved: true}, nil
+		}
+	}
+	p, err := NewPool([]Target{
+		{Name: "a", Backend: &fakeBackend{solverFunc: blocking("a")}, MaxConcurrent: 1},
+		// b is unhealthy and so only taken when a is full
+		{Name: "b", Backend: &fakeBackend{solverFunc: blocking("b"), health: errors.New("not running")}, MaxConcurrent: 1},
+	}, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	if p.Capacity() != 2 {
+		t.Errorf("expected capacity 2, got %d", p.Capacity())
+	}
+	p.Check(context.Background())
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Solve(context.Background(), "image.jpg", nil) //nolint:errcheck // Only the dispatch is tested
+		}()
+	}
+	got := map[string]bool{<-started: true, <-started: true}
+	if !got["a"] || !got["b"] {
+		t.Errorf("expected a full target to be passed over, got %v", got)
+	}
+	if _, err := p.Solve(context.Background(), "image.jpg", nil); !errors.Is(err, ErrTargetsFull) {
+		t.Errorf("expected ErrTargetsFull with every target full, got %v", err)
+	}
+	close(finish)
+	wg.Wait()
+
+	p, _ = NewPool([]Target{{Name: "a", Backend: &fakeBackend{}, MaxConcurrent: 2}, {Name: "b", Backend: &fakeBackend{}}}, 2, time.Minute)
+	if p.Capacity() != 0 {
+		t.Errorf("expected no capacity limit with an unlimited target, got %d", p.Capacity())
+	}
+}
+
+func TestPool_QueueFullAndCancel(t *testing.T) {
+	full := &queue.FullError{RetryAfter: time.Second}
+	p := newTestPool(t, map[string]*fakeBackend{
+		"a": {solverFunc: failing(full)},
+		"b": {solverFunc: failing(full)},
+	}, "a", "b")
+
+	for range 3 {
+		_, err := p.Solve(context.Background(), "image.jpg", nil)
+		if !errors.Is(err, queue.ErrQueueFull) {
+			t.Fatalf("expected queue full error, got %v", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.targets[0].Backend = &fakeBackend{solverFunc: failing(context.Canceled)}
+	if _, err := p.Solve(ctx, "image.jpg", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+
+	for _, s := range p.Stats() {
+		if !s.Healthy || s.Failures != 0 {
+			t.Errorf("expected busy and cancelled solves not to count against %s, got %+v", s.Name, s)
+		}
+	}
+}
+
+func TestPool_Check(t *testing.T) {
+	backends := map[string]*fakeBackend{
+		"a": {health: errors.New("index path missing")},
+		"b": {},
+	}
+	p := newTestPool(t, backends, "a", "b")
+
+	if err := p.Health(context.Background()); err != nil {
+		t.Errorf("expected pool with a healthy target to be healthy, got %v", err)
+	}
+	if !p.Stats()[0].Healthy {
+		t.Error("expected Health to leave a in use")
+	}
+	p.Check(context.Background())
+	stats := p.Stats()
+	if stats[0].Healthy || stats[0].LastError != "index path missing" {
+		t.Errorf("expected a to be marked unhealthy, got %+v", stats[0])
+	}
+
+	backends["b"].health = errors.New("not running")
+	if err := p.Health(context.Background()); !errors.Is(err, ErrNoTargets) || !strings.Contains(err.Error(), "b: not running") {
+		t.Errorf("expected no healthy targets, got %v", err)
+	}
+
+	backends["a"].health = nil
+	p.Check(context.Background())
+	if !p.Stats()[0].Healthy {
+		t.Error("expected a to be healthy again after passing its check")
+	}
+}
+
+func TestNewPool_Invalid(t *testing.T) {
+	if _, err := NewPool(nil, 1, time.Minute); err == nil {
+		t.Error("expected error for empty pool")
+	}
+	b := &fakeBackend{}
+	if _, err := NewPool([]Target{{Name: "a", Backend: b}, {Name: "a", Backend: b}}, 1, time.Minute); err == nil {
+		t.Error("expected error for duplicate target names")
+	}
+}
+
+func TestNewTargets(t *testing.T) {
+	targets, err := NewTargets("docker", []string{"solver-1", "http://worker:8080"}, Config{IndexPath: "/data/indexes"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 2 || targets[0].Backend.Name() != "docker" || targets[1].Backend.Name() != "remote" {
+		t.Errorf("expected a docker and a remote target, got %+v", targets)
+	}
+
+	targets, err = NewTargets("docker", nil, Config{ContainerName: "astrometry-solver"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 1 || targets[0].Name != "astrometry-solver" {
+		t.Errorf("expected a single target named after the container, got %+v", targets)
+	}
+
+	p, err := NewPool(append(targets, Target{Name: "worker", Backend: &Remote{}}), 1, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Name() != "docker+remote" {
+		t.Errorf("expected pool name docker+remote, got %s", p.Name())
+	}
+}
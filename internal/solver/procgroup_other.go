@@ -0,0 +1,9 @@
+//go:build !unix
+
+package solver
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as it is: without process groups, only
+// solve-field itself is killed when its context is done
+func killProcessGroup(cmd *exec.Cmd) {}
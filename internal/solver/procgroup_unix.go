@@ -0,0 +1,18 @@
+//go:build unix
+
+package solver
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup makes cmd start in its own process group and be killed
+// with the whole group when its context is done, so the astrometry-engine
+// processes solve-field starts are killed with it
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,69 @@
+//go:build unix
+
+package solver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// running reports whether the process is alive, counting a zombie as gone
+func running(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		// Without /proc a zombie cannot be told apart
+		return !errors.Is(err, os.ErrNotExist)
+	}
+	// The state follows the command name in parentheses
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func TestSolveField_CancelKillsChildren(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	// Like solve-field, the script leaves the work to a child process
+	s := newTestSolveField(`sleep 30 &
+echo $! > ` + pidFile + `
+wait`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Solve(ctx, filepath.Join(dir, "image.jpg"), nil)
+		done <- err
+	}()
+
+	var pid int
+	deadline := time.Now().Add(5 * time.Second)
+	for pid == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		data, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	if pid == 0 {
+		t.Fatal("expected the child to start")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	for running(pid) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if running(pid) {
+		syscall.Kill(pid, syscall.SIGKILL) //nolint:errcheck // Only cleans up after the failure
+		t.Errorf("expected child %d to be killed with the solve", pid)
+	}
+}
@@ -0,0 +1,257 @@
+package solver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+// remoteHealthTimeout bounds a remote health check
+const remoteHealthTimeout = 5 * time.Second
+
+// Remote sends solves to another instance of this server, acting as a solver
+// worker, through its POST /solve endpoint
+type Remote struct {
+	baseURL string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// NewRemote creates a backend sending solves to the server at cfg.URL
+func NewRemote(cfg Config) (*Remote, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid solver URL %q", cfg.URL)
+	}
+	return &Remote{
+		baseURL: strings.TrimSuffix(cfg.URL, "/"),
+		timeout: cfg.Timeout,
+		// Solves take minutes, so they are bounded by their context instead
+		client: &http.Client{},
+	}, nil
+}
+
+// Name returns "remote"
+func (r *Remote) Name() string {
+	return "remote"
+}
+
+// remoteResponse is the subset of the worker's SolveResponse needed to rebuild the result
+type remoteResponse struct {
+	Solved      bool              `json:"solved"`
+	RA          float64           `json:"ra"`
+	Dec         float64           `json:"dec"`
+	PixelScale  float64           `json:"pixel_scale"`
+	Rotation    float64           `json:"rotation"`
+	FieldWidth  float64           `json:"field_width"`
+	FieldHeight float64           `json:"field_height"`
+	WCSHeader   map[string]string `json:"wcs_header"`
+	SolveTime   float64           `json:"solve_time"`
+	RawOutput   string            `json:"raw_output"`
+	Error       string            `json:"error"`
+}
+
+// Solve uploads imagePath to the worker. The worker's progress events are
+// passed on to the OutputFunc attached to ctx as solver output. A full
+// worker queue is reported as a *queue.FullError, and a worker that cannot
+// be reached or fails with a server error as a *BackendError.
+func (r *Remote) Solve(ctx context.Context, imagePath string, opts *client.SolveOptions) (*client.Result, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	body, contentType := remoteForm(imagePath, opts)
+	defer body.Close() //nolint:errcheck // Only stops the form writer early
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/solve", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "text/event-stream, application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &BackendError{fmt.Errorf("remote solver: %w", err)}
+	}
+	defer resp.Body.Close() //nolint:errcheck // Error from Close on read is not critical
+
+	var response remoteResponse
+	switch mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, &queue.FullError{RetryAfter: time.Duration(max(retryAfter, 1)) * time.Second}
+	case resp.StatusCode != http.StatusOK:
+		json.NewDecoder(resp.Body).Decode(&response) //nolint:errcheck // The status is reported either way
+		if response.Error == "" {
+			response.Error = resp.Status
+		}
+		err := fmt.Errorf("remote solver: %s", response.Error)
+		if resp.StatusCode >= 500 {
+			return nil, &BackendError{err}
+		}
+		return nil, err
+	case mediaType == "text/event-stream":
+		err = readEvents(ctx, resp.Body, &response)
+	default:
+		err = json.NewDecoder(resp.Body).Decode(&response)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// The connection was lost or the worker is not speaking its protocol
+		return nil, &BackendError{fmt.Errorf("remote solver: %w", err)}
+	}
+	if !response.Solved && response.Error != "" {
+		return nil, fmt.Errorf("remote solver: %s", response.Error)
+	}
+
+	return &client.Result{
+		Solved:      response.Solved,
+		RA:          response.RA,
+		Dec:         response.Dec,
+		PixelScale:  response.PixelScale,
+		Rotation:    response.Rotation,
+		FieldWidth:  response.FieldWidth,
+		FieldHeight: response.FieldHeight,
+		WCSHeader:   response.WCSHeader,
+		SolveTime:   response.SolveTime,
+		RawOutput:   response.RawOutput,
+	}, nil
+}
+
+// remoteForm streams the multipart form for a solve of imagePath with opts
+func remoteForm(imagePath string, opts *client.SolveOptions) (io.ReadCloser, string) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeRemoteForm(form, imagePath, opts)) //nolint:errcheck // Always returns nil
+	}()
+	return pr, form.FormDataContentType()
+}
+
+func writeRemoteForm(form *multipart.Writer, imagePath string, opts *client.SolveOptions) error {
+	if opts == nil {
+		opts = client.DefaultSolveOptions()
+	}
+	fields := map[string]string{
+		"scale_units":       opts.ScaleUnits,
+		"downsample_factor": strconv.Itoa(opts.DownsampleFactor),
+		"depth_low":         strconv.Itoa(opts.DepthLow),
+		"depth_high":        strconv.Itoa(opts.DepthHigh),
+		// The worker caches results itself; this server already checked its own cache
+		"no_cache": "true",
+	}
+	for name, value := range map[string]float64{
+		"scale_low":  opts.ScaleLow,
+		"scale_high": opts.ScaleHigh,
+	} {
+		if value != 0 {
+			fields[name] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+	}
+	// A radius is what makes a position a hint, as for solve-field; ra and dec
+	// are sent with it even when one of them is 0
+	if opts.Radius > 0 {
+		fields["ra"] = strconv.FormatFloat(opts.RA, 'f', -1, 64)
+		fields["dec"] = strconv.FormatFloat(opts.Dec, 'f', -1, 64)
+		fields["radius"] = strconv.FormatFloat(opts.Radius, 'f', -1, 64)
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck // Error from Close on read is not critical
+
+	part, err := form.CreateFormFile("image", filepath.Base(imagePath))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// readEvents reads the worker's Server-Sent Events, passing the solver output
+// lines of "phase" events to ctx's OutputFunc and decoding the "result" event
+// into response
+func readEvents(ctx context.Context, body io.Reader, response *remoteResponse) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case line == "":
+			switch event {
+			case "phase":
+				var phase struct {
+					Line string `json:"line"`
+				}
+				if json.Unmarshal([]byte(data), &phase) == nil && phase.Line != "" {
+					Output(ctx, phase.Line)
+				}
+			case "result":
+				return json.Unmarshal([]byte(data), response)
+			}
+			event, data = "", ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("event stream ended without a result")
+}
+
+// Health checks that the worker's /health endpoint responds
+func (r *Remote) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, remoteHealthTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // Error from Close on read is not critical
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("worker health check responded %s", resp.Status)
+	}
+	return nil
+}
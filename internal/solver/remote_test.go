@@ -0,0 +1,183 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DiarmuidKelly/astrometry-api-server/internal/queue"
+	client "github.com/DiarmuidKelly/astrometry-go-client"
+)
+
+func newTestRemote(t *testing.T, handler http.HandlerFunc) (*Remote, string) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	remote, err := NewRemote(Config{URL: server.URL + "/"})
+	if err != nil {
+		t.Fatalf("failed to create remote backend: %v", err)
+	}
+
+	imagePath := filepath.Join(t.TempDir(), "image.jpg")
+	if err := os.WriteFile(imagePath, []byte("fake image"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return remote, imagePath
+}
+
+func TestRemote_SolveJSON(t *testing.T) {
+	remote, imagePath := newTestRemote(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/solve" {
+			t.Errorf("expected /solve, got %s", r.URL.Path)
+		}
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			t.Errorf("expected image upload: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		if string(data) != "fake image" {
+			t.Errorf("expected image to be uploaded, got %q", data)
+		}
+		if got := r.FormValue("scale_low"); got != "10.5" {
+			t.Errorf("expected scale_low 10.5, got %q", got)
+		}
+		if got := r.FormValue("ra"); got != "" {
+			t.Errorf("expected unset ra to be left out, got %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"solved":true,"ra":83.8,"dec":-5.4,"pixel_scale":1.2,"wcs_header":{"CTYPE1":"RA---TAN"}}`)
+	})
+
+	opts := client.DefaultSolveOptions()
+	opts.ScaleLow, opts.ScaleHigh = 10.5, 20
+	result, err := remote.Solve(context.Background(), imagePath, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Solved || result.RA != 83.8 || result.Dec != -5.4 || result.PixelScale != 1.2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result.WCSHeader["CTYPE1"] != "RA---TAN" {
+		t.Errorf("expected WCS header, got %v", result.WCSHeader)
+	}
+}
+
+func TestRemote_SolveHint(t *testing.T) {
+	remote, imagePath := newTestRemote(t, func(w http.ResponseWriter, r *http.Request) {
+		// A hint on the equinox still sends its ra of 0
+		if ra, dec, radius := r.FormValue("ra"), r.FormValue("dec"), r.FormValue("radius"); ra != "0" || dec != "-5.4" || radius != "2" {
+			t.Errorf("expected ra 0 dec -5.4 radius 2, got %q %q %q", ra, dec, radius)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"solved":true}`)
+	})
+
+	opts := client.DefaultSolveOptions()
+	opts.RA, opts.Dec, opts.Radius = 0, -5.4, 2
+	if _, err := remote.Solve(context.Background(), imagePath, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRemote_SolveEvents(t *testing.T) {
+	remote, imagePath := newTestRemote(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: queue\ndata: {\"position\":1}\n\n")
+		fmt.Fprint(w, "event: phase\ndata: {\"phase\":\"extracting\",\"line\":\"simplexy: found 120 sources.\"}\n\n")
+		fmt.Fprint(w, "event: phase\ndata: {\"phase\":\"solved\"}\n\n")
+		fmt.Fprint(w, "event: result\ndata: {\"solved\":true,\"ra\":10.7,\"raw_output\":\"Field 1: solved\"}\n\n")
+	})
+
+	var lines []string
+	ctx := WithOutputFunc(context.Background(), func(line string) {
+		lines = append(lines, line)
+	})
+	result, err := remote.Solve(ctx, imagePath, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Solved || result.RA != 10.7 || result.RawOutput != "Field 1: solved" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if len(lines) != 1 || lines[0] != "simplexy: found 120 sources." {
+		t.Errorf("expected solver output to be passed on, got %v", lines)
+	}
+}
+
+func TestRemote_SolveErrors(t *testing.T) {
+	remote, imagePath := newTestRemote(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("downsample_factor") {
+		case "1":
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "3":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid image"}`)
+		case "4":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: result\ndata: {\"solved\":false,\"error\":\"solve-field: exit status 1\"}\n\n")
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error":"solve failed"}`)
+		}
+	})
+
+	opts := client.DefaultSolveOptions()
+	opts.DownsampleFactor = 1
+	_, err := remote.Solve(context.Background(), imagePath, opts)
+	var full *queue.FullError
+	if !errors.As(err, &full) {
+		t.Fatalf("expected queue full error, got %v", err)
+	}
+	if full.RetryAfter != 7*time.Second {
+		t.Errorf("expected retry after 7s, got %v", full.RetryAfter)
+	}
+
+	// Only failures of the worker itself are backend errors
+	var backendErr *BackendError
+	_, err = remote.Solve(context.Background(), imagePath, nil)
+	if err == nil || err.Error() != "remote solver: solve failed" || !errors.As(err, &backendErr) {
+		t.Errorf("expected remote backend error, got %v", err)
+	}
+	for _, downsample := range []int{3, 4} {
+		opts.DownsampleFactor = downsample
+		_, err = remote.Solve(context.Background(), imagePath, opts)
+		if err == nil || errors.As(err, &backendErr) {
+			t.Errorf("downsample %d: expected an error caused by the image, got %v", downsample, err)
+		}
+	}
+
+	unreachable := &Remote{client: http.DefaultClient, baseURL: "http://127.0.0.1:1"}
+	if _, err := unreachable.Solve(context.Background(), imagePath, nil); !errors.As(err, &backendErr) {
+		t.Errorf("expected an unreachable worker to be a backend error, got %v", err)
+	}
+}
+
+func TestRemote_Health(t *testing.T) {
+	healthy := true
+	remote, _ := newTestRemote(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Errorf("expected /health, got %s", r.URL.Path)
+		}
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	if err := remote.Health(context.Background()); err != nil {
+		t.Errorf("expected healthy worker, got %v", err)
+	}
+	healthy = false
+	if err := remote.Health(context.Background()); err == nil {
+		t.Error("expected unhealthy worker to be reported")
+	}
+}
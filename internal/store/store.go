@@ -0,0 +1,128 @@
+// Package store is a small embedded key-value store keeping one JSON file per record
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned by Get when no record exists for the key
+var ErrNotFound = errors.New("record not found")
+
+// ErrInvalidKey is returned for keys that cannot be used as file names
+var ErrInvalidKey = errors.New("invalid record key")
+
+const recordExt = ".json"
+
+var validKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Store persists JSON-encoded records in a directory
+type Store struct {
+	dir string
+}
+
+// Open opens (creating if needed) a store in dir
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Put writes v under key, replacing any existing record. The write is atomic:
+// readers see either the old or the new record, even if the process crashes.
+func (s *Store) Put(key string, v any) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode record %s: %w", key, err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "."+key+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write record %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // Already renamed on success
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck // Already failing
+		return fmt.Errorf("failed to write record %s: %w", key, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck // Already failing
+		return fmt.Errorf("failed to write record %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write record %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write record %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get decodes the record stored under key into v
+func (s *Store) Get(key string, v any) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read record %s: %w", key, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode record %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes the record stored under key. Deleting a missing record is not an error.
+func (s *Store) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete record %s: %w", key, err)
+	}
+	return nil
+}
+
+// Keys returns the keys of all stored records in sorted order
+func (s *Store) Keys() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != recordExt {
+			continue
+		}
+		keys = append(keys, strings.TrimSuffix(name, recordExt))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *Store) path(key string) (string, error) {
+	if !validKey.MatchString(key) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(s.dir, key+recordExt), nil
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type record struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+}
+
+func TestStore_PutGetDelete(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	if err := s.Put("b", &record{Name: "second", Value: 2}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := s.Put("a", &record{Name: "first", Value: 1}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := s.Put("a", &record{Name: "first", Value: 10}); err != nil {
+		t.Fatalf("overwrite failed: %v", err)
+	}
+
+	var got record
+	if err := s.Get("a", &got); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if got.Value != 10 {
+		t.Errorf("expected overwritten value 10, got %d", got.Value)
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		t.Fatalf("keys failed: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("expected keys [a b], got %v", keys)
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := s.Delete("a"); err != nil {
+		t.Errorf("expected deleting a missing record to succeed, got %v", err)
+	}
+	if err := s.Get("a", &got); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStore_SurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if err := s.Put("job-1", &record{Name: "persisted"}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+
+	// Leftover temp files from an interrupted write are ignored
+	if err := os.WriteFile(filepath.Join(dir, ".job-2-123.tmp"), []byte("{"), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	keys, err := reopened.Keys()
+	if err != nil {
+		t.Fatalf("keys failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "job-1" {
+		t.Errorf("expected keys [job-1], got %v", keys)
+	}
+
+	var got record
+	if err := reopened.Get("job-1", &got); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if got.Name != "persisted" {
+		t.Errorf("expected persisted record, got %+v", got)
+	}
+}
+
+func TestStore_InvalidKey(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	for _, key := range []string{"", "../escape", "a/b", "a.json"} {
+		if err := s.Put(key, &record{}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected ErrInvalidKey for %q, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// Package wcs converts between pixel and sky coordinates using the TAN or
+// TAN-SIP world coordinate system of a solve
+package wcs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrNoWCS is returned when a header has no celestial WCS
+var ErrNoWCS = errors.New("no celestial WCS in header")
+
+// WCS is a gnomonic (TAN) projection with optional SIP distortion. Pixel
+// coordinates are 1-based, as in FITS.
+type WCS struct {
+	// CRVAL is the sky position of the reference pixel in degrees
+	CRVAL [2]float64
+	// CRPIX is the reference pixel
+	CRPIX [2]float64
+	// CD maps pixel offsets to intermediate world coordinates in degrees
+	CD [2][2]float64
+	// Width and Height are the image size in pixels, or 0 if not known
+	Width  int
+	Height int
+
+	// SIP polynomials: A and B distort pixels towards the sky, AP and BP undo it
+	A, B, AP, BP sip
+	cdInverse    [2][2]float64
+}
+
+// sip holds the coefficients of a SIP polynomial, indexed [p][q] for u^p v^q
+type sip [][]float64
+
+// Parse reads a WCS from header keywords, as returned in the wcs_header of a
+// solve. Values may be quoted as in a FITS header.
+func Parse(header map[string]string) (*WCS, error) {
+	h := make(map[string]string, len(header))
+	for key, value := range header {
+		h[strings.ToUpper(strings.TrimSpace(key))] = strings.TrimSpace(strings.Trim(strings.TrimSpace(value), "'"))
+	}
+
+	ctype1, ctype2 := h["CTYPE1"], h["CTYPE2"]
+	if ctype1 == "" && ctype2 == "" {
+		return nil, ErrNoWCS
+	}
+	if !strings.HasPrefix(ctype1, "RA--") || !strings.HasPrefix(ctype2, "DEC-") {
+		return nil, fmt.Errorf("unsupported axes %q and %q: expected RA and DEC", ctype1, ctype2)
+	}
+	projection := strings.TrimLeft(ctype1[4:], "-")
+	if projection != strings.TrimLeft(ctype2[4:], "-") || (projection != "TAN" && projection != "TAN-SIP") {
+		return nil, fmt.Errorf("unsupported projection %q: only TAN and TAN-SIP are supported", ctype1)
+	}
+
+	w := &WCS{}
+	var err error
+	for i, axis := range []string{"1", "2"} {
+		if w.CRVAL[i], err = number(h, "CRVAL"+axis, nil); err != nil {
+			return nil, err
+		}
+		if w.CRPIX[i], err = number(h, "CRPIX"+axis, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.parseCD(h); err != nil {
+		return nil, err
+	}
+	det := w.CD[0][0]*w.CD[1][1] - w.CD[0][1]*w.CD[1][0]
+	if det == 0 {
+		return nil, errors.New("the CD matrix is singular")
+	}
+	w.cdInverse = [2][2]float64{
+		{w.CD[1][1] / det, -w.CD[0][1] / det},
+		{-w.CD[1][0] / det, w.CD[0][0] / det},
+	}
+
+	for _, size := range []struct {
+		dst  *int
+		keys []string
+	}{
+		{&w.Width, []string{"IMAGEW", "NAXIS1"}},
+		{&w.Height, []string{"IMAGEH", "NAXIS2"}},
+	} {
+		for _, key := range size.keys {
+			if value, err := number(h, key, nil); err == nil && value > 0 {
+				*size.dst = int(value)
+				break
+			}
+		}
+	}
+
+	if projection == "TAN-SIP" {
+		for _, p := range []struct {
+			dst    *sip
+			prefix string
+		}{{&w.A, "A"}, {&w.B, "B"}, {&w.AP, "AP"}, {&w.BP, "BP"}} {
+			if *p.dst, err = parseSIP(h, p.prefix); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return w, nil
+}
+
+// parseCD reads the CD matrix, or builds it from CDELT with PC or CROTA2
+func (w *WCS) parseCD(h map[string]string) error {
+	if _, ok := h["CD1_1"]; ok {
+		for i := range 2 {
+			for j := range 2 {
+				var err error
+				w.CD[i][j], err = number(h, fmt.Sprintf("CD%d_%d", i+1, j+1), new(float64))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	cdelt1, err := number(h, "CDELT1", nil)
+	if err != nil {
+		return err
+	}
+	cdelt2, err := number(h, "CDELT2", nil)
+	if err != nil {
+		return err
+	}
+	if _, ok := h["PC1_1"]; ok {
+		var pc [2][2]float64
+		for i := range 2 {
+			for j := range 2 {
+				identity := 0.0
+				if i == j {
+					identity = 1
+				}
+				if pc[i][j], err = number(h, fmt.Sprintf("PC%d_%d", i+1, j+1), &identity); err != nil {
+					return err
+				}
+			}
+		}
+		w.CD = [2][2]float64{
+			{cdelt1 * pc[0][0], cdelt1 * pc[0][1]},
+			{cdelt2 * pc[1][0], cdelt2 * pc[1][1]},
+		}
+		return nil
+	}
+
+	rotation, err := number(h, "CROTA2", new(float64))
+	if err != nil {
+		return err
+	}
+	sin, cos := math.Sincos(rotation * math.Pi / 180)
+	w.CD = [2][2]float64{
+		{cdelt1 * cos, -cdelt2 * sin},
+		{cdelt1 * sin, cdelt2 * cos},
+	}
+	return nil
+}
+
+// number returns the numeric value of key, or def if it is missing and def is
+// not nil
+func number(h map[string]string, key string, def *float64) (float64, error) {
+	value, ok := h[key]
+	if !ok {
+		if def != nil {
+			return *def, nil
+		}
+		return 0, fmt.Errorf("missing %s", key)
+	}
+	n, err := strconv.ParseFloat(strings.Replace(value, "D", "E", 1), 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid %s value %q", key, value)
+	}
+	return n, nil
+}
+
+// parseSIP reads the polynomial with the given prefix, or nil if its order is
+// not set
+func parseSIP(h map[string]string, prefix string) (sip, error) {
+	if _, ok := h[prefix+"_ORDER"]; !ok {
+		return nil, nil
+	}
+	order, err := number(h, prefix+"_ORDER", nil)
+	if err != nil {
+		return nil, err
+	}
+	if order < 0 || order > 9 || order != math.Trunc(order) {
+		return nil, fmt.Errorf("invalid %s_ORDER value %v", prefix, order)
+	}
+	n := int(order)
+	poly := make(sip, n+1)
+	for p := 0; p <= n; p++ {
+		poly[p] = make([]float64, n+1-p)
+		for q := 0; q <= n-p; q++ {
+			if poly[p][q], err = number(h, fmt.Sprintf("%s_%d_%d", prefix, p, q), new(float64)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return poly, nil
+}
+
+// eval returns the polynomial's value at (u, v)
+func (s sip) eval(u, v float64) float64 {
+	sum := 0.0
+	up := 1.0
+	for p := range s {
+		term := up
+		for q := range s[p] {
+			sum += s[p][q] * term
+			term *= v
+		}
+		up *= u
+	}
+	return sum
+}
+
+// PixelToSky returns the RA and Dec in degrees of the pixel at (x, y)
+func (w *WCS) PixelToSky(x, y float64) (ra, dec float64) {
+	u, v := x-w.CRPIX[0], y-w.CRPIX[1]
+	u, v = u+w.A.eval(u, v), v+w.B.eval(u, v)
+	xi := (w.CD[0][0]*u + w.CD[0][1]*v) * math.Pi / 180
+	eta := (w.CD[1][0]*u + w.CD[1][1]*v) * math.Pi / 180
+
+	ra0, dec0 := w.CRVAL[0]*math.Pi/180, w.CRVAL[1]*math.Pi/180
+	sinDec0, cosDec0 := math.Sincos(dec0)
+	denominator := cosDec0 - eta*sinDec0
+	ra = ra0 + math.Atan2(xi, denominator)
+	dec = math.Atan2(sinDec0+eta*cosDec0, math.Hypot(xi, denominator))
+	return normalizeRA(ra * 180 / math.Pi), dec * 180 / math.Pi
+}
+
+// SkyToPixel returns the pixel of the sky position at ra and dec in degrees.
+// ok is false when the position is on the far side of the sky from the image,
+// where the projection is undefined, or so far from it that the distortion
+// cannot be undone.
+func (w *WCS) SkyToPixel(ra, dec float64) (x, y float64, ok bool) {
+	ra0, dec0 := w.CRVAL[0]*math.Pi/180, w.CRVAL[1]*math.Pi/180
+	sinDec0, cosDec0 := math.Sincos(dec0)
+	sinDec, cosDec := math.Sincos(dec * math.Pi / 180)
+	sinDRA, cosDRA := math.Sincos(ra*math.Pi/180 - ra0)
+	d := sinDec*sinDec0 + cosDec*cosDec0*cosDRA
+	if d <= 0 {
+		return 0, 0, false
+	}
+	xi := cosDec * sinDRA / d * 180 / math.Pi
+	eta := (sinDec*cosDec0 - cosDec*sinDec0*cosDRA) / d * 180 / math.Pi
+
+	u := w.cdInverse[0][0]*xi + w.cdInverse[0][1]*eta
+	v := w.cdInverse[1][0]*xi + w.cdInverse[1][1]*eta
+	switch {
+	case w.AP != nil || w.BP != nil:
+		u, v = u+w.AP.eval(u, v), v+w.BP.eval(u, v)
+	case w.A != nil || w.B != nil:
+		// Without the inverse polynomials, undo the distortion by iteration
+		U, V := u, v
+		for range 20 {
+			u, v = U-w.A.eval(u, v), V-w.B.eval(u, v)
+		}
+	}
+	x, y = u+w.CRPIX[0], v+w.CRPIX[1]
+	if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+		return 0, 0, false
+	}
+	return x, y, true
+}
+
+// Contains reports whether the pixel at (x, y) lies on the image. It is false
+// when the image size is not known.
+func (w *WCS) Contains(x, y float64) bool {
+	return x >= 0.5 && y >= 0.5 && x <= float64(w.Width)+0.5 && y <= float64(w.Height)+0.5
+}
+
+// Center returns the sky position of the centre of the image. Like Corners and
+// Radius, it needs the image size.
+func (w *WCS) Center() (ra, dec float64) {
+	return w.PixelToSky(float64(w.Width)/2+0.5, float64(w.Height)/2+0.5)
+}
+
+// Corners returns the sky positions of the outer corners of the image as RA
+// and Dec pairs, in pixel order: (0.5, 0.5), (Width+0.5, 0.5), then
+// (Width+0.5, Height+0.5) and (0.5, Height+0.5)
+func (w *WCS) Corners() [4][2]float64 {
+	right, bottom := float64(w.Width)+0.5, float64(w.Height)+0.5
+	var corners [4][2]float64
+	for i, pixel := range [4][2]float64{{0.5, 0.5}, {right, 0.5}, {right, bottom}, {0.5, bottom}} {
+		corners[i][0], corners[i][1] = w.PixelToSky(pixel[0], pixel[1])
+	}
+	return corners
+}
+
+// Radius returns the angle in degrees from the centre of the image to its
+// furthest corner
+func (w *WCS) Radius() float64 {
+	ra, dec := w.Center()
+	radius := 0.0
+	for _, corner := range w.Corners() {
+		radius = max(radius, Separation(ra, dec, corner[0], corner[1]))
+	}
+	return radius
+}
+
+// PixelScale returns the mean size of a pixel in arcseconds
+func (w *WCS) PixelScale() float64 {
+	det := w.CD[0][0]*w.CD[1][1] - w.CD[0][1]*w.CD[1][0]
+	return math.Sqrt(math.Abs(det)) * 3600
+}
+
+func normalizeRA(ra float64) float64 {
+	ra = math.Mod(ra, 360)
+	if ra < 0 {
+		ra += 360
+	}
+	return ra
+}
+
+// Separation returns the angle in degrees between two sky positions in degrees
+func Separation(ra1, dec1, ra2, dec2 float64) float64 {
+	ra1, dec1, ra2, dec2 = ra1*math.Pi/180, dec1*math.Pi/180, ra2*math.Pi/180, dec2*math.Pi/180
+	// The haversine formula stays accurate for small angles
+	sinDDec, sinDRA := math.Sin((dec2-dec1)/2), math.Sin((ra2-ra1)/2)
+	h := sinDDec*sinDDec + math.Cos(dec1)*math.Cos(dec2)*sinDRA*sinDRA
+	return 2 * math.Asin(math.Sqrt(min(h, 1))) * 180 / math.Pi
+}
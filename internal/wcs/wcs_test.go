@@ -0,0 +1,188 @@
+package wcs
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// testHeader is a TAN-SIP solution of a 1024x768 image of M42, as written by
+// solve-field
+func testHeader() map[string]string {
+	return map[string]string{
+		"WCSAXES":  "2",
+		"CTYPE1":   "'RA---TAN-SIP'",
+		"CTYPE2":   "'DEC--TAN-SIP'",
+		"EQUINOX":  "2000.0",
+		"CRVAL1":   "83.8221",
+		"CRVAL2":   "-5.3911",
+		"CRPIX1":   "512.5",
+		"CRPIX2":   "384.5",
+		"CD1_1":    "-0.000275",
+		"CD1_2":    "0.0000312",
+		"CD2_1":    "-0.0000312",
+		"CD2_2":    "-0.000275",
+		"IMAGEW":   "1024",
+		"IMAGEH":   "768",
+		"A_ORDER":  "2",
+		"A_0_2":    "2.1E-07",
+		"A_1_1":    "-1.3E-07",
+		"A_2_0":    "4.0E-07",
+		"B_ORDER":  "2",
+		"B_0_2":    "-3.2E-07",
+		"B_1_1":    "2.5E-07",
+		"B_2_0":    "1.1E-07",
+		"AP_ORDER": "2",
+		"AP_0_2":   "-2.1E-07",
+		"AP_1_1":   "1.3E-07",
+		"AP_2_0":   "-4.0E-07",
+		"BP_ORDER": "2",
+		"BP_0_2":   "3.2E-07",
+		"BP_1_1":   "-2.5E-07",
+		"BP_2_0":   "-1.1E-07",
+	}
+}
+
+func TestParse(t *testing.T) {
+	w, err := Parse(testHeader())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Width != 1024 || w.Height != 768 {
+		t.Errorf("expected 1024x768, got %dx%d", w.Width, w.Height)
+	}
+	if len(w.A) != 3 || w.A[2][0] != 4e-7 {
+		t.Errorf("expected A_2_0 4e-7, got %v", w.A)
+	}
+	if scale := w.PixelScale(); math.Abs(scale-0.9963) > 0.001 {
+		t.Errorf("expected a pixel scale of 0.996, got %f", scale)
+	}
+
+	cdelt := map[string]string{
+		"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN",
+		"CRVAL1": "10", "CRVAL2": "20", "CRPIX1": "1", "CRPIX2": "1",
+		"CDELT1": "-0.001", "CDELT2": "0.001", "CROTA2": "90",
+	}
+	w, err = Parse(cdelt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if math.Abs(w.CD[0][1]+0.001) > 1e-12 || math.Abs(w.CD[1][0]+0.001) > 1e-12 || math.Abs(w.CD[0][0]) > 1e-12 {
+		t.Errorf("expected CD from CDELT rotated by 90 degrees, got %v", w.CD)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	if _, err := Parse(map[string]string{"NAXIS": "2"}); !errors.Is(err, ErrNoWCS) {
+		t.Errorf("expected ErrNoWCS, got %v", err)
+	}
+	tests := map[string]map[string]string{
+		"projection": {"CTYPE1": "RA---SIN", "CTYPE2": "DEC--SIN"},
+		"galactic":   {"CTYPE1": "GLON-TAN", "CTYPE2": "GLAT-TAN"},
+		"missing":    {"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN", "CRVAL1": "10"},
+		"singular": {
+			"CTYPE1": "RA---TAN", "CTYPE2": "DEC--TAN", "CRVAL1": "10", "CRVAL2": "20",
+			"CRPIX1": "1", "CRPIX2": "1", "CD1_1": "0", "CD2_2": "0",
+		},
+	}
+	for name, header := range tests {
+		if _, err := Parse(header); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPixelToSky(t *testing.T) {
+	w, err := Parse(testHeader())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ra, dec := w.PixelToSky(512.5, 384.5)
+	if math.Abs(ra-83.8221) > 1e-9 || math.Abs(dec+5.3911) > 1e-9 {
+		t.Errorf("expected the reference pixel at CRVAL, got %f %f", ra, dec)
+	}
+
+	// With CD1_1 negative, RA grows to the left of the image
+	ra, _ = w.PixelToSky(1, 384.5)
+	if ra <= 83.8221 {
+		t.Errorf("expected RA to grow to the left, got %f", ra)
+	}
+
+	for _, pixel := range [][2]float64{{1, 1}, {1024, 768}, {100, 700}, {512.5, 1}} {
+		ra, dec := w.PixelToSky(pixel[0], pixel[1])
+		x, y, ok := w.SkyToPixel(ra, dec)
+		if !ok || math.Abs(x-pixel[0]) > 0.01 || math.Abs(y-pixel[1]) > 0.01 {
+			t.Errorf("expected %v back, got %f %f", pixel, x, y)
+		}
+	}
+}
+
+func TestSkyToPixel(t *testing.T) {
+	header := testHeader()
+	for _, key := range []string{"AP_ORDER", "BP_ORDER"} {
+		delete(header, key)
+	}
+	w, err := Parse(header)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Without AP and BP the distortion is undone by iteration
+	ra, dec := w.PixelToSky(1000, 50)
+	if x, y, ok := w.SkyToPixel(ra, dec); !ok || math.Abs(x-1000) > 1e-6 || math.Abs(y-50) > 1e-6 {
+		t.Errorf("expected 1000 50 back, got %f %f", x, y)
+	}
+
+	if _, _, ok := w.SkyToPixel(83.8221+180, 5.3911); ok {
+		t.Error("expected the antipode not to project")
+	}
+	// Far from the image the iteration diverges rather than giving a pixel
+	if x, y, ok := w.SkyToPixel(160, -60); ok && (math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0)) {
+		t.Errorf("expected a finite pixel or none, got %f %f", x, y)
+	}
+	if !w.Contains(1, 768) || w.Contains(0, 10) || w.Contains(10, 769) {
+		t.Error("expected Contains to check the image bounds")
+	}
+
+	// Near RA 0 the result wraps into [0, 360)
+	w.CRVAL = [2]float64{0.001, 0}
+	if ra, _ := w.PixelToSky(1000, 384.5); ra < 0 || ra >= 360 {
+		t.Errorf("expected RA in [0, 360), got %f", ra)
+	}
+}
+
+func TestWCS_Corners(t *testing.T) {
+	w, err := Parse(testHeader())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ra, dec := w.Center()
+	if math.Abs(ra-83.8221) > 1e-6 || math.Abs(dec+5.3911) > 1e-6 {
+		t.Errorf("expected the centre at CRVAL, got %f %f", ra, dec)
+	}
+	corners := w.Corners()
+	// RA grows to the left and Dec downwards
+	if corners[0][0] <= corners[1][0] || corners[0][1] <= corners[3][1] {
+		t.Errorf("expected corners in pixel order, got %v", corners)
+	}
+	// The diagonal is 1280 pixels of about 1"
+	if radius := w.Radius(); math.Abs(radius-640*0.9963/3600) > 0.002 {
+		t.Errorf("expected a radius of about 0.177 degrees, got %f", radius)
+	}
+}
+
+func TestSeparation(t *testing.T) {
+	tests := []struct {
+		ra1, dec1, ra2, dec2, want float64
+	}{
+		{0, 0, 90, 0, 90},
+		{10, 89, 190, 89, 2},
+		{359.9, 0, 0.1, 0, 0.2},
+		{83.8221, -5.3911, 83.8221, -5.3911, 0},
+		{0, 90, 0, -90, 180},
+	}
+	for _, tt := range tests {
+		if got := Separation(tt.ra1, tt.dec1, tt.ra2, tt.dec2); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("expected %f between (%f, %f) and (%f, %f), got %f", tt.want, tt.ra1, tt.dec1, tt.ra2, tt.dec2, got)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package webhook delivers signed HTTP callbacks
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the request body, as "sha256=<hex>"
+const SignatureHeader = "X-Signature-256"
+
+// maxBackoff caps the delay between retries
+const maxBackoff = 10 * time.Minute
+
+// Attempt describes one delivery attempt
+type Attempt struct {
+	Number     int
+	At         time.Time
+	StatusCode int
+	Err        error
+}
+
+// Sender posts signed JSON payloads to allowlisted hosts, retrying failed
+// deliveries with exponential backoff
+type Sender struct {
+	secret       []byte
+	allowedHosts []string
+	maxAttempts  int
+	backoff      time.Duration
+	client       *http.Client
+}
+
+// NewSender creates a sender signing payloads with secret. Callback URLs must
+// point at one of allowedHosts; an entry of the form "*.example.com" allows any
+// subdomain. A failed delivery is tried up to maxAttempts times, waiting backoff
+// after the first failure and doubling the wait after each further one.
+func NewSender(secret string, allowedHosts []string, maxAttempts int, backoff time.Duration) *Sender {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	hosts := make([]string, 0, len(allowedHosts))
+	for _, host := range allowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return &Sender{
+		secret:       []byte(secret),
+		allowedHosts: hosts,
+		maxAttempts:  maxAttempts,
+		backoff:      backoff,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			// A redirect could lead to a host that is not allowed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// CheckURL reports whether rawURL may be used as a callback URL
+func (s *Sender) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("malformed URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("URL must use http or https")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.New("URL has no host")
+	}
+	if !s.allowed(host) {
+		return fmt.Errorf("host %s is not allowed", host)
+	}
+	return nil
+}
+
+func (s *Sender) allowed(host string) bool {
+	for _, pattern := range s.allowedHosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Send posts body to rawURL with the given extra headers until it is accepted
+// with a 2xx status, the attempts run out, the receiver rejects it with a
+// client error or ctx is done. Every attempt is passed to record.
+func (s *Sender) Send(ctx context.Context, rawURL string, header http.Header, body []byte, record func(Attempt)) error {
+	if err := s.CheckURL(rawURL); err != nil {
+		return err
+	}
+
+	var err error
+	for n := 1; n <= s.maxAttempts; n++ {
+		if n > 1 {
+			select {
+			case <-time.After(s.delay(n)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		attempt := Attempt{Number: n, At: time.Now()}
+		var retry bool
+		attempt.StatusCode, retry, err = s.post(ctx, rawURL, header, body)
+		attempt.Err = err
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		record(attempt)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", s.maxAttempts, err)
+}
+
+// delay is the wait before attempt n (n >= 2)
+func (s *Sender) delay(n int) time.Duration {
+	d := s.backoff
+	for i := 2; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// post makes a single delivery attempt, reporting whether a failure is worth retrying
+func (s *Sender) post(ctx context.Context, rawURL string, header http.Header, body []byte) (int, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(s.secret, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()                                 //nolint:errcheck // Response body is drained and discarded
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) //nolint:errcheck // Only drained to reuse the connection
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return resp.StatusCode, true, fmt.Errorf("receiver responded %s", resp.Status)
+	default:
+		return resp.StatusCode, false, fmt.Errorf("receiver rejected delivery: %s", resp.Status)
+	}
+}
+
+// Sign returns the signature header value for body: "sha256=" followed by the
+// hex-encoded HMAC-SHA256 of body keyed with secret
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of body, for receivers
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, body)))
+}
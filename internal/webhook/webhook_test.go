@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSender_CheckURL(t *testing.T) {
+	s := NewSender("secret", []string{"hooks.example.com", " *.pipeline.internal "}, 3, time.Millisecond)
+
+	for rawURL, ok := range map[string]bool{
+		"https://hooks.example.com/solved":        true,
+		"http://HOOKS.example.com:8443/x":         true,
+		"https://worker.pipeline.internal/cb":     true,
+		"https://pipeline.internal/cb":            false,
+		"https://evil.example.com/solved":         false,
+		"https://hooks.example.com.evil.com/x":    false,
+		"ftp://hooks.example.com/solved":          false,
+		"hooks.example.com/solved":                false,
+		"http://169.254.169.254/latest/meta-data": false,
+	} {
+		if err := s.CheckURL(rawURL); (err == nil) != ok {
+			t.Errorf("%s: expected allowed=%v, got error %v", rawURL, ok, err)
+		}
+	}
+}
+
+func TestSender_SendSignsAndRetries(t *testing.T) {
+	var calls atomic.Int32
+	var gotBody []byte
+	var gotSignature, gotID string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSignature = r.Header.Get(SignatureHeader)
+		gotID = r.Header.Get("X-Solve-ID")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	s := NewSender("secret", []string{"127.0.0.1"}, 5, time.Millisecond)
+	header := http.Header{}
+	header.Set("X-Solve-ID", "abc")
+	body := []byte(`{"solved":true}`)
+
+	var attempts []Attempt
+	err := s.Send(context.Background(), receiver.URL+"/hook", header, body, func(a Attempt) {
+		attempts = append(attempts, a)
+	})
+	if err != nil {
+		t.Fatalf("unexpected send error: %v", err)
+	}
+
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(attempts))
+	}
+	if attempts[0].StatusCode != http.StatusServiceUnavailable || attempts[0].Err == nil {
+		t.Errorf("expected first attempt to fail with 503, got %+v", attempts[0])
+	}
+	if attempts[2].StatusCode != http.StatusNoContent || attempts[2].Err != nil {
+		t.Errorf("expected last attempt to succeed, got %+v", attempts[2])
+	}
+	if string(gotBody) != string(body) {
+		t.Errorf("expected body %s, got %s", body, gotBody)
+	}
+	if !Verify([]byte("secret"), gotBody, gotSignature) {
+		t.Errorf("expected valid signature, got %q", gotSignature)
+	}
+	if gotID != "abc" {
+		t.Errorf("expected extra header to be sent, got %q", gotID)
+	}
+}
+
+func TestSender_SendGivesUp(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status   int
+		attempts int
+	}{
+		"server errors are retried": {http.StatusInternalServerError, 3},
+		"client errors are final":   {http.StatusBadRequest, 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer receiver.Close()
+
+			s := NewSender("secret", []string{"127.0.0.1"}, 3, time.Millisecond)
+			attempts := 0
+			err := s.Send(context.Background(), receiver.URL, nil, []byte(`{}`), func(Attempt) { attempts++ })
+			if err == nil {
+				t.Error("expected delivery to fail")
+			}
+			if attempts != tc.attempts {
+				t.Errorf("expected %d attempts, got %d", tc.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestSender_Delay(t *testing.T) {
+	s := NewSender("secret", nil, 10, time.Second)
+	for n, expected := range map[int]time.Duration{
+		2:  time.Second,
+		3:  2 * time.Second,
+		4:  4 * time.Second,
+		20: maxBackoff,
+	} {
+		if got := s.delay(n); got != expected {
+			t.Errorf("attempt %d: expected delay %v, got %v", n, expected, got)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"solved":true}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=410b4db5dd558e5f9b7ca61ca018895a65eced1e109ddbae788cde6475dc0d52"
+	if got := Sign([]byte("secret"), []byte(`{"solved":true}`)); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if Verify([]byte("other"), []byte(`{"solved":true}`), expected) {
+		t.Error("expected signature to depend on the secret")
+	}
+}
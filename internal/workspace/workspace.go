@@ -0,0 +1,266 @@
+// Package workspace gives each request its own directory on the shared volume
+package workspace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ownerFile records which server process created a workspace
+const ownerFile = ".owner"
+
+// dirPrefix marks directories created by the manager so Reap leaves anything else alone
+const dirPrefix = "ws-"
+
+// Manager creates and tracks per-request workspaces under a root directory
+type Manager struct {
+	root       string
+	owner      owner
+	staleAfter time.Duration
+
+	mu     sync.Mutex
+	active map[string]*Workspace
+}
+
+// Workspace is a directory owned by a single request
+type Workspace struct {
+	ID  string
+	Dir string
+
+	manager *Manager
+	once    sync.Once
+}
+
+// owner identifies the process that created a workspace. The instance token
+// distinguishes a restarted process that was given the same PID (e.g. PID 1 in a container).
+type owner struct {
+	host     string
+	pid      int
+	instance string
+}
+
+// NewManager creates a workspace manager rooted at root. Workspaces left behind by
+// processes on other hosts are only reaped once they are older than staleAfter.
+func NewManager(root string, staleAfter time.Duration) (*Manager, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace root: %w", err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate instance token: %w", err)
+	}
+
+	return &Manager{
+		root:       root,
+		owner:      owner{host: host, pid: os.Getpid(), instance: hex.EncodeToString(token)},
+		staleAfter: staleAfter,
+		active:     make(map[string]*Workspace),
+	}, nil
+}
+
+// Root returns the directory workspaces are created in
+func (m *Manager) Root() string {
+	return m.root
+}
+
+// Create makes a new, uniquely named workspace. The name is used as a readable
+// prefix of the directory and may be empty.
+func (m *Manager) Create(name string) (*Workspace, error) {
+	dir, err := os.MkdirTemp(m.root, dirPrefix+name+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	// The solver container reads (and writes next to) files in the workspace
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir) //nolint:errcheck // Best-effort cleanup of a half-created workspace
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ownerFile), []byte(m.owner.String()), 0644); err != nil {
+		os.RemoveAll(dir) //nolint:errcheck // Best-effort cleanup of a half-created workspace
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	return m.track(dir), nil
+}
+
+// Adopt takes ownership of an existing workspace, e.g. one left behind by a
+// previous run whose work is being resumed, so that Reap leaves it alone.
+func (m *Manager) Adopt(dir string) (*Workspace, error) {
+	dir = filepath.Clean(dir)
+	if filepath.Dir(dir) != filepath.Clean(m.root) || !strings.HasPrefix(filepath.Base(dir), dirPrefix) {
+		return nil, fmt.Errorf("not a workspace: %s", dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to adopt workspace: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a workspace: %s", dir)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ownerFile), []byte(m.owner.String()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to adopt workspace: %w", err)
+	}
+
+	return m.track(dir), nil
+}
+
+func (m *Manager) track(dir string) *Workspace {
+	ws := &Workspace{
+		ID:      filepath.Base(dir),
+		Dir:     dir,
+		manager: m,
+	}
+
+	m.mu.Lock()
+	m.active[ws.ID] = ws
+	m.mu.Unlock()
+
+	return ws
+}
+
+// Active returns the number of workspaces currently in use by this process
+func (m *Manager) Active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.active)
+}
+
+// Close releases every workspace still held by this process
+func (m *Manager) Close() {
+	m.mu.Lock()
+	active := make([]*Workspace, 0, len(m.active))
+	for _, ws := range m.active {
+		active = append(active, ws)
+	}
+	m.mu.Unlock()
+
+	for _, ws := range active {
+		if err := ws.Release(); err != nil {
+			log.Printf("Failed to remove workspace %s: %v", ws.ID, err)
+		}
+	}
+}
+
+// Reap removes workspaces abandoned by processes that are no longer running,
+// e.g. after a crash. It returns the number of workspaces removed.
+func (m *Manager) Reap() (int, error) {
+	entries, err := os.ReadDir(m.root)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), dirPrefix) {
+			continue
+		}
+		dir := filepath.Join(m.root, entry.Name())
+		if !m.abandoned(dir) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Failed to reap workspace %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// abandoned reports whether the workspace in dir belongs to a process that has gone away
+func (m *Manager) abandoned(dir string) bool {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false
+	}
+	stale := m.staleAfter > 0 && time.Since(info.ModTime()) > m.staleAfter
+
+	data, err := os.ReadFile(filepath.Join(dir, ownerFile))
+	if err != nil {
+		// Ownerless directories are half-created workspaces; only reap them once stale
+		return stale
+	}
+	o, err := parseOwner(string(data))
+	if err != nil {
+		return stale
+	}
+
+	switch {
+	case o == m.owner:
+		return false
+	case o.host != m.owner.host:
+		// We cannot check processes on other hosts sharing the volume
+		return stale
+	case o.pid == m.owner.pid:
+		// Same host and PID but a different instance: we are the restarted process
+		return true
+	default:
+		return !processAlive(o.pid)
+	}
+}
+
+// Path returns the path of a file inside the workspace
+func (w *Workspace) Path(name string) string {
+	return filepath.Join(w.Dir, name)
+}
+
+// Detach stops tracking the workspace without removing it, so it survives
+// Close and can be adopted by the next run
+func (w *Workspace) Detach() {
+	w.manager.mu.Lock()
+	delete(w.manager.active, w.ID)
+	w.manager.mu.Unlock()
+}
+
+// Release removes the workspace and everything in it. It is safe to call more than once.
+func (w *Workspace) Release() error {
+	var err error
+	w.once.Do(func() {
+		w.manager.mu.Lock()
+		delete(w.manager.active, w.ID)
+		w.manager.mu.Unlock()
+
+		err = os.RemoveAll(w.Dir)
+	})
+	return err
+}
+
+func (o owner) String() string {
+	return fmt.Sprintf("%s %d %s", o.host, o.pid, o.instance)
+}
+
+func parseOwner(s string) (owner, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return owner{}, errors.New("malformed owner")
+	}
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return owner{}, fmt.Errorf("malformed owner pid: %w", err)
+	}
+	return owner{host: fields[0], pid: pid, instance: fields[2]}, nil
+}
+
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,120 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManager_CreateAndRelease(t *testing.T) {
+	m, err := NewManager(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	a, err := m.Create("solve")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	b, err := m.Create("solve")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+
+	if a.Dir == b.Dir {
+		t.Fatalf("expected unique directories, both are %s", a.Dir)
+	}
+	if filepath.Dir(a.Dir) != m.Root() {
+		t.Errorf("expected workspace under %s, got %s", m.Root(), a.Dir)
+	}
+	if m.Active() != 2 {
+		t.Errorf("expected 2 active workspaces, got %d", m.Active())
+	}
+
+	if err := os.WriteFile(a.Path("image.jpg"), []byte("data"), 0644); err != nil {
+		t.Fatalf("failed to write into workspace: %v", err)
+	}
+
+	if err := a.Release(); err != nil {
+		t.Fatalf("failed to release workspace: %v", err)
+	}
+	if err := a.Release(); err != nil {
+		t.Errorf("expected second release to be a no-op, got %v", err)
+	}
+	if _, err := os.Stat(a.Dir); !os.IsNotExist(err) {
+		t.Errorf("expected workspace directory to be removed, got %v", err)
+	}
+	if m.Active() != 1 {
+		t.Errorf("expected 1 active workspace, got %d", m.Active())
+	}
+
+	m.Close()
+	if _, err := os.Stat(b.Dir); !os.IsNotExist(err) {
+		t.Errorf("expected Close to remove remaining workspaces, got %v", err)
+	}
+}
+
+func TestManager_Reap(t *testing.T) {
+	root := t.TempDir()
+
+	// A previous instance of this process (same host and PID, e.g. a restarted container)
+	previous, err := NewManager(root, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	orphan, err := previous.Create("solve")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+
+	current, err := NewManager(root, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	mine, err := current.Create("solve")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+
+	// A fresh workspace from another host must be left alone
+	remote := filepath.Join(root, dirPrefix+"remote")
+	if err := os.Mkdir(remote, 0755); err != nil {
+		t.Fatalf("failed to create remote workspace: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(remote, ownerFile), []byte("otherhost 42 abcdef"), 0644); err != nil {
+		t.Fatalf("failed to write owner: %v", err)
+	}
+
+	// Unrelated directories on the shared volume are never touched
+	unrelated := filepath.Join(root, "indexes")
+	if err := os.Mkdir(unrelated, 0755); err != nil {
+		t.Fatalf("failed to create unrelated dir: %v", err)
+	}
+
+	removed, err := current.Reap()
+	if err != nil {
+		t.Fatalf("reap failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 workspace reaped, got %d", removed)
+	}
+
+	if _, err := os.Stat(orphan.Dir); !os.IsNotExist(err) {
+		t.Error("expected orphaned workspace to be reaped")
+	}
+	for _, dir := range []string{mine.Dir, remote, unrelated} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("expected %s to be kept, got %v", dir, err)
+		}
+	}
+
+	// Once stale, the remote workspace is reaped too
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(remote, old, old); err != nil {
+		t.Fatalf("failed to age remote workspace: %v", err)
+	}
+	if removed, _ := current.Reap(); removed != 1 {
+		t.Errorf("expected stale remote workspace to be reaped, got %d", removed)
+	}
+}